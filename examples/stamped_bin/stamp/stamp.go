@@ -26,3 +26,6 @@ var XdefBuildTimestamp = ""
 
 // an xdef with a missing key should leave this alone
 var XdefInvalid = "pass"
+
+// an xdef should set this to a value with a status key in it
+var XdefTemplate = ""
@@ -16,6 +16,7 @@ limitations under the License.
 package stamped_bin_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/bazelbuild/rules_go/examples/stamped_bin/stamp"
@@ -37,4 +38,7 @@ func TestStampedBin(t *testing.T) {
 	if stamp.XdefInvalid != "pass" {
 		t.Errorf("Expected XdefInvalid to have been left alone, got %s.", stamp.XdefInvalid)
 	}
+	if !strings.HasPrefix(stamp.XdefTemplate, "built at ") || stamp.XdefTemplate == "built at " {
+		t.Errorf("Expected XdefTemplate to have been set with a timestamp, got %s.", stamp.XdefTemplate)
+	}
 }
@@ -12,14 +12,27 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-load("@io_bazel_rules_go//go/private:repositories.bzl", "go_repositories")
+load("@io_bazel_rules_go//go/private:repositories.bzl", "go_download_sdk", "go_host_sdk", "go_register_toolchains", "go_repositories", "go_wrap_sdk")
 load("@io_bazel_rules_go//go/private:go_repository.bzl", "go_repository", "new_go_repository")
 load("@io_bazel_rules_go//go/private:go_prefix.bzl", "go_prefix")
-load("@io_bazel_rules_go//go/private:library.bzl", "go_library")
-load("@io_bazel_rules_go//go/private:binary.bzl", "go_binary")
-load("@io_bazel_rules_go//go/private:test.bzl", "go_test")
-load("@io_bazel_rules_go//go/private:cgo.bzl", "cgo_library", "cgo_genrule")
+load("@io_bazel_rules_go//go/private:benchmark.bzl", "go_benchmark")
+load("@io_bazel_rules_go//go/private:binary.bzl", "go_binary_macro", "go_cross_binary", "go_tool")
+load("@io_bazel_rules_go//go/private:cgo.bzl", "cgo_library", "cgo_genrule", "go_library_macro", "go_test_macro")
+load("@io_bazel_rules_go//go/private:common.bzl", "go_toolchain_type")
+load("@io_bazel_rules_go//go/private:context.bzl", "go_context")
+load("@io_bazel_rules_go//go/private:embed_data.bzl", "go_embed_data")
+load("@io_bazel_rules_go//go/private:fmt.bzl", "go_fmt_test")
 load("@io_bazel_rules_go//go/private:gazelle.bzl", "gazelle")
+load("@io_bazel_rules_go//go/private:genrule.bzl", "go_genrule")
+load("@io_bazel_rules_go//go/private:go_path.bzl", "go_path")
+load("@io_bazel_rules_go//go/private:golangci_lint.bzl", "golangci_lint_download", "golangci_lint_test")
+load("@io_bazel_rules_go//go/private:library.bzl", "go_tool_library")
+load("@io_bazel_rules_go//go/private:nogo.bzl", "nogo")
+load("@io_bazel_rules_go//go/private:pkg_info.bzl", "go_pkg_info_aspect")
+load("@io_bazel_rules_go//go/private:providers.bzl", "GoArchive", "GoLibrary", "GoPkgInfo", "GoSource")
+load("@io_bazel_rules_go//go/private:release.bzl", "go_release")
+load("@io_bazel_rules_go//go/private:sbom.bzl", "go_repository_metadata", "go_sbom", "go_sbom_aspect")
+load("@io_bazel_rules_go//go/private:source.bzl", "go_source")
 
 """These are bare-bones Go rules.
 
@@ -29,6 +42,8 @@ In order of priority:
 
 - No support for SWIG
 
-- No test sharding or test XML.
-
 """
+
+go_binary = go_binary_macro
+go_library = go_library_macro
+go_test = go_test_macro
@@ -0,0 +1,62 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+def _go_benchmark_impl(ctx):
+  args = [
+      "-test.run=^$",
+      "-test.bench=" + ctx.attr.bench,
+      "-test.count=%d" % ctx.attr.count,
+  ]
+  if ctx.attr.benchtime:
+    args += ["-test.benchtime=" + ctx.attr.benchtime]
+  if ctx.attr.benchmem:
+    args += ["-test.benchmem"]
+  if ctx.attr.cpu:
+    args += ["-test.cpu=" + ",".join([str(cpu) for cpu in ctx.attr.cpu])]
+  # The test is run with its runfiles, like a tool in a genrule, and finds its
+  # package directory in them.
+  inputs, _, input_manifests = ctx.resolve_command(tools = [ctx.attr.test])
+  ctx.action(
+      inputs = inputs,
+      outputs = [ctx.outputs.out],
+      command = 'out="$1"; shift; "$@" >"$out"',
+      arguments = ["go_benchmark", ctx.outputs.out.path, ctx.executable.test.path] + args,
+      input_manifests = input_manifests,
+      mnemonic = "GoBenchmark",
+      progress_message = "Running benchmarks of %s" % ctx.attr.test.label,
+  )
+  return struct(files = depset([ctx.outputs.out]))
+
+go_benchmark = rule(
+    _go_benchmark_impl,
+    attrs = {
+        "test": attr.label(
+            mandatory = True,
+            executable = True,
+            cfg = "host",
+        ),
+        "bench": attr.string(default = "."),
+        "benchtime": attr.string(),
+        "count": attr.int(default = 1),
+        "benchmem": attr.bool(),
+        "cpu": attr.int_list(),
+    },
+    outputs = {"out": "%{name}.txt"},
+)
+"""Runs the benchmarks of the go_test in test that match bench, and saves
+their results in <name>.txt, in the format of go test -bench, which benchstat
+reads. The test is built for the host, since the benchmarks run there as part
+of the build. Results are cached like other outputs, so they only run again
+when the test changes.
+"""
@@ -12,8 +12,10 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "go_filetype")
-load("@io_bazel_rules_go//go/private:library.bzl", "emit_library_actions")
+load("@io_bazel_rules_go//go/private:common.bzl", "define_flags", "get_go_toolchain", "go_toolchain_type", "go_filetype", "emit_params_file", "go_prefix_default")
+load("@io_bazel_rules_go//go/private:library.bzl", "c_linkmode_enabled", "debug_enabled", "pie_enabled", "sanitizer", "plugin_enabled", "pure_env", "emit_library_actions", "get_embed", "get_stdlib", "new_go_archive", "race_enabled")
+load("@io_bazel_rules_go//go/private:providers.bzl", "GoLibrary")
+load("@io_bazel_rules_go//go/private:cross.bzl", "GOARCH_VALUES", "GOOS_VALUES", "LINKMODE_VALUES", "PURE_VALUES", "go_cross_aspect", "go_package_labels")
 
 def _go_binary_impl(ctx):
   """go_binary_impl emits actions for compiling and linking a go executable."""
@@ -21,24 +23,107 @@ def _go_binary_impl(ctx):
       sources = depset(ctx.files.srcs),
       deps = ctx.attr.deps,
       cgo_object = None,
-      library = ctx.attr.library,
+      embed = get_embed(ctx),
+      embedsrcs = ctx.files.embedsrcs,
   )
+  race = race_enabled(ctx)
+  if race:
+    transitive_go_libraries = lib_result.transitive_go_libraries_race
+    transitive_go_library_paths = lib_result.transitive_go_library_paths_race
+    libs = [lib_result.race_library]
+  else:
+    transitive_go_libraries = lib_result.transitive_go_libraries
+    transitive_go_library_paths = lib_result.transitive_go_library_paths
+    libs = lib_result.files
+
+  c_library = c_linkmode_enabled(ctx)
+  plugin = plugin_enabled(ctx)
+  executable = ctx.outputs.executable
+  files = [executable]
+  runfiles = lib_result.runfiles.merge(plugin_runfiles(ctx))
+  # For js/wasm, a WebAssembly module is built, and the executable is
+  # a script that runs it.
+  if wasm_enabled(ctx):
+    executable = ctx.outputs.wasm
+    files = [executable, ctx.outputs.executable]
+    runfiles = runfiles.merge(emit_wasm_launcher(ctx, executable))
+  # A C library and its header, or a plugin, are built instead of an
+  # executable. The executable Bazel expects is a script that explains this.
+  if c_library or plugin:
+    ctx.file_action(
+        output = ctx.outputs.executable,
+        content = "#!/bin/sh\necho '%s is a %s library and cannot be run' >&2\nexit 1\n" % (ctx.label, ctx.attr.linkmode),
+        executable = True,
+    )
+  if plugin:
+    executable = ctx.outputs.plugin
+    files = [executable]
+  if c_library:
+    if not lib_result.cgo_object:
+      fail("%s libraries must be built with cgo, so they can export functions with //export" % ctx.attr.linkmode, "linkmode")
+    exports = list(lib_result.cgo_object.cgo_exports)
+    executable = ctx.outputs.c_library
+    files = [executable, ctx.outputs.c_header]
+    ctx.action(
+        inputs = exports,
+        outputs = [ctx.outputs.c_header],
+        mnemonic = "GoCHeader",
+        executable = get_go_toolchain(ctx).copy_file,
+        arguments = [exports[0].path, ctx.outputs.c_header.path],
+    )
+
   emit_go_link_action(
     ctx,
-    transitive_go_libraries=lib_result.transitive_go_libraries,
-    transitive_go_library_paths=lib_result.transitive_go_library_paths,
+    transitive_go_libraries=transitive_go_libraries,
+    transitive_go_library_paths=transitive_go_library_paths,
     cgo_deps=lib_result.transitive_cgo_deps,
-    libs=lib_result.files,
-    executable=ctx.outputs.executable,
+    libs=libs,
+    executable=executable,
     gc_linkopts=gc_linkopts(ctx),
-    x_defs=ctx.attr.x_defs)
+    x_defs=ctx.attr.x_defs,
+    race=race,
+    env=pure_env(ctx),
+    static=static_enabled(ctx),
+    linkmode="pie" if pie_enabled(ctx) else ctx.attr.linkmode,
+    pluginpath=lib_result.importpath)
 
   return struct(
-      files = depset([ctx.outputs.executable]),
-      runfiles = lib_result.runfiles,
+      files = depset(files),
+      runfiles = runfiles,
+      output_groups = {"unused_deps": lib_result.unused_deps},
       cgo_object = lib_result.cgo_object,
+      go_plugin = struct(
+          file = executable,
+          goroot = get_go_toolchain(ctx).env["GOROOT"],
+          package_labels = go_package_labels(ctx),
+      ) if plugin else None,
+      providers = [
+          GoLibrary(label = ctx.label, importpath = lib_result.importpath, importmap = lib_result.importmap),
+          new_go_archive(lib_result),
+      ],
   )
 
+def _go_binary_outputs(linkmode, goos):
+  """Declares the library and header built by a go_binary with linkmode
+  "c-shared" or "c-archive", so they can be used in srcs of cc rules, the
+  plugin built with linkmode "plugin", and the WebAssembly module built for
+  js/wasm."""
+  if goos == "js":
+    return {"wasm": "%{name}.wasm"}
+  if linkmode == "c-shared":
+    return {
+        "c_library": "%{name}.so",
+        "c_header": "%{name}.h",
+    }
+  if linkmode == "c-archive":
+    return {
+        "c_library": "%{name}.a",
+        "c_header": "%{name}.h",
+    }
+  if linkmode == "plugin":
+    return {"plugin": "%{name}.so"}
+  return {}
+
 go_binary = rule(
     _go_binary_impl,
     attrs = {
@@ -47,12 +132,14 @@ go_binary = rule(
             cfg = "data",
         ),
         "srcs": attr.label_list(allow_files = go_filetype),
+        "embedsrcs": attr.label_list(allow_files = True),
         "deps": attr.label_list(
             providers = [
                 "transitive_go_library_paths",
                 "transitive_go_libraries",
                 "transitive_cgo_deps",
             ],
+            aspects = [go_cross_aspect],
         ),
         "importpath": attr.string(),
         "library": attr.label(
@@ -63,29 +150,242 @@ go_binary = rule(
                 "cgo_object",
                 "gc_goopts",
             ],
+            aspects = [go_cross_aspect],
+        ),
+        "embed": attr.label_list(
+            providers = [
+                "direct_deps",
+                "go_sources",
+                "asm_sources",
+                "cgo_object",
+                "gc_goopts",
+            ],
+            aspects = [go_cross_aspect],
         ),
         "gc_goopts": attr.string_list(),
+        "asmopts": attr.string_list(),
         "gc_linkopts": attr.string_list(),
         "linkstamp": attr.string(),
         "x_defs": attr.string_dict(),
-        #TODO(toolchains): Remove _toolchain attribute when real toolchains arrive
-        "_go_toolchain": attr.label(default = Label("@io_bazel_rules_go_toolchain//:go_toolchain")),
-        "_go_prefix": attr.label(default = Label(
-            "//:go_prefix",
-            relative_to_caller_repository = True,
-        )),
+        "pgo": attr.label(allow_single_file = True),
+        "race": attr.bool(),
+        "goos": attr.string(values = GOOS_VALUES),
+        "goarch": attr.string(values = GOARCH_VALUES),
+        "pure": attr.string(values = PURE_VALUES, default = "auto"),
+        "static": attr.string(values = STATIC_VALUES, default = "auto"),
+        "linkmode": attr.string(values = LINKMODE_VALUES, default = "normal"),
+        "plugins": attr.label_list(providers = ["go_plugin"]),
+        "_go_prefix": attr.label(default = go_prefix_default),
+        "_nogo": attr.label(
+            default = Label("@io_bazel_rules_nogo//:nogo"),
+            cfg = "host",
+        ),
+        "_pgo": attr.label(
+            default = Label("@io_bazel_rules_go_pgo//:pgo"),
+            allow_files = True,
+        ),
+        "_stamp": attr.label(default = Label("@io_bazel_rules_go//go/private:stamp")),
     },
+    toolchains = [go_toolchain_type],
     executable = True,
+    outputs = _go_binary_outputs,
     fragments = ["cpp"],
 )
 
-def c_linker_options(ctx, blacklist=[]):
+def go_binary_macro(name, linkmode = "normal", **kwargs):
+  """Builds a Go binary.
+
+  With linkmode = "c-archive", this also declares a cc_library named
+  <name>.cc that wraps the archive and its header. It carries the flags C and
+  C++ programs need to link the Go runtime, so cc rules can depend on it
+  instead of listing the archive in their srcs.
+
+  All arguments are passed to the underlying go_binary rule.
+  """
+  go_binary(
+      name = name,
+      linkmode = linkmode,
+      **kwargs
+  )
+  if linkmode != "c-archive":
+    return
+  native.cc_library(
+      name = name + ".cc",
+      srcs = [name + ".a"],
+      hdrs = [name + ".h"],
+      linkopts = select({
+          "@io_bazel_rules_go//go/platform:darwin_amd64": [
+              "-framework", "CoreFoundation",
+              "-framework", "Security",
+          ],
+          "//conditions:default": ["-pthread"],
+      }),
+      linkstatic = 1,
+      tags = kwargs.get("tags"),
+      visibility = kwargs.get("visibility"),
+  )
+
+def _go_cross_binary_impl(ctx):
+  """go_cross_binary_impl links a go_binary compiled for another platform by
+  go_cross_aspect."""
+  if not ctx.attr.goos and not ctx.attr.goarch:
+    fail("at least one of goos and goarch must be set")
+  if ctx.attr.goos == "js":
+    fail("js/wasm isn't supported; set goos and goarch on a go_binary instead", "goos")
+  if not getattr(ctx.attr.target, "go_cross_link", None):
+    fail("must be a go_binary", "target")
+  cross = ctx.attr.target.go_cross
+  link = ctx.attr.target.go_cross_link
+  emit_go_link_action(
+    ctx,
+    transitive_go_libraries=cross.transitive_go_libraries,
+    transitive_go_library_paths=cross.transitive_go_library_paths,
+    cgo_deps=cross.transitive_cgo_deps,
+    libs=[cross.library],
+    executable=ctx.outputs.executable,
+    gc_linkopts=[ctx.expand_make_variables("gc_linkopts", f, {})
+                 for f in link.gc_linkopts],
+    x_defs=link.x_defs,
+    env=pure_env(ctx))
+  return struct(
+      files = depset([ctx.outputs.executable]),
+      runfiles = ctx.attr.target.data_runfiles,
+  )
+
+go_cross_binary = rule(
+    _go_cross_binary_impl,
+    attrs = {
+        "target": attr.label(
+            mandatory = True,
+            aspects = [go_cross_aspect],
+        ),
+        "goos": attr.string(values = GOOS_VALUES),
+        "goarch": attr.string(values = GOARCH_VALUES),
+        "pure": attr.string(values = PURE_VALUES, default = "auto"),
+        "_stamp": attr.label(default = Label("@io_bazel_rules_go//go/private:stamp")),
+    },
+    toolchains = [go_toolchain_type],
+    executable = True,
+    fragments = ["cpp"],
+)
+"""Builds the go_binary in target for the platform in goos and goarch,
+without declaring another go_binary. The binary and everything it imports
+are compiled again for that platform, in pure mode, and linked with the
+binary's gc_linkopts and x_defs.
+"""
+
+def _go_tool_impl(ctx):
+  """go_tool_impl copies a go_binary built for the host, so it runs where the
+  actions using it do."""
+  binary = ctx.executable.binary
+  ctx.action(
+      inputs = [binary],
+      outputs = [ctx.outputs.executable],
+      executable = ctx.executable._copy_file,
+      arguments = [binary.path, ctx.outputs.executable.path],
+      mnemonic = "GoTool",
+  )
+  runfiles = ctx.runfiles(files = [ctx.outputs.executable])
+  return struct(
+      files = depset([ctx.outputs.executable]),
+      runfiles = runfiles.merge(ctx.attr.binary.default_runfiles),
+  )
+
+go_tool = rule(
+    _go_tool_impl,
+    attrs = {
+        "binary": attr.label(
+            mandatory = True,
+            executable = True,
+            cfg = "host",
+            providers = [GoLibrary],
+        ),
+        "_copy_file": attr.label(
+            default = Label("@io_bazel_rules_go//go/tools/builders:copy_file"),
+            executable = True,
+            cfg = "host",
+        ),
+    },
+    executable = True,
+)
+"""Builds the go_binary in binary for the host, the platform actions are
+executed on, as an executable that other rules can run while they build:
+in the tools of a genrule, or in an attribute of a rule that doesn't build
+it for the host. Generators like stringer and mockgen, from go_repository
+rules or the workspace, run from the build graph rather than from PATH.
+"""
+
+def wasm_enabled(ctx):
+  """Returns whether the binary or test being built is compiled to
+  WebAssembly, to be run by a JavaScript host."""
+  return getattr(ctx.attr, "goos", "") == "js"
+
+def emit_wasm_launcher(ctx, wasm):
+  """Writes the executable of a binary or test compiled to WebAssembly: a
+  script that runs the module wasm with Node.js, which must be on PATH, using
+  the wasm_exec.js support file from the SDK. Returns runfiles containing
+  both.
+  """
+  if ctx.attr.goarch != "wasm":
+    fail("goos \"js\" is only supported with goarch \"wasm\"", "goarch")
+  if ctx.attr.linkmode != "normal":
+    fail("linkmode \"%s\" is not supported for js/wasm" % ctx.attr.linkmode, "linkmode")
+  wasm_exec = get_go_toolchain(ctx).wasm_exec
+  if not wasm_exec:
+    fail("js/wasm requires Go 1.11 or later, whose SDK has misc/wasm/wasm_exec.js", "goos")
+  ctx.file_action(
+      output = ctx.outputs.executable,
+      content = _wasm_launcher % (ctx.workspace_name, wasm_exec[0].short_path, wasm.short_path),
+      executable = True,
+  )
+  return ctx.runfiles(files = [wasm] + wasm_exec)
+
+_wasm_launcher = """#!/bin/sh
+RUNFILES="${TEST_SRCDIR:-${RUNFILES_DIR:-$0.runfiles}}/%s"
+exec node "$RUNFILES/%s" "$RUNFILES/%s" "$@"
+"""
+
+def plugin_runfiles(ctx):
+  """Checks that the plugins in the plugins attribute of the binary or test
+  being built can be loaded by it, and returns runfiles containing them.
+
+  The plugin package refuses to load a plugin built with a different Go
+  toolchain, or with a different version of a package the program also
+  uses, so this is checked when building instead. Packages are compared by
+  the label of the library that provides them.
+  """
+  plugins = getattr(ctx.attr, "plugins", [])
+  if not plugins:
+    return ctx.runfiles()
+  if pure_env(ctx):
+    fail("the plugin package requires cgo, which is disabled in pure mode and when cross-compiling", "plugins")
+  if race_enabled(ctx):
+    fail("race detector is not supported for plugins", "plugins")
+  goroot = get_go_toolchain(ctx).env["GOROOT"]
+  labels = go_package_labels(ctx)
+  files = []
+  for plugin in plugins:
+    if not plugin.go_plugin:
+      fail("%s is not a plugin. It must be built with linkmode = \"plugin\"" % plugin.label, "plugins")
+    if plugin.go_plugin.goroot != goroot:
+      fail("%s was built with the Go toolchain in %s, but %s uses the one in %s" %
+           (plugin.label, plugin.go_plugin.goroot, ctx.label, goroot), "plugins")
+    for importpath, label in plugin.go_plugin.package_labels.items():
+      if importpath in labels and labels[importpath] != label:
+        fail("%s and %s must use the same version of %s, but they depend on %s and %s" %
+             (plugin.label, ctx.label, importpath, label, labels[importpath]), "plugins")
+    files += [plugin.go_plugin.file]
+  return ctx.runfiles(files = files)
+
+def c_linker_options(ctx, blacklist=[], static=False):
   """Extracts flags to pass to $(CC) on link from the current context
 
   Args:
     ctx: the current context
     blacklist: Any flags starts with any of these prefixes are filtered out from
       the return value.
+    static: If True, the C++ toolchain's options for fully static executables
+      are used instead of its default ones.
 
   Returns:
     A list of command line flags
@@ -95,7 +395,10 @@ def c_linker_options(ctx, blacklist=[]):
   options = cpp.compiler_options(features)
   options += cpp.unfiltered_compiler_options(features)
   options += cpp.link_options
-  options += cpp.mostly_static_link_options(ctx.features, False)
+  if static:
+    options += cpp.fully_static_link_options(features, False)
+  else:
+    options += cpp.mostly_static_link_options(features, False)
   filtered = []
   for opt in options:
     if any([opt.startswith(prefix) for prefix in blacklist]):
@@ -103,6 +406,24 @@ def c_linker_options(ctx, blacklist=[]):
     filtered.append(opt)
   return filtered
 
+# Values accepted by the static attribute of go_binary and go_test. "auto"
+# means the executable is linked statically only with --features=static.
+STATIC_VALUES = [
+    "auto",
+    "off",
+    "on",
+]
+
+def static_enabled(ctx):
+  """Returns whether the binary or test being built is linked statically:
+  either its static attribute is "on", or it's "auto" and --features=static
+  or --features=fully_static_link, the C++ rules' name for it, was passed.
+  """
+  static = getattr(ctx.attr, "static", "auto")
+  if static == "auto":
+    return "static" in ctx.features or "fully_static_link" in ctx.features
+  return static == "on"
+
 def gc_linkopts(ctx):
   gc_linkopts = [ctx.expand_make_variables("gc_linkopts", f, {})
                  for f in ctx.attr.gc_linkopts]
@@ -132,43 +453,139 @@ def _extract_extldflags(gc_linkopts, extldflags):
       filtered_gc_linkopts += [opt]
   return filtered_gc_linkopts, extldflags
 
+def _go_stamp_setting_impl(ctx):
+  return struct(stamp = ctx.attr.stamp)
+
+go_stamp_setting = rule(
+    _go_stamp_setting_impl,
+    attrs = {
+        "stamp": attr.bool(),
+    },
+)
+"""Provides whether --stamp was passed, for the _stamp attribute of binaries
+and tests. It's set with a select in //go/private:stamp, since rules can't
+read the flag otherwise.
+"""
+
+def stamp_enabled(ctx):
+  """Returns whether the binary or test being built is stamped with workspace
+  status values, which is the case when it's built with --stamp.
+  """
+  stamp = getattr(ctx.attr, "_stamp", None)
+  return stamp != None and stamp.stamp
+
 def emit_go_link_action(ctx, transitive_go_library_paths, transitive_go_libraries, cgo_deps, libs,
-                         executable, gc_linkopts, x_defs):
-  """Sets up a symlink tree to libraries to link together."""
+                         executable, gc_linkopts, x_defs, race=False, env=None, static=False,
+                         linkmode="normal", pluginpath=None):
+  """Links libraries together into an executable, or into the library or
+  plugin linkmode asks for.
+
+  If race is True, the executable is linked with -race. All of the libraries
+  must have been compiled with -race.
+
+  If env is set, it's the environment for the linker, from pure_env. All of
+  the libraries must have been compiled for the same platform.
+
+  If static is True, the executable is linked statically, so it can run
+  without a C library, for example in a container built from scratch. Without
+  cgo, the Go linker always does this by itself. Otherwise, the external
+  linker is used with -static and the C++ toolchain's options for fully
+  static executables, since the Go linker can't link C code statically. The
+  external linker is always the C++ toolchain's compiler, so a toolchain for
+  musl, or one that runs zig cc, links the C library it was configured with.
+
+  If linkmode is "c-shared" or "c-archive", a shared library or a static
+  archive is linked instead of an executable, with exported functions C code
+  can call. If it's "plugin", a Go plugin is linked, identified by
+  pluginpath. If it's "pie", a position-independent executable is linked
+  with the external linker. All of the libraries must have been compiled for
+  that mode.
+  """
   go_toolchain = get_go_toolchain(ctx)
+  pure = env and env.get("CGO_ENABLED") == "0"
+  if static and (env or go_toolchain.env)["GOOS"] == "darwin":
+    fail("statically linked executables are not supported on darwin", "static")
+  if linkmode == "plugin" and go_toolchain.env["GOOS"] != "linux":
+    fail("plugins are only supported on linux", "linkmode")
+  if linkmode == "pie" and go_toolchain.env["GOOS"] != "linux":
+    fail("position-independent executables are only supported on linux", "linkmode")
   config_strip = len(ctx.configuration.bin_dir.path) + 1
   pkg_depth = executable.dirname[config_strip:].count('/') + 1
 
   ld = "%s" % ctx.fragments.cpp.compiler_executable
-  extldflags = c_linker_options(ctx) + [
-      "-Wl,-rpath,$ORIGIN/" + ("../" * pkg_depth),
-  ]
+  extldflags = c_linker_options(ctx, static=static)
+  if not static:
+    extldflags += ["-Wl,-rpath,$ORIGIN/" + ("../" * pkg_depth)]
   for d in cgo_deps:
     if d.basename.endswith('.so'):
       short_dir = d.dirname[len(d.root.path):]
+      if static:
+        fail("%s is a shared library, which can't be linked statically" % d.short_path, "static")
       extldflags += ["-Wl,-rpath,$ORIGIN/" + ("../" * pkg_depth) + short_dir]
 
+  # Flags passed with --define=gc_linkopts=... are added for every link.
+  gc_linkopts = gc_linkopts + define_flags(ctx, "gc_linkopts")
   gc_linkopts, extldflags = _extract_extldflags(gc_linkopts, extldflags)
+  san = sanitizer(ctx)
+  if san and race:
+    fail("%s can't be used together with the race detector" % san, "race")
+  if san:
+    # Like the go tool, link the sanitizer's runtime with the external
+    # linker, which the Go linker always uses with -msan and -asan.
+    extldflags += ["-fsanitize=memory" if san == "msan" else "-fsanitize=address"]
 
+  stdlib = get_stdlib(ctx, env=env, race=race,
+      shared=linkmode in ["c-shared", "c-archive", "pie"],
+      dynlink=linkmode == "plugin")
   link_opts = [
-      "-L", "."
+      "-L", ".",
+      "-L", stdlib.searchpath,
   ]
   for path in transitive_go_library_paths:
     link_opts += ["-L", path]
   link_opts += [
       "-o", executable.path,
   ] + gc_linkopts
+  if race:
+    link_opts += ["-race"]
+  if san:
+    link_opts += ["-" + san]
+  if pure:
+    link_opts += ["-installsuffix", "pure"]
+  if static and not pure:
+    link_opts += ["-linkmode", "external"]
+    if "-static" not in extldflags:
+      extldflags += ["-static"]
+  if linkmode != "normal":
+    if static:
+      fail("linkmode \"%s\" can't be combined with static linking" % linkmode, "static")
+    link_opts += ["-buildmode", linkmode]
+  if linkmode == "plugin":
+    link_opts += ["-installsuffix", "dynlink", "-pluginpath", pluginpath]
+  elif linkmode != "normal":
+    link_opts += ["-installsuffix", "shared"]
+  if linkmode == "pie":
+    # The Go linker can't link position-independent executables itself. It
+    # passes -pie to the external linker.
+    link_opts += ["-linkmode", "external"]
 
   # Process x_defs, either adding them directly to linker options, or
-  # saving them to process through stamping support.
+  # saving them to process through stamping support. Values that refer to
+  # workspace status keys, like {BUILD_SCM_REVISION}, are expanded by the
+  # link builder. Without --stamp, it isn't given the status files unless
+  # linkstamp is set, so it leaves those variables unset.
   stamp_x_defs = {}
   for k, v in x_defs.items():
-    if v.startswith("{") and v.endswith("}"):
-      stamp_x_defs[k] = v[1:-1]
+    if "{" in v:
+      stamp_x_defs[k] = v
     else:
       link_opts += ["-X", "%s=%s" % (k, v)]
 
-  link_opts += go_toolchain.link_flags + [
+  link_flags = go_toolchain.link_flags
+  if debug_enabled(ctx):
+    # Keep the symbol table and DWARF, which the toolchain may strip.
+    link_flags = [f for f in link_flags if f not in ("-s", "-w")]
+  link_opts += link_flags + [
       "-extld", ld,
       "-extldflags", " ".join(extldflags),
   ] + [lib.path for lib in libs]
@@ -176,26 +593,28 @@ def emit_go_link_action(ctx, transitive_go_library_paths, transitive_go_librarie
   link_args = [go_toolchain.go.path]
   # Stamping support
   stamp_inputs = []
-  if stamp_x_defs or ctx.attr.linkstamp:
+  linkstamp = getattr(ctx.attr, "linkstamp", "")
+  for k,v in stamp_x_defs.items():
+    link_args += ["-X", "%s=%s" % (k, v)]
+  if (stamp_x_defs and stamp_enabled(ctx)) or linkstamp:
     stamp_inputs = [ctx.info_file, ctx.version_file]
     for f in stamp_inputs:
       link_args += ["-stamp", f.path]
-    for k,v in stamp_x_defs.items():
-      link_args += ["-X", "%s=%s" % (k, v)]
     # linkstamp option support: read workspace status files,
     # converting "KEY value" lines to "-X $linkstamp.KEY=value" arguments
     # to the go linker.
-    if ctx.attr.linkstamp:
-      link_args += ["-linkstamp", ctx.attr.linkstamp]
+    if linkstamp:
+      link_args += ["-linkstamp", linkstamp]
 
   link_args += ["--"] + link_opts
+  params = emit_params_file(ctx, executable, link_args)
 
   ctx.action(
       inputs = list(transitive_go_libraries + [lib] + cgo_deps +
-                go_toolchain.tools + go_toolchain.crosstool + stamp_inputs),
+                go_toolchain.tools + go_toolchain.crosstool + stamp_inputs) + stdlib.files + [params],
       outputs = [executable],
       mnemonic = "GoLink",
       executable = go_toolchain.link,
-      arguments = link_args,
-      env = go_toolchain.env,
+      arguments = ["@" + params.path],
+      env = env or go_toolchain.env,
   )
\ No newline at end of file
@@ -12,8 +12,9 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "emit_generate_params_action", "go_filetype", "cgo_filetype", "cc_hdr_filetype", "hdr_exts", "pkg_dir")
+load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "go_minor_at_least", "go_toolchain_type", "emit_generate_params_action", "emit_params_file", "go_filetype", "cgo_filetype", "cc_hdr_filetype", "hdr_exts", "pkg_dir")
 load("@io_bazel_rules_go//go/private:library.bzl", "go_library")
+load("@io_bazel_rules_go//go/private:test.bzl", "go_test")
 load("@io_bazel_rules_go//go/private:binary.bzl", "c_linker_options")
 
 def cgo_genrule(tags=[], **kwargs):
@@ -74,6 +75,93 @@ def cgo_library(name, srcs,
       **kwargs
   )
 
+def go_library_macro(name, srcs=None, cgo=False, cdeps=[], copts=[], clinkopts=[], **kwargs):
+  """Builds a Go library, optionally with cgo.
+
+  Args:
+    name: A unique name for this rule.
+    srcs: List of Go, assembly, C and C++ files that are processed to build a
+      Go library. C and C++ files are only allowed when cgo is True.
+    cgo: If True, the library may contain cgo code. Go files that import "C"
+      are processed by cgo; other Go files are compiled as usual. C and C++
+      files are built with the C/C++ toolchain and linked into the library.
+    cdeps: List of C/C++ libraries to be linked into the binary target.
+      They must be `cc_library` rules. Only valid when cgo is True.
+    copts: Add these flags to the C++ compiler. Only valid when cgo is True.
+    clinkopts: Add these flags to the C++ linker. Only valid when cgo is True.
+
+  All other arguments are passed to the underlying go_library rule. This
+  replaces the older pattern of a cgo_library and a go_library with a library
+  attribute pointing to it.
+  """
+  if not cgo:
+    if cdeps or copts or clinkopts:
+      fail("cdeps, copts, and clinkopts may only be set when cgo = True", "cgo")
+    go_library(
+        name = name,
+        srcs = srcs,
+        **kwargs
+    )
+    return
+
+  cgogen = _setup_cgo_library(
+      name = name,
+      srcs = srcs,
+      cdeps = cdeps,
+      copts = copts,
+      clinkopts = clinkopts,
+  )
+
+  go_library(
+      name = name,
+      srcs = cgogen.go_srcs,
+      cgo_object = cgogen.cgo_object,
+      **kwargs
+  )
+
+def go_test_macro(name, srcs=None, cgo=False, cdeps=[], copts=[], clinkopts=[], **kwargs):
+  """Builds a Go test, optionally with cgo.
+
+  Args:
+    name: A unique name for this rule.
+    srcs: List of Go, C and C++ files that are processed to build a Go test.
+      C and C++ files are only allowed when cgo is True.
+    cgo: If True, the test may contain cgo code. Go files that import "C"
+      are processed by cgo; other Go files are compiled as usual.
+    cdeps: List of C/C++ libraries to be linked into the test. They must be
+      `cc_library` rules. Only valid when cgo is True.
+    copts: Add these flags to the C++ compiler. Only valid when cgo is True.
+    clinkopts: Add these flags to the C++ linker. Only valid when cgo is True.
+
+  All other arguments are passed to the underlying go_test rule. A test that
+  uses cgo can't embed a library that also uses cgo, since cgo can only
+  process a package once. List the library's sources in srcs instead.
+  """
+  if not cgo:
+    if cdeps or copts or clinkopts:
+      fail("cdeps, copts, and clinkopts may only be set when cgo = True", "cgo")
+    go_test(
+        name = name,
+        srcs = srcs,
+        **kwargs
+    )
+    return
+
+  cgogen = _setup_cgo_library(
+      name = name,
+      srcs = srcs,
+      cdeps = cdeps,
+      copts = copts,
+      clinkopts = clinkopts,
+  )
+
+  go_test(
+      name = name,
+      srcs = cgogen.go_srcs,
+      cgo_object = cgogen.cgo_object,
+      **kwargs
+  )
+
 def _cgo_select_go_files_impl(ctx):
   return struct(files = ctx.attr.dep.go_files)
 
@@ -84,15 +172,29 @@ def _cgo_select_main_c_impl(ctx):
 
 _cgo_select_main_c = rule(_cgo_select_main_c_impl, attrs = {"dep": attr.label()})
 
+def _cgo_select_objc_files_impl(ctx):
+  return struct(files = ctx.attr.dep.objc_files)
+
+_cgo_select_objc_files = rule(_cgo_select_objc_files_impl, attrs = {"dep": attr.label()})
+
+def _cgo_select_objcxx_files_impl(ctx):
+  return struct(files = ctx.attr.dep.objcxx_files)
+
+_cgo_select_objcxx_files = rule(_cgo_select_objcxx_files_impl, attrs = {"dep": attr.label()})
+
 def _cgo_codegen_impl(ctx):
   go_toolchain = get_go_toolchain(ctx)
   srcs = ctx.files.srcs
   go_srcs = [src for src in srcs if src.basename.endswith(".go")]
   c_hdrs = [src for src in srcs if any([src.basename.endswith(ext) for ext in hdr_exts])]
-  other_srcs = [src for src in srcs if not src in go_srcs and not src in c_hdrs]
+  src_hdrs = list(c_hdrs)
+  syso_srcs = [src for src in srcs if src.basename.endswith(".syso")]
+  other_srcs = [src for src in srcs
+                if not src in go_srcs and not src in c_hdrs and not src in syso_srcs]
   linkopts = ctx.attr.linkopts
   copts = ctx.fragments.cpp.c_options + ctx.attr.copts
   deps = set([], order="link")
+  dep_hdrs = []
   cgo_export_h = ctx.new_file(ctx.attr.out_dir + "/_cgo_export.h")
   cgo_export_c = ctx.new_file(ctx.attr.out_dir + "/_cgo_export.c")
   cgo_main = ctx.new_file(ctx.attr.out_dir + "/_cgo_main.c")
@@ -100,14 +202,18 @@ def _cgo_codegen_impl(ctx):
 
   c_hdrs += [cgo_export_h]
   c_outs = depset(c_hdrs) + [cgo_export_c]
-  go_outs = depset([cgo_types])
+  objc_outs = depset(c_hdrs)
+  objcxx_outs = depset(c_hdrs)
+  # .syso files are passed through to the Go library, which packs them
+  # into its archive.
+  go_outs = depset([cgo_types] + syso_srcs)
   outputs = [ cgo_export_h, cgo_export_c, cgo_main, cgo_types]
   out_dir = cgo_main.dirname
 
   for hdr in c_hdrs:
     copts += ['-iquote', hdr.dirname]
   for d in ctx.attr.deps:
-    srcs += list(d.cc.transitive_headers)
+    dep_hdrs += list(d.cc.transitive_headers)
     deps += d.cc.libs
     copts += ['-D' + define for define in d.cc.defines]
     for inc in d.cc.include_directories:
@@ -123,6 +229,13 @@ def _cgo_codegen_impl(ctx):
         linkopts += [lib.path]
     linkopts += d.cc.link_flags
 
+  # The paths cgo writes in line directives are relative to the execution
+  # root, like the ones the compiler records. cgo only trims paths since
+  # Go 1.13.
+  cgo_trimpath = ""
+  if go_minor_at_least(go_toolchain.go_minor, 13):
+    cgo_trimpath = '-trimpath "$execroot" '
+
   cc = ctx.fragments.cpp.compiler_executable
   cmds = [
       # We cannot use env for CC because $(CC) on OSX is relative
@@ -131,14 +244,18 @@ def _cgo_codegen_impl(ctx):
       'export CXX=$CC',
       "objdir='%s'" % out_dir,
       'execroot=$(pwd)',
+      # GOROOT is relative to the execution root.
+      'case "$GOROOT" in /*) ;; *) export GOROOT="$execroot/$GOROOT" ;; esac',
       'mkdir -p "$objdir"',
       # Apply build constraints to .go sources before passing them to cgo.
       # We have to do this here because _cgo_filter_srcs creates empty files
       # which lack package declarations.
       'unfiltered_go_files=(%s)' % ' '.join(["'%s'" % f.path for f in go_srcs]),
       'filtered_go_files=()',
+      # Files that don't import "C" are not passed to cgo. They are copied
+      # into place below.
       'for file in "${unfiltered_go_files[@]}"; do',
-      '  if %s -cgo -quiet "$file"; then' % go_toolchain.filter_tags.path,
+      '  if %s -cgo -import_c -quiet "$file"; then' % go_toolchain.filter_tags.path,
       '    filtered_go_files+=("$file")',
       '  fi',
       'done',
@@ -146,8 +263,8 @@ def _cgo_codegen_impl(ctx):
       '  echo no buildable Go source files in %s >&1' % str(ctx.label),
       '  exit 1',
       'fi',
-      '"$GOROOT/bin/go" tool cgo -objdir "$objdir" -- %s "${filtered_go_files[@]}"' %
-          ' '.join(['"%s"' % copt for copt in copts]),
+      '"$GOROOT/bin/go" tool cgo %s-objdir "$objdir" -- %s "${filtered_go_files[@]}"' %
+          (cgo_trimpath, ' '.join(['"%s"' % copt for copt in copts])),
       'rm -rf "$objdir"/{_cgo_.o,_cgo_flags}',
   ]
 
@@ -164,6 +281,9 @@ def _cgo_codegen_impl(ctx):
       "if [ -f '%s' ]; then" % (mangled_stem + ".cgo1.go"),
       "  mv '%s' '%s'" % (mangled_stem + ".cgo1.go", gen_go_file.path),
       "  mv '%s' '%s'" % (mangled_stem + ".cgo2.c", gen_c_file.path),
+      "elif '%s' -cgo -quiet '%s'; then" % (go_toolchain.filter_tags.path, s.path),
+      "  cp '%s' '%s'" % (s.path, gen_go_file.path),
+      "  echo -n >'%s'" % gen_c_file.path,
       "else",
       "  grep --max-count=1 '^package ' '%s' >'%s'" % (s.path, gen_go_file.path),
       "  echo -n >'%s'" % gen_c_file.path,
@@ -173,23 +293,38 @@ def _cgo_codegen_impl(ctx):
     c_outs += [gen_c_file]
     go_outs += [gen_go_file]
 
+  # Build constraints are applied to the other sources in actions of their
+  # own. cgo doesn't read them, so editing one doesn't run cgo again.
   for src in other_srcs:
     base, _, ext = src.basename.rpartition(".")
+    # cc_library doesn't accept Objective-C sources, so they are copied with
+    # C or C++ extensions and compiled in separate libraries with -x.
+    if ext == "m":
+      ext = "m.c"
+    elif ext == "mm":
+      ext = "mm.cc"
     dst = ctx.new_file(base + "." + ctx.label.name +"." + ext)
-    cmds += [
-      "if '%s' -cgo -quiet '%s'; then" %
-          (go_toolchain.filter_tags.path, src.path),
-      "  cp '%s' '%s'" % (src.path, dst.path),
-      "else",
-      "  echo -n >'%s'" % dst.path,
-      "fi",
-    ]
-    outputs += [dst]
-    c_outs += [dst]
+    params = emit_params_file(ctx, dst, ["-cgo", "-copy", dst.path, src.path])
+    ctx.action(
+        inputs = [src, params],
+        outputs = [dst],
+        mnemonic = "CGoFilter",
+        executable = go_toolchain.filter_tags,
+        arguments = ["@" + params.path],
+        env = go_toolchain.env,
+    )
+    if ext == "m.c":
+      objc_outs += [dst]
+    elif ext == "mm.cc":
+      objcxx_outs += [dst]
+    else:
+      c_outs += [dst]
 
   f = emit_generate_params_action(cmds, ctx, ctx.label.name + ".CGoCodeGenFile.params")
 
-  inputs = srcs + go_toolchain.tools + go_toolchain.crosstool + [f, go_toolchain.filter_tags]
+  # cgo only reads the Go files, and the headers their preambles include.
+  inputs = (go_srcs + src_hdrs + dep_hdrs + go_toolchain.tools +
+            go_toolchain.crosstool + [f, go_toolchain.filter_tags])
   ctx.action(
       inputs = inputs,
       outputs = outputs,
@@ -203,9 +338,12 @@ def _cgo_codegen_impl(ctx):
   return struct(
       label = ctx.label,
       files = c_outs,
+      objc_files = objc_outs,
+      objcxx_files = objcxx_outs,
       go_files = go_outs,
       main_c = depset([cgo_main]),
       cgo_deps = deps,
+      cgo_exports = depset([cgo_export_h]),
   )
 
 _cgo_codegen_rule = rule(
@@ -219,15 +357,15 @@ _cgo_codegen_rule = rule(
         "copts": attr.string_list(),
         "linkopts": attr.string_list(),
         "out_dir": attr.string(mandatory = True),
-        #TODO(toolchains): Remove _toolchain attribute when real toolchains arrive
-        "_go_toolchain": attr.label(default = Label("@io_bazel_rules_go_toolchain//:go_toolchain")),
     },
+    toolchains = [go_toolchain_type],
     fragments = ["cpp"],
 )
 
 def _cgo_import_impl(ctx):
   go_toolchain = get_go_toolchain(ctx)
   cmds = [
+      'case "$GOROOT" in /*) ;; *) export GOROOT="$(pwd)/$GOROOT" ;; esac',
       (go_toolchain.go.path + " tool cgo" +
        " -dynout " + ctx.outputs.out.path +
        " -dynimport " + ctx.file.cgo_o.path +
@@ -259,9 +397,8 @@ _cgo_import = rule(
         "out": attr.output(
             mandatory = True,
         ),
-        #TODO(toolchains): Remove _toolchain attribute when real toolchains arrive
-        "_go_toolchain": attr.label(default = Label("@io_bazel_rules_go_toolchain//:go_toolchain")),
     },
+    toolchains = [go_toolchain_type],
     fragments = ["cpp"],
 )
 
@@ -288,10 +425,16 @@ def _cgo_object_impl(ctx):
   ] + go_toolchain.cgo_link_flags
 
   lo = ctx.files.src[-1]
-  arguments += [lo.path]
+  los = [lo]
+  # Objective-C libraries are empty when there are no such sources.
+  for lib in ctx.attr.objc_libs:
+    files = lib.files.to_list()
+    if files:
+      los += [files[-1]]
+  arguments += [f.path for f in los]
 
   ctx.action(
-      inputs = [lo] + go_toolchain.crosstool,
+      inputs = los + go_toolchain.crosstool,
       outputs = [ctx.outputs.out],
       mnemonic = "CGoObject",
       progress_message = "Linking %s" % ctx.outputs.out.short_path,
@@ -304,6 +447,7 @@ def _cgo_object_impl(ctx):
       files = set([ctx.outputs.out]),
       cgo_obj = ctx.outputs.out,
       cgo_deps = ctx.attr.cgogen.cgo_deps,
+      cgo_exports = ctx.attr.cgogen.cgo_exports,
       runfiles = runfiles,
   )
 
@@ -314,16 +458,18 @@ _cgo_object = rule(
             mandatory = True,
             providers = ["cc"],
         ),
+        "objc_libs": attr.label_list(
+            providers = ["cc"],
+        ),
         "cgogen": attr.label(
             mandatory = True,
-            providers = ["cgo_deps"],
+            providers = ["cgo_deps", "cgo_exports"],
         ),
         "out": attr.output(
             mandatory = True,
         ),
-        #TODO(toolchains): Remove _toolchain attribute when real toolchains arrive
-        "_go_toolchain": attr.label(default = Label("@io_bazel_rules_go_toolchain//:go_toolchain")),
     },
+    toolchains = [go_toolchain_type],
     fragments = ["cpp"],
 )
 
@@ -354,13 +500,20 @@ def _setup_cgo_library(name, srcs, cdeps, copts, clinkopts):
       "external/" + REPOSITORY_NAME[1:] if len(REPOSITORY_NAME) > 1 else "",
       PACKAGE_NAME)
   copts += ["-I", base_dir]
+  # With --features=msan or --features=asan, Go code is compiled with -msan
+  # or -asan, and the C code it calls must be instrumented the same way.
+  sanitizer_opts = select({
+      "@io_bazel_rules_go//go/private:msan": ["-fsanitize=memory"],
+      "@io_bazel_rules_go//go/private:asan": ["-fsanitize=address"],
+      "//conditions:default": [],
+  })
 
   cgo_codegen_name = name + ".cgo_codegen"
   _cgo_codegen_rule(
       name = cgo_codegen_name,
       srcs = srcs,
       deps = cdeps,
-      copts = copts,
+      copts = copts + sanitizer_opts,
       linkopts = clinkopts,
       out_dir = cgo_codegen_dir,
       visibility = ["//visibility:private"],
@@ -386,14 +539,42 @@ def _setup_cgo_library(name, srcs, cdeps, copts, clinkopts):
   platform_copts = select({
       "@io_bazel_rules_go//go/platform:windows_amd64": ["-mthreads"],
       "//conditions:default": ["-pthread"],
-  })
+  }) + sanitizer_opts
   platform_linkopts = platform_copts
 
+  # Objective-C and Objective-C++ sources are compiled in their own
+  # libraries, since the language must be set with -x. These libraries are
+  # empty if there are no such sources.
+  objc_lib_names = []
+  for suffix, lang, select_rule in [
+      ("objc", "objective-c", _cgo_select_objc_files),
+      ("objcxx", "objective-c++", _cgo_select_objcxx_files)]:
+    select_name = name + ".select_" + suffix + "_files"
+    select_rule(
+        name = select_name,
+        dep = cgo_codegen_name,
+        visibility = ["//visibility:private"],
+    )
+    objc_lib_name = name + ".cgo_" + suffix + "_lib"
+    native.cc_library(
+        name = objc_lib_name,
+        srcs = [select_name],
+        deps = cdeps,
+        copts = copts + platform_copts + [
+            "-I", "$(BINDIR)/" + base_dir + "/" + cgo_codegen_dir,
+            "-x", lang,
+        ],
+        linkstatic = 1,
+        alwayslink = 1,
+        visibility = ["//visibility:private"],
+    )
+    objc_lib_names += [objc_lib_name]
+
   cgo_lib_name = name + ".cgo_c_lib"
   native.cc_library(
       name = cgo_lib_name,
       srcs = [cgo_codegen_name],
-      deps = cdeps,
+      deps = cdeps + objc_lib_names,
       copts = copts + platform_copts + [
           "-I", "$(BINDIR)/" + base_dir + "/" + cgo_codegen_dir,
           # The generated thunks often contain unused variables.
@@ -415,8 +596,8 @@ def _setup_cgo_library(name, srcs, cdeps, copts, clinkopts):
       name = cgo_o_name,
       srcs = [select_main_c],
       deps = cdeps + [cgo_lib_name],
-      copts = copts,
-      linkopts = clinkopts,
+      copts = copts + sanitizer_opts,
+      linkopts = clinkopts + sanitizer_opts,
       visibility = ["//visibility:private"],
   )
 
@@ -436,6 +617,7 @@ def _setup_cgo_library(name, srcs, cdeps, copts, clinkopts):
   _cgo_object(
       name = all_o_name,
       src = cgo_lib_name,
+      objc_libs = objc_lib_names,
       out = cgo_codegen_dir + "/_all.o",
       cgogen = cgo_codegen_name,
       visibility = ["//visibility:private"],
@@ -16,11 +16,22 @@ DEFAULT_LIB = "go_default_library"
 
 VENDOR_PREFIX = "/vendor/"
 
+def go_prefix_default(importpath):
+  """Computes the default value of the _go_prefix attribute.
+
+  Rules with an explicit importpath don't need a go_prefix rule, so it is
+  only required when importpath is not set.
+  """
+  return (None
+          if importpath
+          else Label("//:go_prefix", relative_to_caller_repository = True))
+
 go_filetype = FileType([
     ".go",
     ".s",
     ".S",
     ".h",  # may be included by .s
+    ".syso",
 ])
 
 # be consistent to cc_library.
@@ -42,16 +53,53 @@ cgo_filetype = FileType([
     ".cc",
     ".cxx",
     ".cpp",
+    ".m",
+    ".mm",
     ".s",
     ".S",
     ".h",
     ".hh",
     ".hpp",
     ".hxx",
+    ".syso",
 ])
 
+# The toolchain types the Go rules depend on. Toolchains of these types are
+# declared in //go/toolchain and registered by go_register_toolchains.
+go_toolchain_type = "@io_bazel_rules_go//go:toolchain"
+go_bootstrap_toolchain_type = "@io_bazel_rules_go//go:bootstrap_toolchain"
+
 def get_go_toolchain(ctx):
-    return ctx.attr._go_toolchain #TODO(toolchains): ctx.toolchains[go_toolchain_type]
+  return ctx.toolchains[go_toolchain_type]
+
+def go_minor_at_least(go_minor, minor):
+  """Returns whether an SDK whose repository recorded go_minor as its minor
+  version is Go 1.<minor> or later. Development versions record 0, and are
+  newer than every release."""
+  return go_minor == 0 or go_minor >= minor
+
+def shared_codegen_flags(go_toolchain):
+  """Returns the flags for the compiler and assembler that produce
+  position-independent code for a c-shared or c-archive library, or
+  a position-independent executable. Like the go tool, no flags are needed
+  on darwin, where all code is position-independent."""
+  if go_toolchain.env["GOOS"] == "darwin":
+    return []
+  return ["-shared"]
+
+def define_flags(ctx, name):
+  """Returns the flags passed with --define=<name>=<flags>, split on spaces.
+  They're added to the flags of every Go target in the build, for example
+  --define=gc_goopts=-m to see the compiler's escape analysis decisions."""
+  return [flag for flag in ctx.var.get(name, "").split(" ") if flag]
+
+def emit_params_file(ctx, sibling, args):
+  """Writes args to a file next to sibling, one per line, and returns it.
+  Builders read arguments from it when given @file. Windows limits the length
+  of command lines, which actions with many sources or dependencies exceed."""
+  params = ctx.new_file(sibling, sibling.basename + ".params")
+  ctx.file_action(output = params, content = "\n".join(args) + "\n")
+  return params
 
 def emit_generate_params_action(cmds, ctx, fn):
   cmds_all = [
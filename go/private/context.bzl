@@ -0,0 +1,104 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:binary.bzl", "emit_go_link_action")
+load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain")
+load("@io_bazel_rules_go//go/private:library.bzl", "emit_library_actions", "go_library_struct", "new_go_archive")
+load("@io_bazel_rules_go//go/private:providers.bzl", "GoLibrary")
+load("@io_bazel_rules_go//go/private:source.bzl", "new_go_source")
+
+def _new_library(go, importpath, importmap=None):
+  return GoLibrary(label = go._ctx.label, importpath = importpath, importmap = importmap or importpath)
+
+def _library_to_source(go, srcs=[], embedsrcs=[], deps=[], embed=[], gc_goopts=[], asmopts=[]):
+  return new_go_source(go._ctx,
+      srcs = srcs,
+      embedsrcs = embedsrcs,
+      deps = deps,
+      embed = embed,
+      gc_goopts = gc_goopts,
+      asmopts = asmopts,
+  )
+
+def _archive(go, library, source):
+  lib_result = emit_library_actions(go._ctx,
+      sources = source.go_sources + source.asm_sources + source.asm_headers + source.syso_sources,
+      deps = source.deps,
+      cgo_object = source.cgo_object,
+      embed = [],
+      embedsrcs = source.embed_sources,
+      importpath = library.importpath,
+      importmap = library.importmap,
+      gc_goopts = source.gc_goopts,
+      asmopts = source.asmopts,
+  )
+  return new_go_archive(lib_result, runfiles = lib_result.runfiles.merge(source.runfiles))
+
+def _link(go, archive, executable, gc_linkopts=[], x_defs={}):
+  emit_go_link_action(go._ctx,
+      transitive_go_library_paths = archive.transitive_go_library_paths,
+      transitive_go_libraries = archive.transitive_go_libraries,
+      cgo_deps = archive.transitive_cgo_deps,
+      libs = [archive.library],
+      executable = executable,
+      gc_linkopts = gc_linkopts,
+      x_defs = x_defs,
+  )
+
+def _library_struct(go, library, archive, output_groups={}, providers=[]):
+  return go_library_struct(library, archive, output_groups = output_groups, providers = providers)
+
+def go_context(ctx):
+  """Returns the API rules outside of rules_go use to compile and link Go
+  code. The rule must declare toolchains = [go_toolchain_type], with
+  go_toolchain_type loaded from @io_bazel_rules_go//go:def.bzl, and
+  fragments = ["cpp"]. Each function takes the context as its first
+  argument:
+
+    go = go_context(ctx)
+    library = go.new_library(go, importpath = "example.com/gen")
+    source = go.library_to_source(go, srcs = [generated], deps = ctx.attr.deps)
+    archive = go.archive(go, library, source)
+    return go.library_struct(go, library, archive)
+
+  Fields:
+    toolchain: the Go toolchain, with the SDK and the builders.
+    env: the environment actions run the Go SDK in, with GOROOT, GOOS and
+      GOARCH.
+    new_library(go, importpath, importmap): returns a GoLibrary for the
+      target being built. importmap defaults to importpath.
+    library_to_source(go, srcs, embedsrcs, deps, embed, gc_goopts, asmopts):
+      returns a GoSource, combining the files with the sources of the targets
+      in embed, like go_source does.
+    archive(go, library, source): compiles source, and returns a GoArchive.
+      It declares files named after the target and the import path, so it's
+      called once per target.
+    link(go, archive, executable, gc_linkopts, x_defs): links archive, a main
+      package, and everything it imports into executable.
+    library_struct(go, library, archive, output_groups, providers): returns
+      what the rule returns to be used like a go_library, in the deps and
+      embed of other Go targets. This includes the GoLibrary, GoSource and
+      GoArchive providers, and the rule's own providers.
+  """
+  toolchain = get_go_toolchain(ctx)
+  return struct(
+      _ctx = ctx,
+      toolchain = toolchain,
+      env = toolchain.env,
+      new_library = _new_library,
+      library_to_source = _library_to_source,
+      archive = _archive,
+      link = _link,
+      library_struct = _library_struct,
+  )
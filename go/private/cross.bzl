@@ -0,0 +1,253 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:common.bzl", "go_toolchain_type")
+load("@io_bazel_rules_go//go/private:library.bzl", "c_linkmode_enabled", "pie_enabled", "plugin_enabled", "pure_env", "emit_go_compile_action", "dep_importmaps", "get_embed")
+load("@io_bazel_rules_go//go/private:providers.bzl", "GoArchive")
+
+# Values accepted by the goos and goarch attributes of go_binary and go_test.
+# An empty string means the platform of the toolchain.
+GOOS_VALUES = [
+    "",
+    "android",
+    "darwin",
+    "dragonfly",
+    "freebsd",
+    "js",
+    "linux",
+    "netbsd",
+    "openbsd",
+    "plan9",
+    "solaris",
+    "windows",
+]
+
+GOARCH_VALUES = [
+    "",
+    "386",
+    "amd64",
+    "arm",
+    "arm64",
+    "mips",
+    "mips64",
+    "mips64le",
+    "mipsle",
+    "ppc64",
+    "ppc64le",
+    "s390x",
+    "wasm",
+]
+
+# Values accepted by the pure attribute of go_binary and go_test. "auto"
+# means pure mode is used only with --features=pure or when cross-compiling.
+PURE_VALUES = [
+    "auto",
+    "off",
+    "on",
+]
+
+# Values accepted by the linkmode attribute of go_binary and go_test.
+# "normal" builds an executable. "c-shared" and "c-archive" build a shared
+# library or a static archive that C code can call into. "plugin" builds a
+# Go plugin, which programs can load with the plugin package. "pie" builds
+# a position-independent executable.
+LINKMODE_VALUES = [
+    "normal",
+    "c-archive",
+    "c-shared",
+    "pie",
+    "plugin",
+]
+
+def _go_cross_aspect_impl(target, ctx):
+  """Compiles a Go library for the mode of the binary or test that depends on
+  it. In pure mode, or when cross-compiling for the platform in the goos and
+  goarch attributes, the library is compiled without cgo. When the binary is
+  linked as a C library or a position-independent executable, it's compiled as
+  position-independent code, and when it's linked as a plugin, it's compiled
+  with -dynlink.
+
+  The library's sources are taken from its providers, so embedded sources and
+  coverage instrumentation are handled the same way as in the library itself.
+  The aspect provides go_cross, a struct with the same fields as a library's
+  providers, pointing to the archives it compiled. It also provides
+  go_cross_deps, the dependencies (with this aspect applied) of the library and
+  of the libraries it embeds, and go_package_labels, which is used to check
+  that plugins and the programs that load them agree on their packages.
+  These two are provided in every mode.
+
+  go_cross_binary applies the aspect to a go_binary, whose package is
+  compiled the same way, from the sources in its GoArchive. The aspect then
+  also provides go_cross_link, with the binary's gc_linkopts and x_defs, so
+  it can be linked.
+  """
+  # A go_proto_compiler isn't a library, but the libraries the code it
+  # generates imports are compiled for the same mode.
+  if ctx.rule.kind == "go_proto_compiler":
+    return struct(go_cross_deps = ctx.rule.attr.deps)
+  binary = ctx.rule.kind == "go_binary"
+  if binary:
+    source = target[GoArchive].source
+    importpath = target[GoArchive].importpath
+    importmap = target[GoArchive].importmap
+  elif hasattr(target, "go_sources"):
+    source = target
+    importpath = getattr(target, "importpath", None)
+    importmap = getattr(target, "importmap", importpath)
+  else:
+    return struct()
+  deps = list(getattr(ctx.rule.attr, "deps", []))
+  embed = list(getattr(ctx.rule.attr, "embed", []))
+  if getattr(ctx.rule.attr, "library", None):
+    embed += [ctx.rule.attr.library]
+  for library in embed:
+    deps += library.go_cross_deps
+  # go_proto_library also depends on the libraries of its compilers, unless
+  # they're already in deps.
+  for compiler in getattr(ctx.rule.attr, "compilers", []):
+    for dep in compiler.go_cross_deps:
+      if dep.label not in [d.label for d in deps]:
+        deps += [dep]
+  # A go_source isn't a package of its own. Its sources are compiled by the
+  # targets that embed it, so only its dependencies are provided.
+  if ctx.rule.kind == "go_source":
+    package_labels = depset()
+  else:
+    package_labels = depset(["%s=%s" % (importmap, target.label)])
+  for dep in deps:
+    package_labels += dep.go_package_labels
+
+  env = pure_env(ctx)
+  shared = c_linkmode_enabled(ctx) or pie_enabled(ctx)
+  dynlink = plugin_enabled(ctx)
+  if not (env or shared or dynlink) or ctx.rule.kind == "go_source":
+    return struct(
+        go_cross_deps = deps,
+        go_package_labels = package_labels,
+    )
+  cgo_obj = None
+  transitive_cgo_deps = depset([], order="link")
+  if source.cgo_object:
+    if env:
+      fail("%s uses cgo, which is disabled in pure mode and when cross-compiling" % target.label)
+    cgo_obj = source.cgo_object.cgo_obj
+    transitive_cgo_deps += source.cgo_object.cgo_deps
+  dep_libs = [dep.go_cross for dep in deps]
+
+  if shared:
+    prefix = ctx.label.name + "~shared/"
+  elif dynlink:
+    prefix = ctx.label.name + "~dynlink/"
+  else:
+    prefix = "%s~%s/" % (ctx.label.name, env["GOOS"] + "_" + env["GOARCH"])
+  lib_name = importmap + ".a"
+  out_lib = ctx.new_file(prefix + lib_name)
+  searchpath = out_lib.path[:-len(lib_name)]
+
+  emit_go_compile_action(ctx,
+      sources = source.go_sources,
+      libs = [dep.library for dep in dep_libs],
+      lib_paths = [dep.searchpath for dep in dep_libs],
+      direct_paths = [dep.importpath for dep in dep_libs],
+      out_lib = out_lib,
+      gc_goopts = source.gc_goopts,
+      asm_srcs = source.asm_sources,
+      asm_hdrs = source.asm_headers,
+      asmopts = source.asmopts,
+      extra_objects = source.syso_sources,
+      cgo_obj = cgo_obj,
+      embedsrcs = source.embed_sources,
+      importmap = importmap if importmap != importpath else None,
+      importmaps = dep_importmaps(dep_libs),
+      cover = False,
+      env = env,
+      shared = shared,
+      dynlink = dynlink,
+      nogo = ctx.rule.kind != "go_tool_library",
+  )
+
+  transitive_go_libraries = depset([out_lib])
+  transitive_go_library_paths = depset([searchpath])
+  for dep in dep_libs:
+    transitive_go_libraries += dep.transitive_go_libraries
+    transitive_go_library_paths += dep.transitive_go_library_paths
+    transitive_cgo_deps += dep.transitive_cgo_deps
+
+  # Race mode isn't supported in these modes. The race fields point
+  # to the same archives. emit_library_actions expects them on every dep.
+  return struct(
+      go_cross = struct(
+          library = out_lib,
+          searchpath = searchpath,
+          importpath = importpath,
+          importmap = importmap,
+          transitive_go_libraries = transitive_go_libraries,
+          transitive_go_library_paths = transitive_go_library_paths,
+          transitive_cgo_deps = transitive_cgo_deps,
+          race_library = out_lib,
+          race_searchpath = searchpath,
+          transitive_go_libraries_race = transitive_go_libraries,
+          transitive_go_library_paths_race = transitive_go_library_paths,
+      ),
+      go_cross_link = struct(
+          gc_linkopts = ctx.rule.attr.gc_linkopts,
+          x_defs = ctx.rule.attr.x_defs,
+      ) if binary else None,
+      go_cross_deps = deps,
+      go_package_labels = package_labels,
+  )
+
+go_cross_aspect = aspect(
+    _go_cross_aspect_impl,
+    attr_aspects = ["deps", "embed", "library", "compilers"],
+    attrs = {
+        "goos": attr.string(values = GOOS_VALUES),
+        "goarch": attr.string(values = GOARCH_VALUES),
+        "pure": attr.string(values = PURE_VALUES, default = "auto"),
+        "linkmode": attr.string(values = LINKMODE_VALUES, default = "normal"),
+        "_nogo": attr.label(
+            default = Label("@io_bazel_rules_nogo//:nogo"),
+            cfg = "host",
+        ),
+        "_pgo": attr.label(
+            default = Label("@io_bazel_rules_go_pgo//:pgo"),
+            allow_files = True,
+        ),
+    },
+    toolchains = [go_toolchain_type],
+)
+"""Compiles the Go libraries a go_binary or go_test depends on for its mode:
+without cgo, when it's built in pure mode or for the platform in its goos
+and goarch attributes, as position-independent code, when it's linked as
+a C library or a position-independent executable, or with -dynlink, when it's
+linked as a plugin.
+"""
+
+def go_package_labels(ctx):
+  """Returns a dict from the importmaps of packages to the labels of the
+  libraries that provide them, for the transitive dependencies of the binary
+  or test being built. The importmap is the import path, unless a library
+  sets another.
+  """
+  deps = list(ctx.attr.deps)
+  for library in get_embed(ctx):
+    deps += library.go_cross_deps
+  labels = depset()
+  for dep in deps:
+    labels += dep.go_package_labels
+  result = {}
+  for entry in labels:
+    importpath, label = entry.split("=", 1)
+    result[importpath] = label
+  return result
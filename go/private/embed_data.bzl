@@ -0,0 +1,72 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+def _go_embed_data_impl(ctx):
+  if ctx.attr.src and ctx.attr.srcs:
+    fail("only one of src and srcs may be set")
+  if not ctx.attr.src and not ctx.attr.srcs:
+    fail("one of src and srcs must be set")
+  package = ctx.attr.package
+  if not package:
+    package = ctx.label.package.rpartition("/")[2] or "main"
+
+  args = [
+      "-out", ctx.outputs.out.path,
+      "-package", package,
+      "-var", ctx.attr.var,
+  ]
+  if ctx.attr.srcs:
+    args += ["-multi"]
+    srcs = ctx.files.srcs
+  else:
+    srcs = ctx.files.src
+  if ctx.attr.string:
+    args += ["-string"]
+  if ctx.attr.compress:
+    args += ["-compress"]
+  args += ["--"]
+  for src in srcs:
+    key = src.basename if ctx.attr.flatten else src.short_path
+    args += [key, src.path]
+
+  ctx.action(
+      inputs = srcs,
+      outputs = [ctx.outputs.out],
+      executable = ctx.executable._embed_data,
+      arguments = args,
+      mnemonic = "GoEmbedData",
+  )
+
+go_embed_data = rule(
+    _go_embed_data_impl,
+    attrs = {
+        "src": attr.label(allow_single_file = True),
+        "srcs": attr.label_list(allow_files = True),
+        "package": attr.string(),
+        "var": attr.string(default = "Data"),
+        "flatten": attr.bool(),
+        "string": attr.bool(),
+        "compress": attr.bool(),
+        "_embed_data": attr.label(
+            default = Label("@io_bazel_rules_go//go/tools/builders:embed_data"),
+            executable = True,
+            cfg = "host",
+        ),
+    },
+    outputs = {"out": "%{name}.go"},
+)
+"""Generates a Go source file, <name>.go, declaring a variable that holds the
+contents of src, or a map from the paths of srcs to their contents. The file
+can be listed in the srcs of a go_library.
+"""
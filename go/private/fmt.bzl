@@ -0,0 +1,69 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "go_toolchain_type")
+
+_script = """#!/bin/sh
+# Checks that Go sources are formatted with gofmt. With --fix, the sources
+# are rewritten instead. They're symlinks in the runfiles tree, so gofmt
+# writes through them to the files in the workspace.
+RUNFILES="${{TEST_SRCDIR:-${{RUNFILES_DIR:-$0.runfiles}}}}/{workspace}"
+cd "$RUNFILES" || exit 1
+GOFMT="{gofmt}"
+SRCS="{srcs}"
+if [ "${{1:-}}" = "--fix" ]; then
+  exec "$GOFMT" -l -w $SRCS
+fi
+UNFORMATTED=$("$GOFMT" -l $SRCS) || exit 1
+if [ -n "$UNFORMATTED" ]; then
+  echo "These files are not formatted with gofmt:"
+  echo "$UNFORMATTED"
+  echo "Run \\"bazel run {label} -- --fix\\" to format them."
+  "$GOFMT" -d $UNFORMATTED
+  exit 1
+fi
+"""
+
+def _go_fmt_test_impl(ctx):
+  gofmt = get_go_toolchain(ctx).gofmt
+  if not gofmt:
+    fail("the Go SDK has no bin/gofmt")
+  ctx.file_action(
+      output = ctx.outputs.executable,
+      content = _script.format(
+          workspace = ctx.workspace_name,
+          gofmt = gofmt[0].short_path,
+          srcs = " ".join([src.short_path for src in ctx.files.srcs]),
+          label = str(ctx.label),
+      ),
+      executable = True,
+  )
+  return struct(
+      runfiles = ctx.runfiles(files = ctx.files.srcs + gofmt),
+  )
+
+go_fmt_test = rule(
+    _go_fmt_test_impl,
+    attrs = {
+        "srcs": attr.label_list(
+            allow_files = [".go"],
+            mandatory = True,
+        ),
+    },
+    toolchains = [go_toolchain_type],
+    test = True,
+)
+"""Checks that Go sources are formatted with gofmt from the Go SDK. Running
+the test with "bazel run" and --fix formats them instead.
+"""
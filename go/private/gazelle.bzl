@@ -23,10 +23,15 @@ def _gazelle_script_impl(ctx):
   args = ctx.attr.args
   args = [
       "-repo_root", "$WORKSPACE",
-      "-go_prefix", ctx.attr._go_prefix.go_prefix,
       "-external", ctx.attr.external,
       "-mode", ctx.attr.mode,
+      # Imports are standard if they're in the registered SDK.
+      "-std_packages", "$BASE/" + ctx.file._std_packages.short_path,
   ]
+  # If no prefix is given, Gazelle reads it from the go_prefix rule or the
+  # "# gazelle:prefix" directive in the root BUILD file.
+  if ctx.attr.prefix:
+    args += ["-go_prefix", ctx.attr.prefix]
   if ctx.attr.build_tags:
     args += ["-build_tags", ",".join(ctx.attr.build_tags)]
   script_content = _script_content.format(gazelle=ctx.file._gazelle.short_path, args=" ".join(args))
@@ -34,7 +39,7 @@ def _gazelle_script_impl(ctx):
   ctx.file_action(output=script_file, executable=True, content=script_content)
   return struct(
     files = depset([script_file]),
-    runfiles = ctx.runfiles([ctx.file._gazelle])
+    runfiles = ctx.runfiles([ctx.file._gazelle, ctx.file._std_packages])
   )
 
 _gazelle_script = rule(
@@ -44,6 +49,7 @@ _gazelle_script = rule(
         "external": attr.string(mandatory=True, values=["external", "vendored"]),
         "build_tags": attr.string_list(mandatory=True),
         "args": attr.string_list(mandatory=True),
+        "prefix": attr.string(),
         "_gazelle": attr.label(
             default = Label("@io_bazel_rules_go//go/tools/gazelle/gazelle:gazelle"),
             allow_files = True,
@@ -51,14 +57,15 @@ _gazelle_script = rule(
             executable = True,
             cfg = "host"
         ),
-        "_go_prefix": attr.label(default = Label(
-            "//:go_prefix",
-            relative_to_caller_repository = True,
-        )),
+        "_std_packages": attr.label(
+            default = Label("@io_bazel_rules_go_toolchain//:std_packages.txt"),
+            allow_files = True,
+            single_file = True,
+        ),
     }
 )
 
-def gazelle(name, mode = "fix", external="external", build_tags=[], args = []):
+def gazelle(name, mode = "fix", external="external", build_tags=[], args = [], prefix = ""):
   script_name = name+"_script"
   _gazelle_script(
       name = script_name,
@@ -66,6 +73,7 @@ def gazelle(name, mode = "fix", external="external", build_tags=[], args = []):
       external = external,
       build_tags = build_tags,
       args = args,
+      prefix = prefix,
       tags = ["manual"],
   )
   native.sh_binary(
@@ -0,0 +1,77 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "go_toolchain_type", "pkg_dir")
+
+# Sets up the go command from the SDK before the command of a go_genrule
+# runs. Like other actions, it only uses the SDK through its declared inputs,
+# with GOROOT relative to the execution root, so it's made absolute for
+# commands that change directories. The build and module caches are only
+# kept while the command runs.
+_go_env = """set -e
+case "$GOROOT" in /*) ;; *) export GOROOT="$(pwd)/$GOROOT" ;; esac
+tmp="$(mktemp -d)"
+trap 'chmod -R u+w "$tmp" 2>/dev/null || true; rm -rf "$tmp"' EXIT
+export GOCACHE="$tmp/cache" GOPATH="$tmp/gopath" HOME="$tmp/home"
+export PATH="$GOROOT/bin:$PATH"
+"""
+
+def _go_genrule_impl(ctx):
+  toolchain = get_go_toolchain(ctx)
+  outs = ctx.outputs.outs
+  substitutions = {
+      "SRCS": " ".join([src.path for src in ctx.files.srcs]),
+      "OUTS": " ".join([out.path for out in outs]),
+      "GO": toolchain.go.path,
+  }
+  if len(outs) == 1:
+    substitutions["@"] = outs[0].path
+    substitutions["@D"] = outs[0].dirname
+  else:
+    substitutions["@D"] = outs[0].root.path + "/" + pkg_dir(ctx.label.workspace_root, ctx.label.package)
+  cmd = ctx.expand_location(ctx.attr.cmd, ctx.attr.srcs + ctx.attr.tools)
+  cmd = ctx.expand_make_variables("cmd", cmd, substitutions)
+  tool_inputs, _, input_manifests = ctx.resolve_command(tools = ctx.attr.tools)
+  ctx.action(
+      inputs = ctx.files.srcs + tool_inputs + toolchain.tools + toolchain.stdlib + [toolchain.go],
+      outputs = outs,
+      command = _go_env + cmd,
+      input_manifests = input_manifests,
+      progress_message = ctx.attr.message or "Executing go_genrule %s" % ctx.label,
+      mnemonic = "GoGenrule",
+      env = {
+          "GOROOT": toolchain.root.path,
+          # cgo would need the C toolchain, which isn't an input.
+          "CGO_ENABLED": "0",
+          "PATH": "/usr/local/bin:/usr/bin:/bin",
+      },
+  )
+  return struct(files = depset(outs))
+
+go_genrule = rule(
+    _go_genrule_impl,
+    attrs = {
+        "srcs": attr.label_list(allow_files = True),
+        "outs": attr.output_list(mandatory = True),
+        "cmd": attr.string(mandatory = True),
+        "tools": attr.label_list(allow_files = True, cfg = "host"),
+        "message": attr.string(),
+    },
+    toolchains = [go_toolchain_type],
+)
+"""Like genrule, but cmd runs with the go command of the Go SDK the build
+uses first on PATH, GOROOT set to the SDK, and GOCACHE, GOPATH and HOME in a
+directory of their own, so code generation scripts that run go directly are
+hermetic. $(GO) is the path of the go command. cgo is disabled.
+"""
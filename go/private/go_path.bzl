@@ -0,0 +1,169 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Values accepted by the mode attribute of go_path. "archive" writes a zip
+# file of the tree, and "copy" copies the files into a directory.
+GO_PATH_MODES = [
+    "archive",
+    "copy",
+]
+
+def _package_path(ctx, src):
+  """Returns the path of src relative to the package of the target the
+  aspect is applied to. Files from other packages are laid out by their
+  basename."""
+  path = src.short_path
+  if path.startswith("../"):
+    # Files in external repositories start with ../<repository>/.
+    path = path.split("/", 2)[2]
+  package = ctx.label.package
+  if not package:
+    return path
+  if not path.startswith(package + "/"):
+    return src.basename
+  return path[len(package) + 1:]
+
+def _go_path_importpath(target, ctx):
+  """Returns the import path of the directory the sources of target are laid
+  out in. Binaries and tests don't provide one, so it's derived from their
+  importpath attribute, or from the prefix and their package."""
+  if hasattr(target, "importpath"):
+    return target.importpath
+  if ctx.rule.attr.importpath:
+    return ctx.rule.attr.importpath
+  path = ctx.rule.attr._go_prefix.go_prefix
+  if path.endswith("/"):
+    path = path[:-1]
+  if ctx.label.package:
+    path += "/" + ctx.label.package
+  return path.lstrip("/")
+
+def _go_path_aspect_impl(target, ctx):
+  """Collects the sources of a Go library, binary or test, and of everything
+  it depends on, for go_path. It provides go_path_sources, the sources of the
+  target's own package as "path=file" strings, with paths relative to the
+  package, go_path_entries, the same for every package including this one,
+  with paths relative to the root of the tree, and go_path_files, the files
+  themselves.
+
+  cgo libraries are compiled from files generated by cgo, so the sources
+  they were generated from are taken from the rule that ran cgo, through
+  cgo_object. Those rules provide go_path_cgo_srcs.
+  """
+  kind = ctx.rule.kind
+  if kind == "_cgo_codegen_rule":
+    return struct(go_path_cgo_srcs = depset(ctx.rule.files.srcs))
+  if kind == "_cgo_object":
+    return struct(go_path_cgo_srcs = ctx.rule.attr.cgogen.go_path_cgo_srcs)
+  if not hasattr(target, "go_sources") and kind not in ("go_binary", "go_test"):
+    return struct()
+
+  cgo_object = getattr(ctx.rule.attr, "cgo_object", None)
+  if cgo_object:
+    srcs = list(cgo_object.go_path_cgo_srcs)
+  else:
+    srcs = list(getattr(ctx.rule.files, "srcs", []))
+  srcs += getattr(ctx.rule.files, "embedsrcs", [])
+  sources = depset(["%s=%s" % (_package_path(ctx, src), src.path) for src in srcs])
+  files = depset(srcs)
+  entries = depset()
+
+  embed = list(getattr(ctx.rule.attr, "embed", []))
+  if getattr(ctx.rule.attr, "library", None):
+    embed += [ctx.rule.attr.library]
+  deps = list(getattr(ctx.rule.attr, "deps", []))
+  for library in embed:
+    if hasattr(library, "go_path_sources"):
+      sources += library.go_path_sources
+      deps += [library]
+  # A go_source isn't a package of its own. Its sources are laid out with
+  # the targets that embed it.
+  if kind != "go_source":
+    importpath = _go_path_importpath(target, ctx)
+    entries += ["src/%s/%s" % (importpath, source) for source in sources]
+  for dep in deps:
+    if hasattr(dep, "go_path_entries"):
+      entries += dep.go_path_entries
+      files += dep.go_path_files
+  return struct(
+      go_path_sources = sources,
+      go_path_entries = entries,
+      go_path_files = files,
+  )
+
+go_path_aspect = aspect(
+    _go_path_aspect_impl,
+    attr_aspects = ["deps", "embed", "library", "cgo_object", "cgogen"],
+)
+"""Collects the transitive sources of Go targets for go_path."""
+
+def _go_path_impl(ctx):
+  entries = depset()
+  files = depset()
+  for dep in ctx.attr.deps:
+    entries += dep.go_path_entries
+    files += dep.go_path_files
+  # Embedded libraries are reached through their dependents as well, so
+  # the same file may be listed more than once. Different files at the same
+  # path come from packages with the same import path.
+  paths = {}
+  for entry in entries:
+    dst, src = entry.split("=", 1)
+    if paths.get(dst, src) != src:
+      fail("%s and %s are both laid out at %s" % (paths[dst], src, dst))
+    paths[dst] = src
+  args = []
+  for dst in sorted(paths):
+    args += [dst, paths[dst]]
+
+  if ctx.attr.mode == "archive":
+    out = ctx.new_file(ctx.label.name + ".zip")
+    outputs = [out]
+    root = out.path
+  else:
+    outputs = [ctx.new_file(ctx.label.name + "/" + dst) for dst in sorted(paths)]
+    if not outputs:
+      fail("no Go sources to copy", "deps")
+    root = outputs[0].path[:-len(sorted(paths)[0]) - 1]
+  args = ["-mode", ctx.attr.mode, "-out", root, "--"] + args
+  # Like emit_params_file, but outside of the tree, so it isn't copied into
+  # the directory with the sources.
+  params = ctx.new_file(ctx.label.name + ".params")
+  ctx.file_action(output = params, content = "\n".join(args) + "\n")
+  ctx.action(
+      inputs = list(files) + [params],
+      outputs = outputs,
+      executable = ctx.executable._go_path,
+      arguments = ["@" + params.path],
+      mnemonic = "GoPath",
+  )
+  return struct(files = depset(outputs))
+
+go_path = rule(
+    _go_path_impl,
+    attrs = {
+        "deps": attr.label_list(aspects = [go_path_aspect]),
+        "mode": attr.string(values = GO_PATH_MODES, default = "archive"),
+        "_go_path": attr.label(
+            default = Label("@io_bazel_rules_go//go/tools/builders:go_path"),
+            executable = True,
+            cfg = "host",
+        ),
+    },
+)
+"""Lays out the sources of Go targets in deps, and of everything they depend
+on, in a GOPATH-style tree: each file is at src/<importpath>/<path>. With
+mode "archive", the tree is written to <name>.zip. With mode "copy", the
+files are copied into the <name> directory, which can be used as a GOPATH.
+"""
@@ -70,15 +70,36 @@ def _go_repository_impl(ctx):
     gazelle = ctx.path(ctx.attr._gazelle)
     cmds = [gazelle, '--go_prefix', ctx.attr.importpath, '--mode', 'fix',
             '--repo_root', ctx.path(''),
+            '--repo_name', ctx.name,
             "--build_tags", ",".join(ctx.attr.build_tags)]
     if ctx.attr.build_file_name:
         cmds += ["--build_file_name", ctx.attr.build_file_name]
+    go_version = env_execute(ctx, ["cat", ctx.path(ctx.attr._go_version)]).stdout.strip()
+    if go_version:
+        cmds += ["--go_version", go_version]
+    cmds += ["--std_packages", ctx.path(ctx.attr._std_packages)]
     cmds += [ctx.path('')]
     result = env_execute(ctx, cmds)
     if result.return_code:
       fail("failed to generate BUILD files for %s: %s" % (
           ctx.attr.importpath, result.stderr))
 
+  _write_repository_info(ctx)
+
+def _write_repository_info(ctx):
+  """Concatenates the license files at the root of the repository into
+  _go_repository_info/LICENSES, which go_repository_metadata reads for
+  go_sbom. It's in a package of its own, so it can be read whether or not
+  the root of the repository has a build file."""
+  result = env_execute(ctx, ["sh", "-c", """
+cd "$1" && for f in LICENSE* LICENCE* COPYING* NOTICE*; do
+  if [ -f "$f" ]; then cat "$f"; echo; fi
+done
+""", "sh", ctx.path("")], environment = {"PATH": ctx.os.environ["PATH"]})
+  if result.return_code:
+    fail("failed to collect the licenses of %s: %s" % (ctx.attr.importpath, result.stderr))
+  ctx.file("_go_repository_info/LICENSES", result.stdout)
+  ctx.file("_go_repository_info/BUILD.bazel", 'exports_files(["LICENSES"])\n')
 
 go_repository = repository_rule(
     implementation = _go_repository_impl,
@@ -118,6 +139,16 @@ go_repository = repository_rule(
             executable = True,
             cfg = "host",
         ),
+        "_go_version": attr.label(
+            default = Label("@io_bazel_rules_go_toolchain//:VERSION"),
+            allow_files = True,
+            single_file = True,
+        ),
+        "_std_packages": attr.label(
+            default = Label("@io_bazel_rules_go_toolchain//:std_packages.txt"),
+            allow_files = True,
+            single_file = True,
+        ),
     },
 )
 
@@ -14,18 +14,20 @@
 
 def _go_root_impl(ctx):
   """go_root_impl propogates a GOROOT path string."""
-  return struct(path = ctx.attr.path)
+  return struct(path = ctx.attr.path or ctx.label.workspace_root or ".")
 
 go_root = rule(
   _go_root_impl,
   attrs = {
-    "path": attr.string(mandatory = True),
+    "path": attr.string(),
   },
 )
 """Captures the goroot value for use as a label dependency.
 
 Args:
-  path (string): the absolute path to GOROOT.
+  path (string): the path to GOROOT. Defaults to the root of the repository
+    the go_root is declared in, relative to the execution root, so actions
+    only use the SDK files they declare as inputs.
 
 Returns:
   (struct): .go_root (string): the GOROOT value provider.
@@ -11,19 +11,17 @@
 # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 # See the License for the specific language governing permissions and
 # limitations under the License.
-load('//go/private:go_toolchain.bzl', 'toolchain_type', 'ConstraintValueInfo', 'go_toolchain_core_attrs')
-
-go_bootstrap_toolchain_type = toolchain_type()
+load('//go/private:common.bzl', 'go_bootstrap_toolchain_type', 'go_minor_at_least')
+load('//go/private:go_toolchain.bzl', 'go_toolchain_core_attrs')
 
 def _go_bootstrap_toolchain_impl(ctx):
-  return go_bootstrap_toolchain_type(
-      exec_compatible_with = ctx.attr.exec_compatible_with,
-      target_compatible_with = ctx.attr.target_compatible_with,
+  return [platform_common.ToolchainInfo(
       root = ctx.attr.root.path,
+      go_minor = ctx.attr.go_minor,
       go = ctx.executable.go,
       tools = ctx.files.tools,
       stdlib = ctx.files.stdlib,
-  )
+  )]
 
 go_bootstrap_toolchain = rule(
     _go_bootstrap_toolchain_impl,
@@ -31,13 +29,32 @@ go_bootstrap_toolchain = rule(
 )
 
 def _go_tool_binary_impl(ctx):
-  toolchain = ctx.attr._go_toolchain #TODO(toolchains): ctx.toolchains[go_bootstrap_toolchain_type]
+  toolchain = ctx.toolchains[go_bootstrap_toolchain_type]
+  # The tools are inputs of most actions, so they're built the same way
+  # wherever the workspace is, or those actions aren't cached. Before Go 1.13,
+  # the go command can't trim paths itself, so the compiler and assembler trim
+  # the execution root, which is only known when the action runs.
+  if go_minor_at_least(toolchain.go_minor, 13):
+    trimpath = '-trimpath'
+  else:
+    trimpath = '-gcflags "-trimpath=$(pwd)" -asmflags "-trimpath=$(pwd)"'
+  # Like other actions, this only uses the SDK through its declared inputs,
+  # with GOROOT relative to the execution root. go build needs it absolute,
+  # and a cache of its own, which is used only while the tool is built.
   ctx.action(
       inputs = ctx.files.srcs + toolchain.tools + toolchain.stdlib,
       outputs = [ctx.outputs.executable],
-      command = [
+      command = " && ".join([
+          'case "$GOROOT" in /*) ;; *) export GOROOT="$(pwd)/$GOROOT" ;; esac',
+          'tmp="$(mktemp -d)"',
+          'trap \'rm -rf "$tmp"\' EXIT',
+          'export GOCACHE="$tmp/cache" GOPATH="$tmp/gopath"',
+          'go="$1"',
+          'shift',
+          '"$go" build %s "$@"' % trimpath,
+      ]),
+      arguments = [
           toolchain.go.path,
-          "build",
           "-o",
           ctx.outputs.executable.path,
       ] + [src.path for src in ctx.files.srcs],
@@ -51,9 +68,8 @@ go_tool_binary = rule(
     _go_tool_binary_impl,
     attrs = {
         "srcs": attr.label_list(allow_files = FileType([".go"])),
-        #TODO(toolchains): Remove toolchain attribute when we switch to real toolchains
-        "_go_toolchain": attr.label(default = Label("@io_bazel_rules_go_toolchain//:bootstrap_toolchain")),
     },
+    toolchains = [go_bootstrap_toolchain_type],
     executable = True,
 )
 """Builds a Go program using `go build`.
@@ -15,56 +15,32 @@
 Toolchain rules used by go.
 """
 
-####################################
-#### Special compatability functions
-#TODO(toolchains): Remove this entire block when real toolchains arrive
-
-def _constraint_rule_impl(ctx):
-    return struct(i_am_a_constraint=True)
-
-_constraint = rule(
-    _constraint_rule_impl,
-    attrs = {},
-)
-
-def toolchain_type():
-    # Should be platform_common.toolchain_type
-    return provider()
-
-ConstraintValueInfo = "i_am_a_constraint" # Should be platform_common.ConstraintValueInfo
-
-def platform(name, constraint_values):
-    return
-
-def constraint_setting(name):
-    _constraint(name = name)
-
-def constraint_value(name, setting):
-    _constraint(name = name)
-
-#### End of special compatability functions
-###########################################
-
-go_toolchain_type = toolchain_type()
-
 def _go_toolchain_impl(ctx):
-  return go_toolchain_type(
-      exec_compatible_with = ctx.attr.exec_compatible_with,
-      target_compatible_with = ctx.attr.target_compatible_with,
-      env = {
-          "GOROOT": ctx.attr.root.path,
-          "GOOS": ctx.attr.goos,
-          "GOARCH": ctx.attr.goarch,
-      },
+  env = {
+      "GOROOT": ctx.attr.root.path,
+      "GOOS": ctx.attr.goos,
+      "GOARCH": ctx.attr.goarch,
+  }
+  if ctx.attr.goexperiment:
+    # The compiler and linker read it from the environment, like the go
+    # command does.
+    env["GOEXPERIMENT"] = ctx.attr.goexperiment
+  return [platform_common.ToolchainInfo(
+      env = env,
       name = ctx.label.name,
       sdk = ctx.attr.sdk,
+      go_minor = ctx.attr.go_minor,
       go = ctx.executable.go,
       root = ctx.attr.root,
       tools = ctx.files.tools,
+      gofmt = ctx.files.gofmt,
       stdlib = ctx.files.stdlib,
+      stdlibs = {s.go_stdlib.key: s.go_stdlib for s in ctx.attr.stdlibs},
       headers = ctx.attr.headers,
+      wasm_exec = ctx.files.wasm_exec,
       filter_tags = ctx.executable.filter_tags,
-      asm = ctx.executable.asm,
+      copy_file = ctx.executable.copy_file,
+      pack = ctx.executable.pack,
       compile = ctx.executable.compile,
       link = ctx.executable.link,
       test_generator = ctx.executable.test_generator,
@@ -72,26 +48,30 @@ def _go_toolchain_impl(ctx):
       link_flags = ctx.attr.link_flags,
       cgo_link_flags = ctx.attr.cgo_link_flags,
       crosstool = ctx.files.crosstool,
-  )
+  )]
 
 go_toolchain_core_attrs = {
-    "exec_compatible_with": attr.label_list(providers = [ConstraintValueInfo]),
-    "target_compatible_with": attr.label_list(providers = [ConstraintValueInfo]),
     "sdk": attr.string(),
+    "go_minor": attr.int(),
     "root": attr.label(),
     "go": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host"),
     "tools": attr.label(allow_files = True),
+    "gofmt": attr.label(allow_files = True),
     "stdlib": attr.label(allow_files = True),
     "headers": attr.label(),
+    "wasm_exec": attr.label(allow_files = True),
 }
 
 go_toolchain_attrs = go_toolchain_core_attrs + {
     "is_cross": attr.bool(),
     "goos": attr.string(),
     "goarch": attr.string(),
+    "goexperiment": attr.string(),
+    "stdlibs": attr.label_list(providers = ["go_stdlib"]),
     "filter_tags": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", default=Label("//go/tools/builders:filter_tags")),
-    "asm": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", default=Label("//go/tools/builders:asm")),
+    "copy_file": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", default=Label("//go/tools/builders:copy_file")),
     "compile": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", default=Label("//go/tools/builders:compile")),
+    "pack": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", default=Label("//go/tools/builders:pack")),
     "link": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", default=Label("//go/tools/builders:link")),
     "test_generator": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", default=Label("//go/tools/builders:generate_test_main")),
     "extract_package": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", default=Label("//go/tools/extract_package")),
@@ -105,10 +85,16 @@ go_toolchain = rule(
     attrs = go_toolchain_attrs,
 )
 """Declares a go toolchain for use.
-This is used when porting the rules_go to a new platform.
+This is used when porting the rules_go to a new platform. The toolchain is
+made available to the Go rules by a toolchain rule of type
+@io_bazel_rules_go//go:toolchain that refers to it, which declares the
+platforms it can execute on and build for.
 Args:
   name: The name of the toolchain instance.
-  exec_compatible_with: The set of constraints this toolchain requires to execute.
-  target_compatible_with: The set of constraints for the outputs built with this toolchain.
   go: The location of the `go` binary.
+  go_minor: The minor version of the SDK, like 8 for Go 1.8.3, which
+    determines the flags its tools are run with. 0 means a development
+    version, newer than every release.
+  goexperiment: If set, the value of GOEXPERIMENT the SDK's tools are run
+    with, like "boringcrypto". The stdlibs must be built with it too.
 """
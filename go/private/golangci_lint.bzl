@@ -0,0 +1,192 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "go_toolchain_type")
+load("@io_bazel_rules_go//go/private:toolchain.bzl", "detect_host")
+
+def _golangci_lint_aspect_impl(target, ctx):
+  """Collects the Go sources of a library and of its transitive
+  dependencies, with the import paths they're compiled under, so they can be
+  laid out in a GOPATH for golangci-lint to load. Sources of embedded
+  libraries are already in the library's go_sources, so only their
+  dependencies are collected."""
+  if not hasattr(target, "go_sources"):
+    return struct()
+  dep_files = depset()
+  dep_paths = depset()
+  deps = list(getattr(ctx.rule.attr, "deps", []))
+  for dep in deps:
+    if hasattr(dep, "go_lint_files"):
+      dep_files += dep.go_lint_files
+      dep_paths += dep.go_lint_paths
+  embed = list(getattr(ctx.rule.attr, "embed", []))
+  if getattr(ctx.rule.attr, "library", None):
+    embed += [ctx.rule.attr.library]
+  for library in embed:
+    if hasattr(library, "go_lint_dep_files"):
+      dep_files += library.go_lint_dep_files
+      dep_paths += library.go_lint_dep_paths
+  if ctx.rule.kind == "go_source":
+    # The sources of a go_source are collected with the library that
+    # embeds it.
+    return struct(
+        go_lint_dep_files = dep_files,
+        go_lint_dep_paths = dep_paths,
+    )
+  files = depset(target.go_sources) + dep_files
+  paths = depset(["%s=%s" % (target.importpath, src.short_path) for src in target.go_sources]) + dep_paths
+  return struct(
+      go_lint_files = files,
+      go_lint_paths = paths,
+      go_lint_dep_files = dep_files,
+      go_lint_dep_paths = dep_paths,
+  )
+
+_golangci_lint_aspect = aspect(
+    _golangci_lint_aspect_impl,
+    attr_aspects = ["deps", "embed", "library"],
+)
+
+_script = """#!/bin/sh
+# Lays out the sources of the packages under test and of their dependencies
+# in a GOPATH, and runs golangci-lint on the packages under test.
+set -e
+RUNFILES="${{TEST_SRCDIR:-${{RUNFILES_DIR:-$0.runfiles}}}}/{workspace}"
+cd "$RUNFILES"
+RUNFILES="$(pwd)"
+TMP="${{TEST_TMPDIR:-$(mktemp -d)}}"
+export GOROOT="$RUNFILES/{goroot}"
+export GOPATH="$TMP/gopath"
+export GOCACHE="$TMP/cache"
+export HOME="$TMP"
+export GO111MODULE=off
+export GOFLAGS=
+export PATH="$GOROOT/bin:$PATH"
+while read -r importpath src; do
+  mkdir -p "$GOPATH/src/$importpath"
+  cp -f "$src" "$GOPATH/src/$importpath/"
+done <<'EOF'
+{sources}
+EOF
+LINT="$RUNFILES/{golangci_lint}"
+cd "$GOPATH/src"
+exec "$LINT" run {config} {packages}
+"""
+
+def _golangci_lint_test_impl(ctx):
+  go_toolchain = get_go_toolchain(ctx)
+  files = depset()
+  paths = depset()
+  packages = []
+  for library in ctx.attr.libraries:
+    files += library.go_lint_files
+    paths += library.go_lint_paths
+    packages += ["./" + library.importpath]
+  sources = []
+  for entry in paths:
+    importpath, src = entry.split("=", 1)
+    sources += ["%s %s" % (importpath, src)]
+  config = ""
+  runfiles = list(files) + [ctx.file._golangci_lint, go_toolchain.go] + go_toolchain.tools + go_toolchain.stdlib
+  if ctx.file.config:
+    config = "--config \"$RUNFILES/%s\"" % ctx.file.config.short_path
+    runfiles += [ctx.file.config]
+  ctx.file_action(
+      output = ctx.outputs.executable,
+      content = _script.format(
+          workspace = ctx.workspace_name,
+          goroot = go_toolchain.go.short_path.rpartition("/bin/")[0],
+          sources = "\n".join(sources),
+          golangci_lint = ctx.file._golangci_lint.short_path,
+          config = config,
+          packages = " ".join(packages),
+      ),
+      executable = True,
+  )
+  return struct(
+      runfiles = ctx.runfiles(files = runfiles),
+  )
+
+golangci_lint_test = rule(
+    _golangci_lint_test_impl,
+    attrs = {
+        "libraries": attr.label_list(
+            providers = ["go_sources", "importpath"],
+            aspects = [_golangci_lint_aspect],
+            mandatory = True,
+        ),
+        "config": attr.label(allow_single_file = True),
+        "_golangci_lint": attr.label(
+            default = Label("@com_github_golangci_golangci_lint//:golangci_lint"),
+            allow_single_file = True,
+        ),
+    },
+    toolchains = [go_toolchain_type],
+    test = True,
+)
+"""Runs golangci-lint on the packages built by libraries. Their sources, and
+those of their dependencies, are copied into a GOPATH, so golangci-lint can
+type-check them with the Go SDK of the toolchain. The linters it runs are
+configured with config, a .golangci.yml file.
+"""
+
+# Names of the hosts in golangci-lint release archives.
+_golangci_lint_hosts = {
+    "darwin_amd64": "darwin-amd64",
+    "linux_386": "linux-386",
+    "linux_amd64": "linux-amd64",
+    "linux_arm": "linux-armv6",
+    "linux_arm64": "linux-arm64",
+    "windows_386": "windows-386",
+    "windows_amd64": "windows-amd64",
+}
+
+def _golangci_lint_download_impl(ctx):
+  goos, goarch = detect_host(ctx)
+  host = goos + "_" + goarch
+  if host not in _golangci_lint_hosts:
+    fail("golangci-lint has no release for %s" % host)
+  version = ctx.attr.version
+  prefix = "golangci-lint-%s-%s" % (version, _golangci_lint_hosts[host])
+  extension = ".zip" if goos == "windows" else ".tar.gz"
+  ctx.download_and_extract(
+      url = [url.format(version = version, filename = prefix + extension) for url in ctx.attr.urls],
+      sha256 = ctx.attr.sha256s.get(host, ""),
+      stripPrefix = prefix,
+  )
+  binary = "golangci-lint.exe" if goos == "windows" else "golangci-lint"
+  ctx.file("BUILD.bazel", """
+alias(
+    name = "golangci_lint",
+    actual = "{}",
+    visibility = ["//visibility:public"],
+)
+""".format(binary))
+
+golangci_lint_download = repository_rule(
+    _golangci_lint_download_impl,
+    attrs = {
+        "version": attr.string(mandatory = True),
+        "sha256s": attr.string_dict(),
+        "urls": attr.string_list(
+            default = ["https://github.com/golangci/golangci-lint/releases/download/v{version}/{filename}"],
+        ),
+    },
+)
+"""Downloads a release of golangci-lint for the host. version pins the
+release, and sha256s maps <goos>_<goarch> to the SHA-256 sum of its archive.
+urls are templates, where {version} and {filename} are substituted. This
+must be declared as com_github_golangci_golangci_lint for golangci_lint_test
+to find it.
+"""
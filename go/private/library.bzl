@@ -12,71 +12,184 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "DEFAULT_LIB", "VENDOR_PREFIX", "go_filetype")
-load("@io_bazel_rules_go//go/private:asm.bzl", "emit_go_asm_action")
+load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "go_toolchain_type", "DEFAULT_LIB", "VENDOR_PREFIX", "go_filetype", "go_prefix_default", "define_flags", "emit_params_file", "shared_codegen_flags")
+load("@io_bazel_rules_go//go/private:stdlib.bzl", "stdlib_key")
+load("@io_bazel_rules_go//go/private:providers.bzl", "GoArchive", "GoLibrary", "GoSource")
 
-def emit_library_actions(ctx, sources, deps, cgo_object, library):
+def emit_library_actions(ctx, sources, deps, cgo_object, embed, test_package=None, embedsrcs=[], importpath=None, importmap=None, gc_goopts=None, asmopts=None):
+  """Compiles a Go package from sources and the sources of the libraries in
+  embed, and returns a struct with the fields go_library provides. The import
+  path, the importmap and the compiler and assembler flags are taken from the
+  attributes of the target being built, unless importpath, importmap,
+  gc_goopts and asmopts are set.
+  """
   go_toolchain = get_go_toolchain(ctx)
 
   go_srcs = depset([s for s in sources if s.basename.endswith('.go')])
   asm_srcs = [s for s in sources if s.basename.endswith('.s') or s.basename.endswith('.S')]
   asm_hdrs = [s for s in sources if s.basename.endswith('.h')]
+  syso_srcs = [s for s in sources if s.basename.endswith('.syso')]
+  embed_srcs = list(embedsrcs)
   dep_runfiles = [d.data_runfiles for d in deps]
+  # With --features=unused_deps, the deps declared by this target that its
+  # sources don't import are reported, and buildozer commands removing them
+  # are written to a file in the unused_deps output group.
+  unused_deps = None
+  dep_labels = []
+  if "unused_deps" in ctx.features:
+    unused_deps = ctx.new_file(ctx.label.name + ".unused_deps")
+    dep_labels = ["%s=%s" % (d.importpath, d.label) for d in deps]
+
+  # When a binary or test is built in pure mode or cross-compiled, or a
+  # binary is linked as a C library, a plugin or a position-independent
+  # executable, go_cross_aspect has compiled its dependencies for that mode.
+  # Their archives are used instead.
+  env = pure_env(ctx)
+  shared = c_linkmode_enabled(ctx) or pie_enabled(ctx)
+  dynlink = plugin_enabled(ctx)
+  race_feature = "race" in ctx.features
+  if env and (race_feature or race_enabled(ctx)):
+    fail("race detector requires cgo, which is disabled in pure mode and when cross-compiling", "race")
+  san = sanitizer(ctx)
+  if san and (race_feature or race_enabled(ctx)):
+    fail("%s can't be used together with the race detector" % san, "race")
+  if san and env:
+    fail("%s requires cgo, which is disabled in pure mode and when cross-compiling" % san, "pure")
+  if san and (shared or dynlink):
+    fail("%s is not supported for c-shared and c-archive libraries, plugins and position-independent executables" % san, "linkmode")
+  if shared and env:
+    fail("c-shared and c-archive libraries and position-independent executables require cgo, which is disabled in pure mode and when cross-compiling", "linkmode")
+  if shared and (race_feature or race_enabled(ctx)):
+    fail("race detector is not supported for c-shared and c-archive libraries and position-independent executables", "race")
+  if dynlink and env:
+    fail("plugins require cgo, which is disabled in pure mode and when cross-compiling", "linkmode")
+  if dynlink and (race_feature or race_enabled(ctx)):
+    fail("race detector is not supported for plugins", "race")
 
-  if library:
+  for library in embed:
     go_srcs += library.go_sources
     asm_srcs += library.asm_sources
     asm_hdrs += library.asm_headers
-    deps += library.direct_deps
+    syso_srcs += library.syso_sources
+    embed_srcs += library.embed_sources
+    deps += library.go_cross_deps if env or shared or dynlink else library.direct_deps
     dep_runfiles += [library.data_runfiles]
     if library.cgo_object:
       if cgo_object:
         fail("go_library %s cannot have cgo_object because the package " +
              "already has cgo_object in %s" % (ctx.label.name,
-                                               library.name))
+                                               library.label))
       cgo_object = library.cgo_object
   if not go_srcs:
     fail("may not be empty", "srcs")
 
   transitive_cgo_deps = depset([], order="link")
   if cgo_object:
+    if env:
+      fail("cgo is disabled in pure mode and when cross-compiling")
     dep_runfiles += [cgo_object.data_runfiles]
     transitive_cgo_deps += cgo_object.cgo_deps
 
-  extra_objects = [cgo_object.cgo_obj] if cgo_object else []
-  for src in asm_srcs:
-    obj = ctx.new_file(src, "%s.dir/%s.o" % (ctx.label.name, src.basename[:-2]))
-    emit_go_asm_action(ctx, src, asm_hdrs, obj)
-    extra_objects += [obj]
+  cgo_obj = cgo_object.cgo_obj if cgo_object else None
+  # Like the go tool, pack .syso files into the archive as they are. The
+  # linker treats them as host objects.
+  extra_objects = syso_srcs
+  if asmopts == None:
+    asmopts = get_asmopts(ctx)
 
-  importpath = go_importpath(ctx)
-  lib_name = importpath + ".a"
-  out_lib = ctx.new_file(lib_name)
-  out_object = ctx.new_file(ctx.label.name + ".o")
+  if importpath == None:
+    importpath = go_importpath(ctx)
+  if importmap == None:
+    importmap = getattr(ctx.attr, "importmap", "") or importpath
+  lib_name = importmap + ".a"
+  # Tests compile the package under test with its internal test files. It
+  # usually has the import path of a library in the same package, so its
+  # archive is written to a directory of its own.
+  if test_package:
+    out_lib = ctx.new_file(ctx.label.name + "~test/" + lib_name)
+  else:
+    out_lib = ctx.new_file(lib_name)
   searchpath = out_lib.path[:-len(lib_name)]
-  gc_goopts = get_gc_goopts(ctx)
+  if gc_goopts == None:
+    gc_goopts = get_gc_goopts(ctx)
   direct_go_library_deps = []
   direct_search_paths = []
   direct_import_paths = []
+  direct_importmaps = []
   transitive_go_library_deps = depset()
   transitive_go_library_paths = depset([searchpath])
+  if env or shared or dynlink:
+    deps = [dep.go_cross for dep in deps]
   for dep in deps:
     direct_go_library_deps += [dep.library]
     direct_search_paths += [dep.searchpath]
     direct_import_paths += [dep.importpath]
+    direct_importmaps += dep_importmaps([dep])
     transitive_go_library_deps += dep.transitive_go_libraries
     transitive_cgo_deps += dep.transitive_cgo_deps
     transitive_go_library_paths += dep.transitive_go_library_paths
 
-  go_srcs = emit_go_compile_action(ctx,
+  cover_vars = emit_go_compile_action(ctx,
       sources = go_srcs,
       libs = direct_go_library_deps,
       lib_paths = direct_search_paths,
       direct_paths = direct_import_paths,
-      out_object = out_object,
+      out_lib = out_lib,
       gc_goopts = gc_goopts,
+      asm_srcs = asm_srcs,
+      asm_hdrs = asm_hdrs,
+      asmopts = asmopts,
+      extra_objects = extra_objects,
+      cgo_obj = cgo_obj,
+      test_package = test_package,
+      embedsrcs = embed_srcs,
+      importmap = importmap if importmap != importpath else None,
+      importmaps = direct_importmaps,
+      race = race_feature,
+      env = env,
+      shared = shared,
+      dynlink = dynlink,
+      unused_deps = unused_deps,
+      dep_labels = dep_labels,
   )
-  emit_go_pack_action(ctx, out_lib, [out_object] + extra_objects)
+
+  # Binaries and tests with race = True link against archives compiled with
+  # -race, so every library also declares a race-mode archive, instrumented
+  # for coverage the same way. Bazel only runs these actions when a race
+  # binary needs them. With --features=race,
+  # everything is compiled with -race already, so the archive is reused.
+  # Cross-compiled binaries and tests, C libraries, plugins and
+  # position-independent executables can't use -race, so they reuse it too.
+  if race_feature or env or shared or dynlink:
+    out_race_lib = out_lib
+    race_searchpath = searchpath
+  else:
+    out_race_lib = ctx.new_file(ctx.label.name + "~race/" + lib_name)
+    race_searchpath = out_race_lib.path[:-len(lib_name)]
+    emit_go_compile_action(ctx,
+        sources = go_srcs,
+        libs = [dep.race_library for dep in deps],
+        lib_paths = [dep.race_searchpath for dep in deps],
+        direct_paths = direct_import_paths,
+        out_lib = out_race_lib,
+        gc_goopts = gc_goopts,
+        asm_srcs = asm_srcs,
+        asm_hdrs = asm_hdrs,
+        asmopts = asmopts,
+        extra_objects = extra_objects,
+        cgo_obj = cgo_obj,
+        test_package = test_package,
+        embedsrcs = embed_srcs,
+        importmap = importmap if importmap != importpath else None,
+        importmaps = direct_importmaps,
+        race = True,
+        nogo = False,
+    )
+  transitive_go_library_race_deps = depset()
+  transitive_go_library_race_paths = depset([race_searchpath])
+  for dep in deps:
+    transitive_go_library_race_deps += dep.transitive_go_libraries_race
+    transitive_go_library_race_paths += dep.transitive_go_library_paths_race
 
   dylibs = []
   if cgo_object:
@@ -95,13 +208,23 @@ def emit_library_actions(ctx, sources, deps, cgo_object, library):
     go_sources = go_srcs,
     asm_sources = asm_srcs,
     asm_headers = asm_hdrs,
+    syso_sources = syso_srcs,
+    embed_sources = embed_srcs,
     importpath = importpath,
+    importmap = importmap,
     cgo_object = cgo_object,
     direct_deps = deps,
     transitive_cgo_deps = transitive_cgo_deps,
     transitive_go_libraries = transitive_go_library_deps + [out_lib],
     transitive_go_library_paths = transitive_go_library_paths,
+    race_library = out_race_lib,
+    race_searchpath = race_searchpath,
+    transitive_go_libraries_race = transitive_go_library_race_deps + [out_race_lib],
+    transitive_go_library_paths_race = transitive_go_library_race_paths,
     gc_goopts = gc_goopts,
+    asmopts = asmopts,
+    cover_vars = cover_vars,
+    unused_deps = depset([unused_deps] if unused_deps else []),
   )
 
 def _go_library_impl(ctx):
@@ -113,62 +236,158 @@ def _go_library_impl(ctx):
       sources = depset(ctx.files.srcs),
       deps = ctx.attr.deps,
       cgo_object = cgo_object,
-      library = ctx.attr.library,
+      embed = get_embed(ctx),
+      embedsrcs = ctx.files.embedsrcs,
+  )
+  return go_library_struct(
+      GoLibrary(label = ctx.label, importpath = lib_result.importpath, importmap = lib_result.importmap),
+      new_go_archive(lib_result),
+      output_groups = {"unused_deps": lib_result.unused_deps},
+  )
+
+def new_go_archive(lib_result, runfiles=None):
+  """Returns the GoArchive for the package emit_library_actions compiled. If
+  runfiles is set, it replaces the runfiles of the result."""
+  if runfiles == None:
+    runfiles = lib_result.runfiles
+  return GoArchive(
+      label = lib_result.label,
+      importpath = lib_result.importpath,
+      importmap = lib_result.importmap,
+      library = lib_result.library,
+      searchpath = lib_result.searchpath,
+      transitive_go_libraries = lib_result.transitive_go_libraries,
+      transitive_go_library_paths = lib_result.transitive_go_library_paths,
+      transitive_cgo_deps = lib_result.transitive_cgo_deps,
+      race_library = lib_result.race_library,
+      race_searchpath = lib_result.race_searchpath,
+      transitive_go_libraries_race = lib_result.transitive_go_libraries_race,
+      transitive_go_library_paths_race = lib_result.transitive_go_library_paths_race,
+      source = GoSource(
+          label = lib_result.label,
+          go_sources = lib_result.go_sources,
+          asm_sources = lib_result.asm_sources,
+          asm_headers = lib_result.asm_headers,
+          syso_sources = lib_result.syso_sources,
+          embed_sources = lib_result.embed_sources,
+          deps = lib_result.direct_deps,
+          cgo_object = lib_result.cgo_object,
+          gc_goopts = lib_result.gc_goopts,
+          asmopts = lib_result.asmopts,
+          runfiles = runfiles,
+      ),
+      runfiles = runfiles,
   )
 
+def go_library_struct(library, archive, output_groups={}, providers=[]):
+  """Returns what a rule returns to be used like a go_library: in the deps and
+  embed of other Go targets, with the GoLibrary, GoSource and GoArchive
+  providers, and the legacy fields the Go rules read. providers are returned
+  as well.
+  """
+  source = archive.source
   return struct(
-    label = ctx.label,
-    files = lib_result.files,
-    library = lib_result.library,
-    searchpath = lib_result.searchpath,
-    runfiles = lib_result.runfiles,
-    go_sources = lib_result.go_sources,
-    asm_sources = lib_result.asm_sources,
-    asm_headers = lib_result.asm_headers,
-    importpath = lib_result.importpath,
-    cgo_object = lib_result.cgo_object,
-    direct_deps = lib_result.direct_deps,
-    transitive_cgo_deps = lib_result.transitive_cgo_deps,
-    transitive_go_libraries = lib_result.transitive_go_libraries,
-    transitive_go_library_paths = lib_result.transitive_go_library_paths,
-    gc_goopts = lib_result.gc_goopts,
+    label = library.label,
+    files = depset([archive.library]),
+    library = archive.library,
+    searchpath = archive.searchpath,
+    runfiles = archive.runfiles,
+    go_sources = source.go_sources,
+    asm_sources = source.asm_sources,
+    asm_headers = source.asm_headers,
+    syso_sources = source.syso_sources,
+    embed_sources = source.embed_sources,
+    importpath = library.importpath,
+    importmap = archive.importmap,
+    cgo_object = source.cgo_object,
+    direct_deps = source.deps,
+    transitive_cgo_deps = archive.transitive_cgo_deps,
+    transitive_go_libraries = archive.transitive_go_libraries,
+    transitive_go_library_paths = archive.transitive_go_library_paths,
+    race_library = archive.race_library,
+    race_searchpath = archive.race_searchpath,
+    transitive_go_libraries_race = archive.transitive_go_libraries_race,
+    transitive_go_library_paths_race = archive.transitive_go_library_paths_race,
+    gc_goopts = source.gc_goopts,
+    asmopts = source.asmopts,
+    output_groups = output_groups,
+    providers = [library, source, archive] + providers,
   )
 
+_library_attrs = {
+    "data": attr.label_list(allow_files = True, cfg = "data"),
+    "srcs": attr.label_list(allow_files = go_filetype),
+    "embedsrcs": attr.label_list(allow_files = True),
+    "deps": attr.label_list(
+        providers = [
+            "transitive_go_library_paths",
+            "transitive_go_libraries",
+            "transitive_cgo_deps",
+        ],
+    ),
+    "importpath": attr.string(),
+    "importmap": attr.string(),
+    "library": attr.label(
+        providers = [
+            "direct_deps",
+            "go_sources",
+            "asm_sources",
+            "cgo_object",
+            "gc_goopts",
+        ],
+    ),
+    "embed": attr.label_list(
+        providers = [
+            "direct_deps",
+            "go_sources",
+            "asm_sources",
+            "cgo_object",
+            "gc_goopts",
+        ],
+    ),
+    "gc_goopts": attr.string_list(),
+    "asmopts": attr.string_list(),
+    "cgo_object": attr.label(
+        providers = [
+            "cgo_obj",
+            "cgo_deps",
+        ],
+    ),
+    "_go_prefix": attr.label(default = go_prefix_default),
+}
+
 go_library = rule(
     _go_library_impl,
-    attrs = {
-        "data": attr.label_list(allow_files = True, cfg = "data"),
-        "srcs": attr.label_list(allow_files = go_filetype),
-        "deps": attr.label_list(
-            providers = [
-                "transitive_go_library_paths",
-                "transitive_go_libraries",
-                "transitive_cgo_deps",
-            ],
-        ),
-        "importpath": attr.string(),
-        "library": attr.label(
-            providers = [
-                "direct_deps",
-                "go_sources",
-                "asm_sources",
-                "cgo_object",
-                "gc_goopts",
-            ],
+    attrs = _library_attrs + {
+        "_nogo": attr.label(
+            default = Label("@io_bazel_rules_nogo//:nogo"),
+            cfg = "host",
         ),
-        "gc_goopts": attr.string_list(),
-        "cgo_object": attr.label(
-            providers = [
-                "cgo_obj",
-                "cgo_deps",
-            ],
+        "_pgo": attr.label(
+            default = Label("@io_bazel_rules_go_pgo//:pgo"),
+            allow_files = True,
         ),
-        #TODO(toolchains): Remove _toolchain attribute when real toolchains arrive
-        "_go_toolchain": attr.label(default = Label("@io_bazel_rules_go_toolchain//:go_toolchain")),
-        "_go_prefix": attr.label(default=Label("//:go_prefix", relative_to_caller_repository = True)),
     },
+    toolchains = [go_toolchain_type],
+    fragments = ["cpp"],
+)
+
+go_tool_library = rule(
+    _go_library_impl,
+    attrs = _library_attrs,
+    toolchains = [go_toolchain_type],
     fragments = ["cpp"],
 )
+"""Like go_library, but the library is never checked by nogo. It's used for
+analyzers and the libraries they depend on, since nogo itself can't be
+checked while it's being built.
+"""
+
+def dep_importmaps(deps):
+  """Returns "importpath=importmap" strings for the deps that were compiled
+  under an importmap, for the importmaps of emit_go_compile_action. deps
+  are libraries, or the structs of their archives."""
+  return ["%s=%s" % (d.importpath, d.importmap) for d in deps if d.importmap != d.importpath]
 
 def go_importpath(ctx):
   """Returns the expected importpath of the go_library being built.
@@ -195,14 +414,167 @@ def go_importpath(ctx):
     path = path[1:]
   return path
 
+def get_embed(ctx):
+  """Returns the targets whose sources are compiled into the target being built.
+
+  This is the contents of the embed attribute, followed by the target in the
+  deprecated library attribute, if it is set.
+  """
+  embed = list(ctx.attr.embed)
+  if ctx.attr.library:
+    embed += [ctx.attr.library]
+  return embed
+
 def get_gc_goopts(ctx):
   gc_goopts = ctx.attr.gc_goopts
-  if ctx.attr.library:
-    gc_goopts += ctx.attr.library.gc_goopts
+  for library in get_embed(ctx):
+    gc_goopts += library.gc_goopts
   return gc_goopts
 
-def emit_go_compile_action(ctx, sources, libs, lib_paths, direct_paths, out_object, gc_goopts):
-  """Construct the command line for compiling Go code.
+def get_asmopts(ctx):
+  asmopts = ctx.attr.asmopts
+  for library in get_embed(ctx):
+    asmopts += library.asmopts
+  return asmopts
+
+def get_nogo(ctx):
+  """Returns the nogo that checks the packages compiled by the rule being
+  built, a struct with the binary and its configuration file, or None if
+  nogo isn't configured with go_register_toolchains, or the rule builds
+  nogo's own dependencies.
+  """
+  nogo = getattr(ctx.attr, "_nogo", None)
+  if not nogo or not hasattr(nogo, "nogo"):
+    return None
+  return nogo.nogo
+
+def get_pgo(ctx):
+  """Returns the pprof profile the packages compiled by the rule being built
+  are optimized with: the pgo attribute of a binary or test, or the default
+  profile passed to go_register_toolchains. Returns None if there's neither,
+  or the rule builds tools, which are never optimized with a profile.
+  """
+  pgo = getattr(ctx.file, "pgo", None)
+  if pgo:
+    return pgo
+  default = getattr(ctx.files, "_pgo", [])
+  if default:
+    return default[0]
+  return None
+
+def race_enabled(ctx):
+  """Returns whether the binary or test being built is compiled and linked
+  with -race: either its race attribute is set, or --features=race was passed.
+  """
+  return getattr(ctx.attr, "race", False) or "race" in ctx.features
+
+def debug_enabled(ctx):
+  """Returns whether the target is built with -c dbg. Go code is then
+  compiled without optimizations and inlining, and linked with its debug
+  information, for debuggers like delve and gdb.
+  """
+  return ctx.var.get("COMPILATION_MODE") == "dbg"
+
+def sanitizer(ctx):
+  """Returns "msan" if the target is built with --features=msan, "asan" if
+  it's built with --features=asan, or None. Go code is then compiled and
+  linked with -msan or -asan, against the standard library built the same
+  way, and the C code of cgo libraries is built with -fsanitize.
+  """
+  msan = "msan" in ctx.features
+  asan = "asan" in ctx.features
+  if msan and asan:
+    fail("--features=msan and --features=asan can't be used together")
+  if msan:
+    return "msan"
+  if asan:
+    return "asan"
+  return None
+
+def c_linkmode_enabled(ctx):
+  """Returns whether the binary being built is linked as a C library, with
+  linkmode "c-shared" or "c-archive", so it and its dependencies must be
+  compiled as position-independent code.
+  This is also called by go_cross_aspect, which has the same attribute.
+  """
+  return getattr(ctx.attr, "linkmode", "normal") in ("c-shared", "c-archive")
+
+def plugin_enabled(ctx):
+  """Returns whether the binary being built is linked as a Go plugin, with
+  linkmode "plugin", so it and its dependencies must be compiled with
+  -dynlink. This is also called by go_cross_aspect.
+  """
+  return getattr(ctx.attr, "linkmode", "normal") == "plugin"
+
+def pie_enabled(ctx):
+  """Returns whether the binary or test being built is linked as a
+  position-independent executable: either its linkmode attribute is "pie",
+  or it's "normal" and --features=pie was passed. Like C libraries, it and
+  its dependencies must be compiled as position-independent code. This is
+  also called by go_cross_aspect.
+  """
+  linkmode = getattr(ctx.attr, "linkmode", "normal")
+  return linkmode == "pie" or (linkmode == "normal" and "pie" in ctx.features)
+
+def pure_env(ctx):
+  """Returns the environment for actions that compile the binary or test
+  being built in pure mode, with cgo disabled, or None if cgo may be used.
+
+  Pure mode is enabled by pure = "on", or by --features=pure unless pure is
+  "off". Binaries and tests for another platform, set with the goos and
+  goarch attributes, are always built in pure mode, since there's no C
+  toolchain for the target. This is also called by go_cross_aspect, which has
+  the same attributes. Libraries are never built in pure mode themselves.
+  """
+  if not hasattr(ctx.attr, "pure"):
+    return None
+  goos = ctx.attr.goos
+  goarch = ctx.attr.goarch
+  pure = ctx.attr.pure
+  if goos or goarch:
+    if pure == "off":
+      fail("cross-compiling requires pure mode", "pure")
+  elif pure == "off" or (pure == "auto" and "pure" not in ctx.features):
+    return None
+  env = dict(get_go_toolchain(ctx).env)
+  if goos:
+    env["GOOS"] = goos
+  if goarch:
+    env["GOARCH"] = goarch
+  env["CGO_ENABLED"] = "0"
+  return env
+
+def get_stdlib(ctx, env=None, race=False, shared=False, dynlink=False):
+  """Returns the go_stdlib code is compiled and linked against, for the
+  platform in env, or the toolchain's, and the mode set by the other
+  arguments and --features=msan or asan. The toolchain has the standard
+  library in every mode for its SDK's platform, and in pure mode for the
+  others, which cross toolchains use without cgo."""
+  go_toolchain = get_go_toolchain(ctx)
+  platform_env = env or go_toolchain.env
+  if env and env.get("CGO_ENABLED") == "0":
+    mode = "pure"
+  elif race:
+    mode = "race"
+  elif shared:
+    mode = "shared"
+  elif dynlink:
+    mode = "dynlink"
+  else:
+    mode = sanitizer(ctx) or "normal"
+  key = stdlib_key(platform_env["GOOS"], platform_env["GOARCH"], mode)
+  if key not in go_toolchain.stdlibs and mode == "normal":
+    key = stdlib_key(platform_env["GOOS"], platform_env["GOARCH"], "pure")
+  if key not in go_toolchain.stdlibs:
+    fail("the Go toolchain %s has no standard library for %s_%s in %s mode" % (
+        go_toolchain.name, platform_env["GOOS"], platform_env["GOARCH"], mode))
+  return go_toolchain.stdlibs[key]
+
+def emit_go_compile_action(ctx, sources, libs, lib_paths, direct_paths, out_lib, gc_goopts, asm_srcs=[], asm_hdrs=[], asmopts=[], extra_objects=[], cgo_obj=None, test_package=None, embedsrcs=[], package=None, importmap=None, importmaps=[], race=False, cover=True, env=None, shared=False, dynlink=False, nogo=True, unused_deps=None, dep_labels=[]):
+  """Emits the action that builds a Go package into an archive. It
+  instruments the sources for coverage, compiles them, assembles asm_srcs
+  and packs them together with extra_objects, and checks the sources with
+  nogo or vet, all in one action.
 
   Args:
     ctx: The skylark Context.
@@ -211,14 +583,71 @@ def emit_go_compile_action(ctx, sources, libs, lib_paths, direct_paths, out_obje
     lib_paths: the set of paths to search for imported libraries.
     direct_paths: iterable of of import paths for the package's direct deps,
       including those in the library attribute. Used for strict dep checking.
-    out_object: the object file that should be produced
-    gc_goopts: additional flags to pass to the compiler.
+    out_lib: the archive that should be produced.
+    gc_goopts: additional flags to pass to the compiler. Flags passed with
+      --define=gc_goopts=... are added to them.
+    asm_srcs: assembly files of the package. They may include go_asm.h,
+      which the compiler writes, and the headers in asm_hdrs.
+    asm_hdrs: headers assembly files may include.
+    asmopts: additional flags to pass to the assembler. Flags passed with
+      --define=asmopts=... are added to them.
+    extra_objects: objects packed into the archive as they are, like .syso
+      files.
+    cgo_obj: if set, the object of a cgo library's C code. It's added to the
+      archive by an action of its own, so the Go code isn't compiled again
+      when only the C code changes.
+    test_package: if "internal", files in an external test package (ending
+      with _test.go, in a package ending with _test) are left out. If
+      "external", only those files are compiled. They are never instrumented
+      for coverage.
+    embedsrcs: files that //go:embed directives in sources may match. Their
+      paths are relative to the package of the target being built, or to
+      package if it is set.
+    package: the package embedsrcs are relative to, when sources are those of
+      a library in another package.
+    importmap: if set, the path the package is compiled as, when it's not the
+      import path it's imported by. out_lib should be named after it.
+    importmaps: "importpath=importmap" strings for the direct deps compiled
+      under an importmap, from dep_importmaps.
+    race: if True, the code is compiled with -race. libs must have been
+      compiled with -race too.
+    cover: if False, sources are not instrumented for coverage, even if
+      the target being built is.
+    env: if set, the environment for the compiler, from pure_env. Otherwise,
+      the toolchain's environment is used.
+    shared: if True, the code is compiled for a C library or
+      a position-independent executable. libs must have been compiled the
+      same way.
+    dynlink: if True, the code is compiled for a plugin, with -dynlink.
+      libs must have been compiled the same way.
+    nogo: if False, the sources are not checked by nogo, or by vet with
+      --features=vet. This is used when the same sources are already checked
+      by another compile action.
+    unused_deps: if set, the file where buildozer commands removing the deps
+      in dep_labels that aren't imported by sources are written.
+    dep_labels: the deps declared by the target being built, as
+      "importpath=label" strings.
+
+  If get_pgo returns a profile, the code is compiled with profile-guided
+  optimization. Standard library packages are imported from the archives
+  get_stdlib returns.
+
+  Returns the coverage variables of the instrumented sources, as
+  "var=path" strings, which are registered by the generated test main.
   """
   go_toolchain = get_go_toolchain(ctx)
-  if ctx.coverage_instrumented():
-    sources = _emit_go_cover_action(ctx, sources)
+  # Sources are instrumented like go test -cover does, in set mode. Like the
+  # files generated by cgo for its own use, tests aren't.
+  cover_vars = []
+  if cover and ctx.coverage_instrumented() and test_package != "external":
+    for src in sources:
+      if (src.basename.endswith(".go") and not src.basename.endswith("_test.go") and
+          not src.basename.startswith("_cgo")):
+        cover_vars += ["GoCover_%d=%s" % (len(cover_vars), src.path)]
   gc_goopts = [ctx.expand_make_variables("gc_goopts", f, {}) for f in gc_goopts]
-  inputs = depset([go_toolchain.go]) + sources + libs
+  gc_goopts += define_flags(ctx, "gc_goopts")
+  stdlib = get_stdlib(ctx, env=env, race=race, shared=shared, dynlink=dynlink)
+  inputs = depset([go_toolchain.go]) + go_toolchain.tools + sources + libs + stdlib.files
   go_sources = [s.path for s in sources if not s.basename.startswith("_cgo")]
   cgo_sources = [s.path for s in sources if s.basename.startswith("_cgo")]
   args = [go_toolchain.go.path]
@@ -226,70 +655,132 @@ def emit_go_compile_action(ctx, sources, libs, lib_paths, direct_paths, out_obje
     args += ["-src", src]
   for dep in direct_paths:
     args += ["-dep", dep]
-  args += ["-o", out_object.path, "-trimpath", ".", "-I", "."]
+  # These are used to report imports missing from deps, with the label of
+  # the target that probably provides them.
+  args += ["-label", str(ctx.label)]
+  go_prefix = getattr(ctx.attr, "_go_prefix", None)
+  if go_prefix:
+    args += ["-prefix", go_prefix.go_prefix]
+  if test_package:
+    args += ["-test_package", test_package]
+  for cover_var in cover_vars:
+    args += ["-cover", cover_var]
+  for src in embedsrcs:
+    args += ["-embedsrc", "%s=%s" % (_embed_path(ctx, src, package), src.path)]
+  inputs += embedsrcs
+  if nogo and "vet" in ctx.features:
+    args += ["-vet"]
+    inputs += go_toolchain.tools
+  nogo = get_nogo(ctx) if nogo else None
+  if nogo:
+    args += ["-nogo", nogo.binary.path]
+    inputs += [nogo.binary]
+    if nogo.config:
+      args += ["-nogo_config", nogo.config.path]
+      inputs += [nogo.config]
+  if asm_srcs:
+    includes = depset([f.dirname for f in asm_hdrs])
+    includes += [f.dirname for f in go_toolchain.headers.cc.transitive_headers]
+    inputs += asm_srcs + asm_hdrs + list(go_toolchain.headers.cc.transitive_headers)
+    for src in asm_srcs:
+      args += ["-asm", src.path]
+    for inc in includes:
+      args += ["-asm_include", inc]
+    asmflags = [ctx.expand_make_variables("asmopts", f, {}) for f in asmopts]
+    asmflags += define_flags(ctx, "asmopts")
+    if shared:
+      asmflags += shared_codegen_flags(go_toolchain)
+    if dynlink:
+      asmflags += ["-dynlink"]
+    for flag in asmflags:
+      args += ["-asmflag", flag]
+  for obj in extra_objects:
+    args += ["-object", obj.path]
+  inputs += extra_objects
+  go_lib = out_lib
+  if cgo_obj:
+    go_lib = ctx.new_file(out_lib, out_lib.basename[:-len(".a")] + ".go.a")
+  outputs = [go_lib]
+  if unused_deps:
+    for dep in dep_labels:
+      args += ["-dep_label", dep]
+    args += ["-unused_deps", unused_deps.path]
+    outputs += [unused_deps]
+  if importmap:
+    args += ["-p", importmap]
+  for entry in importmaps:
+    args += ["-importmap", entry]
+  args += ["-o", go_lib.path, "-trimpath", ".", "-I", ".", "-stdlib", stdlib.searchpath]
   for path in lib_paths:
     args += ["-I", path]
-  args += ["--"] + gc_goopts + cgo_sources
+  args += ["--"] + gc_goopts
+  if debug_enabled(ctx):
+    args += ["-N", "-l"]
+  if race:
+    args += ["-race"]
+  elif sanitizer(ctx):
+    # Race-mode archives of libraries are only linked into race binaries,
+    # which can't be built with a sanitizer.
+    args += ["-" + sanitizer(ctx)]
+  if env and env.get("CGO_ENABLED") == "0":
+    # Pure mode uses the standard library compiled without cgo.
+    args += ["-installsuffix", "pure"]
+  if shared:
+    # C libraries and position-independent executables are linked from
+    # position-independent code, including the standard library built that
+    # way.
+    args += shared_codegen_flags(go_toolchain) + ["-installsuffix", "shared"]
+  if dynlink:
+    # Plugins share packages with the program that loads them, so they're
+    # compiled to be linked dynamically, against the standard library
+    # built that way.
+    args += ["-dynlink", "-installsuffix", "dynlink"]
+  pgo = get_pgo(ctx)
+  if pgo:
+    args += ["-pgoprofile=" + pgo.path]
+    inputs += [pgo]
+  args += cgo_sources
+  params = emit_params_file(ctx, go_lib, args)
   ctx.action(
-      inputs = list(inputs),
-      outputs = [out_object],
-      mnemonic = "GoCompile",
+      inputs = list(inputs) + [params],
+      outputs = outputs,
+      mnemonic = "GoCompilePkg",
       executable = go_toolchain.compile,
-      arguments = args,
-      env = go_toolchain.env,
+      arguments = ["@" + params.path],
+      env = env or go_toolchain.env,
   )
+  if cgo_obj:
+    _emit_go_pack_action(ctx, go_lib, [cgo_obj], out_lib, env=env)
 
-  return sources
-
-def emit_go_pack_action(ctx, out_lib, objects):
-  """Construct the command line for packing objects together.
+  return cover_vars
 
-  Args:
-    ctx: The skylark Context.
-    out_lib: the archive that should be produced
-    objects: an iterable of object files to be added to the output archive file.
-  """
+def _emit_go_pack_action(ctx, in_lib, objects, out_lib, env=None):
+  """Copies the archive in_lib to out_lib, adding objects to it."""
   go_toolchain = get_go_toolchain(ctx)
+  args = ["-go", go_toolchain.go.path, "-in", in_lib.path, "-out", out_lib.path]
+  args += [obj.path for obj in objects]
+  params = emit_params_file(ctx, out_lib, args)
   ctx.action(
-      inputs = objects + go_toolchain.tools,
+      inputs = [in_lib, go_toolchain.go, params] + objects + go_toolchain.tools,
       outputs = [out_lib],
       mnemonic = "GoPack",
-      executable = go_toolchain.go,
-      arguments = ["tool", "pack", "c", out_lib.path] + [a.path for a in objects],
-      env = go_toolchain.env,
+      executable = go_toolchain.pack,
+      arguments = ["@" + params.path],
+      env = env or go_toolchain.env,
   )
 
-def _emit_go_cover_action(ctx, sources):
-  """Construct the command line for test coverage instrument.
-
-  Args:
-    ctx: The skylark Context.
-    sources: an iterable of Go source files.
-
-  Returns:
-    A list of Go source code files which might be coverage instrumented.
-  """
-  go_toolchain = get_go_toolchain(ctx)
-  outputs = []
-  # TODO(linuxerwang): make the mode configurable.
-  count = 0
-
-  for src in sources:
-    if not src.path.endswith(".go") or src.path.endswith("_test.go"):
-      outputs += [src]
-      continue
-
-    cover_var = "GoCover_%d" % count
-    out = ctx.new_file(src, src.basename[:-3] + '_' + cover_var + '.cover.go')
-    outputs += [out]
-    ctx.action(
-        inputs = [src] + go_toolchain.tools,
-        outputs = [out],
-        mnemonic = "GoCover",
-        executable = go_toolchain.go,
-        arguments = ["tool", "cover", "--mode=set", "-var=%s" % cover_var, "-o", out.path, src.path],
-        env = go_toolchain.env,
-    )
-    count += 1
-
-  return outputs
+def _embed_path(ctx, src, package=None):
+  """Returns the path of src relative to package, or to the package of the
+  target being built, which is how //go:embed patterns refer to it. Files in
+  other packages can't be embedded, as in the go command."""
+  path = src.short_path
+  if path.startswith("../"):
+    # Files in external repositories start with ../<repository>/.
+    path = path.split("/", 2)[2]
+  if package == None:
+    package = ctx.label.package
+  if not package:
+    return path
+  if not path.startswith(package + "/"):
+    fail("%s is not in the directory of package %s" % (src.short_path, package), "embedsrcs")
+  return path[len(package) + 1:]
@@ -0,0 +1,183 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:common.bzl", "go_toolchain_type")
+load("@io_bazel_rules_go//go/private:library.bzl", "dep_importmaps", "emit_go_compile_action")
+load("@io_bazel_rules_go//go/private:binary.bzl", "emit_go_link_action")
+
+# Analyzers from golang.org/x/tools/go/analysis/passes that check the same
+# things as go vet. printf is left out, since it relies on facts about the
+# packages a package imports, which nogo doesn't pass between packages.
+_VET_ANALYZERS = [
+    "asmdecl",
+    "assign",
+    "atomic",
+    "bools",
+    "buildtag",
+    "composite",
+    "copylock",
+    "httpresponse",
+    "loopclosure",
+    "lostcancel",
+    "nilfunc",
+    "shift",
+    "stdmethods",
+    "structtag",
+    "tests",
+    "unmarshal",
+    "unreachable",
+    "unsafeptr",
+    "unusedresult",
+]
+
+_ANALYZERS_GO = """package main
+
+import (
+	"golang.org/x/tools/go/analysis"
+{imports}
+)
+
+var analyzers = []*analysis.Analyzer{{
+{analyzers}
+}}
+"""
+
+def _nogo_impl(ctx):
+  """Builds the nogo binary: the driver in nogo_main.go, and a generated
+  file listing the analyzers it runs. The binary and the configuration file
+  are provided to the compile actions that run it."""
+  analyzers_go = ctx.new_file(ctx.label.name + "~nogo/analyzers.go")
+  imports = []
+  analyzers = []
+  for i, dep in enumerate(ctx.attr.deps):
+    imports += ["\tanalyzer%d \"%s\"" % (i, dep.importpath)]
+    analyzers += ["\tanalyzer%d.Analyzer," % i]
+  ctx.file_action(analyzers_go, _ANALYZERS_GO.format(
+      imports = "\n".join(imports),
+      analyzers = "\n".join(analyzers),
+  ))
+
+  deps = ctx.attr.deps + [ctx.attr._analysis]
+  main_lib = ctx.new_file(ctx.label.name + "~nogo/main.a")
+  emit_go_compile_action(ctx,
+      sources = depset([analyzers_go] + ctx.files._nogo_srcs),
+      libs = [dep.library for dep in deps],
+      lib_paths = [dep.searchpath for dep in deps],
+      direct_paths = [dep.importpath for dep in deps],
+      out_lib = main_lib,
+      gc_goopts = [],
+      importmaps = dep_importmaps(deps),
+      cover = False,
+      nogo = False,
+  )
+
+  transitive_go_libraries = depset()
+  transitive_go_library_paths = depset()
+  transitive_cgo_deps = depset([], order="link")
+  for dep in deps:
+    transitive_go_libraries += dep.transitive_go_libraries
+    transitive_go_library_paths += dep.transitive_go_library_paths
+    transitive_cgo_deps += dep.transitive_cgo_deps
+  emit_go_link_action(ctx,
+      transitive_go_libraries = transitive_go_libraries,
+      transitive_go_library_paths = transitive_go_library_paths,
+      cgo_deps = transitive_cgo_deps,
+      libs = [main_lib],
+      executable = ctx.outputs.executable,
+      gc_linkopts = [],
+      x_defs = {},
+  )
+  return struct(
+      files = depset([ctx.outputs.executable]),
+      nogo = struct(
+          binary = ctx.outputs.executable,
+          config = ctx.file.config,
+      ),
+  )
+
+_nogo = rule(
+    _nogo_impl,
+    attrs = {
+        "deps": attr.label_list(
+            providers = [
+                "importpath",
+                "transitive_go_library_paths",
+                "transitive_go_libraries",
+                "transitive_cgo_deps",
+            ],
+        ),
+        "config": attr.label(allow_single_file = [".json"]),
+        "_nogo_srcs": attr.label_list(
+            default = [
+                Label("@io_bazel_rules_go//go/tools/builders:importmap.go"),
+                Label("@io_bazel_rules_go//go/tools/builders:nogo_config.go"),
+                Label("@io_bazel_rules_go//go/tools/builders:nogo_main.go"),
+            ],
+            allow_files = True,
+        ),
+        "_analysis": attr.label(
+            default = Label("@org_golang_x_tools//go/analysis:go_tool_library"),
+        ),
+    },
+    toolchains = [go_toolchain_type],
+    executable = True,
+    fragments = ["cpp"],
+)
+
+def nogo(name, deps = [], vet = False, **kwargs):
+  """Builds a nogo binary, which runs the analyzers in deps on every Go
+  package that's compiled, once it's set with go_register_toolchains.
+
+  Each of deps is a go_tool_library with a package-level variable named
+  Analyzer, of type *analysis.Analyzer. If vet is True, the analyzers from
+  golang.org/x/tools that check the same things as go vet are added.
+  config, passed through kwargs, is a JSON file that turns analyzers off,
+  or limits the files they report issues in.
+  """
+  if vet:
+    deps = deps + [
+        "@org_golang_x_tools//go/analysis/passes/%s:go_tool_library" % analyzer
+        for analyzer in _VET_ANALYZERS
+    ]
+  _nogo(name = name, deps = deps, **kwargs)
+
+def _go_nogo_repository_impl(ctx):
+  if ctx.attr.nogo:
+    ctx.file("BUILD.bazel", """
+alias(
+    name = "nogo",
+    actual = "{}",
+    visibility = ["//visibility:public"],
+)
+""".format(ctx.attr.nogo))
+  else:
+    # Without nogo, the rules depend on an empty filegroup, and nothing is
+    # checked.
+    ctx.file("BUILD.bazel", """
+filegroup(
+    name = "nogo",
+    visibility = ["//visibility:public"],
+)
+""")
+
+go_nogo_repository = repository_rule(
+    _go_nogo_repository_impl,
+    attrs = {
+        "nogo": attr.string(),
+    },
+)
+"""Declares @io_bazel_rules_nogo//:nogo, which the Go rules depend on to
+find the nogo binary. It's an alias of the nogo target passed to
+go_register_toolchains, or an empty filegroup if none was passed.
+"""
@@ -0,0 +1,43 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+def _go_pgo_repository_impl(ctx):
+  if ctx.attr.pgo:
+    ctx.file("BUILD.bazel", """
+alias(
+    name = "pgo",
+    actual = "{}",
+    visibility = ["//visibility:public"],
+)
+""".format(ctx.attr.pgo))
+  else:
+    # Without a default profile, the rules depend on an empty filegroup, and
+    # only binaries and tests with a pgo attribute are optimized.
+    ctx.file("BUILD.bazel", """
+filegroup(
+    name = "pgo",
+    visibility = ["//visibility:public"],
+)
+""")
+
+go_pgo_repository = repository_rule(
+    _go_pgo_repository_impl,
+    attrs = {
+        "pgo": attr.string(),
+    },
+)
+"""Declares @io_bazel_rules_go_pgo//:pgo, the default profile Go packages are
+compiled with. It's an alias of the pprof profile passed to
+go_register_toolchains, or an empty filegroup if none was passed.
+"""
@@ -0,0 +1,81 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:providers.bzl", "GoArchive", "GoLibrary", "GoPkgInfo")
+
+def _go_pkg_info_aspect_impl(target, ctx):
+  """Collects the packages of a Go target and of everything it depends on, and
+  writes a JSON file describing the target's own package. Paths in the file
+  are relative to the execution root, like those of the files in the
+  output groups."""
+  pkg_info = depset()
+  archives = depset()
+  sources = depset()
+  deps = list(getattr(ctx.rule.attr, "deps", []))
+  deps += getattr(ctx.rule.attr, "embed", [])
+  if getattr(ctx.rule.attr, "library", None):
+    deps += [ctx.rule.attr.library]
+  for dep in deps:
+    if GoPkgInfo in dep:
+      pkg_info += dep[GoPkgInfo].go_pkg_info
+      archives += dep[GoPkgInfo].go_archives
+      sources += dep[GoPkgInfo].go_sources
+
+  if GoArchive in target:
+    archive = target[GoArchive]
+    source = archive.source
+    other_files = source.asm_sources + source.asm_headers + source.syso_sources
+    json = ctx.new_file(ctx.label.name + ".pkg.json")
+    ctx.file_action(
+        output = json,
+        content = struct(
+            ID = str(target.label),
+            PkgPath = archive.importpath,
+            GoFiles = [f.path for f in source.go_sources],
+            OtherFiles = [f.path for f in other_files],
+            EmbedFiles = [f.path for f in source.embed_sources],
+            ExportFile = archive.library.path,
+            Imports = [d[GoLibrary].importpath for d in source.deps if GoLibrary in d],
+        ).to_json(),
+    )
+    pkg_info += [json]
+    archives += [archive.library]
+    sources += source.go_sources + other_files + source.embed_sources
+
+  info = GoPkgInfo(
+      go_pkg_info = pkg_info,
+      go_archives = archives,
+      go_sources = sources,
+  )
+  return struct(
+      output_groups = {
+          "go_pkg_info": pkg_info,
+          "go_archives": archives,
+          "go_sources": sources,
+      },
+      providers = [info],
+  )
+
+go_pkg_info_aspect = aspect(
+    _go_pkg_info_aspect_impl,
+    attr_aspects = ["deps", "embed", "library"],
+)
+"""Walks Go targets and everything they depend on, and provides GoPkgInfo,
+with their sources, their archives, which hold the export data tools
+type-check against, and a JSON description of each package. IDE drivers and
+analysis tools can build it from the command line:
+
+    bazel build --aspects=@io_bazel_rules_go//go:def.bzl%go_pkg_info_aspect \\
+        --output_groups=go_pkg_info,go_archives //...
+"""
@@ -0,0 +1,88 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# The providers of the Go rules that other rules may depend on. The fields of
+# each are listed below, and are kept compatible across releases. The legacy
+# struct fields the rules also return, like go_sources and
+# transitive_go_libraries, are implementation details.
+
+GoLibrary = provider()
+"""Identifies a Go package: provided by go_library, go_binary and go_test,
+and by rules that build packages with go_context.
+
+Fields:
+  label: The label of the target that builds the package.
+  importpath: The import path of the package.
+  importmap: The path the package is compiled as, which its archive is named
+    after. It's the import path, unless the importmap attribute sets another,
+    so packages with the same import path can be linked together.
+"""
+
+GoSource = provider()
+"""The sources of a Go package, with everything needed to compile them:
+provided by go_library and go_source, and returned by
+go_context(ctx).library_to_source.
+
+Fields:
+  label: The label of the target the sources come from.
+  go_sources: A depset of the .go files.
+  asm_sources: A list of the .s and .S files.
+  asm_headers: A list of the .h files the assembly files may include.
+  syso_sources: A list of the .syso files, packed into the archive as they are.
+  embed_sources: A list of the files //go:embed directives may match.
+  deps: A list of the targets providing the packages the sources import.
+  cgo_object: The cgo_object the package is linked with, or None.
+  gc_goopts: A list of flags for the compiler.
+  asmopts: A list of flags for the assembler.
+  runfiles: The runfiles of the sources, from data and deps.
+"""
+
+GoArchive = provider()
+"""A compiled Go package, and what's needed to link it: provided by
+go_library, and returned by go_context(ctx).archive. go_binary and go_test
+provide the archive of their own package. The archive holds the export data
+other packages are compiled against.
+
+Fields:
+  label: The label of the target that compiled the archive.
+  importpath: The import path of the package.
+  importmap: The path the package is compiled as.
+  library: The archive file, <importmap>.a.
+  searchpath: The directory the compiler and linker search for the archive
+    with -I and -L.
+  transitive_go_libraries: A depset of the archives of the package and of
+    everything it imports.
+  transitive_go_library_paths: A depset of their search paths.
+  transitive_cgo_deps: A depset of the C libraries and objects cgo packages
+    among them are linked with.
+  race_library, race_searchpath, transitive_go_libraries_race,
+    transitive_go_library_paths_race: The same, compiled with -race.
+  source: The GoSource the archive was compiled from. Its go_sources are
+    instrumented for coverage when coverage is collected.
+  runfiles: The runfiles of the package and of everything it imports.
+"""
+
+GoPkgInfo = provider()
+"""The Go packages a target depends on, transitively: provided by
+go_pkg_info_aspect. The files are in output groups of the same names, so
+they can be built for targets on the command line.
+
+Fields:
+  go_pkg_info: A depset of JSON files, one per package, describing it: its
+    label (ID), import path (PkgPath), sources (GoFiles, OtherFiles,
+    EmbedFiles), archive (ExportFile) and the import paths of its direct
+    dependencies (Imports).
+  go_archives: A depset of the archives of the packages.
+  go_sources: A depset of the sources of the packages.
+"""
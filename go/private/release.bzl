@@ -0,0 +1,147 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:binary.bzl", "go_cross_binary")
+
+# Values accepted by the format attribute of _go_release_archive.
+RELEASE_FORMATS = [
+    "tar.gz",
+    "zip",
+]
+
+_release = Label("@io_bazel_rules_go//go/tools/builders:release")
+
+def _go_release_archive_outputs(out):
+  return {"archive": out}
+
+def _go_release_archive_impl(ctx):
+  archive = ctx.outputs.archive
+  args = ["-mode", "archive", "-format", ctx.attr.format, "-out", archive.path,
+          "-executable", ctx.attr.binary_name, "--",
+          ctx.attr.binary_name, ctx.file.binary.path]
+  for src in ctx.files.srcs:
+    args += [src.basename, src.path]
+  ctx.action(
+      inputs = [ctx.file.binary] + ctx.files.srcs,
+      outputs = [archive],
+      executable = ctx.executable._release,
+      arguments = args,
+      mnemonic = "GoReleaseArchive",
+  )
+  return struct(files = depset([archive]))
+
+_go_release_archive = rule(
+    _go_release_archive_impl,
+    attrs = {
+        "binary": attr.label(mandatory = True, allow_single_file = True),
+        "binary_name": attr.string(mandatory = True),
+        "srcs": attr.label_list(allow_files = True),
+        "format": attr.string(values = RELEASE_FORMATS, mandatory = True),
+        "out": attr.string(mandatory = True),
+        "_release": attr.label(default = _release, executable = True, cfg = "host"),
+    },
+    outputs = _go_release_archive_outputs,
+)
+"""Packages binary, as binary_name, and srcs, by their basenames, into the
+archive out."""
+
+def _go_release_checksums_impl(ctx):
+  checksums = ctx.outputs.checksums
+  ctx.action(
+      inputs = ctx.files.srcs,
+      outputs = [checksums],
+      executable = ctx.executable._release,
+      arguments = ["-mode", "checksums", "-out", checksums.path, "--"] +
+                  [src.path for src in ctx.files.srcs],
+      mnemonic = "GoReleaseChecksums",
+  )
+  return struct(files = depset([checksums]))
+
+_go_release_checksums = rule(
+    _go_release_checksums_impl,
+    attrs = {
+        "srcs": attr.label_list(allow_files = True),
+        "_release": attr.label(default = _release, executable = True, cfg = "host"),
+    },
+    outputs = {"checksums": "%{name}.txt"},
+)
+"""Writes the SHA-256 checksums of srcs to <name>.txt, in the format of
+sha256sum."""
+
+def _label_name(label):
+  if ":" in label:
+    return label.rpartition(":")[2]
+  return label.rpartition("/")[2]
+
+def go_release(name, binary, platforms, srcs = [], binary_name = None, pure = "auto", **kwargs):
+  """Builds binary, a go_binary, for each of platforms and packages it for
+  release.
+
+  Each platform is a "<goos>_<goarch>" string, like "linux_amd64". For each
+  one, a go_cross_binary <name>_<goos>_<goarch> is declared, and packaged
+  with srcs into <name>_<goos>_<goarch>.zip on Windows, and
+  <name>_<goos>_<goarch>.tar.gz elsewhere. In the archive, the binary is
+  named binary_name, which defaults to the name of the binary target, with
+  ".exe" added on Windows, and srcs are at their basenames.
+  <name>_checksums.txt lists the SHA-256 checksums of the archives, and the
+  target <name> builds the archives and the checksums.
+
+  pure is passed to each go_cross_binary, and the remaining arguments, like
+  tags and visibility, to every target.
+  """
+  if not platforms:
+    fail("at least one platform must be listed", "platforms")
+  if not binary_name:
+    binary_name = _label_name(binary)
+  archives = []
+  for platform in platforms:
+    goos, _, goarch = platform.partition("_")
+    if not goos or not goarch:
+      fail("%s is not a <goos>_<goarch> platform" % platform, "platforms")
+    platform_name = "%s_%s_%s" % (name, goos, goarch)
+    go_cross_binary(
+        name = platform_name,
+        target = binary,
+        goos = goos,
+        goarch = goarch,
+        pure = pure,
+        **kwargs
+    )
+    if goos == "windows":
+      format = "zip"
+      archive_binary_name = binary_name + ".exe"
+    else:
+      format = "tar.gz"
+      archive_binary_name = binary_name
+    out = "%s.%s" % (platform_name, format)
+    _go_release_archive(
+        name = platform_name + "_archive",
+        binary = ":" + platform_name,
+        binary_name = archive_binary_name,
+        srcs = srcs,
+        format = format,
+        out = out,
+        **kwargs
+    )
+    archives.append(":" + out)
+  _go_release_checksums(
+      name = name + "_checksums",
+      srcs = archives,
+      **kwargs
+  )
+  native.filegroup(
+      name = name,
+      srcs = archives + [":%s_checksums.txt" % name],
+      **kwargs
+  )
@@ -14,9 +14,17 @@
 
 # Once nested repositories work, this file should cease to exist.
 
-load("@io_bazel_rules_go//go/private:toolchain.bzl", "go_sdk_repository", "go_repository_select")
+load("@io_bazel_rules_go//go/private:toolchain.bzl",
+    "go_download_sdk_repository",
+    "go_host_sdk_repository",
+    "go_sdk_repository",
+    "go_version_repository",
+    "go_wrap_sdk_repository",
+)
 load("@io_bazel_rules_go//go/private:repository_tools.bzl", "go_repository_tools")
 load("@io_bazel_rules_go//go/private:go_repository.bzl", "go_repository")
+load("@io_bazel_rules_go//go/private:nogo.bzl", "go_nogo_repository")
+load("@io_bazel_rules_go//go/private:pgo.bzl", "go_pgo_repository")
 
 _sdk_repositories = {
     # 1.8.3 repositories
@@ -55,22 +63,55 @@ _sdk_repositories = {
     'go1.7.5.darwin-amd64.tar.gz': '2e2a5e0a5c316cf922cf7d59ee5724d49fc35b07a154f6c4196172adfc14b2ca',
 }
 
+# Platforms each host SDK has cross-compiling toolchains for, as
+# <goos>_<goarch>; js_wasm requires Go 1.11. Keep this in sync with
+# cross_targets in go/toolchain/toolchains.bzl.
+_cross_targets = {
+    "darwin_amd64": ["darwin_arm64", "js_wasm", "linux_amd64", "linux_arm", "linux_arm64"],
+    "linux_amd64": ["darwin_amd64", "darwin_arm64", "js_wasm", "linux_386", "linux_arm", "linux_arm64", "windows_amd64"],
+}
+
+# Hosts toolchains are declared for in //go/toolchain, as <goos>_<goarch>,
+# and the names of those toolchains after the version. Keep this in sync with
+# the hosts in go/toolchain/toolchains.bzl.
+_toolchain_hosts = {
+    "darwin_amd64": "osx-x86_64",
+    "freebsd_386": "freebsd-386",
+    "freebsd_amd64": "freebsd-x86_64",
+    "linux_386": "linux-386",
+    "linux_amd64": "linux-x86_64",
+    "windows_386": "windows-386",
+    "windows_amd64": "windows-x86_64",
+}
+
+DEFAULT_GO_VERSION = "1.8.3"
+
 def go_repositories(
     go_version = None,
     go_linux = None,
     go_darwin = None):
+  """Declares the external repositories the Go rules depend on, including an
+  SDK for each supported Go version and host. SDKs are only downloaded when
+  they're used. The toolchains that use them have to be registered with
+  go_register_toolchains. For compatibility, this is done here if go_version
+  is set.
+  """
 
   for filename, sha256 in _sdk_repositories.items():
     name = filename
     for suffix in [".tar.gz", ".zip"]:
       if name.endswith(suffix):
         name = name[:-len(suffix)]
+    host = name[name.rfind(".") + 1:].replace("-", "_")
+    goos, goarch = host.split("_")
     name = name.replace("-", "_").replace(".", "_")
     go_sdk_repository(
         name = name,
         url = "https://storage.googleapis.com/golang/" + filename,
         sha256 = sha256,
         strip_prefix = "go",
+        goos = goos,
+        goarch = "arm" if goarch == "armv6l" else goarch,
     )
 
   # Needed for gazelle and wtool
@@ -81,14 +122,189 @@ def go_repositories(
       type = "zip",
   )
 
-  # Needed for fetch repo
-  go_repository(
-      name = "org_golang_x_tools",
-      importpath = "golang.org/x/tools",
-      urls = ["https://codeload.github.com/golang/tools/zip/3d92dd60033c312e3ae7cac319c792271cf67e37"],
-      strip_prefix = "tools-3d92dd60033c312e3ae7cac319c792271cf67e37",
-      type = "zip",
-  )
+  # Needed for fetch repo. nogo needs a newer version with go/analysis, so
+  # a version declared earlier in WORKSPACE is used instead.
+  if not native.existing_rule("org_golang_x_tools"):
+    go_repository(
+        name = "org_golang_x_tools",
+        importpath = "golang.org/x/tools",
+        urls = ["https://codeload.github.com/golang/tools/zip/3d92dd60033c312e3ae7cac319c792271cf67e37"],
+        strip_prefix = "tools-3d92dd60033c312e3ae7cac319c792271cf67e37",
+        type = "zip",
+    )
 
-  go_repository_select(name = "io_bazel_rules_go_toolchain", go_version = go_version)
   go_repository_tools(name = "io_bazel_rules_go_repository_tools")
+
+  if go_version:
+    go_register_toolchains(go_version)
+
+def _version_sdks(version):
+  """Returns the SDK repositories declared by go_repositories for version, as
+  a dict from <goos>_<goarch> to the repository name."""
+  prefixes = ["go" + version.replace(".", "_") + "_"]
+  if version.endswith(".0"):
+    # Repositories for x.y.0 releases are named after x.y.
+    prefixes += ["go" + version[:-len(".0")].replace(".", "_") + "_"]
+  sdks = {}
+  for rule in native.existing_rules().values():
+    if rule["kind"] != "go_sdk_repository":
+      continue
+    for prefix in prefixes:
+      if rule["name"].startswith(prefix + rule["goos"] + "_"):
+        sdks[rule["goos"] + "_" + rule["goarch"]] = rule["name"]
+  return sdks
+
+def _full_version(version):
+  """Returns a Go version with a point release, like 1.8.0 for 1.8."""
+  if version.count(".") == 1:
+    return version + ".0"
+  return version
+
+def go_register_toolchains(go_version = DEFAULT_GO_VERSION, additional_versions = [], nogo = None, pgo = None, boringcrypto = None):
+  """Registers the Go toolchains declared in //go/toolchain for go_version,
+  so Bazel's toolchain resolution selects the SDK for that version when
+  building for and on each supported host. That includes the toolchains
+  that cross-compile from each host, and the bootstrap toolchains used to
+  build the builders. go_repositories must be called first, since it
+  declares the SDKs. Toolchains registered earlier in WORKSPACE, or with
+  --extra_toolchains, take precedence over these.
+
+  The toolchains for additional_versions are registered too, but they're
+  only selected when building for a platform with the version's constraint,
+  like @io_bazel_rules_go//go/toolchain:go1.7.5. So are the toolchains for
+  go_version, so a platform can ask for it explicitly.
+
+  If nogo is set, it's the label of a nogo target, and every Go package
+  compiled by go_library, go_binary and go_test is checked by it. The build
+  fails if it finds any issues.
+
+  If pgo is set, it's the label of a pprof CPU profile, and every Go package
+  is compiled with profile-guided optimization using it. A go_binary or
+  go_test with a pgo attribute compiles its own package with that profile
+  instead.
+
+  If boringcrypto is set, it's the name of an SDK repository declared with
+  go_download_sdk, go_host_sdk or go_wrap_sdk, with register_toolchains =
+  False. Its BoringCrypto toolchain is registered ahead of the others, and is
+  selected when building for a platform with the constraint
+  @io_bazel_rules_go//go/toolchain:boringcrypto, so binaries that must use
+  FIPS 140 validated crypto are built with it, and the others aren't. The
+  SDK must be a b-series release, like 1.8.3b4, or Go 1.19 or later, which is
+  run with GOEXPERIMENT=boringcrypto.
+  """
+  go_version = _full_version(go_version)
+  boringcrypto_toolchains = []
+  if boringcrypto:
+    boringcrypto_toolchains = ["@%s//:boringcrypto_toolchain" % boringcrypto]
+  versioned = []
+  for version in [go_version] + additional_versions:
+    versioned += [name + "-versioned" for name in _version_toolchains(_full_version(version), bootstrap = False)]
+  toolchains = _version_toolchains(go_version, bootstrap = True)
+
+  if not native.existing_rule("io_bazel_rules_go_toolchain"):
+    go_version_repository(
+        name = "io_bazel_rules_go_toolchain",
+        go_version = go_version,
+        sdks = _version_sdks(go_version),
+    )
+  if not native.existing_rule("io_bazel_rules_nogo"):
+    go_nogo_repository(name = "io_bazel_rules_nogo", nogo = nogo or "")
+  if not native.existing_rule("io_bazel_rules_go_pgo"):
+    go_pgo_repository(name = "io_bazel_rules_go_pgo", pgo = pgo or "")
+  native.register_toolchains(*(boringcrypto_toolchains + versioned + toolchains))
+
+def _version_toolchains(go_version, bootstrap):
+  """Returns the labels of the toolchains in //go/toolchain for go_version on
+  each host, including the cross toolchains, and the bootstrap toolchains if
+  bootstrap is True."""
+  toolchains = []
+  for filename in _sdk_repositories:
+    name = filename
+    for suffix in [".tar.gz", ".zip"]:
+      if name.endswith(suffix):
+        name = name[:-len(suffix)]
+    version, _, host = name[len("go"):].rpartition(".")
+    host = host.replace("-", "_")
+    if _full_version(version) != go_version or host not in _toolchain_hosts:
+      continue
+    toolchain = "@io_bazel_rules_go//go/toolchain:go%s-%s" % (go_version, _toolchain_hosts[host])
+    toolchains += [toolchain]
+    if bootstrap:
+      toolchains += [toolchain + "-bootstrap"]
+    for target in _cross_targets.get(host, []):
+      goos, goarch = target.split("_")
+      target_os = "osx" if goos == "darwin" else goos
+      target_arch = "x86_64" if goarch == "amd64" else goarch
+      toolchains += ["%s-cross-%s-%s" % (toolchain, target_os, target_arch)]
+  if not toolchains:
+    fail("go_register_toolchains: there is no SDK for Go version %s" % go_version)
+  return toolchains
+
+# Hosts go_download_sdk can find release archives for when only a version is
+# given, and the platforms in their file names.
+_download_hosts = {
+    "darwin_amd64": "darwin-amd64",
+    "linux_386": "linux-386",
+    "linux_amd64": "linux-amd64",
+    "linux_arm": "linux-armv6l",
+    "linux_arm64": "linux-arm64",
+}
+
+def go_download_sdk(name, sdks = None, version = None, sha256s = {}, urls = None, strip_prefix = None, register_toolchains = True):
+  """Downloads an SDK release and registers its toolchains. sdks is a dict
+  from <goos>_<goarch> to the archive's file name and SHA-256 sum; only the
+  entry for the host is downloaded. Instead of sdks, version may be given,
+  and the archives are named as they are for official releases. Their sums
+  are looked up in sha256s, a dict from <goos>_<goarch> to SHA-256 sum, then
+  in the sums of the SDKs go_repositories knows about. urls are templates,
+  where the file name is substituted for {}, or base URLs of mirrors.
+  This must be called before go_register_toolchains, so the toolchains using
+  this SDK are selected first. If register_toolchains is False, they aren't
+  registered, and the SDK is only used if it's passed to
+  go_register_toolchains as boringcrypto.
+  """
+  if not sdks:
+    if not version:
+      fail("go_download_sdk: either sdks or version must be set")
+    sdks = {}
+    for host, platform in _download_hosts.items():
+      filename = "go%s.%s.tar.gz" % (version, platform)
+      sdks[host] = [filename, sha256s.get(host, _sdk_repositories.get(filename, ""))]
+  kwargs = {}
+  if urls:
+    kwargs["urls"] = urls
+  if strip_prefix != None:
+    kwargs["strip_prefix"] = strip_prefix
+  go_download_sdk_repository(name = name, sdks = sdks, **kwargs)
+  if register_toolchains:
+    _register_sdk_toolchains(name)
+
+def go_host_sdk(name, register_toolchains = True):
+  """Uses the SDK installed on the host, found through GOROOT or the go command
+  on PATH, and registers its toolchains. This must be called before
+  go_register_toolchains, so the toolchains using this SDK are selected first.
+  register_toolchains works like it does for go_download_sdk.
+  """
+  go_host_sdk_repository(name = name)
+  if register_toolchains:
+    _register_sdk_toolchains(name)
+
+def go_wrap_sdk(name, path, register_toolchains = True):
+  """Uses the SDK installed at path, an absolute GOROOT, and registers its
+  toolchains. This must be called before go_register_toolchains, so the
+  toolchains using this SDK are selected first. register_toolchains works
+  like it does for go_download_sdk.
+  """
+  go_wrap_sdk_repository(name = name, path = path)
+  if register_toolchains:
+    _register_sdk_toolchains(name)
+
+def _register_sdk_toolchains(name):
+  """Registers the toolchains declared by the SDK repository name, and
+  records its Go version for go_repository if no other SDK has."""
+  if not native.existing_rule("io_bazel_rules_go_toolchain"):
+    go_version_repository(name = "io_bazel_rules_go_toolchain", sdk = name)
+  native.register_toolchains(
+      "@%s//:go_toolchain" % name,
+      "@%s//:bootstrap_toolchain" % name,
+  )
@@ -0,0 +1,160 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:providers.bzl", "GoLibrary")
+
+# Values accepted by the format attribute of go_sbom.
+GO_SBOM_FORMATS = [
+    "spdx",
+    "cyclonedx",
+]
+
+# The repository go_repository_metadata declares, which go_sbom reads.
+_METADATA_REPOSITORY = "io_bazel_rules_go_repository_metadata"
+
+def _repository_name(label):
+  """Returns the name of the repository label is in, or "" for the main
+  repository."""
+  root = label.workspace_root
+  if root.startswith("external/"):
+    return root[len("external/"):]
+  return root
+
+def _go_sbom_aspect_impl(target, ctx):
+  """Collects the packages a Go target depends on, transitively, as
+  "repository=importpath" strings in go_sbom_packages."""
+  packages = depset()
+  deps = list(getattr(ctx.rule.attr, "deps", []))
+  deps += getattr(ctx.rule.attr, "embed", [])
+  if getattr(ctx.rule.attr, "library", None):
+    deps += [ctx.rule.attr.library]
+  for dep in deps:
+    if hasattr(dep, "go_sbom_packages"):
+      packages += dep.go_sbom_packages
+  if GoLibrary in target:
+    packages += ["%s=%s" % (_repository_name(target.label), target[GoLibrary].importpath)]
+  return struct(go_sbom_packages = packages)
+
+go_sbom_aspect = aspect(
+    _go_sbom_aspect_impl,
+    attr_aspects = ["deps", "embed", "library"],
+)
+"""Collects the transitive packages of Go targets for go_sbom."""
+
+def _go_sbom_impl(ctx):
+  if ctx.attr.format == "spdx":
+    out = ctx.new_file(ctx.label.name + ".spdx.json")
+  else:
+    out = ctx.new_file(ctx.label.name + ".cdx.json")
+  inventory = ctx.new_file(ctx.label.name + ".licenses.json")
+  args = [
+      "-name", str(ctx.attr.binary.label),
+      "-format", ctx.attr.format,
+      "-metadata", ctx.file._metadata.path,
+      "-out", out.path,
+      "-inventory", inventory.path,
+  ]
+  for package in ctx.attr.binary.go_sbom_packages:
+    args += ["-package", package]
+  # The license files of each repository are named after it.
+  for license in ctx.files._licenses:
+    args += ["-license", "%s=%s" % (license.basename, license.path)]
+  params = ctx.new_file(ctx.label.name + ".params")
+  ctx.file_action(output = params, content = "\n".join(args) + "\n")
+  ctx.action(
+      inputs = ctx.files._licenses + [ctx.file._metadata, params],
+      outputs = [out, inventory],
+      executable = ctx.executable._sbom,
+      arguments = ["@" + params.path],
+      mnemonic = "GoSbom",
+  )
+  return struct(files = depset([out, inventory]))
+
+go_sbom = rule(
+    _go_sbom_impl,
+    attrs = {
+        "binary": attr.label(
+            mandatory = True,
+            aspects = [go_sbom_aspect],
+            providers = [GoLibrary],
+        ),
+        "format": attr.string(values = GO_SBOM_FORMATS, default = "spdx"),
+        "_metadata": attr.label(
+            default = Label("@%s//:metadata.json" % _METADATA_REPOSITORY),
+            allow_files = True,
+            single_file = True,
+        ),
+        "_licenses": attr.label(
+            default = Label("@%s//:licenses" % _METADATA_REPOSITORY),
+            allow_files = True,
+        ),
+        "_sbom": attr.label(
+            default = Label("@io_bazel_rules_go//go/tools/builders:sbom"),
+            executable = True,
+            cfg = "host",
+        ),
+    },
+)
+"""Writes a software bill of materials for a go_binary or go_test, listing
+the external repositories its packages come from, transitively, with their
+versions and licenses: <name>.spdx.json, in SPDX 2.2, or <name>.cdx.json,
+in CycloneDX 1.4, depending on format. <name>.licenses.json is an inventory
+of the same repositories, with the packages of the binary each provides.
+The metadata comes from the go_repository rules in WORKSPACE, through
+go_repository_metadata, which must be called after them.
+"""
+
+def _go_repository_metadata_impl(ctx):
+  entries = []
+  for name in sorted(ctx.attr.repositories):
+    # go_repository writes the license files of the repository here.
+    ctx.symlink(ctx.path(Label("@%s//_go_repository_info:LICENSES" % name)), "licenses/" + name)
+    entries += ['"%s": %s' % (name, ctx.attr.repositories[name])]
+  ctx.file("metadata.json", "{\n  " + ",\n  ".join(entries) + "\n}\n")
+  ctx.file("BUILD.bazel", """exports_files(["metadata.json"])
+
+filegroup(
+    name = "licenses",
+    srcs = glob(["licenses/*"]),
+    visibility = ["//visibility:public"],
+)
+""")
+
+_go_repository_metadata = repository_rule(
+    _go_repository_metadata_impl,
+    attrs = {
+        "repositories": attr.string_dict(),
+    },
+)
+
+def go_repository_metadata():
+  """Records the go_repository rules declared so far in WORKSPACE, with their
+  import paths, versions and license files, for go_sbom. Call it after the
+  last go_repository, including those declared by go_repositories and
+  go_proto_repositories. The repositories are only fetched when a go_sbom
+  target is built."""
+  repositories = {}
+  for rule in native.existing_rules().values():
+    if rule["kind"] != "go_repository":
+      continue
+    repositories[rule["name"]] = struct(
+        importpath = rule.get("importpath", ""),
+        version = rule.get("tag") or rule.get("commit") or "",
+        remote = rule.get("remote", ""),
+        urls = rule.get("urls") or [],
+    ).to_json()
+  _go_repository_metadata(
+      name = _METADATA_REPOSITORY,
+      repositories = repositories,
+  )
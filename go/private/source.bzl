@@ -0,0 +1,118 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:common.bzl", "go_filetype")
+load("@io_bazel_rules_go//go/private:providers.bzl", "GoSource")
+
+def new_go_source(ctx, srcs=[], embedsrcs=[], deps=[], embed=[], gc_goopts=[], asmopts=[], runfiles=None):
+  """Returns a GoSource for srcs, and for the sources of the go_library and
+  go_source targets in embed, which are compiled together. embedsrcs, deps
+  and the flags are combined with those of embed the same way. runfiles
+  defaults to the data of the target being built."""
+  go_srcs = depset([s for s in srcs if s.basename.endswith(".go")])
+  asm_srcs = [s for s in srcs if s.basename.endswith(".s") or s.basename.endswith(".S")]
+  asm_hdrs = [s for s in srcs if s.basename.endswith(".h")]
+  syso_srcs = [s for s in srcs if s.basename.endswith(".syso")]
+  embed_srcs = list(embedsrcs)
+  deps = list(deps)
+  gc_goopts = list(gc_goopts)
+  asmopts = list(asmopts)
+  if runfiles == None:
+    runfiles = ctx.runfiles(collect_data = True)
+  cgo_object = None
+  for library in embed:
+    go_srcs += library.go_sources
+    asm_srcs += library.asm_sources
+    asm_hdrs += library.asm_headers
+    syso_srcs += library.syso_sources
+    embed_srcs += library.embed_sources
+    deps += library.direct_deps
+    gc_goopts += library.gc_goopts
+    asmopts += library.asmopts
+    runfiles = runfiles.merge(library.data_runfiles)
+    if library.cgo_object:
+      if cgo_object:
+        fail("%s cannot embed %s, because it already has cgo_object from another library" % (ctx.label.name, library.label))
+      cgo_object = library.cgo_object
+  return GoSource(
+      label = ctx.label,
+      go_sources = go_srcs,
+      asm_sources = asm_srcs,
+      asm_headers = asm_hdrs,
+      syso_sources = syso_srcs,
+      embed_sources = embed_srcs,
+      deps = deps,
+      cgo_object = cgo_object,
+      gc_goopts = gc_goopts,
+      asmopts = asmopts,
+      runfiles = runfiles,
+  )
+
+def _go_source_impl(ctx):
+  """Implements the go_source() rule. Nothing is compiled: the sources,
+  dependencies and options are provided the same way a go_library provides
+  them, so targets that embed this one compile them into their package."""
+  source = new_go_source(ctx,
+      srcs = ctx.files.srcs,
+      embedsrcs = ctx.files.embedsrcs,
+      deps = ctx.attr.deps,
+      embed = ctx.attr.embed,
+      gc_goopts = ctx.attr.gc_goopts,
+      asmopts = ctx.attr.asmopts,
+  )
+  return struct(
+      label = ctx.label,
+      runfiles = source.runfiles,
+      go_sources = source.go_sources,
+      asm_sources = source.asm_sources,
+      asm_headers = source.asm_headers,
+      syso_sources = source.syso_sources,
+      embed_sources = source.embed_sources,
+      cgo_object = source.cgo_object,
+      direct_deps = source.deps,
+      gc_goopts = source.gc_goopts,
+      asmopts = source.asmopts,
+      providers = [source],
+  )
+
+go_source = rule(
+    _go_source_impl,
+    attrs = {
+        "data": attr.label_list(allow_files = True, cfg = "data"),
+        "srcs": attr.label_list(allow_files = go_filetype),
+        "embedsrcs": attr.label_list(allow_files = True),
+        "deps": attr.label_list(
+            providers = [
+                "transitive_go_library_paths",
+                "transitive_go_libraries",
+                "transitive_cgo_deps",
+            ],
+        ),
+        "embed": attr.label_list(
+            providers = [
+                "direct_deps",
+                "go_sources",
+                "asm_sources",
+                "cgo_object",
+                "gc_goopts",
+            ],
+        ),
+        "gc_goopts": attr.string_list(),
+        "asmopts": attr.string_list(),
+    },
+)
+"""Groups Go sources with their dependencies and options, without compiling
+them. A go_library, go_binary or go_test that lists it in embed compiles the
+sources into its own package, so sources can be shared by several targets.
+"""
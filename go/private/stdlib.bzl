@@ -0,0 +1,246 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+load("@io_bazel_rules_go//go/private:common.bzl", "go_minor_at_least")
+
+# Modes the standard library is built in. "normal" is the SDK's own platform
+# with cgo, and "pure" is without cgo, which is also how it's built for other
+# platforms. The others are for binaries and tests built with race = True,
+# linked as C libraries or position-independent executables ("shared") or as
+# plugins ("dynlink"), and built with --features=msan or --features=asan.
+STDLIB_MODES = [
+    "normal",
+    "pure",
+    "race",
+    "shared",
+    "dynlink",
+    "msan",
+    "asan",
+]
+
+# Platforms the standard library can be built for in pure mode, as
+# <goos>_<goarch>: those go_binary and go_test can cross-compile for, out of
+# the ones the SDK supports. Older SDKs don't support some of them, like
+# js_wasm, so building for those fails.
+STDLIB_PLATFORMS = [
+    "android_386",
+    "android_amd64",
+    "android_arm",
+    "android_arm64",
+    "darwin_amd64",
+    "darwin_arm64",
+    "dragonfly_amd64",
+    "freebsd_386",
+    "freebsd_amd64",
+    "freebsd_arm",
+    "freebsd_arm64",
+    "js_wasm",
+    "linux_386",
+    "linux_amd64",
+    "linux_arm",
+    "linux_arm64",
+    "linux_mips",
+    "linux_mips64",
+    "linux_mips64le",
+    "linux_mipsle",
+    "linux_ppc64",
+    "linux_ppc64le",
+    "linux_s390x",
+    "netbsd_386",
+    "netbsd_amd64",
+    "netbsd_arm",
+    "netbsd_arm64",
+    "openbsd_386",
+    "openbsd_amd64",
+    "openbsd_arm",
+    "openbsd_arm64",
+    "plan9_386",
+    "plan9_amd64",
+    "plan9_arm",
+    "solaris_amd64",
+    "windows_386",
+    "windows_amd64",
+    "windows_arm",
+    "windows_arm64",
+]
+
+def _go_stdlib_impl(ctx):
+  """go_stdlib_impl builds the standard library with go install, into a
+  directory of archives the compiler and linker search with -I and -L."""
+  mode = ctx.attr.mode
+  out = ctx.new_file(ctx.label.name + "/pkg")
+  env = {
+      "GOROOT": ctx.attr.root.path,
+      "GOOS": ctx.attr.goos,
+      "GOARCH": ctx.attr.goarch,
+      "CGO_ENABLED": "0" if mode == "pure" else "1",
+      # Go 1.20 and later only install the standard library with this.
+      "GODEBUG": "installgoroot=all",
+  }
+  if ctx.attr.goexperiment:
+    env["GOEXPERIMENT"] = ctx.attr.goexperiment
+  if mode == "msan":
+    # -msan needs clang.
+    env["CC"] = "clang"
+  elif mode != "pure":
+    env["CC"] = ctx.fragments.cpp.compiler_executable
+
+  args = []
+  gcflags = []
+  asmflags = []
+  if mode == "pure":
+    args += ["-installsuffix", "pure"]
+  elif mode == "race":
+    args += ["-race"]
+  elif mode == "shared":
+    # On darwin, all code is position-independent already.
+    args += ["-installsuffix", "shared"]
+    if ctx.attr.goos != "darwin":
+      gcflags += ["-shared"]
+      asmflags += ["-shared"]
+  elif mode == "dynlink":
+    args += ["-installsuffix", "dynlink"]
+    gcflags += ["-dynlink"]
+    asmflags += ["-dynlink"]
+  elif mode in ["msan", "asan"]:
+    args += ["-" + mode]
+
+  # -trimpath keeps the action's directories out of the archives, so they're
+  # the same on every machine, and so are the binaries linked with them.
+  # Before Go 1.13, the go command can't trim paths itself, so the compiler
+  # and assembler trim the execution root, which is only known when the
+  # action runs.
+  if go_minor_at_least(ctx.attr.go_minor, 13):
+    install = "install -trimpath"
+    if gcflags:
+      args += ["-gcflags", " ".join(gcflags)]
+    if asmflags:
+      args += ["-asmflags", " ".join(asmflags)]
+  else:
+    install = 'install -gcflags "%s" -asmflags "%s"' % (
+        " ".join(["-trimpath=$(pwd)"] + gcflags),
+        " ".join(["-trimpath=$(pwd)"] + asmflags))
+
+  progress_message = "Building the Go standard library for %s_%s in %s mode" % (ctx.attr.goos, ctx.attr.goarch, mode)
+  if ctx.attr.goexperiment:
+    progress_message += " with GOEXPERIMENT=" + ctx.attr.goexperiment
+
+  # The go command's cache isn't shared between actions, so it's only used
+  # while the standard library is built, in a temporary directory. Bazel
+  # caches the archives instead.
+  ctx.action(
+      inputs = ctx.files.srcs + ctx.files.tools + [ctx.executable.go],
+      outputs = [out],
+      mnemonic = "GoStdlib",
+      command = " && ".join([
+          'out="$(pwd)/$1"',
+          'shift',
+          # The go command builds packages in their directories, so GOROOT,
+          # which is relative to the execution root, is made absolute.
+          'case "$GOROOT" in /*) ;; *) export GOROOT="$(pwd)/$GOROOT" ;; esac',
+          'tmp="$(mktemp -d)"',
+          'trap \'rm -rf "$tmp"\' EXIT',
+          'export GOCACHE="$tmp/cache" GOPATH="$tmp/gopath"',
+          # The C compiler may be relative to the execution root.
+          'case "${CC:-}" in /*|"") ;; */*) export CC="$(pwd)/$CC" ;; esac',
+          'mkdir -p "$out"',
+          'go="$1"',
+          'shift',
+          '"$go" %s "$@" -pkgdir "$out" std' % install,
+      ]),
+      arguments = [out.path, ctx.executable.go.path] + args,
+      env = env,
+      progress_message = progress_message,
+  )
+  return struct(
+      files = depset([out]),
+      go_stdlib = struct(
+          key = stdlib_key(ctx.attr.goos, ctx.attr.goarch, mode),
+          searchpath = out.path,
+          files = [out],
+      ),
+  )
+
+go_stdlib = rule(
+    _go_stdlib_impl,
+    attrs = {
+        "root": attr.label(mandatory = True),
+        "go": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host"),
+        "tools": attr.label(allow_files = True),
+        "srcs": attr.label(allow_files = True),
+        "goos": attr.string(mandatory = True),
+        "goarch": attr.string(mandatory = True),
+        "mode": attr.string(values = STDLIB_MODES, default = "normal"),
+        "goexperiment": attr.string(),
+        "go_minor": attr.int(),
+    },
+    fragments = ["cpp"],
+)
+"""Builds the standard library of an SDK for a platform and mode. Each SDK
+repository declares one for every mode on its own platform, and for every
+platform in pure mode. They are only built when a compile or link action
+needs them, once for all of the targets that do. If goexperiment is set, the
+standard library is built with GOEXPERIMENT set to it, like the packages
+compiled against it."""
+
+def stdlib_key(goos, goarch, mode):
+  """Returns the name of the go_stdlib for goos, goarch and mode, relative to
+  its SDK repository, which identifies it in the toolchain."""
+  return "stdlib_%s_%s_%s" % (goos, goarch, mode)
+
+def stdlib_labels(repository, goos, goarch, boringcrypto = False):
+  """Returns the labels of the go_stdlib targets declare_stdlibs declares in
+  repository, an SDK for goos and goarch. If repository is empty, they're
+  relative to the package the function is called from. If boringcrypto is
+  True, they're the ones built with GOEXPERIMENT=boringcrypto, which are only
+  declared for the SDK's own platform."""
+  if boringcrypto:
+    keys = ["boringcrypto_" + stdlib_key(goos, goarch, mode) for mode in STDLIB_MODES]
+  else:
+    keys = [stdlib_key(goos, goarch, mode) for mode in STDLIB_MODES if mode != "pure"]
+    keys += [stdlib_key(platform.split("_")[0], platform.split("_")[1], "pure") for platform in STDLIB_PLATFORMS]
+  return ["%s//:%s" % (repository, key) if repository else ":" + key for key in keys]
+
+def declare_stdlibs(goos, goarch, boringcrypto = "", go_minor = 0):
+  """Declares the go_stdlib targets of an SDK repository for goos and goarch.
+  This is called from the repository's BUILD file. If boringcrypto is
+  "goexperiment", the SDK's platform also gets a go_stdlib for each mode built
+  with GOEXPERIMENT=boringcrypto, for the SDK's BoringCrypto toolchain.
+  go_minor is the minor version of the SDK, or 0 for development versions."""
+  for mode in STDLIB_MODES:
+    if mode != "pure":
+      _declare_stdlib(goos, goarch, mode, go_minor)
+  for platform in STDLIB_PLATFORMS:
+    target_goos, target_goarch = platform.split("_")
+    _declare_stdlib(target_goos, target_goarch, "pure", go_minor)
+  if boringcrypto == "goexperiment":
+    for mode in STDLIB_MODES:
+      _declare_stdlib(goos, goarch, mode, go_minor, goexperiment = "boringcrypto")
+
+def _declare_stdlib(goos, goarch, mode, go_minor, goexperiment = ""):
+  name = stdlib_key(goos, goarch, mode)
+  if goexperiment:
+    name = goexperiment + "_" + name
+  go_stdlib(
+      name = name,
+      root = ":root",
+      go = ":go",
+      tools = ":tools",
+      srcs = ":srcs",
+      goos = goos,
+      goarch = goarch,
+      mode = mode,
+      go_minor = go_minor,
+      goexperiment = goexperiment,
+      tags = ["manual"],
+  )
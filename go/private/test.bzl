@@ -12,9 +12,11 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "go_filetype", "pkg_dir")
-load("@io_bazel_rules_go//go/private:library.bzl", "emit_library_actions", "go_importpath", "emit_go_compile_action", "get_gc_goopts", "emit_go_pack_action")
-load("@io_bazel_rules_go//go/private:binary.bzl", "emit_go_link_action", "gc_linkopts")
+load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "go_toolchain_type", "go_filetype", "go_prefix_default", "pkg_dir")
+load("@io_bazel_rules_go//go/private:library.bzl", "emit_library_actions", "get_embed", "go_importpath", "emit_go_compile_action", "dep_importmaps", "get_gc_goopts", "new_go_archive", "race_enabled", "pure_env", "c_linkmode_enabled", "pie_enabled", "plugin_enabled")
+load("@io_bazel_rules_go//go/private:providers.bzl", "GoLibrary")
+load("@io_bazel_rules_go//go/private:cross.bzl", "GOARCH_VALUES", "GOOS_VALUES", "LINKMODE_VALUES", "PURE_VALUES", "go_cross_aspect")
+load("@io_bazel_rules_go//go/private:binary.bzl", "STATIC_VALUES", "emit_go_link_action", "emit_wasm_launcher", "gc_linkopts", "plugin_runfiles", "static_enabled", "wasm_enabled")
 
 def _go_test_impl(ctx):
   """go_test_impl implements go testing.
@@ -23,63 +25,319 @@ def _go_test_impl(ctx):
   test into a binary."""
 
   go_toolchain = get_go_toolchain(ctx)
+  if c_linkmode_enabled(ctx):
+    fail("tests can't be linked as C libraries", "linkmode")
+  if plugin_enabled(ctx):
+    fail("tests can't be linked as plugins", "linkmode")
+  # With fuzz, the package under test and its external test package are
+  # instrumented like go test -fuzz does, so the coverage of the inputs
+  # guides the fuzzer.
+  gc_goopts = get_gc_goopts(ctx)
+  if ctx.attr.fuzz:
+    gc_goopts = gc_goopts + ["-d=libfuzzer"]
+  # The internal test files are part of the package under test, so like
+  # go test, they're compiled with the sources of the library they embed,
+  # under its import path and importmap.
+  importpath = None
+  importmap = None
+  if not ctx.attr.importpath:
+    for library in get_embed(ctx):
+      if getattr(library, "importpath", None):
+        importpath = library.importpath
+        importmap = library.importmap
+        break
   lib_result = emit_library_actions(ctx,
       sources = depset(ctx.files.srcs),
       deps = ctx.attr.deps,
-      cgo_object = None,
-      library = ctx.attr.library,
+      cgo_object = ctx.attr.cgo_object,
+      embed = get_embed(ctx),
+      test_package = "internal",
+      embedsrcs = ctx.files.embedsrcs,
+      importpath = importpath,
+      importmap = importmap,
+      gc_goopts = gc_goopts,
   )
+
+  # With race, the test and everything it imports are compiled with -race,
+  # using the race-mode archives of the library and its dependencies.
+  # In pure mode or when cross-compiling, lib_result and its direct deps
+  # already refer to archives compiled without cgo for the target platform.
+  race = race_enabled(ctx)
+  env = pure_env(ctx)
+  pie = pie_enabled(ctx)
+  if race:
+    library = lib_result.race_library
+    searchpath = lib_result.race_searchpath
+    transitive_go_libraries = lib_result.transitive_go_libraries_race
+    transitive_go_library_paths = lib_result.transitive_go_library_paths_race
+  else:
+    library = lib_result.library
+    searchpath = lib_result.searchpath
+    transitive_go_libraries = lib_result.transitive_go_libraries
+    transitive_go_library_paths = lib_result.transitive_go_library_paths
+
+  # Dependencies of the external test package may import the package under
+  # test, like helpers written for its tests. Like go test, they're
+  # recompiled against the package compiled with the internal test files, so
+  # only that copy of it is linked into the test.
+  deps = list(ctx.attr.deps)
+  for embedded in get_embed(ctx):
+    deps += embedded.go_cross_deps if env or pie else embedded.direct_deps
+  test_archive = struct(
+      library = library,
+      searchpath = searchpath,
+      importpath = lib_result.importpath,
+      importmap = lib_result.importmap,
+      race_library = library,
+      race_searchpath = searchpath,
+  )
+  variants = _emit_test_variants(ctx, test_archive, deps,
+      len(list(transitive_go_libraries)), race, env, pie)
+  dep_archives = [variants.archives[str(d.label)] for d in deps]
+  if race:
+    dep_libs = [d.race_library for d in dep_archives]
+    dep_searchpaths = [d.race_searchpath for d in dep_archives]
+  else:
+    dep_libs = [d.library for d in dep_archives]
+    dep_searchpaths = [d.searchpath for d in dep_archives]
+
+  # Files in an external test package import the package under test, so they
+  # are compiled separately into a package with a "_test" suffix. If there are
+  # no such files, an empty package is compiled, and the test main doesn't
+  # import it.
+  xtest_importpath = lib_result.importpath + "_test"
+  xtest_lib = ctx.new_file(ctx.label.name + "~xtest/" + xtest_importpath + ".a")
+  xtest_searchpath = xtest_lib.path[:-len(xtest_importpath + ".a")]
+  emit_go_compile_action(
+    ctx,
+    sources=lib_result.go_sources,
+    libs=[library] + dep_libs,
+    lib_paths=[searchpath] + dep_searchpaths,
+    direct_paths=[lib_result.importpath] + [d.importpath for d in dep_archives],
+    out_lib=xtest_lib,
+    gc_goopts=gc_goopts,
+    test_package="external",
+    embedsrcs=lib_result.embed_sources,
+    importmaps=dep_importmaps([test_archive] + dep_archives),
+    race=race,
+    env=env,
+    shared=pie,
+  )
+
   main_go = ctx.new_file(ctx.label.name + "_main_test.go")
-  main_object = ctx.new_file(ctx.label.name + "_main_test.o")
   main_lib = ctx.new_file(ctx.label.name + "_main_test.a")
-  run_dir = pkg_dir(ctx.label.workspace_root, ctx.label.package)
+  # Tests run in their package directory, like with go test, unless rundir
+  # sets another directory, relative to the root of their workspace.
+  if ctx.attr.rundir:
+    rundir = ctx.attr.rundir
+    if rundir.startswith("/") or rundir == ".." or rundir.startswith("../") or "/../" in rundir:
+      fail("must be a path in the workspace, like \".\" for its root", "rundir")
+    run_dir = pkg_dir(ctx.label.workspace_root, "" if rundir == "." else rundir.rstrip("/"))
+  else:
+    run_dir = pkg_dir(ctx.label.workspace_root, ctx.label.package)
 
+  test_gen_args = [
+      '--package',
+      lib_result.importpath,
+      '--rundir',
+      run_dir,
+      '--output',
+      main_go.path,
+      '--bin_dir',
+      ctx.configuration.bin_dir.path,
+      '--workspace',
+      ctx.workspace_name,
+  ]
+  if race:
+    test_gen_args += ['--tags', 'race']
+  # The package under test registers the coverage of its sources, which are
+  # instrumented when it's compiled.
+  for cover_var in lib_result.cover_vars:
+    test_gen_args += ['--cover', cover_var]
   ctx.action(
       inputs = list(lib_result.go_sources),
       outputs = [main_go],
       mnemonic = "GoTestGenTest",
       executable = go_toolchain.test_generator,
-      arguments = [
-          '--package',
-          lib_result.importpath,
-          '--rundir',
-          run_dir,
-          '--output',
-          main_go.path,
-      ] + [src.path for src in lib_result.go_sources],
-      env = dict(go_toolchain.env, RUNDIR=ctx.label.package)
+      arguments = test_gen_args + [src.path for src in lib_result.go_sources],
+      env = dict(env or go_toolchain.env, RUNDIR=ctx.label.package)
   )
 
   emit_go_compile_action(
     ctx,
     sources=depset([main_go]),
-    libs=[lib_result.library],
-    lib_paths=[lib_result.searchpath],
-    direct_paths=[lib_result.importpath],
-    out_object=main_object,
+    libs=[library, xtest_lib],
+    lib_paths=[searchpath, xtest_searchpath],
+    direct_paths=[lib_result.importpath, xtest_importpath],
+    out_lib=main_lib,
     gc_goopts=get_gc_goopts(ctx),
+    importmaps=dep_importmaps([test_archive]),
+    race=race,
+    env=env,
+    shared=pie,
+    nogo=False,
   )
-  emit_go_pack_action(ctx, main_lib, [main_object])
+  # A test compiled to WebAssembly is linked into a module, and run by
+  # a launcher script.
+  executable = ctx.outputs.wasm if wasm_enabled(ctx) else ctx.outputs.executable
+  # The linker uses the first archive it finds for each import path, so the
+  # package under test and the variants come before the archives the
+  # dependencies were compiled to.
+  link_paths = [searchpath] + variants.searchpaths
+  link_paths += [p for p in transitive_go_library_paths if p not in link_paths]
   emit_go_link_action(
     ctx,
-    transitive_go_library_paths=lib_result.transitive_go_library_paths,
-    transitive_go_libraries=lib_result.transitive_go_libraries,
+    transitive_go_library_paths=link_paths + [xtest_searchpath],
+    transitive_go_libraries=transitive_go_libraries + variants.libraries + [xtest_lib],
     cgo_deps=lib_result.transitive_cgo_deps,
     libs=[main_lib],
-    executable=ctx.outputs.executable,
+    executable=executable,
     gc_linkopts=gc_linkopts(ctx),
-    x_defs=ctx.attr.x_defs)
+    x_defs=ctx.attr.x_defs,
+    race=race,
+    env=env,
+    static=static_enabled(ctx),
+    linkmode="pie" if pie else "normal")
 
   # TODO(bazel-team): the Go tests should do a chdir to the directory
   # holding the data files, so open-source go tests continue to work
   # without code changes.
   runfiles = ctx.runfiles(files = [ctx.outputs.executable])
   runfiles = runfiles.merge(lib_result.runfiles)
+  runfiles = runfiles.merge(plugin_runfiles(ctx))
+  if wasm_enabled(ctx):
+    runfiles = runfiles.merge(emit_wasm_launcher(ctx, executable))
   return struct(
       files = set([ctx.outputs.executable]),
       runfiles = runfiles,
+      output_groups = {"unused_deps": lib_result.unused_deps},
+      providers = [
+          GoLibrary(label = ctx.label, importpath = lib_result.importpath, importmap = lib_result.importmap),
+          new_go_archive(lib_result),
+      ],
+  )
+
+def _test_variant_deps(target, cross):
+  """Returns the Go dependencies of target, with go_cross_aspect applied
+  when cross is True."""
+  if cross:
+    return getattr(target, "go_cross_deps", [])
+  return getattr(target, "direct_deps", [])
+
+def _emit_test_variants(ctx, test_archive, deps, max_depth, race, env, shared):
+  """Recompiles the transitive dependencies in deps that import the package
+  under test against test_archive, the package compiled with its internal test
+  files.
+
+  Returns a struct with archives, a dict from the labels of the dependencies
+  to the archives the test uses for them, either variants or the archives
+  they were compiled to, and with the libraries and searchpaths of the
+  variants.
+  max_depth bounds the depth of the dependency graph, since Skylark has no
+  recursion.
+  """
+  cross = bool(env or shared)
+  targets = {}
+  frontier = deps
+  for _ in range(max_depth + 1):
+    if not frontier:
+      break
+    next = []
+    for target in frontier:
+      key = str(target.label)
+      if key not in targets:
+        targets[key] = target
+        next += _test_variant_deps(target, cross)
+    frontier = next
+
+  # Packages are told apart by their importmaps, so vendored copies of the
+  # package under test aren't replaced.
+  archives = {}
+  if test_archive.importmap not in [t.importmap for t in targets.values()]:
+    for key, target in targets.items():
+      archives[key] = target.go_cross if cross else target
+    return struct(archives = archives, libraries = [], searchpaths = [])
+
+  # Each pass handles the targets whose dependencies were handled before, so
+  # a target is only recompiled after the variants it imports. replaced holds
+  # the labels of the targets the test doesn't use the archives of.
+  replaced = {}
+  libraries = []
+  searchpaths = []
+  prefix = ctx.label.name + "~variant/"
+  for _ in range(len(targets) + 1):
+    if len(archives) == len(targets):
+      break
+    for key, target in targets.items():
+      if key in archives:
+        continue
+      target_deps = _test_variant_deps(target, cross)
+      if [d for d in target_deps if str(d.label) not in archives]:
+        continue
+      if target.importmap == test_archive.importmap:
+        archives[key] = test_archive
+        replaced[key] = True
+      elif [d for d in target_deps if str(d.label) in replaced]:
+        dep_archives = [archives[str(d.label)] for d in target_deps]
+        archives[key] = _emit_test_variant(ctx, prefix, target, dep_archives, race, env, shared)
+        libraries += [archives[key].library]
+        # The variants are all in the prefix directory.
+        searchpaths = [archives[key].searchpath]
+        replaced[key] = True
+      else:
+        archives[key] = target.go_cross if cross else target
+  return struct(
+      archives = archives,
+      libraries = libraries,
+      searchpaths = searchpaths,
   )
 
+def _emit_test_variant(ctx, prefix, target, dep_archives, race, env, shared):
+  """Compiles the sources of target against dep_archives, into an archive in
+  the prefix directory."""
+  importpath = target.importpath
+  importmap = target.importmap
+  lib_name = importmap + ".a"
+  out_lib = ctx.new_file(prefix + lib_name)
+  searchpath = out_lib.path[:-len(lib_name)]
+
+  emit_go_compile_action(ctx,
+      sources = target.go_sources,
+      libs = [d.race_library if race else d.library for d in dep_archives],
+      lib_paths = [d.race_searchpath if race else d.searchpath for d in dep_archives],
+      direct_paths = [d.importpath for d in dep_archives],
+      out_lib = out_lib,
+      gc_goopts = target.gc_goopts,
+      asm_srcs = target.asm_sources,
+      asm_hdrs = target.asm_headers,
+      asmopts = target.asmopts,
+      extra_objects = target.syso_sources,
+      cgo_obj = target.cgo_object.cgo_obj if target.cgo_object else None,
+      embedsrcs = target.embed_sources,
+      package = target.label.package,
+      importmap = importmap if importmap != importpath else None,
+      importmaps = dep_importmaps(dep_archives),
+      race = race,
+      cover = False,
+      env = env,
+      shared = shared,
+      nogo = False,
+  )
+  return struct(
+      library = out_lib,
+      searchpath = searchpath,
+      importpath = importpath,
+      importmap = importmap,
+      race_library = out_lib,
+      race_searchpath = searchpath,
+  )
+
+def _go_test_outputs(goos):
+  """Declares the WebAssembly module built for tests for js/wasm."""
+  if goos == "js":
+    return {"wasm": "%{name}.wasm"}
+  return {}
+
 go_test = rule(
     _go_test_impl,
     attrs = {
@@ -88,14 +346,17 @@ go_test = rule(
             cfg = "data",
         ),
         "srcs": attr.label_list(allow_files = go_filetype),
+        "embedsrcs": attr.label_list(allow_files = True),
         "deps": attr.label_list(
             providers = [
                 "transitive_go_library_paths",
                 "transitive_go_libraries",
                 "transitive_cgo_deps",
             ],
+            aspects = [go_cross_aspect],
         ),
         "importpath": attr.string(),
+        "rundir": attr.string(),
         "library": attr.label(
             providers = [
                 "direct_deps",
@@ -104,19 +365,52 @@ go_test = rule(
                 "cgo_object",
                 "gc_goopts",
             ],
+            aspects = [go_cross_aspect],
+        ),
+        "embed": attr.label_list(
+            providers = [
+                "direct_deps",
+                "go_sources",
+                "asm_sources",
+                "cgo_object",
+                "gc_goopts",
+            ],
+            aspects = [go_cross_aspect],
         ),
         "gc_goopts": attr.string_list(),
+        "asmopts": attr.string_list(),
+        "cgo_object": attr.label(
+            providers = [
+                "cgo_obj",
+                "cgo_deps",
+            ],
+        ),
         "gc_linkopts": attr.string_list(),
         "linkstamp": attr.string(),
         "x_defs": attr.string_dict(),
-        #TODO(toolchains): Remove _toolchain attribute when real toolchains arrive
-        "_go_toolchain": attr.label(default = Label("@io_bazel_rules_go_toolchain//:go_toolchain")),
-        "_go_prefix": attr.label(default = Label(
-            "//:go_prefix",
-            relative_to_caller_repository = True,
-        )),
+        "pgo": attr.label(allow_single_file = True),
+        "race": attr.bool(),
+        "fuzz": attr.bool(),
+        "goos": attr.string(values = GOOS_VALUES),
+        "goarch": attr.string(values = GOARCH_VALUES),
+        "pure": attr.string(values = PURE_VALUES, default = "auto"),
+        "static": attr.string(values = STATIC_VALUES, default = "auto"),
+        "linkmode": attr.string(values = LINKMODE_VALUES, default = "normal"),
+        "plugins": attr.label_list(providers = ["go_plugin"]),
+        "_go_prefix": attr.label(default = go_prefix_default),
+        "_nogo": attr.label(
+            default = Label("@io_bazel_rules_nogo//:nogo"),
+            cfg = "host",
+        ),
+        "_pgo": attr.label(
+            default = Label("@io_bazel_rules_go_pgo//:pgo"),
+            allow_files = True,
+        ),
+        "_stamp": attr.label(default = Label("@io_bazel_rules_go//go/private:stamp")),
     },
+    toolchains = [go_toolchain_type],
     executable = True,
+    outputs = _go_test_outputs,
     fragments = ["cpp"],
     test = True,
 )
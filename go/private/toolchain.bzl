@@ -12,33 +12,198 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-GO_SELECT_TOOLCHAIN_BUILD_FILE = """
-exports_files(["BUILD.bazel"])
-
-alias(
-    name = "go_toolchain",
-    actual = "{toolchain}",
-    visibility = ["//visibility:public"],
-)
-
-alias(
-    name = "bootstrap_toolchain",
-    actual = "{bootstrap}",
-    visibility = ["//visibility:public"],
-)
-"""
-
 def _go_sdk_repository_impl(ctx):
   ctx.download_and_extract(
       url = ctx.attr.url,
       stripPrefix = ctx.attr.strip_prefix,
       sha256 = ctx.attr.sha256)
-  goroot = ctx.path(".")
-  ctx.template("BUILD.bazel", 
+  _prepare_sdk(ctx, ctx.attr.goos, ctx.attr.goarch)
+
+def _go_download_sdk_impl(ctx):
+  goos, goarch = detect_host(ctx)
+  host = goos + "_" + goarch
+  if host not in ctx.attr.sdks:
+    fail("no SDK for %s in sdks" % host)
+  filename, sha256 = ctx.attr.sdks[host]
+  ctx.download_and_extract(
+      url = [_sdk_url(url, filename) for url in ctx.attr.urls],
+      stripPrefix = ctx.attr.strip_prefix,
+      sha256 = sha256)
+  _prepare_sdk(ctx, goos, goarch)
+
+def _sdk_url(url, filename):
+  """Returns the URL to download filename from. url is either a template,
+  where filename is substituted for {}, or the base URL of a mirror."""
+  if "{}" in url:
+    return url.format(filename)
+  return url.rstrip("/") + "/" + filename
+
+def _go_host_sdk_impl(ctx):
+  goroot = ctx.os.environ.get("GOROOT")
+  if not goroot:
+    go = ctx.which("go")
+    if not go:
+      fail("GOROOT is not set, and go was not found on PATH")
+    result = ctx.execute([go, "env", "GOROOT"])
+    if result.return_code:
+      fail("failed to run go env GOROOT: %s" % result.stderr)
+    goroot = result.stdout.strip()
+  _wrap_sdk(ctx, goroot)
+
+def _go_wrap_sdk_impl(ctx):
+  _wrap_sdk(ctx, ctx.attr.path)
+
+def _wrap_sdk(ctx, goroot):
+  """Links an SDK installed at goroot into the repository. Only the host's
+  packages are linked from its pkg directory, since the rules build the
+  standard library variants they need themselves. On Windows, where
+  symbolic links need special privileges, the SDK is copied instead."""
+  if not ctx.path(goroot + "/" + _go_tool(ctx)).exists:
+    fail("%s is not a Go SDK: %s not found" % (goroot, _go_tool(ctx)))
+  goos, goarch = detect_host(ctx)
+  host = goos + "_" + goarch
+  if _is_windows(ctx):
+    _copy_dir(ctx, goroot, ".", exclude = [goroot + "/pkg"])
+  else:
+    for entry in _list_dir(ctx, goroot):
+      if entry != "pkg":
+        ctx.symlink(goroot + "/" + entry, entry)
+  for entry in ["include", "tool", host, host + "_race"]:
+    if not ctx.path(goroot + "/pkg/" + entry).exists:
+      continue
+    if _is_windows(ctx):
+      _copy_dir(ctx, goroot + "/pkg/" + entry, "pkg/" + entry)
+    else:
+      ctx.symlink(goroot + "/pkg/" + entry, "pkg/" + entry)
+  _prepare_sdk(ctx, goos, goarch)
+
+def _copy_dir(ctx, src, dst, exclude = []):
+  """Copies the directory src into dst, in the repository, on Windows hosts,
+  leaving out the directories in exclude."""
+  args = ["robocopy", src.replace("/", "\\"), str(ctx.path(dst)).replace("/", "\\"),
+          "/e", "/njh", "/njs", "/nfl", "/ndl", "/np"]
+  if exclude:
+    args += ["/xd"] + [path.replace("/", "\\") for path in exclude]
+  result = ctx.execute(args)
+  # robocopy exits with 8 or more when it fails.
+  if result.return_code >= 8:
+    fail("failed to copy %s: %s" % (src, result.stdout + result.stderr))
+
+def _list_dir(ctx, path):
+  result = ctx.execute(["ls", path])
+  if result.return_code:
+    fail("failed to list %s: %s" % (path, result.stderr))
+  return [entry.strip() for entry in result.stdout.strip().split("\n")]
+
+def _is_windows(ctx):
+  return ctx.os.name.startswith("windows")
+
+def _go_tool(ctx):
+  """Returns the path of the go command relative to the root of an SDK."""
+  if _is_windows(ctx):
+    return "bin/go.exe"
+  return "bin/go"
+
+def detect_host(ctx):
+  """Returns the GOOS and GOARCH of the host the repository is fetched on."""
+  if ctx.os.name == "linux":
+    goos = "linux"
+  elif ctx.os.name == "mac os x":
+    goos = "darwin"
+  elif _is_windows(ctx):
+    goos = "windows"
+  else:
+    fail("Unsupported operating system: " + ctx.os.name)
+  if goos == "windows":
+    # There's no uname on Windows hosts.
+    machine = ctx.os.environ.get("PROCESSOR_ARCHITECTURE", "").lower()
+  else:
+    result = ctx.execute(["uname", "-m"])
+    if result.return_code:
+      fail("failed to detect the host architecture: %s" % result.stderr)
+    machine = result.stdout.strip()
+  if machine in ["x86_64", "amd64"]:
+    goarch = "amd64"
+  elif machine in ["i386", "i686", "x86"]:
+    goarch = "386"
+  elif machine in ["aarch64", "arm64"]:
+    goarch = "arm64"
+  elif machine.startswith("arm"):
+    goarch = "arm"
+  else:
+    fail("Unsupported architecture: " + machine)
+  return goos, goarch
+
+def _prepare_sdk(ctx, goos, goarch):
+  """Writes the BUILD file of an SDK repository, which declares toolchains for
+  it on goos and goarch, and the variants of the standard library the rules
+  use. Those are built by actions, when they're needed, rather than when the
+  SDK is fetched. packages.txt lists the SDK's standard library packages.
+  go_minor tells the rules which flags the SDK's tools support."""
+  ctx.template("BUILD.bazel",
     Label("@io_bazel_rules_go//go/private:BUILD.sdk.bazel"),
-    substitutions = {"{goroot}": str(goroot)}, 
+    substitutions = {
+        "{name}": ctx.name,
+        "{goos}": goos,
+        "{goarch}": goarch,
+        "{boringcrypto}": _boringcrypto_support(ctx),
+        "{go_minor}": str(_go_minor_version(ctx)),
+    },
     executable = False,
   )
+  _write_std_packages(ctx)
+
+def _write_std_packages(ctx):
+  """Writes packages.txt, the import paths of the standard library packages
+  of the SDK in the repository, one per line, for Gazelle: each directory
+  under src with non-test .go files, except commands, testdata, directories
+  the go command ignores and the packages vendored for the standard
+  library's own use."""
+  result = ctx.execute(["sh", "-c", """
+cd src && find . -name '*.go' ! -name '*_test.go' | sed -e 's|^\\./||' -e 's|/[^/]*$||' |
+  grep -v -e '^cmd/' -e '^vendor/' -e '/testdata/' -e '/testdata$' -e '^testdata' -e '^[^/]*\\.go$' -e '^[_.]' -e '/[_.]' | sort -u
+"""])
+  # Without a list, as on hosts without sh, Gazelle falls back to guessing.
+  packages = ""
+  if result.return_code == 0:
+    packages = result.stdout
+  ctx.file("packages.txt", packages, False)
+
+def _boringcrypto_support(ctx):
+  """Returns how the SDK in the repository supports BoringCrypto, from the
+  version in its VERSION file: "builtin" for b-series releases, like
+  go1.8.3b4, which always use it, "goexperiment" for Go 1.19 and later, and
+  development versions, which use it with GOEXPERIMENT=boringcrypto, and ""
+  for older releases, which don't support it."""
+  result = ctx.execute(["cat", "VERSION"])
+  if result.return_code or not result.stdout.startswith("go"):
+    return "goexperiment"
+  version = result.stdout.split("\n")[0][len("go"):]
+  b = version.rfind("b")
+  if b > 0 and version[b + 1:].isdigit():
+    return "builtin"
+  minor = _go_minor_version(ctx)
+  if minor and minor < 19:
+    return ""
+  return "goexperiment"
+
+def _go_minor_version(ctx):
+  """Returns the minor version of the Go 1 SDK in the repository, from its
+  VERSION file, like 8 for go1.8.3. 0 is returned for development versions,
+  which have no VERSION file or don't name a release in it. They're treated
+  as newer than every release."""
+  result = ctx.execute(["cat", "VERSION"])
+  if result.return_code or not result.stdout.startswith("go1."):
+    return 0
+  version = result.stdout.split("\n")[0][len("go1."):]
+  minor = ""
+  for i in range(len(version)):
+    if not version[i].isdigit():
+      break
+    minor += version[i]
+  if not minor:
+    return 0
+  return int(minor)
 
 go_sdk_repository = repository_rule(
     implementation = _go_sdk_repository_impl, 
@@ -46,30 +211,70 @@ go_sdk_repository = repository_rule(
         "url" : attr.string(),
         "strip_prefix" : attr.string(),
         "sha256" : attr.string(),
+        "goos" : attr.string(),
+        "goarch" : attr.string(),
     },
 )
 
-def _go_repository_select_impl(ctx):
-  host = ""
-  if ctx.os.name == 'linux':
-    host = 'linux-x86_64'
-  elif ctx.os.name == 'mac os x':
-    host = 'osx-x86_64'
+go_download_sdk_repository = repository_rule(
+    implementation = _go_download_sdk_impl,
+    attrs = {
+        "sdks" : attr.string_list_dict(mandatory = True),
+        "urls" : attr.string_list(default = ["https://storage.googleapis.com/golang/{}"]),
+        "strip_prefix" : attr.string(default = "go"),
+    },
+)
+"""Downloads the SDK for the host from sdks, a dict from <goos>_<goarch> to
+the archive's file name and SHA-256 sum. Each of urls is a template, where the
+file name is substituted for {}, or the base URL of a mirror. An empty sum
+isn't checked."""
+
+go_host_sdk_repository = repository_rule(
+    implementation = _go_host_sdk_impl,
+    environ = ["GOROOT", "PATH"],
+)
+"""Wraps the SDK installed on the host: the one in GOROOT, or the one the go
+command on PATH belongs to."""
+
+go_wrap_sdk_repository = repository_rule(
+    implementation = _go_wrap_sdk_impl,
+    attrs = {
+        "path" : attr.string(mandatory = True),
+    },
+)
+"""Wraps the SDK installed at path, an absolute GOROOT."""
+
+def _go_version_repository_impl(ctx):
+  ctx.file("BUILD.bazel", 'exports_files(["BUILD.bazel", "VERSION", "std_packages.txt"])\n', False)
+  # VERSION records the Go version of the registered toolchains, so
+  # go_repository can tell Gazelle which release tags are satisfied. For
+  # a custom SDK, it's read from the SDK's VERSION file, which is missing in
+  # development versions.
+  go_version = ctx.attr.go_version
+  if ctx.attr.sdk:
+    go_version = ""
+    sdk_version = str(ctx.path(Label("@%s//:BUILD.bazel" % ctx.attr.sdk)).dirname) + "/VERSION"
+    result = ctx.execute(["cat", sdk_version])
+    if result.return_code == 0 and result.stdout.startswith("go"):
+      go_version = result.stdout.split("\n")[0][len("go"):]
+  ctx.file("VERSION", go_version + "\n", False)
+  # std_packages.txt is the list of standard library packages of the SDK,
+  # so Gazelle agrees with the toolchain on which imports are standard. For
+  # a version, it's the SDK of that version for the host, if there is one in
+  # sdks. Otherwise, it's empty, and Gazelle guesses.
+  sdk = ctx.attr.sdk
+  if not sdk:
+    goos, goarch = detect_host(ctx)
+    sdk = ctx.attr.sdks.get(goos + "_" + goarch, "")
+  if sdk:
+    ctx.symlink(ctx.path(Label("@%s//:packages.txt" % sdk)), "std_packages.txt")
   else:
-    fail("Unsupported operating system: " + ctx.os.name)
-  toolchain = ctx.os.environ.get("GO_TOOLCHAIN")
-  if not toolchain:
-    toolchain = "@io_bazel_rules_go//go/toolchain:go"+ctx.attr.go_version+"-"+host
-  bootstrap = toolchain.split("-cross-")[0] + "-bootstrap"
-
-  ctx.file("BUILD.bazel", GO_SELECT_TOOLCHAIN_BUILD_FILE.format(
-      toolchain = toolchain,
-      bootstrap = bootstrap,
-  ))
-
-go_repository_select = repository_rule(
-    implementation = _go_repository_select_impl,
-    environ = ["GO_TOOLCHAIN"],
+    ctx.file("std_packages.txt", "", False)
+
+go_version_repository = repository_rule(
+    implementation = _go_version_repository_impl,
     attrs = {
-        "go_version" : attr.string(default = "1.8.3"),
+        "go_version" : attr.string(),
+        "sdk" : attr.string(),
+        "sdks" : attr.string_dict(),
     })
@@ -0,0 +1,188 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package runfiles finds the data dependencies of Go binaries and tests built
+// by Bazel, whether they're run by bazel test, bazel run, or directly.
+//
+// Files are looked up by their runfiles path: the name of the workspace they
+// come from, followed by their path in that workspace, like
+// "io_bazel_rules_go/go/runfiles/runfiles.go". Runfiles are either laid out
+// in a directory tree, or listed in a manifest file that maps runfiles paths
+// to the real paths of the files, when the tree isn't built, as on Windows.
+// Both layouts are supported.
+package runfiles
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	manifestFileVar = "RUNFILES_MANIFEST_FILE"
+	dirVar          = "RUNFILES_DIR"
+	testSrcDirVar   = "TEST_SRCDIR"
+)
+
+// Runfiles finds the runfiles of a binary or test.
+type Runfiles struct {
+	// manifest maps runfiles paths to real paths. It's nil when the runfiles
+	// are in the directory dir.
+	manifest     map[string]string
+	manifestFile string
+	dir          string
+}
+
+// New returns the Runfiles of the running binary or test. It uses the
+// manifest in RUNFILES_MANIFEST_FILE or the directory in RUNFILES_DIR or
+// TEST_SRCDIR when they're set, as they are by bazel test. Otherwise, as with
+// bazel run or when the binary is run directly, it looks for the
+// <binary>.runfiles directory or the <binary>.runfiles_manifest file next to
+// the executable.
+func New() (*Runfiles, error) {
+	if manifest := os.Getenv(manifestFileVar); manifest != "" {
+		return NewManifest(manifest)
+	}
+	if dir := os.Getenv(dirVar); dir != "" {
+		return NewDir(dir)
+	}
+	if dir := os.Getenv(testSrcDirVar); dir != "" {
+		return NewDir(dir)
+	}
+	exe, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, prefix := range []string{exe, strings.TrimSuffix(exe, ".exe")} {
+		if info, err := os.Stat(prefix + ".runfiles"); err == nil && info.IsDir() {
+			return NewDir(prefix + ".runfiles")
+		}
+		if _, err := os.Stat(prefix + ".runfiles_manifest"); err == nil {
+			return NewManifest(prefix + ".runfiles_manifest")
+		}
+	}
+	return nil, fmt.Errorf("runfiles: can't find the runfiles of %s; %s and %s are not set", os.Args[0], manifestFileVar, dirVar)
+}
+
+// NewManifest returns Runfiles that looks files up in the manifest file at
+// path. Each line of the manifest is a runfiles path, a space, and the real
+// path of the file.
+func NewManifest(path string) (*Runfiles, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifest := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+		// Empty files, like generated __init__.py files, have no real path.
+		key, value := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			key, value = line[:i], line[i+1:]
+		}
+		manifest[key] = value
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("runfiles: error reading %s: %v", path, err)
+	}
+	return &Runfiles{manifest: manifest, manifestFile: path}, nil
+}
+
+// NewDir returns Runfiles that looks files up in the runfiles directory dir.
+func NewDir(dir string) (*Runfiles, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Runfiles{dir: abs}, nil
+}
+
+// Rlocation returns the path of the file with the runfiles path path, like
+// "io_bazel_rules_go/go/runfiles/runfiles.go". Absolute paths are returned
+// unchanged. If the file isn't in the runfiles, the error satisfies
+// os.IsNotExist.
+func (r *Runfiles) Rlocation(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("runfiles: empty path")
+	}
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	clean := filepath.ToSlash(filepath.Clean(path))
+	if clean != path || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("runfiles: %q is not a normalized runfiles path", path)
+	}
+	notFound := &os.PathError{Op: "rlocation", Path: path, Err: os.ErrNotExist}
+	if r.manifest != nil {
+		if real, ok := r.manifest[path]; ok && real != "" {
+			return real, nil
+		}
+		return "", notFound
+	}
+	real := filepath.Join(r.dir, filepath.FromSlash(path))
+	if _, err := os.Stat(real); err != nil {
+		return "", notFound
+	}
+	return real, nil
+}
+
+// Env returns environment variables, in the form "key=value", that let
+// another binary started by this one find the same runfiles.
+func (r *Runfiles) Env() []string {
+	if r.manifest != nil {
+		return []string{manifestFileVar + "=" + r.manifestFile}
+	}
+	return []string{dirVar + "=" + r.dir}
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultRunfiles *Runfiles
+	defaultErr      error
+)
+
+func getDefault() (*Runfiles, error) {
+	defaultOnce.Do(func() {
+		defaultRunfiles, defaultErr = New()
+	})
+	return defaultRunfiles, defaultErr
+}
+
+// Rlocation returns the path of the file with the runfiles path path, using
+// the Runfiles returned by New.
+func Rlocation(path string) (string, error) {
+	r, err := getDefault()
+	if err != nil {
+		return "", err
+	}
+	return r.Rlocation(path)
+}
+
+// Env returns the environment variables of the Runfiles returned by New, to
+// pass to other binaries.
+func Env() ([]string, error) {
+	r, err := getDefault()
+	if err != nil {
+		return nil, err
+	}
+	return r.Env(), nil
+}
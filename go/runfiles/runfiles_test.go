@@ -0,0 +1,156 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runfiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRlocation(t *testing.T) {
+	// bazel test sets TEST_SRCDIR or RUNFILES_MANIFEST_FILE, and
+	// TEST_WORKSPACE.
+	workspace := os.Getenv("TEST_WORKSPACE")
+	if workspace == "" {
+		t.Skip("not run by bazel test")
+	}
+	path, err := Rlocation(workspace + "/go/runfiles/testdata/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "hello\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func writeRunfiles(t *testing.T) (dir, real string) {
+	dir, err := ioutil.TempDir("", "TestRunfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	real = filepath.Join(dir, "runfiles", "ws", "data", "hello.txt")
+	if err := os.MkdirAll(filepath.Dir(real), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(real, []byte("hello\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	manifest := "ws/data/hello.txt " + real + "\nws/empty.py\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "MANIFEST"), []byte(manifest), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return dir, real
+}
+
+func TestManifest(t *testing.T) {
+	dir, real := writeRunfiles(t)
+	defer os.RemoveAll(dir)
+	r, err := NewManifest(filepath.Join(dir, "MANIFEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Rlocation("ws/data/hello.txt"); err != nil {
+		t.Error(err)
+	} else if got != real {
+		t.Errorf("got %s; want %s", got, real)
+	}
+	for _, path := range []string{"ws/missing.txt", "ws/empty.py"} {
+		if _, err := r.Rlocation(path); !os.IsNotExist(err) {
+			t.Errorf("%s: got error %v; want a not exist error", path, err)
+		}
+	}
+	if got, want := r.Env(), []string{"RUNFILES_MANIFEST_FILE=" + filepath.Join(dir, "MANIFEST")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got env %q; want %q", got, want)
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir, real := writeRunfiles(t)
+	defer os.RemoveAll(dir)
+	r, err := NewDir(filepath.Join(dir, "runfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Rlocation("ws/data/hello.txt"); err != nil {
+		t.Error(err)
+	} else if got != real {
+		t.Errorf("got %s; want %s", got, real)
+	}
+	if _, err := r.Rlocation("ws/missing.txt"); !os.IsNotExist(err) {
+		t.Errorf("got error %v; want a not exist error", err)
+	}
+	if got, want := r.Env(), []string{"RUNFILES_DIR=" + filepath.Join(dir, "runfiles")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got env %q; want %q", got, want)
+	}
+}
+
+func TestInvalidPaths(t *testing.T) {
+	r, err := NewDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"", "..", "../ws/a.txt", "ws/../a.txt", "ws//a.txt", "./ws/a.txt"} {
+		if _, err := r.Rlocation(path); err == nil || os.IsNotExist(err) {
+			t.Errorf("%q: got error %v; want an invalid path error", path, err)
+		}
+	}
+	abs, err := filepath.Abs("runfiles.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Rlocation(abs); err != nil || got != abs {
+		t.Errorf("got %s, %v; want %s, nil", got, err, abs)
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	dir, real := writeRunfiles(t)
+	defer os.RemoveAll(dir)
+	for _, v := range []string{manifestFileVar, dirVar, testSrcDirVar} {
+		if old, ok := os.LookupEnv(v); ok {
+			defer os.Setenv(v, old)
+		} else {
+			defer os.Unsetenv(v)
+		}
+		os.Unsetenv(v)
+	}
+	for _, tc := range []struct {
+		v, value string
+	}{
+		{manifestFileVar, filepath.Join(dir, "MANIFEST")},
+		{dirVar, filepath.Join(dir, "runfiles")},
+		{testSrcDirVar, filepath.Join(dir, "runfiles")},
+	} {
+		os.Setenv(tc.v, tc.value)
+		r, err := New()
+		os.Unsetenv(tc.v)
+		if err != nil {
+			t.Errorf("%s: %v", tc.v, err)
+			continue
+		}
+		if got, err := r.Rlocation("ws/data/hello.txt"); err != nil {
+			t.Errorf("%s: %v", tc.v, err)
+		} else if got != real {
+			t.Errorf("%s: got %s; want %s", tc.v, got, real)
+		}
+	}
+}
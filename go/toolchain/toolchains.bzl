@@ -1,71 +1,164 @@
-load('//go/private:go_toolchain.bzl', 'go_toolchain', 'platform', 'constraint_setting', 'constraint_value')
-load('//go/private:go_tool_binary.bzl', 'go_bootstrap_toolchain')
+load('@io_bazel_rules_go//go/private:common.bzl', 'go_bootstrap_toolchain_type', 'go_toolchain_type')
+load('@io_bazel_rules_go//go/private:go_toolchain.bzl', 'go_toolchain')
+load('@io_bazel_rules_go//go/private:go_tool_binary.bzl', 'go_bootstrap_toolchain')
+load('@io_bazel_rules_go//go/private:stdlib.bzl', 'stdlib_labels')
+
+def _link_flags(goos):
+  if goos == "darwin":
+    # workaround for a bug in ld(1) on Mac OS X.
+    # http://lists.apple.com/archives/Darwin-dev/2006/Sep/msg00084.html
+    # TODO(yugui) Remove this workaround once rules_go stops supporting XCode 7.2
+    # or earlier.
+    return ["-s"]
+  return []
+
+def _cgo_link_flags(goos):
+  if goos == "darwin":
+    return ["-shared", "-Wl,-all_load"]
+  if goos == "linux":
+    return ["-Wl,-whole-archive"]
+  return []
+
+def _os_constraint(goos):
+  if goos == "darwin":
+    return "@bazel_tools//platforms:osx"
+  if goos in ["freebsd", "linux", "windows"]:
+    return "@bazel_tools//platforms:" + goos
+  return "@io_bazel_rules_go//go/toolchain:" + goos
+
+def _arch_constraint(goarch):
+  if goarch == "amd64":
+    return "@bazel_tools//platforms:x86_64"
+  if goarch == "386":
+    return "@bazel_tools//platforms:x86_32"
+  if goarch == "arm":
+    return "@bazel_tools//platforms:arm"
+  return "@io_bazel_rules_go//go/toolchain:" + goarch
+
+def declare_sdk_toolchains(sdk, goos, goarch, boringcrypto = "", go_minor = 0):
+  """Declares the toolchains for an SDK repository whose host is goos and
+  goarch. This is called from the repository's BUILD file, so targets are
+  relative to it. go_toolchain and bootstrap_toolchain are registered by
+  go_download_sdk, go_host_sdk and go_wrap_sdk.
+
+  boringcrypto_toolchain is only compatible with platforms that have the
+  boringcrypto constraint, and is registered by go_register_toolchains when
+  it's asked for. It's declared if boringcrypto is "goexperiment", where it
+  runs the SDK with GOEXPERIMENT=boringcrypto, or "builtin", for b-series
+  releases, which always use BoringCrypto. Older SDKs have neither.
+
+  go_minor is the minor version of the SDK, or 0 for development versions."""
+  constraints = [_os_constraint(goos), _arch_constraint(goarch)]
+  attrs = dict(
+      sdk = sdk,
+      go_minor = go_minor,
+      is_cross = False,
+      root = ":root",
+      goos = goos,
+      goarch = goarch,
+      go = ":go",
+      tools = ":tools",
+      gofmt = ":gofmt",
+      stdlib = ":stdlib_%s_%s" % (goos, goarch),
+      headers = ":headers",
+      wasm_exec = ":wasm_exec",
+      link_flags = _link_flags(goos),
+      cgo_link_flags = _cgo_link_flags(goos),
+      tags = ["manual"],
+  )
+  go_toolchain(
+      name = "go_toolchain-impl",
+      stdlibs = stdlib_labels("", goos, goarch),
+      **attrs
+  )
+  native.toolchain(
+      name = "go_toolchain",
+      toolchain_type = go_toolchain_type,
+      exec_compatible_with = constraints,
+      target_compatible_with = constraints,
+      toolchain = ":go_toolchain-impl",
+      tags = ["manual"],
+  )
+  if not boringcrypto:
+    return
+  experiment = boringcrypto == "goexperiment"
+  go_toolchain(
+      name = "boringcrypto_toolchain-impl",
+      goexperiment = "boringcrypto" if experiment else "",
+      stdlibs = stdlib_labels("", goos, goarch, boringcrypto = experiment),
+      **attrs
+  )
+  native.toolchain(
+      name = "boringcrypto_toolchain",
+      toolchain_type = go_toolchain_type,
+      exec_compatible_with = constraints,
+      target_compatible_with = constraints + ["@io_bazel_rules_go//go/toolchain:boringcrypto"],
+      toolchain = ":boringcrypto_toolchain-impl",
+      tags = ["manual"],
+  )
+  go_bootstrap_toolchain(
+      name = "bootstrap_toolchain-impl",
+      go_minor = go_minor,
+      root = ":root",
+      go = ":go",
+      tools = ":tools",
+      stdlib = ":stdlib_%s_%s" % (goos, goarch),
+      tags = ["manual"],
+  )
+  native.toolchain(
+      name = "bootstrap_toolchain",
+      toolchain_type = go_bootstrap_toolchain_type,
+      exec_compatible_with = constraints,
+      target_compatible_with = constraints,
+      toolchain = ":bootstrap_toolchain-impl",
+      tags = ["manual"],
+  )
 
 def generate_toolchains():
-  # Compatability declarations
-  #TODO(toolchains): Swap as many as these as possible for values from @bazel_tools//platforms
-  constraint_setting(name = "os")
-  constraint_value(name = "android", setting = ":os")
-  constraint_value(name = "dragonfly", setting = ":os")
-  constraint_value(name = "freebsd", setting = ":os")
-  constraint_value(name = "linux", setting = ":os") # @bazel_tools//platforms:linux
-  constraint_value(name = "netbsd", setting = ":os")
-  constraint_value(name = "openbsd", setting = ":os")
-  constraint_value(name = "osx", setting = ":os") # @bazel_tools//platforms:osx
-  constraint_value(name = "plan9", setting = ":os")
-  constraint_value(name = "solaris", setting = ":os")
-  constraint_value(name = "windows", setting = ":os") # @bazel_tools//platforms:windows
-  
-  constraint_setting(name = "processor")
-  constraint_value(name = "x86_64", setting = ":processor") # @bazel_tools//platforms:x86_64
-  constraint_value(name = "386", setting = ":processor") # @bazel_tools//platforms:386
-  constraint_value(name = "arm", setting = ":processor")
-  constraint_value(name = "arm64", setting = ":processor")
-  constraint_value(name = "ppc64", setting = ":processor")
-  constraint_value(name = "ppc64le", setting = ":processor")
-  constraint_value(name = "mips", setting = ":processor")
-  constraint_value(name = "mipsle", setting = ":processor")
-  constraint_value(name = "mips64", setting = ":processor")
-  constraint_value(name = "mips64le", setting = ":processor")
-  
-  # Version constraints
-  constraint_setting(name = "go_version")
-  constraint_setting(name = "go_minor_version")
-  constraint_setting(name = "go_point_version")
-  
-  constraint_value(name = "go1", setting = ":go_version")
-  constraint_value(name = "go1.8", setting = ":go_minor_version")
-  constraint_value(name = "go1.8.3", setting = ":go_point_version")
-  constraint_value(name = "go1.8.2", setting = ":go_point_version")
-  constraint_value(name = "go1.8.1", setting = ":go_point_version")
-  constraint_value(name = "go1.8.0", setting = ":go_point_version")
-  constraint_value(name = "go1.7", setting = ":go_minor_version")
-  constraint_value(name = "go1.7.6", setting = ":go_point_version")
-  constraint_value(name = "go1.7.5", setting = ":go_version")
-  
+  # Operating systems and processors Bazel doesn't declare in
+  # @bazel_tools//platforms.
+  native.constraint_value(name = "android", constraint_setting = "@bazel_tools//platforms:os")
+  native.constraint_value(name = "dragonfly", constraint_setting = "@bazel_tools//platforms:os")
+  native.constraint_value(name = "js", constraint_setting = "@bazel_tools//platforms:os")
+  native.constraint_value(name = "netbsd", constraint_setting = "@bazel_tools//platforms:os")
+  native.constraint_value(name = "openbsd", constraint_setting = "@bazel_tools//platforms:os")
+  native.constraint_value(name = "plan9", constraint_setting = "@bazel_tools//platforms:os")
+  native.constraint_value(name = "solaris", constraint_setting = "@bazel_tools//platforms:os")
+
+  native.constraint_value(name = "arm64", constraint_setting = "@bazel_tools//platforms:cpu")
+  native.constraint_value(name = "ppc64", constraint_setting = "@bazel_tools//platforms:cpu")
+  native.constraint_value(name = "ppc64le", constraint_setting = "@bazel_tools//platforms:cpu")
+  native.constraint_value(name = "mips", constraint_setting = "@bazel_tools//platforms:cpu")
+  native.constraint_value(name = "mipsle", constraint_setting = "@bazel_tools//platforms:cpu")
+  native.constraint_value(name = "mips64", constraint_setting = "@bazel_tools//platforms:cpu")
+  native.constraint_value(name = "mips64le", constraint_setting = "@bazel_tools//platforms:cpu")
+  native.constraint_value(name = "wasm", constraint_setting = "@bazel_tools//platforms:cpu")
+
   # All the os types that go knows about and what their bazel name, GOOS and bazel constraint are
   os_android = struct(name="android", goos="android", constraint = ":android")
-  os_darwin = struct(name="osx", goos="darwin", constraint = ":osx")
+  os_darwin = struct(name="osx", goos="darwin", constraint = "@bazel_tools//platforms:osx")
   os_dragonfly = struct(name="dragonfly", goos="dragonfly", constraint = ":dragonfly")
-  os_freebsd = struct(name="freebsd", goos="freebsd", constraint = ":freebsd")
-  os_linux = struct(name="linux", goos="linux", constraint = ":linux")
+  os_freebsd = struct(name="freebsd", goos="freebsd", constraint = "@bazel_tools//platforms:freebsd")
+  os_js = struct(name="js", goos="js", constraint = ":js")
+  os_linux = struct(name="linux", goos="linux", constraint = "@bazel_tools//platforms:linux")
   os_netbsd = struct(name="netbsd", goos="netbsd", constraint = ":netbsd")
   os_openbsd = struct(name="openbsd", goos="openbsd", constraint = ":openbsd")
   os_plan9 = struct(name="plan9", goos="plan9", constraint = ":plan9")
   os_solaris = struct(name="solaris", goos="solaris", constraint = ":solaris")
-  os_windows = struct(name="windows", goos="windows", constraint = ":windows")
-  
+  os_windows = struct(name="windows", goos="windows", constraint = "@bazel_tools//platforms:windows")
+
   # All the target architectures go knows about, and what their bazel name, GOARCH and bazel constraint are
-  arch_arm = struct(name="arm", goarch="arm", constraint = ":arm")
+  arch_arm = struct(name="arm", goarch="arm", constraint = "@bazel_tools//platforms:arm")
   arch_arm64 = struct(name="arm64", goarch="arm64", constraint = ":arm64")
-  arch_386 = struct(name="386", goarch="386", constraint = ":386")
-  arch_amd64 = struct(name="x86_64", goarch="amd64", constraint = ":x86_64")
+  arch_386 = struct(name="386", goarch="386", constraint = "@bazel_tools//platforms:x86_32")
+  arch_amd64 = struct(name="x86_64", goarch="amd64", constraint = "@bazel_tools//platforms:x86_64")
   arch_ppc64 = struct(name="ppc64", goarch="ppc64", constraint = ":ppc64")
   arch_ppc64le = struct(name="ppc64le", goarch="ppc64le", constraint = ":ppc64le")
   arch_mips = struct(name="mips", goarch="mips", constraint = ":mips")
   arch_mipsle = struct(name="mipsle", goarch="mipsle", constraint = ":mipsle")
   arch_mips64 = struct(name="mips64", goarch="mips64", constraint = ":mips64")
   arch_mips64le = struct(name="mips64le", goarch="mips64le", constraint = ":mips64le")
+  arch_wasm = struct(name="wasm", goarch="wasm", constraint = ":wasm")
   
   # The full set of allowed os and arch combinations for the go toolchain
   # This is the set of targets allowed, of which the set of hosts is a strict subset
@@ -78,6 +171,7 @@ def generate_toolchains():
   freebsd_386 = struct(os=os_freebsd, arch=arch_386)
   freebsd_amd64 = struct(os=os_freebsd, arch=arch_amd64)
   freebsd_arm = struct(os=os_freebsd, arch=arch_arm)
+  js_wasm = struct(os=os_js, arch=arch_wasm)
   linux_386 = struct(os=os_linux, arch=arch_386)
   linux_amd64 = struct(os=os_linux, arch=arch_amd64)
   linux_arm = struct(os=os_linux, arch=arch_arm)
@@ -101,7 +195,8 @@ def generate_toolchains():
   windows_amd64 = struct(os=os_windows, arch=arch_amd64)
   
   # The set of acceptable hosts for each of the go versions, this is essentially the
-  # set of sdk's we know how to fetch
+  # set of sdk's we know how to fetch. Keep this in sync with _toolchain_hosts
+  # in go/private/repositories.bzl, which registers these toolchains.
   versions = [
       struct(
           semver = [1,8,3],
@@ -128,20 +223,45 @@ def generate_toolchains():
           hosts = [darwin_amd64, linux_amd64],
       ),
   ]
+
+  # A constraint for building with BoringCrypto, the FIPS 140 validated
+  # crypto module, and a platform for the host with it. Only the
+  # boringcrypto_toolchain of an SDK repository, which go_register_toolchains
+  # registers ahead of the others when it's asked for, is compatible with it.
+  native.constraint_setting(name = "crypto")
+  native.constraint_value(name = "boringcrypto", constraint_setting = ":crypto")
+  native.platform(
+      name = "boringcrypto-host",
+      constraint_values = [":boringcrypto"],
+      host_platform = True,
+  )
+
+  # A constraint for each version, which platforms can use to select it, and
+  # a platform for the host with that constraint. The toolchains ending in
+  # -versioned are only compatible with targets that have the constraint.
+  # go_register_toolchains registers them for each version it's asked for,
+  # ahead of the toolchains for the default version, which don't require any.
+  native.constraint_setting(name = "go_version")
+  for version in versions:
+    point = "go%d.%d.%d" % (version.semver[0], version.semver[1], version.semver[2])
+    native.constraint_value(name = point, constraint_setting = ":go_version")
+    native.platform(
+        name = point + "-host",
+        constraint_values = [":" + point],
+        host_platform = True,
+    )
   
-  # The set of allowed cross compilations
+  # The set of allowed cross compilations. Keep this in sync with
+  # _cross_targets in go/private/repositories.bzl.
   cross_targets = {
-      linux_amd64: [windows_amd64],
-      darwin_amd64: [linux_amd64],
+      linux_amd64: [darwin_amd64, darwin_arm64, js_wasm, linux_386, linux_arm, linux_arm64, windows_amd64],
+      darwin_amd64: [darwin_arm64, js_wasm, linux_amd64, linux_arm, linux_arm64],
   }
 
   # Use all the above information to generate all the possible toolchains we might support
   toolchains = []
   for version in versions:
-    major = "go%d" % (version.semver[0])
-    minor = "%s.%d" % (major, version.semver[1])
-    point = "%s.%d" % (minor, version.semver[2])
-    version_constraints = [":" + major, ":" + minor, ":" + point]
+    point = "go%d.%d.%d" % (version.semver[0], version.semver[1], version.semver[2])
     for host in version.hosts:
       distribution = "@go%d_%d_" % (version.semver[0], version.semver[1])
       if version.semver[2]:
@@ -154,17 +274,22 @@ def generate_toolchains():
           toolchain_name += "-cross-" + target.os.name + "-" + target.arch.name
         toolchains += [dict(
             name = toolchain_name,
+            version = point,
             sdk = distribution[1:], # We have to strip off the @
+            go_minor = version.semver[1],
             is_cross = is_cross,
             exec_compatible_with = [host.os.constraint, host.arch.constraint],
-            target_compatible_with = [target.os.constraint, target.arch.constraint] + version_constraints,
+            target_compatible_with = [target.os.constraint, target.arch.constraint],
             root = distribution+"//:root",
             goos = target.os.goos,
             goarch = target.arch.goarch,
             go = distribution+"//:go",
             tools = distribution+"//:tools",
+            gofmt = distribution+"//:gofmt",
             stdlib = distribution+"//:stdlib_"+target.os.goos + "_" + target.arch.goarch,
+            stdlibs = stdlib_labels(distribution, host.os.goos, host.arch.goarch),
             headers = distribution+"//:headers",
+            wasm_exec = distribution+"//:wasm_exec",
             link_flags = [],
             cgo_link_flags = [],
             tags = ["manual"],
@@ -172,27 +297,51 @@ def generate_toolchains():
 
   # Now we go through the generated toolchains, adding exceptions, and removing invalid combinations.
   for toolchain in toolchains:
-    if toolchain["goos"] == os_darwin.goos:
-      # workaround for a bug in ld(1) on Mac OS X.
-      # http://lists.apple.com/archives/Darwin-dev/2006/Sep/msg00084.html
-      # TODO(yugui) Remove this workaround once rules_go stops supporting XCode 7.2
-      # or earlier.
-      toolchain["link_flags"] += ["-s"]
-      toolchain["cgo_link_flags"] += ["-shared", "-Wl,-all_load"]
-    if toolchain["goos"] == os_linux.goos:
-      toolchain["cgo_link_flags"] += ["-Wl,-whole-archive"]
-
-  # Use the final dictionaries to actually generate all the toolchains
+    toolchain["link_flags"] += _link_flags(toolchain["goos"])
+    toolchain["cgo_link_flags"] += _cgo_link_flags(toolchain["goos"])
+
+  # Use the final dictionaries to actually generate all the toolchains. Each
+  # one is declared as a toolchain of the Go toolchain type, named like
+  # go1.8.3-linux-x86_64, which refers to the go_toolchain with the same name
+  # ending in -impl. The same go_toolchain is also declared as a toolchain
+  # requiring the version's constraint, with the name ending in -versioned.
   for toolchain in toolchains:
-    go_toolchain(**toolchain)
+    name = toolchain["name"]
+    exec_compatible_with = toolchain["exec_compatible_with"]
+    target_compatible_with = toolchain["target_compatible_with"]
+    attrs = {k: v for k, v in toolchain.items() if k not in ["name", "version", "exec_compatible_with", "target_compatible_with"]}
+    go_toolchain(name = name + "-impl", **attrs)
+    native.toolchain(
+        name = name,
+        toolchain_type = go_toolchain_type,
+        exec_compatible_with = exec_compatible_with,
+        target_compatible_with = target_compatible_with,
+        toolchain = ":" + name + "-impl",
+        tags = ["manual"],
+    )
+    native.toolchain(
+        name = name + "-versioned",
+        toolchain_type = go_toolchain_type,
+        exec_compatible_with = exec_compatible_with,
+        target_compatible_with = target_compatible_with + [":" + toolchain["version"]],
+        toolchain = ":" + name + "-impl",
+        tags = ["manual"],
+    )
     if not toolchain["is_cross"]:
       go_bootstrap_toolchain(
-          name = toolchain["name"] + "-bootstrap",
-          exec_compatible_with = toolchain["exec_compatible_with"],
-          target_compatible_with = toolchain["target_compatible_with"],
+          name = name + "-bootstrap-impl",
+          go_minor = toolchain["go_minor"],
           root = toolchain["root"],
           go = toolchain["go"],
           tools = toolchain["tools"],
           stdlib = toolchain["stdlib"],
           tags = ["manual"],
       )
+      native.toolchain(
+          name = name + "-bootstrap",
+          toolchain_type = go_bootstrap_toolchain_type,
+          exec_compatible_with = exec_compatible_with,
+          target_compatible_with = target_compatible_with,
+          toolchain = ":" + name + "-bootstrap-impl",
+          tags = ["manual"],
+      )
@@ -12,38 +12,32 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// asm builds a single .s file with "go tool asm". It is invoked by the
-// Go rules as an action.
 package main
 
 import (
 	"fmt"
-	"go/build"
-	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 )
 
-func run(args []string) error {
-	// process the args
-	if len(args) < 3 || args[2] != "--" {
-		return fmt.Errorf("Usage: asm gotool source.s -- <extra options>")
+// asmArgs returns the arguments "go tool asm" is run with for the assembly
+// files of a package, before the output and the files themselves.
+// hdrDir contains the go_asm.h the compiler writes for them.
+func asmArgs(trimpath, hdrDir string, includes, flags []string) []string {
+	args := []string{"tool", "asm", "-trimpath", trimpath, "-I", hdrDir}
+	for _, inc := range includes {
+		args = append(args, "-I", abs(inc))
 	}
-	gotool := args[0]
-	source := args[1]
-	// filter our input file list
-	bctx := build.Default
-	bctx.CgoEnabled = true
-	matched, err := matchFile(bctx, source)
-	if err != nil {
-		return err
-	}
-	if !matched {
-		source = os.DevNull
-	}
-	goargs := []string{"tool", "asm"}
-	goargs = append(goargs, args[3:]...)
-	goargs = append(goargs, source)
+	return append(args, flags...)
+}
+
+// genSymABIs writes the ABIs of the functions the assembly files define to
+// out, which the compiler reads with -symabis, so Go code calls them
+// directly. go_asm.h in the header directory must exist, but it may be empty.
+// The assembler only has -gensymabis since Go 1.12.
+func genSymABIs(gotool string, args []string, sources []string, out string) error {
+	goargs := append(append(args, "-gensymabis", "-o", out), sources...)
 	cmd := exec.Command(gotool, goargs...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -53,8 +47,19 @@ func run(args []string) error {
 	return nil
 }
 
-func main() {
-	if err := run(os.Args[1:]); err != nil {
-		log.Fatal(err)
+// assemble assembles each of the sources into an object file in dir, and
+// returns the objects.
+func assemble(gotool string, args []string, sources []string, dir string) ([]string, error) {
+	var objects []string
+	for i, src := range sources {
+		out := filepath.Join(dir, fmt.Sprintf("%d_%s.o", i, filepath.Base(src)))
+		cmd := exec.Command(gotool, append(append(args, "-o", out), src)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("error running assembler: %v", err)
+		}
+		objects = append(objects, out)
 	}
+	return objects, nil
 }
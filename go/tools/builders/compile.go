@@ -12,42 +12,61 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// compile compiles .go files with "go tool compile". It is invoked by the
-// Go rules as an action.
+// compile builds a Go package into an archive: it instruments the package's
+// sources for coverage, compiles them with "go tool compile", assembles its
+// assembly files with "go tool asm", packs them all together with any other
+// objects, and checks the sources with vet or nogo. It is invoked by the Go
+// rules as one action for each package.
 package main
 
 import (
 	"flag"
 	"fmt"
 	"go/build"
-	"go/parser"
-	"go/token"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
 )
 
-func abs(path string) string {
-	if abs, err := filepath.Abs(path); err != nil {
-		return path
-	} else {
-		return abs
-	}
-}
-
 func run(args []string) error {
 	sources := multiFlag{}
 	deps := multiFlag{}
+	depLabels := multiFlag{}
 	search := multiFlag{}
+	embedSrcs := multiFlag{}
+	coverVars := multiFlag{}
+	asmSrcs := multiFlag{}
+	asmIncludes := multiFlag{}
+	asmFlags := multiFlag{}
+	objects := multiFlag{}
+	importmaps := multiFlag{}
 	flags := flag.NewFlagSet("compile", flag.ContinueOnError)
 	flags.Var(&sources, "src", "A source file to be filtered and compiled")
 	flags.Var(&deps, "dep", "Import path of a direct dependency")
+	flags.Var(&importmaps, "importmap", "A direct dependency compiled under another path than its import path, as importpath=importmap")
+	flags.Var(&depLabels, "dep_label", "A dependency declared by the target, as importpath=label")
+	unusedDepsPath := flags.String("unused_deps", "", "If set, deps given with -dep_label that aren't imported are reported, and buildozer commands that remove them are written to this file")
 	flags.Var(&search, "I", "Search paths of a direct dependency")
+	stdlib := flags.String("stdlib", "", "The directory of the standard library's archives. Standard imports are found there.")
+	flags.Var(&embedSrcs, "embedsrc", "A file that //go:embed directives may match, as path=file, where path is relative to the package directory")
+	flags.Var(&coverVars, "cover", "A source to instrument for coverage, as var=file, where var is the name of its coverage variable")
+	flags.Var(&asmSrcs, "asm", "An assembly file to be filtered and assembled")
+	flags.Var(&asmIncludes, "asm_include", "A directory assembly files include headers from")
+	flags.Var(&asmFlags, "asmflag", "A flag passed to the assembler")
+	flags.Var(&objects, "object", "An object file packed into the archive as it is, like a .syso file or the C code of a cgo library")
+	packagePath := flags.String("p", "", "The path the package is compiled as, if it's not its import path")
 	trimpath := flags.String("trimpath", "", "The base of the paths to trim")
-	output := flags.String("o", "", "The output object file to write")
+	output := flags.String("o", "", "The output archive to write")
+	testPackage := flags.String("test_package", "", "If \"internal\", files in an external test package are not compiled.\n\tIf \"external\", only those files are compiled.")
+	nogo := flags.String("nogo", "", "The nogo binary. If set, it checks the sources after they're compiled.")
+	nogoConfig := flags.String("nogo_config", "", "The configuration file passed to nogo")
+	label := flags.String("label", "", "The label of the target being compiled, used in error messages")
+	prefix := flags.String("prefix", "", "The Go prefix of the target being compiled, used to suggest missing dependencies")
+	vet := flags.Bool("vet", false, "If true, the sources are checked with go vet after they're compiled.")
 	// process the args
 	if len(args) < 2 {
 		flags.Usage()
@@ -57,101 +76,288 @@ func run(args []string) error {
 	if err := flags.Parse(args[1:]); err != nil {
 		return err
 	}
+	if *stdlib != "" {
+		search = append(search, *stdlib)
+	}
+	if err := absGoroot(); err != nil {
+		return err
+	}
+	importmap, err := parseImportMap(importmaps)
+	if err != nil {
+		return err
+	}
+
+	if *unusedDepsPath != "" {
+		labels := make(map[string]string)
+		for _, entry := range depLabels {
+			i := strings.Index(entry, "=")
+			if i < 0 {
+				return fmt.Errorf("-dep_label %q is not importpath=label", entry)
+			}
+			labels[entry[:i]] = entry[i+1:]
+		}
+		if err := reportUnusedDeps(*unusedDepsPath, *label, unusedDeps(sources, labels)); err != nil {
+			return err
+		}
+	}
 
 	// apply build constraints to the source list
 	bctx := build.Default
-	bctx.CgoEnabled = true
-	sources, err := filterFiles(bctx, sources)
+	bctx.CgoEnabled = cgoEnabled()
+	for _, arg := range flags.Args() {
+		if arg == "-race" {
+			// Like the go tool, satisfy the "race" build tag in race mode.
+			bctx.BuildTags = append(bctx.BuildTags, "race")
+		}
+	}
+	sources, err = filterFiles(bctx, sources)
+	if err != nil {
+		return err
+	}
+	asmSrcs, err = filterFiles(bctx, asmSrcs)
+	if err != nil {
+		return err
+	}
+
+	// Instrumented sources, go_asm.h, the assembled objects and an empty
+	// external test package are written to a directory of the action's own.
+	workDir, err := ioutil.TempDir("", "compile")
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(workDir)
+	// Paths the compiler and the assembler record are made relative to the
+	// execution root, so they're the same wherever the workspace is checked
+	// out. Before Go 1.13, they only trim a single prefix, so paths in the
+	// work directory are left as they are.
+	trimpaths := abs(*trimpath)
+	if goSDKAtLeast(13) {
+		trimpaths = strings.Join([]string{trimpaths, workDir}, ";")
+	}
+	switch *testPackage {
+	case "":
+	case "internal":
+		if sources, _, err = splitXTest(sources); err != nil {
+			return err
+		}
+	case "external":
+		if _, sources, err = splitXTest(sources); err != nil {
+			return err
+		}
+		if len(sources) == 0 {
+			// The test has no external test package, but the rules always
+			// expect an archive. Compile an empty package, which the generated
+			// test main won't import.
+			empty := filepath.Join(workDir, "empty_test.go")
+			if err := ioutil.WriteFile(empty, []byte("package empty_test\n"), 0666); err != nil {
+				return err
+			}
+			sources = []string{empty}
+		}
+	default:
+		return fmt.Errorf("unknown -test_package: %q", *testPackage)
+	}
 	if len(sources) <= 0 {
 		return fmt.Errorf("no unfiltered sources to compile")
 	}
 
 	// Check that the filtered sources don't import anything outside of deps.
-	if err := checkDirectDeps(bctx, sources, deps); err != nil {
+	if err := checkDirectDeps(bctx, *stdlib, sources, deps, *label, *prefix); err != nil {
+		return err
+	}
+
+	// Files in an external test package are never instrumented, and neither
+	// are files filtered out above.
+	vars, err := parseCoverVars(coverVars)
+	if err != nil {
 		return err
 	}
+	compileSrcs := sources
+	if len(vars) > 0 && *testPackage != "external" {
+		if compileSrcs, err = instrumentCoverage(gotool, workDir, sources, vars); err != nil {
+			return err
+		}
+	}
 
-	goargs := []string{"tool", "compile"}
-	goargs = append(goargs, "-trimpath", abs(*trimpath))
+	goargs := []string{"tool", "compile", "-pack"}
+	goargs = append(goargs, "-trimpath", trimpaths)
+	// A package compiled under its importmap records symbols under that path,
+	// and packages importing it are told to look for it there.
+	if *packagePath != "" {
+		goargs = append(goargs, "-p", *packagePath)
+	}
+	goargs = append(goargs, importMapArgs(importmap)...)
+	// Like the go tool, have the compiler write go_asm.h, which assembly files
+	// may include to refer to Go constants and struct offsets, and tell it
+	// which functions the assembly files define. Symbol ABIs only exist since
+	// Go 1.12.
+	var asmargs []string
+	if len(asmSrcs) > 0 {
+		asmhdr := filepath.Join(workDir, "go_asm.h")
+		if err := ioutil.WriteFile(asmhdr, nil, 0666); err != nil {
+			return err
+		}
+		asmargs = asmArgs(trimpaths, workDir, asmIncludes, asmFlags)
+		if goSDKAtLeast(12) {
+			symabis := filepath.Join(workDir, "symabis")
+			if err := genSymABIs(gotool, asmargs, asmSrcs, symabis); err != nil {
+				return err
+			}
+			goargs = append(goargs, "-symabis", symabis)
+		}
+		goargs = append(goargs, "-asmhdr", asmhdr)
+	}
+	embedcfg, err := writeEmbedCfg(sources, embedSrcs)
+	if err != nil {
+		return err
+	}
+	if embedcfg != "" {
+		defer os.Remove(embedcfg)
+		goargs = append(goargs, "-embedcfg", embedcfg)
+	}
 	for _, path := range search {
 		goargs = append(goargs, "-I", abs(path))
 	}
 	goargs = append(goargs, "-o", *output)
 	goargs = append(goargs, flags.Args()...)
-	goargs = append(goargs, sources...)
+	goargs = append(goargs, compileSrcs...)
 	cmd := exec.Command(gotool, goargs...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("error running compiler: %v", err)
 	}
-	return nil
-}
 
-func main() {
-	if err := run(os.Args[1:]); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func checkDirectDeps(bctx build.Context, sources, deps []string) error {
-	depSet := make(map[string]bool)
-	for _, d := range deps {
-		depSet[d] = true
-	}
-
-	var errs depsError
-	fs := token.NewFileSet()
-	for _, s := range sources {
-		f, err := parser.ParseFile(fs, s, nil, parser.ImportsOnly)
+	// Pack the assembled objects and the others into the archive the compiler
+	// wrote.
+	if len(asmSrcs) > 0 {
+		asmObjects, err := assemble(gotool, asmargs, asmSrcs, workDir)
 		if err != nil {
-			// Let the compiler report parse errors.
-			continue
+			return err
 		}
-		for _, i := range f.Imports {
-			path, err := strconv.Unquote(i.Path.Value)
-			if err != nil {
-				// Should never happen, but let the compiler deal with it.
-				continue
-			}
-			if path == "C" || isStandard(bctx, path) || isRelative(path) {
-				// Standard paths don't need to be listed as dependencies (for now).
-				// Relative paths aren't supported yet. We don't emit errors here, but
-				// they will certainly break something else.
-				continue
-			}
-			if !depSet[path] {
-				errs = append(errs, fmt.Errorf("%s: import of %s, which is not a direct dependency", s, path))
-			}
+		objects = append(asmObjects, objects...)
+	}
+	if len(objects) > 0 {
+		cmd := exec.Command(gotool, append([]string{"tool", "pack", "r", *output}, objects...)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("error running pack: %v", err)
+		}
+	}
+	if *vet {
+		if err := runVet(gotool, sources); err != nil {
+			return err
 		}
 	}
-	if len(errs) > 0 {
-		return errs
+	if *nogo != "" {
+		return runNogo(*nogo, *nogoConfig, search, importmap, flags.Args(), sources)
 	}
 	return nil
 }
 
-type depsError []error
+// writeEmbedCfg matches the //go:embed directives in sources against the
+// files given with -embedsrc, and writes the configuration the compiler
+// reads them with to a temporary file. It returns the file's path, or ""
+// if the sources embed nothing.
+func writeEmbedCfg(sources, embedSrcs []string) (string, error) {
+	files := make(map[string]string)
+	for _, entry := range embedSrcs {
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			return "", fmt.Errorf("-embedsrc %q is not path=file", entry)
+		}
+		files[entry[:i]] = abs(entry[i+1:])
+	}
+	cfg, err := buildEmbedCfg(sources, files)
+	if err != nil || cfg == nil {
+		return "", err
+	}
+	data, err := cfg.marshal()
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "embedcfg")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
 
-var _ error = depsError(nil)
+// runVet checks the compiled sources with "go tool vet". vet finds the
+// packages they import in GOROOT, so checks that need type information are
+// only done for the standard library. Others are skipped quietly.
+func runVet(gotool string, sources []string) error {
+	cmd := exec.Command(gotool, append([]string{"tool", "vet"}, sources...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running vet: %v", err)
+	}
+	return nil
+}
 
-func (e depsError) Error() string {
-	errorStrings := make([]string, len(e))
-	for i, err := range e {
-		errorStrings[i] = err.Error()
+// importMapArgs returns the -importmap flags for importmap, sorted by import
+// path, so the command line doesn't depend on map order.
+func importMapArgs(importmap map[string]string) []string {
+	var importpaths []string
+	for importpath := range importmap {
+		importpaths = append(importpaths, importpath)
+	}
+	sort.Strings(importpaths)
+	var args []string
+	for _, importpath := range importpaths {
+		args = append(args, "-importmap", importpath+"="+importmap[importpath])
 	}
-	return "missing strict dependencies:\n\t" + strings.Join(errorStrings, "\n\t")
+	return args
 }
 
-func isStandard(bctx build.Context, path string) bool {
-	rootPath := filepath.Join(bctx.GOROOT, "src", filepath.FromSlash(path))
-	st, err := os.Stat(rootPath)
-	return err == nil && st.IsDir()
+// runNogo checks the compiled sources with nogo, which type-checks them
+// against the same archives the compiler used. Files generated by cgo are
+// passed after the compiler flags, so they're picked out from goopts.
+func runNogo(nogo, config string, search []string, importmap map[string]string, goopts, sources []string) error {
+	args := []string{}
+	if config != "" {
+		args = append(args, "-config", abs(config))
+	}
+	for _, path := range search {
+		args = append(args, "-I", abs(path))
+	}
+	args = append(args, importMapArgs(importmap)...)
+	for i, arg := range goopts {
+		switch {
+		case arg == "-race" || arg == "-msan" || arg == "-asan":
+			args = append(args, "-installsuffix", arg[1:])
+		case arg == "-installsuffix" && i+1 < len(goopts):
+			args = append(args, "-installsuffix", goopts[i+1])
+		}
+	}
+	args = append(args, sources...)
+	for _, arg := range goopts {
+		if strings.HasSuffix(arg, ".go") {
+			args = append(args, arg)
+		}
+	}
+	cmd := exec.Command(nogo, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running nogo: %v", err)
+	}
+	return nil
 }
 
-func isRelative(path string) bool {
-	return strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../")
+func main() {
+	args, err := expandParamsFiles(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(args); err != nil {
+		log.Fatal(err)
+	}
 }
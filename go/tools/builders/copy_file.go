@@ -0,0 +1,42 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// copy_file copies a file, keeping it executable if it was, without a
+// shell, so rules that only need a copy of a file work on any host.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func run(args []string) error {
+	args, err := expandParamsFiles(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("Usage: copy_file src dst")
+	}
+	return copyFile(args[0], args[1])
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("copy_file: ")
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// parseCoverVars parses -cover entries, var=file, into a map from each file
+// to the name of its coverage variable.
+func parseCoverVars(entries []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, entry := range entries {
+		i := strings.Index(entry, "=")
+		if i <= 0 || i == len(entry)-1 {
+			return nil, fmt.Errorf("-cover %q is not var=file", entry)
+		}
+		name, file := entry[:i], entry[i+1:]
+		if other, ok := vars[file]; ok {
+			return nil, fmt.Errorf("%s has two coverage variables, %s and %s", file, other, name)
+		}
+		vars[file] = name
+	}
+	return vars, nil
+}
+
+// instrumentCoverage instruments the sources that have a coverage variable
+// in vars with "go tool cover", in set mode. The instrumented files are
+// written to dir, and returned in place of the originals. They keep their
+// positions in the original files through line directives.
+func instrumentCoverage(gotool, dir string, sources []string, vars map[string]string) ([]string, error) {
+	instrumented := make([]string, len(sources))
+	for i, src := range sources {
+		name, ok := vars[src]
+		if !ok {
+			instrumented[i] = src
+			continue
+		}
+		out := filepath.Join(dir, fmt.Sprintf("%d_%s", i, filepath.Base(src)))
+		cmd := exec.Command(gotool, "tool", "cover", "-mode=set", "-var="+name, "-o", out, src)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("error instrumenting %s for coverage: %v", src, err)
+		}
+		instrumented[i] = out
+	}
+	return instrumented, nil
+}
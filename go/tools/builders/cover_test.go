@@ -0,0 +1,51 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCoverVars(t *testing.T) {
+	for _, c := range []struct {
+		entries []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{entries: nil, want: map[string]string{}},
+		{
+			entries: []string{"GoCover_0=a.go", "GoCover_1=dir/b=c.go"},
+			want:    map[string]string{"a.go": "GoCover_0", "dir/b=c.go": "GoCover_1"},
+		},
+		{entries: []string{"a.go"}, wantErr: true},
+		{entries: []string{"=a.go"}, wantErr: true},
+		{entries: []string{"GoCover_0="}, wantErr: true},
+		{entries: []string{"GoCover_0=a.go", "GoCover_1=a.go"}, wantErr: true},
+	} {
+		got, err := parseCoverVars(c.entries)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseCoverVars(%q): got %v, want error", c.entries, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCoverVars(%q): %v", c.entries, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseCoverVars(%q): got %v, want %v", c.entries, got, c.want)
+		}
+	}
+}
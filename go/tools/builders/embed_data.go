@@ -0,0 +1,180 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// embed_data generates a Go source file that declares a variable holding the
+// contents of one or more files. It is invoked by the go_embed_data rule.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+)
+
+// embedOptions controls the declaration embedData generates.
+type embedOptions struct {
+	// Package is the name of the package the source file belongs to.
+	Package string
+
+	// Var is the name of the declared variable.
+	Var string
+
+	// Multi, if true, declares a map from keys to contents. Otherwise, the
+	// variable holds the contents of a single file.
+	Multi bool
+
+	// String, if true, stores contents as strings instead of byte slices.
+	String bool
+
+	// Compress, if true, stores contents compressed with gzip. They're
+	// decompressed when the package is initialized.
+	Compress bool
+}
+
+// embedFile is a file to embed, under the given key.
+type embedFile struct {
+	key  string
+	data []byte
+}
+
+// embedData returns a formatted Go source file declaring a variable that
+// holds the contents of files.
+func embedData(opts embedOptions, files []embedFile) ([]byte, error) {
+	if !opts.Multi && len(files) != 1 {
+		return nil, fmt.Errorf("expected one file to embed, got %d", len(files))
+	}
+	typ := "[]byte"
+	if opts.String {
+		typ = "string"
+	}
+	gunzip := "gunzip" + opts.Var
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by go_embed_data. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", opts.Package)
+	if opts.Compress {
+		fmt.Fprintf(&buf, "import (\n\"bytes\"\n\"compress/gzip\"\n\"io/ioutil\"\n)\n\n")
+	}
+	if opts.Multi {
+		fmt.Fprintf(&buf, "var %s = map[string]%s{\n", opts.Var, typ)
+	} else {
+		fmt.Fprintf(&buf, "var %s = ", opts.Var)
+	}
+	for _, f := range files {
+		data := f.data
+		if opts.Compress {
+			var err error
+			if data, err = compress(data); err != nil {
+				return nil, err
+			}
+		}
+		value := strconv.Quote(string(data))
+		switch {
+		case opts.Compress && opts.String:
+			value = fmt.Sprintf("string(%s(%s))", gunzip, value)
+		case opts.Compress:
+			value = fmt.Sprintf("%s(%s)", gunzip, value)
+		case !opts.String:
+			value = fmt.Sprintf("[]byte(%s)", value)
+		}
+		if opts.Multi {
+			fmt.Fprintf(&buf, "%s: %s,\n", strconv.Quote(f.key), value)
+		} else {
+			fmt.Fprintf(&buf, "%s\n", value)
+		}
+	}
+	if opts.Multi {
+		fmt.Fprintf(&buf, "}\n")
+	}
+	if opts.Compress {
+		fmt.Fprintf(&buf, `
+func %s(s string) []byte {
+	r, err := gzip.NewReader(bytes.NewReader([]byte(s)))
+	if err != nil {
+		panic(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+`, gunzip)
+	}
+	return format.Source(buf.Bytes())
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func run(args []string) error {
+	var opts embedOptions
+	flags := flag.NewFlagSet("embed_data", flag.ExitOnError)
+	out := flags.String("out", "", "The Go source file to write.")
+	flags.StringVar(&opts.Package, "package", "", "The name of the package of the generated file.")
+	flags.StringVar(&opts.Var, "var", "Data", "The name of the variable to declare.")
+	flags.BoolVar(&opts.Multi, "multi", false, "Whether to declare a map from keys to the contents of files.")
+	flags.BoolVar(&opts.String, "string", false, "Whether to store contents as strings instead of byte slices.")
+	flags.BoolVar(&opts.Compress, "compress", false, "Whether to store contents compressed with gzip.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" || opts.Package == "" {
+		return fmt.Errorf("-out and -package must be set")
+	}
+	// The remaining arguments are pairs of a key, and the path of the file
+	// embedded under that key.
+	if flags.NArg()%2 != 0 {
+		return fmt.Errorf("expected pairs of keys and paths, got %d arguments", flags.NArg())
+	}
+	var files []embedFile
+	for i := 0; i < flags.NArg(); i += 2 {
+		data, err := ioutil.ReadFile(flags.Arg(i + 1))
+		if err != nil {
+			return err
+		}
+		files = append(files, embedFile{key: flags.Arg(i), data: data})
+	}
+	src, err := embedData(opts, files)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*out, src, 0666)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("embed_data: ")
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
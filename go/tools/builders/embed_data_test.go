@@ -0,0 +1,86 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestEmbedData(t *testing.T) {
+	files := []embedFile{
+		{key: "a.txt", data: []byte("hello\n")},
+		{key: "dir/b.bin", data: []byte{0, 0xff, '"'}},
+	}
+	for _, c := range []struct {
+		desc  string
+		opts  embedOptions
+		files []embedFile
+		want  []string
+	}{
+		{
+			desc:  "single",
+			opts:  embedOptions{Package: "p", Var: "Data"},
+			files: files[:1],
+			want:  []string{`var Data = []byte("hello\n")`},
+		}, {
+			desc:  "string",
+			opts:  embedOptions{Package: "p", Var: "Data", String: true},
+			files: files[:1],
+			want:  []string{`var Data = "hello\n"`},
+		}, {
+			desc:  "multi",
+			opts:  embedOptions{Package: "p", Var: "Files", Multi: true},
+			files: files,
+			want: []string{
+				`var Files = map[string][]byte{`,
+				`"a.txt":     []byte("hello\n"),`,
+				`"dir/b.bin": []byte("\x00\xff\""),`,
+			},
+		}, {
+			desc:  "compress",
+			opts:  embedOptions{Package: "p", Var: "Data", String: true, Compress: true},
+			files: files[:1],
+			want: []string{
+				`"compress/gzip"`,
+				`var Data = string(gunzipData("`,
+				`func gunzipData(s string) []byte {`,
+			},
+		},
+	} {
+		t.Run(c.desc, func(t *testing.T) {
+			src, err := embedData(c.opts, c.files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parser.ParseFile(token.NewFileSet(), "embed.go", src, 0); err != nil {
+				t.Fatalf("generated source doesn't parse: %v\n%s", err, src)
+			}
+			for _, want := range c.want {
+				if !strings.Contains(string(src), want) {
+					t.Errorf("generated source doesn't contain %s:\n%s", want, src)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedDataSingleNeedsOneFile(t *testing.T) {
+	if _, err := embedData(embedOptions{Package: "p", Var: "Data"}, nil); err == nil {
+		t.Error("got no error embedding no files in a single variable")
+	}
+}
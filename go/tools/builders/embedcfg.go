@@ -0,0 +1,217 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// embedCfg is the configuration the compiler reads with -embedcfg. It maps
+// each //go:embed pattern to the files it matches, and each of those files
+// to the path it's read from.
+type embedCfg struct {
+	Patterns map[string][]string
+	Files    map[string]string
+}
+
+// embedPattern is a pattern of a //go:embed directive.
+type embedPattern struct {
+	pos     token.Position
+	pattern string
+}
+
+// buildEmbedCfg finds the //go:embed directives in sources, and matches
+// their patterns against embedSrcs, which maps paths relative to the
+// package directory to the paths of the files. It returns nil if there are
+// no directives. Every pattern must match at least one file.
+func buildEmbedCfg(sources []string, embedSrcs map[string]string) (*embedCfg, error) {
+	patterns, err := readEmbedPatterns(sources)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	var names []string
+	for name := range embedSrcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cfg := &embedCfg{
+		Patterns: make(map[string][]string),
+		Files:    make(map[string]string),
+	}
+	for _, p := range patterns {
+		matches, err := matchEmbedPattern(p.pattern, names)
+		if err != nil {
+			return nil, fmt.Errorf("%s: pattern %s: %v", p.pos, p.pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: pattern %s: no matching files found in embedsrcs", p.pos, p.pattern)
+		}
+		cfg.Patterns[p.pattern] = matches
+		for _, name := range matches {
+			cfg.Files[name] = embedSrcs[name]
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg *embedCfg) marshal() ([]byte, error) {
+	return json.MarshalIndent(cfg, "", "\t")
+}
+
+// readEmbedPatterns returns the patterns of the //go:embed directives in
+// sources, in order.
+func readEmbedPatterns(sources []string) ([]embedPattern, error) {
+	var patterns []embedPattern
+	fset := token.NewFileSet()
+	for _, src := range sources {
+		f, err := parser.ParseFile(fset, src, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range f.Comments {
+			for _, comment := range group.List {
+				if !strings.HasPrefix(comment.Text, "//go:embed") {
+					continue
+				}
+				args := strings.TrimPrefix(comment.Text, "//go:embed")
+				if args != "" && !unicode.IsSpace(rune(args[0])) {
+					continue
+				}
+				pos := fset.Position(comment.Pos())
+				list, err := splitEmbedPatterns(args)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", pos, err)
+				}
+				for _, pattern := range list {
+					patterns = append(patterns, embedPattern{pos: pos, pattern: pattern})
+				}
+			}
+		}
+	}
+	return patterns, nil
+}
+
+// splitEmbedPatterns splits the arguments of a //go:embed directive, which
+// are separated by spaces, and may be quoted like Go strings.
+func splitEmbedPatterns(args string) ([]string, error) {
+	var patterns []string
+	for {
+		args = strings.TrimLeftFunc(args, unicode.IsSpace)
+		if args == "" {
+			break
+		}
+		var pattern string
+		switch args[0] {
+		case '"', '`':
+			i := 1
+			for ; i < len(args); i++ {
+				if args[i] == '\\' && args[0] == '"' {
+					i++
+					continue
+				}
+				if args[i] == args[0] {
+					break
+				}
+			}
+			if i >= len(args) {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+			var err error
+			if pattern, err = strconv.Unquote(args[:i+1]); err != nil {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args[:i+1])
+			}
+			args = args[i+1:]
+			if args != "" && !unicode.IsSpace(rune(args[0])) {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+		default:
+			i := strings.IndexFunc(args, unicode.IsSpace)
+			if i < 0 {
+				i = len(args)
+			}
+			pattern, args = args[:i], args[i:]
+		}
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("usage: //go:embed pattern...")
+	}
+	return patterns, nil
+}
+
+// matchEmbedPattern returns the names matched by pattern, like the go
+// command does. A file matches if its path matches the pattern, or if the
+// path of one of its parent directories does. Files in matched directories
+// are left out if the name of the file, or of a directory below the matched
+// one, starts with "." or "_", unless the pattern starts with "all:".
+func matchEmbedPattern(pattern string, names []string) ([]string, error) {
+	glob := pattern
+	all := strings.HasPrefix(glob, "all:")
+	if all {
+		glob = glob[len("all:"):]
+	}
+	if !validEmbedPattern(glob) {
+		return nil, fmt.Errorf("invalid pattern syntax")
+	}
+	var matches []string
+	for _, name := range names {
+		for dir := name; dir != "." && dir != ""; dir = path.Dir(dir) {
+			if ok, _ := path.Match(glob, dir); !ok {
+				continue
+			}
+			if dir == name || all || !hiddenBelow(dir, name) {
+				matches = append(matches, name)
+			}
+			break
+		}
+	}
+	return matches, nil
+}
+
+func validEmbedPattern(glob string) bool {
+	if glob == "" || strings.HasPrefix(glob, "/") || strings.HasSuffix(glob, "/") {
+		return false
+	}
+	for _, elem := range strings.Split(glob, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return false
+		}
+	}
+	_, err := path.Match(glob, "")
+	return err == nil
+}
+
+// hiddenBelow returns whether a path element of name below dir starts with
+// "." or "_".
+func hiddenBelow(dir, name string) bool {
+	for _, elem := range strings.Split(strings.TrimPrefix(name, dir+"/"), "/") {
+		if strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_") {
+			return true
+		}
+	}
+	return false
+}
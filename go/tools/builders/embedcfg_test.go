@@ -0,0 +1,136 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitEmbedPatterns(t *testing.T) {
+	for _, c := range []struct {
+		args    string
+		want    []string
+		wantErr bool
+	}{
+		{args: " a.txt  b/*.html", want: []string{"a.txt", "b/*.html"}},
+		{args: ` "with space.txt" ` + "`raw`", want: []string{"with space.txt", "raw"}},
+		{args: ` "unterminated`, wantErr: true},
+		{args: ` "a"b`, wantErr: true},
+		{args: "", wantErr: true},
+	} {
+		got, err := splitEmbedPatterns(c.args)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("splitEmbedPatterns(%q): got %q, want error", c.args, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitEmbedPatterns(%q): %v", c.args, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitEmbedPatterns(%q): got %q, want %q", c.args, got, c.want)
+		}
+	}
+}
+
+func TestMatchEmbedPattern(t *testing.T) {
+	names := []string{
+		"a.txt",
+		"static/.hidden",
+		"static/_draft.html",
+		"static/css/site.css",
+		"static/index.html",
+	}
+	for _, c := range []struct {
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{pattern: "a.txt", want: []string{"a.txt"}},
+		{pattern: "*.txt", want: []string{"a.txt"}},
+		{pattern: "static", want: []string{"static/css/site.css", "static/index.html"}},
+		{pattern: "all:static", want: names[1:]},
+		{pattern: "static/_draft.html", want: []string{"static/_draft.html"}},
+		{pattern: "missing"},
+		{pattern: "../a.txt", wantErr: true},
+		{pattern: "/a.txt", wantErr: true},
+		{pattern: "[", wantErr: true},
+	} {
+		got, err := matchEmbedPattern(c.pattern, names)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("matchEmbedPattern(%q): got %q, want error", c.pattern, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("matchEmbedPattern(%q): %v", c.pattern, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("matchEmbedPattern(%q): got %q, want %q", c.pattern, got, c.want)
+		}
+	}
+}
+
+func TestBuildEmbedCfg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestBuildEmbedCfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "embed.go")
+	if err := ioutil.WriteFile(src, []byte(`package embed
+
+import _ "embed"
+
+//go:embed hello.txt
+var hello string
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	noEmbed := filepath.Join(dir, "noembed.go")
+	if err := ioutil.WriteFile(noEmbed, []byte("package embed\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := buildEmbedCfg([]string{noEmbed}, nil)
+	if err != nil || cfg != nil {
+		t.Errorf("without directives: got %v, %v; want nil, nil", cfg, err)
+	}
+
+	cfg, err = buildEmbedCfg([]string{src, noEmbed}, map[string]string{
+		"hello.txt": "/path/to/hello.txt",
+		"other.txt": "/path/to/other.txt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &embedCfg{
+		Patterns: map[string][]string{"hello.txt": {"hello.txt"}},
+		Files:    map[string]string{"hello.txt": "/path/to/hello.txt"},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %#v; want %#v", cfg, want)
+	}
+
+	_, err = buildEmbedCfg([]string{src}, map[string]string{"other.txt": "/path/to/other.txt"})
+	if err == nil || !strings.Contains(err.Error(), "embed.go:5:1: pattern hello.txt: no matching files found") {
+		t.Errorf("got error %v; want an error about hello.txt", err)
+	}
+}
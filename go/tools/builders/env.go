@@ -0,0 +1,73 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// abs returns path made absolute, or path itself if that fails.
+func abs(path string) string {
+	if abs, err := filepath.Abs(path); err != nil {
+		return path
+	} else {
+		return abs
+	}
+}
+
+// absGoroot makes GOROOT absolute. The rules set it relative to the
+// execution root, so actions don't depend on where the SDK is on the machine
+// that runs them, but the go command and the tools it runs need it absolute.
+func absGoroot() error {
+	goroot := os.Getenv("GOROOT")
+	if goroot == "" || filepath.IsAbs(goroot) {
+		return nil
+	}
+	abs, err := filepath.Abs(goroot)
+	if err != nil {
+		return err
+	}
+	build.Default.GOROOT = abs
+	return os.Setenv("GOROOT", abs)
+}
+
+// goSDKAtLeast reports whether the Go SDK in GOROOT is Go 1.minor or later,
+// from its VERSION file, so flags newer SDKs added are only passed to them.
+// Development versions, which have no VERSION file or don't name a release
+// in it, are assumed to be newer than every release.
+func goSDKAtLeast(minor int) bool {
+	data, err := ioutil.ReadFile(filepath.Join(os.Getenv("GOROOT"), "VERSION"))
+	if err != nil {
+		return true
+	}
+	version := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	if !strings.HasPrefix(version, "go1.") {
+		return true
+	}
+	v := version[len("go1."):]
+	if i := strings.IndexFunc(v, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		v = v[:i]
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return true
+	}
+	return n >= minor
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAbsGoroot(t *testing.T) {
+	old, oldDefault := os.Getenv("GOROOT"), build.Default.GOROOT
+	defer func() {
+		os.Setenv("GOROOT", old)
+		build.Default.GOROOT = oldDefault
+	}()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("GOROOT", filepath.Join("external", "go_sdk"))
+	if err := absGoroot(); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(wd, "external", "go_sdk")
+	if got := os.Getenv("GOROOT"); got != want {
+		t.Errorf("relative GOROOT: got %q; want %q", got, want)
+	}
+	if build.Default.GOROOT != want {
+		t.Errorf("relative GOROOT: got build.Default.GOROOT %q; want %q", build.Default.GOROOT, want)
+	}
+
+	abs := filepath.Join(wd, "sdk")
+	os.Setenv("GOROOT", abs)
+	if err := absGoroot(); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("GOROOT"); got != abs {
+		t.Errorf("absolute GOROOT: got %q; want %q", got, abs)
+	}
+}
+
+func TestGoSDKAtLeast(t *testing.T) {
+	old := os.Getenv("GOROOT")
+	defer os.Setenv("GOROOT", old)
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "env_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("GOROOT", dir)
+
+	if !goSDKAtLeast(13) {
+		t.Errorf("no VERSION: got false; want true")
+	}
+	for _, tc := range []struct {
+		version string
+		minor   int
+		want    bool
+	}{
+		{"go1.8.3", 12, false},
+		{"go1.8.3b4", 8, true},
+		{"go1.12", 12, true},
+		{"go1.13rc1\n", 13, true},
+		{"go1.21.0\ntime 2023-08-08T19:22:18Z\n", 13, true},
+		{"devel +abc", 30, true},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "VERSION"), []byte(tc.version), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if got := goSDKAtLeast(tc.minor); got != tc.want {
+			t.Errorf("%q at least 1.%d: got %v; want %v", tc.version, tc.minor, got, tc.want)
+		}
+	}
+}
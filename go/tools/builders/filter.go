@@ -16,7 +16,11 @@ package main
 
 import (
 	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
 	"path/filepath"
+	"strings"
 )
 
 // filterFiles applies build constraints to a list of input files. It returns
@@ -33,11 +37,44 @@ func filterFiles(bctx build.Context, inputs []string) ([]string, error) {
 	return outputs, nil
 }
 
+// cgoEnabled returns whether files that use cgo should be compiled. Like
+// build.Default, which reads GOOS and GOARCH from the environment, it
+// respects CGO_ENABLED, which the rules set to "0" in pure mode.
+func cgoEnabled() bool {
+	return os.Getenv("CGO_ENABLED") != "0"
+}
+
 // matchFile applies build constraints to an input file and returns whether
 // it should be compiled.
-// TODO(#70): cross compilation: support GOOS, GOARCH that are different
-// from the host platform.
 func matchFile(bctx build.Context, input string) (bool, error) {
 	dir, base := filepath.Split(input)
 	return bctx.MatchFile(dir, base)
 }
+
+// splitXTest separates files in an external test package from other files
+// in "inputs". Like the go tool, a file is part of the external test package
+// if its name ends with "_test.go" and its package name ends with "_test".
+// If all files are in an external test package, they make up a package of
+// their own, so they are all returned in "internal".
+func splitXTest(inputs []string) (internal, external []string, err error) {
+	fset := token.NewFileSet()
+	for _, input := range inputs {
+		if !strings.HasSuffix(input, "_test.go") {
+			internal = append(internal, input)
+			continue
+		}
+		f, err := parser.ParseFile(fset, input, nil, parser.PackageClauseOnly)
+		if err != nil {
+			return nil, nil, err
+		}
+		if strings.HasSuffix(f.Name.Name, "_test") {
+			external = append(external, input)
+		} else {
+			internal = append(internal, input)
+		}
+	}
+	if len(internal) == 0 {
+		return external, nil, nil
+	}
+	return internal, external, nil
+}
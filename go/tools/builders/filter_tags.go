@@ -19,21 +19,33 @@ import (
 	"flag"
 	"fmt"
 	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
 	"log"
 	"os"
 	"strings"
 )
 
 func run(args []string) error {
+	args, err := expandParamsFiles(args)
+	if err != nil {
+		return err
+	}
 	// Prepare our flags
 	flags := flag.NewFlagSet("filter_tags", flag.ExitOnError)
 	cgo := flags.Bool("cgo", false, "Sets whether cgo-using files are allowed to pass the filter.")
 	quiet := flags.Bool("quiet", false, "Don't print filenames. Return code will be 0 if any files pass the filter.")
 	tags := flags.String("tags", "", "Only pass through files that match these tags.")
 	output := flags.String("output", "", "If set, write the matching files to this file, instead of stdout.")
+	importC := flags.Bool("import_c", false, "Only pass through .go files that import \"C\".")
+	copyTo := flags.String("copy", "", "If set, copy the one input file to this file if it passes the filter, or write an empty file if it doesn't.")
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
+	if *copyTo != "" && flags.NArg() != 1 {
+		return fmt.Errorf("-copy needs exactly one input file, got %d", flags.NArg())
+	}
 	// filter our input file list
 	bctx := build.Default
 	bctx.CgoEnabled = *cgo
@@ -42,6 +54,15 @@ func run(args []string) error {
 	if err != nil {
 		return err
 	}
+	if *importC {
+		filenames, err = filterCgoFiles(filenames)
+		if err != nil {
+			return err
+		}
+	}
+	if *copyTo != "" {
+		return copyOrTruncate(flags.Arg(0), *copyTo, len(filenames) > 0)
+	}
 	// if we are in quite mode, just vary our exit condition based on the results
 	if *quiet {
 		if len(filenames) == 0 {
@@ -66,6 +87,38 @@ func run(args []string) error {
 	return nil
 }
 
+// copyOrTruncate copies src to dst if keep is true. Otherwise, it writes an
+// empty dst, since actions must create their outputs.
+func copyOrTruncate(src, dst string, keep bool) error {
+	var data []byte
+	if keep {
+		var err error
+		if data, err = ioutil.ReadFile(src); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(dst, data, 0666)
+}
+
+// filterCgoFiles returns the .go files in inputs that import "C".
+func filterCgoFiles(inputs []string) ([]string, error) {
+	var outputs []string
+	fset := token.NewFileSet()
+	for _, input := range inputs {
+		f, err := parser.ParseFile(fset, input, nil, parser.ImportsOnly)
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range f.Imports {
+			if imp.Path.Value == `"C"` {
+				outputs = append(outputs, input)
+				break
+			}
+		}
+	}
+	return outputs, nil
+}
+
 func main() {
 	if err := run(os.Args[1:]); err != nil {
 		log.Fatal(err)
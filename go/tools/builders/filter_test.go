@@ -124,3 +124,58 @@ func runTest(t *testing.T, bctx build.Context, inputs []string, expect []string)
 		t.Errorf("filter %v,%v,%v,%v: expect %v got %v", bctx.GOOS, bctx.GOARCH, bctx.CgoEnabled, bctx.BuildTags, expect, got)
 	}
 }
+
+func TestSplitXTest(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "goruletest")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	files := map[string]string{
+		"lib.go":             "package lib",
+		"lib_test.go":        "package lib",
+		"x_test.go":          "package lib_test",
+		"xtest_pkg.go":       "package lib_test",
+		"other_xtest.go":     "package lib",
+		"only_xtest_test.go": "package lib_test",
+	}
+	for name, content := range files {
+		p := filepath.Join(tempdir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", p, err)
+		}
+	}
+	path := func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(tempdir, name))
+		}
+		return paths
+	}
+
+	for _, tc := range []struct {
+		inputs, internal, external []string
+	}{
+		{
+			inputs:   path("lib.go", "lib_test.go", "x_test.go", "xtest_pkg.go"),
+			internal: path("lib.go", "lib_test.go", "xtest_pkg.go"),
+			external: path("x_test.go"),
+		}, {
+			inputs:   path("lib.go", "other_xtest.go"),
+			internal: path("lib.go", "other_xtest.go"),
+		}, {
+			inputs:   path("x_test.go", "only_xtest_test.go"),
+			internal: path("x_test.go", "only_xtest_test.go"),
+		},
+	} {
+		internal, external, err := splitXTest(tc.inputs)
+		if err != nil {
+			t.Errorf("splitXTest(%v) failed: %v", tc.inputs, err)
+			continue
+		}
+		if !reflect.DeepEqual(internal, tc.internal) || !reflect.DeepEqual(external, tc.external) {
+			t.Errorf("splitXTest(%v): got %v, %v; want %v, %v", tc.inputs, internal, external, tc.internal, tc.external)
+		}
+	}
+}
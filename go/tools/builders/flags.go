@@ -14,7 +14,11 @@
 
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
 
 // multiFlag allows repeated string flags to be collected into a slice
 type multiFlag []string
@@ -30,3 +34,26 @@ func (m *multiFlag) Set(v string) error {
 	(*m) = append(*m, v)
 	return nil
 }
+
+// expandParamsFiles replaces arguments of the form @file with the lines of
+// file, one argument per line. The rules pass long command lines this way,
+// since Windows limits the length of a command line.
+func expandParamsFiles(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			expanded = append(expanded, arg)
+			continue
+		}
+		data, err := ioutil.ReadFile(arg[1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read params file: %v", err)
+		}
+		content := strings.TrimSuffix(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+		if content == "" {
+			continue
+		}
+		expanded = append(expanded, strings.Split(content, "\n")...)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,49 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandParamsFiles(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	params := filepath.Join(dir, "compile.params")
+	if err := ioutil.WriteFile(params, []byte("-src\r\na b.go\n-o\nout.o\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.params")
+	if err := ioutil.WriteFile(empty, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := expandParamsFiles([]string{"go", "@" + params, "@" + empty, "--", "-race"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"go", "-src", "a b.go", "-o", "out.o", "--", "-race"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -22,11 +22,12 @@ import (
 	"fmt"
 	"go/ast"
 	"go/build"
+	"go/doc"
 	"go/parser"
 	"go/token"
 	"log"
 	"os"
-	"regexp"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -34,8 +35,6 @@ import (
 )
 
 var (
-	coverFileRegexp = regexp.MustCompile(".+_(?P<cover_var>GoCover_\\d+)\\.cover\\.go")
-
 	testCover      bool     // -cover flag
 	testCoverMode  string   // -covermode flag
 	testCoverPaths []string // -coverpkg flag
@@ -52,16 +51,42 @@ type coverInfo struct {
 	Vars map[string]*CoverVar
 }
 
+// TestCase names a test, benchmark or fuzz target function.
+type TestCase struct {
+	// Alias is the name the package containing the function is imported as:
+	// "undertest" for the package being tested, or "externaltest" for its
+	// external test package.
+	Alias string
+	Name  string
+}
+
+// Example is an example function with an output comment, which is run and
+// has its output checked, as go test does.
+type Example struct {
+	Alias     string
+	Name      string
+	Output    string
+	Unordered bool
+}
+
 // Cases holds template data.
 type Cases struct {
 	Package          string
 	RunDir           string
-	TestNames        []string
-	BenchmarkNames   []string
+	Workspace        string
+	Tests            []TestCase
+	Benchmarks       []TestCase
+	FuzzTargets      []TestCase
+	Examples         []Example
 	HasTestMain      bool
+	TestMainAlias    string
+	ImportUndertest  bool
+	ImportExternal   bool
 	Version17        bool
 	Version18OrNewer bool
-	Cover            []coverInfo
+	// Version118OrNewer is set for Go 1.18 and later, which run fuzz targets.
+	Version118OrNewer bool
+	Cover             []coverInfo
 }
 
 func (c *Cases) CoverMode() string {
@@ -89,22 +114,38 @@ func (c *Cases) Covered() string {
 var codeTpl = `
 package main
 import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
-{{if .Version17}}
+	"os/exec"
+	"path/filepath"
+{{if .HasTestMain}}
+	"reflect"
+{{end}}
 	"regexp"
+{{if .CoverEnabled}}
+	"sort"
 {{end}}
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 {{if .Version18OrNewer}}
 	"testing/internal/testdeps"
 {{end}}
 
-{{if .TestNames}}
-	undertest "{{.Package}}"
-{{else if .BenchmarkNames}}
+{{if .ImportUndertest}}
 	undertest "{{.Package}}"
 {{end}}
+{{if .ImportExternal}}
+	externaltest "{{.Package}}_test"
+{{end}}
 
 {{if .CoverEnabled}}
 	{{$pkg := .Package}}
@@ -115,15 +156,329 @@ import (
 )
 
 var tests = []testing.InternalTest{
-{{range .TestNames}}
-	{"{{.}}", undertest.{{.}} },
+{{range .Tests}}
+	{"{{.Name}}", {{.Alias}}.{{.Name}} },
 {{end}}
 }
 
 var benchmarks = []testing.InternalBenchmark{
-{{range .BenchmarkNames}}
-	{"{{.}}", undertest.{{.}} },
+{{range .Benchmarks}}
+	{"{{.Name}}", {{.Alias}}.{{.Name}} },
+{{end}}
+}
+
+var examples = []testing.InternalExample{
+{{range .Examples}}
+	{Name: "{{.Name}}", F: {{.Alias}}.{{.Name}}, Output: {{printf "%q" .Output}}, Unordered: {{.Unordered}} },
+{{end}}
+}
+
+{{if .Version118OrNewer}}
+var fuzzTargets = []testing.InternalFuzzTarget{
+{{range .FuzzTargets}}
+	{"{{.Name}}", {{.Alias}}.{{.Name}} },
+{{end}}
+}
+
+// prepareFuzzing sets up a fuzzing session started with -test.fuzz, as go
+// test does. Interesting inputs are cached in -test.fuzzcachedir, which defaults to a
+// directory in TEST_TMPDIR with bazel test, and in the Go build cache
+// otherwise. With bazel run, the test runs in its package directory in the
+// workspace rather than in its runfiles, so the seed corpus is read from
+// testdata/fuzz in the source tree, and failing inputs are written there.
+func prepareFuzzing() {
+	// The flags are parsed later, after a TestMain has declared its own.
+	fuzzing, cacheDirSet := false, false
+	for _, arg := range os.Args[1:] {
+		name := strings.TrimLeft(arg, "-")
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		switch name {
+		case "test.fuzz":
+			fuzzing = true
+		case "test.fuzzcachedir":
+			cacheDirSet = true
+		}
+	}
+	if !fuzzing {
+		return
+	}
+	if ws := os.Getenv("BUILD_WORKSPACE_DIRECTORY"); ws != "" {
+		dir := filepath.Join(ws, filepath.FromSlash({{printf "%q" .RunDir}}))
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			if err := os.Chdir(dir); err != nil {
+				log.Fatalf("could not change to the package directory: %v", err)
+			}
+		}
+	}
+	if f := flag.Lookup("test.fuzzcachedir"); f != nil && !cacheDirSet {
+		cache := os.Getenv("TEST_TMPDIR")
+		if cache == "" {
+			cache = os.Getenv("GOCACHE")
+		}
+		if cache == "" {
+			dir, err := os.UserCacheDir()
+			if err != nil {
+				log.Fatalf("could not find a directory to cache fuzzing inputs in; set -test.fuzzcachedir: %v", err)
+			}
+			cache = filepath.Join(dir, "go-build")
+		}
+		f.Value.Set(filepath.Join(cache, "fuzz", {{printf "%q" .Package}}))
+	}
+}
 {{end}}
+
+// shardTests returns the tests to run in this shard, when Bazel runs the
+// test in TEST_TOTAL_SHARDS shards because shard_count is set. Tests are
+// assigned to shards in turn, in the order they're declared. The shard
+// status file is touched to tell Bazel the test supports sharding.
+func shardTests(tests []testing.InternalTest) []testing.InternalTest {
+	total, err := strconv.Atoi(os.Getenv("TEST_TOTAL_SHARDS"))
+	if err != nil || total <= 1 {
+		return tests
+	}
+	index, err := strconv.Atoi(os.Getenv("TEST_SHARD_INDEX"))
+	if err != nil || index < 0 || index >= total {
+		log.Fatalf("invalid TEST_SHARD_INDEX %q for %d shards", os.Getenv("TEST_SHARD_INDEX"), total)
+	}
+	if status := os.Getenv("TEST_SHARD_STATUS_FILE"); status != "" {
+		f, err := os.Create(status)
+		if err != nil {
+			log.Fatalf("could not touch the shard status file: %v", err)
+		}
+		f.Close()
+	}
+	var shard []testing.InternalTest
+	for i, t := range tests {
+		if i%total == index {
+			shard = append(shard, t)
+		}
+	}
+	return shard
+}
+
+// testFilter translates Bazel's --test_filter, passed in
+// TESTBRIDGE_TEST_ONLY, to the patterns of -test.run and -test.bench. The
+// filter is a comma-separated list of test and benchmark names, with
+// subtests after slashes, like "TestFoo/case1". Names without regular
+// expression syntax match exactly, rather than as substrings, and other
+// names are used as patterns. With several names, each level of subtests
+// matches any of the names at that level, and a level is only matched if
+// every name has it. Only benchmarks run if no tests are listed.
+func testFilter(filter string) (run, bench string) {
+	var tests, benchmarks [][]string
+	for _, name := range strings.Split(filter, ",") {
+		if name == "" {
+			continue
+		}
+		if strings.HasPrefix(name, "Benchmark") {
+			benchmarks = append(benchmarks, strings.Split(name, "/"))
+		} else {
+			tests = append(tests, strings.Split(name, "/"))
+		}
+	}
+	run, bench = filterPattern(tests), filterPattern(benchmarks)
+	if run == "" && bench != "" {
+		run = "^$"
+	}
+	return run, bench
+}
+
+// filterPattern returns a pattern for -test.run or -test.bench that matches
+// names, split into levels at slashes. Levels that some names don't have
+// are left out, so a name without subtests isn't limited to the subtests
+// another name selects.
+func filterPattern(names [][]string) string {
+	depth := 0
+	for i, name := range names {
+		if i == 0 || len(name) < depth {
+			depth = len(name)
+		}
+	}
+	levels := make([][]string, depth)
+	for _, name := range names {
+		for i, level := range name[:depth] {
+			if regexp.QuoteMeta(level) == level {
+				level = "^" + level + "$"
+			}
+			levels[i] = append(levels[i], level)
+		}
+	}
+	var patterns []string
+	for _, level := range levels {
+		patterns = append(patterns, strings.Join(level, "|"))
+	}
+	return strings.Join(patterns, "/")
+}
+
+// chdirToRunDir changes to the directory the tests run in: the package
+// directory in the runfiles of the main workspace. It's found from the
+// runfiles variables bazel test sets, or next to the test binary, so the
+// test can also be run with bazel run, directly from bazel-bin, or by
+// go_benchmark. Otherwise, the test is assumed to be started in the runfiles
+// of the main workspace, as it is when they're listed in a manifest.
+// os.Args[0] is made absolute first, so the test can still start itself, as
+// it does to fuzz.
+func chdirToRunDir() error {
+	var roots []string
+	for _, v := range []string{"TEST_SRCDIR", "RUNFILES_DIR"} {
+		if root := os.Getenv(v); root != "" {
+			roots = append(roots, root)
+		}
+	}
+	if exe, err := filepath.Abs(os.Args[0]); err == nil {
+		os.Args[0] = exe
+		roots = append(roots, exe+".runfiles", strings.TrimSuffix(exe, ".exe")+".runfiles")
+	}
+	for _, root := range roots {
+		dir := filepath.Join(root, {{printf "%q" .Workspace}}, filepath.FromSlash({{printf "%q" .RunDir}}))
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return os.Chdir(dir)
+		}
+	}
+	return os.Chdir({{printf "%q" .RunDir}})
+}
+
+// testWrapEnv is set in the environment of the test started by runWithXML,
+// so it runs its tests instead of starting itself again.
+const testWrapEnv = "GO_TEST_WRAP"
+
+// runWithXML runs the test again in a subprocess with -test.v, copying its
+// output, and writes the result of each test to xmlFile in the JUnit XML
+// format Bazel reads from XML_OUTPUT_FILE. It returns the exit code of the
+// subprocess, or false if it couldn't be started, as for js/wasm.
+func runWithXML(xmlFile string) (int, bool) {
+	exe, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		return 0, false
+	}
+	cmd := exec.Command(exe, append([]string{"-test.v"}, os.Args[1:]...)...)
+	cmd.Env = append(os.Environ(), testWrapEnv+"=1")
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, false
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return 0, false
+	}
+	cases := parseTestOutput(io.TeeReader(stdout, os.Stdout))
+	code := 0
+	if err := cmd.Wait(); err != nil {
+		code = 1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() > 0 {
+				code = status.ExitStatus()
+			}
+		}
+	}
+	if err := writeTestXML(xmlFile, cases, time.Since(start), code); err != nil {
+		log.Printf("could not write test results: %v", err)
+	}
+	return code, true
+}
+
+// testCase is the result of a test or subtest, read from the output of
+// -test.v, as test2json reads it.
+type testCase struct {
+	name    string
+	result  string // "PASS", "FAIL" or "SKIP", or "" if the test didn't finish.
+	elapsed string // in seconds, like "0.01".
+	output  bytes.Buffer
+}
+
+var (
+	testStartRegexp  = regexp.MustCompile("^=== (RUN|PAUSE|CONT) +(\\S+)")
+	testResultRegexp = regexp.MustCompile("^ *--- (PASS|FAIL|SKIP): (\\S+) \\(([0-9.]+)s\\)")
+)
+
+// parseTestOutput reads the output of -test.v from r, until it's closed, and
+// returns the tests it ran, in the order they started. Other lines are the
+// output of the last test that started or finished: lines printed while it
+// runs, and its indented log lines, which older versions of Go print after
+// its result.
+func parseTestOutput(r io.Reader) []*testCase {
+	var cases []*testCase
+	byName := make(map[string]*testCase)
+	get := func(name string) *testCase {
+		c, ok := byName[name]
+		if !ok {
+			c = &testCase{name: name}
+			byName[name] = c
+			cases = append(cases, c)
+		}
+		return c
+	}
+	var last *testCase
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if m := testStartRegexp.FindStringSubmatch(line); m != nil {
+			last = get(m[2])
+		} else if m := testResultRegexp.FindStringSubmatch(line); m != nil {
+			last = get(m[2])
+			last.result, last.elapsed = m[1], m[3]
+		} else if last != nil && (last.result == "" || strings.HasPrefix(line, " ")) {
+			last.output.WriteString(line)
+		}
+		if err != nil {
+			return cases
+		}
+	}
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeTestXML writes cases to path as a JUnit XML test suite named after
+// the package under test. Tests that didn't finish are reported as errors.
+// If the test exited with code, but no test failed, as when TestMain or an
+// init function fails, the failure is reported as a test named "main".
+func writeTestXML(path string, cases []*testCase, elapsed time.Duration, code int) error {
+	var b bytes.Buffer
+	var failures, errors, skipped int
+	for _, c := range cases {
+		var element, message string
+		switch c.result {
+		case "PASS":
+		case "FAIL":
+			failures++
+			element, message = "failure", "Failed"
+		case "SKIP":
+			skipped++
+			element, message = "skipped", "Skipped"
+		default:
+			errors++
+			element, message = "error", "No test result found"
+		}
+		fmt.Fprintf(&b, "    <testcase classname=\"%s\" name=\"%s\" time=\"%s\">", xmlEscape({{printf "%q" .Package}}), xmlEscape(c.name), c.elapsed)
+		if element != "" {
+			fmt.Fprintf(&b, "<%s message=\"%s\">%s</%s>", element, message, xmlEscape(c.output.String()), element)
+		} else if c.output.Len() > 0 {
+			fmt.Fprintf(&b, "<system-out>%s</system-out>", xmlEscape(c.output.String()))
+		}
+		b.WriteString("</testcase>\n")
+	}
+	tests := len(cases)
+	if code != 0 && failures == 0 && errors == 0 {
+		tests++
+		failures++
+		fmt.Fprintf(&b, "    <testcase classname=\"%s\" name=\"main\" time=\"0\"><failure message=\"Failed\">exited with code %d</failure></testcase>\n", xmlEscape({{printf "%q" .Package}}), code)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<testsuites>\n")
+	fmt.Fprintf(f, "  <testsuite name=\"%s\" tests=\"%d\" failures=\"%d\" errors=\"%d\" skipped=\"%d\" time=\"%.3f\">\n", xmlEscape({{printf "%q" .Package}}), tests, failures, errors, skipped, elapsed.Seconds())
+	b.WriteTo(f)
+	fmt.Fprintf(f, "  </testsuite>\n</testsuites>\n")
+	return f.Close()
 }
 
 {{if .CoverEnabled}}
@@ -163,17 +518,97 @@ func coverRegisterFile(fileName string, counter []uint32, pos []uint32, numStmts
 	}
 	coverBlocks[fileName] = block
 }
-{{end}}
 
-func main() {
-	if err := os.Chdir("{{.RunDir}}"); err != nil {
-		log.Fatalf("could not change to test directory: %v", err)
+// coverageOutputs returns the files coverage data should be written to
+// when the test is run by "bazel coverage". Bazel sets COVERAGE_DIR and
+// merges the .dat files written there; older versions read
+// COVERAGE_OUTPUT_FILE instead. Paths are made absolute, since the test
+// changes directories before it runs.
+func coverageOutputs() []string {
+	var outputs []string
+	if dir := os.Getenv("COVERAGE_DIR"); dir != "" {
+		outputs = append(outputs, filepath.Join(dir, "go_coverage.dat"))
 	}
+	if file := os.Getenv("COVERAGE_OUTPUT_FILE"); file != "" {
+		outputs = append(outputs, file)
+	}
+	for i, o := range outputs {
+		if abs, err := filepath.Abs(o); err == nil {
+			outputs[i] = abs
+		}
+	}
+	return outputs
+}
 
-	if filter := os.Getenv("TESTBRIDGE_TEST_ONLY"); filter != "" {
-		if f := flag.Lookup("test.run"); f != nil {
-			f.Value.Set(filter)
+type byLine []uint32
+
+func (s byLine) Len() int           { return len(s) }
+func (s byLine) Less(i, j int) bool { return s[i] < s[j] }
+func (s byLine) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// writeLcov writes the coverage counters in lcov format, which Bazel's
+// coverage collection expects. Each line gets the largest count of the
+// blocks that contain it.
+func writeLcov(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var names []string
+	for name := range coverCounters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		counts := make(map[uint32]uint32)
+		blocks := coverBlocks[name]
+		for i, count := range coverCounters[name] {
+			b := blocks[i]
+			if b.Stmts == 0 {
+				continue
+			}
+			for line := b.Line0; line <= b.Line1; line++ {
+				if old, ok := counts[line]; !ok || count > old {
+					counts[line] = count
+				}
+			}
+		}
+		var lines []uint32
+		for line := range counts {
+			lines = append(lines, line)
+		}
+		sort.Sort(byLine(lines))
+		fmt.Fprintf(w, "SF:%s\n", name)
+		hit := 0
+		for _, line := range lines {
+			fmt.Fprintf(w, "DA:%d,%d\n", line, counts[line])
+			if counts[line] > 0 {
+				hit++
+			}
 		}
+		fmt.Fprintf(w, "LH:%d\nLF:%d\nend_of_record\n", hit, len(lines))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+{{end}}
+
+func main() {
+	if xmlFile := os.Getenv("XML_OUTPUT_FILE"); xmlFile != "" && os.Getenv(testWrapEnv) == "" {
+		if code, ok := runWithXML(xmlFile); ok {
+			os.Exit(code)
+		}
+	}
+{{if .CoverEnabled}}
+	coverage := coverageOutputs()
+{{end}}
+	tests = shardTests(tests)
+	if err := chdirToRunDir(); err != nil {
+		log.Fatalf("could not change to test directory: %v", err)
 	}
 
 {{if .CoverEnabled}}
@@ -185,40 +620,89 @@ func main() {
 	})
 {{end}}
 
-{{if .Version18OrNewer}}
-	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, nil)
-	{{if not .HasTestMain}}
-	os.Exit(m.Run())
-	{{else}}
-	undertest.TestMain(m)
-	{{end}}
+{{if .Version118OrNewer}}
+	testdeps.ImportPath = {{printf "%q" .Package}}
+	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, fuzzTargets, examples)
+{{else if .Version18OrNewer}}
+	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, examples)
 {{else if .Version17}}
-	{{if not .HasTestMain}}
-	testing.Main(regexp.MatchString, tests, benchmarks, nil)
-	{{else}}
-	m := testing.MainStart(regexp.MatchString, tests, benchmarks, nil)
-	undertest.TestMain(m)
-	{{end}}
+	m := testing.MainStart(regexp.MatchString, tests, benchmarks, examples)
 {{end}}
+	// The testing flags are registered by MainStart in newer versions of Go,
+	// so the filter is applied after it. Flags passed with --test_arg are
+	// parsed later, and take precedence.
+	if filter := os.Getenv("TESTBRIDGE_TEST_ONLY"); filter != "" {
+		run, bench := testFilter(filter)
+		if f := flag.Lookup("test.run"); f != nil && run != "" {
+			f.Value.Set(run)
+		}
+		if f := flag.Lookup("test.bench"); f != nil && bench != "" {
+			f.Value.Set(bench)
+		}
+	}
+{{if .Version118OrNewer}}
+	prepareFuzzing()
+{{end}}
+{{if not .HasTestMain}}
+	code := m.Run()
+{{else}}
+	{{.TestMainAlias}}.TestMain(m)
+	// TestMain usually calls os.Exit with the code m.Run returns. Since Go
+	// 1.15, it may return instead, and the test exits with that code, as
+	// with go test. Coverage data is only written in that case.
+	code := 0
+	if v := reflect.ValueOf(m).Elem().FieldByName("exitCode"); v.IsValid() {
+		code = int(v.Int())
+	}
+{{end}}
+{{if .CoverEnabled}}
+	for _, path := range coverage {
+		if err := writeLcov(path); err != nil {
+			log.Printf("could not write coverage data: %v", err)
+			if code == 0 {
+				code = 1
+			}
+		}
+	}
+{{end}}
+	os.Exit(code)
 }
 `
 
+// markImported records that a function is used from the package imported as
+// "alias", so the package must be imported.
+func (c *Cases) markImported(alias string) {
+	if alias == "externaltest" {
+		c.ImportExternal = true
+	} else {
+		c.ImportUndertest = true
+	}
+}
+
 func run(args []string) error {
 	// Prepare our flags
 	flags := flag.NewFlagSet("generate_test_main", flag.ExitOnError)
 	pkg := flags.String("package", "", "package from which to import test methods.")
 	runDir := flags.String("rundir", ".", "Path to directory where tests should run.")
+	workspace := flags.String("workspace", "", "Name of the main workspace, whose runfiles directory contains rundir.")
 	out := flags.String("output", "", "output file to write. Defaults to stdout.")
 	tags := flags.String("tags", "", "Only pass through files that match these tags.")
+	binDir := flags.String("bin_dir", "", "Directory where generated sources are written. It's trimmed from their\n\tpaths to get the paths coverage is reported for.")
+	coverVars := multiFlag{}
+	flags.Var(&coverVars, "cover", "A source instrumented for coverage, as var=file, where var is the name of its coverage variable")
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
+	vars, err := parseCoverVars(coverVars)
+	if err != nil {
+		return err
+	}
 	if *pkg == "" {
 		return fmt.Errorf("must set --package.")
 	}
 	// filter our input file list
 	bctx := build.Default
-	bctx.CgoEnabled = true
+	bctx.CgoEnabled = cgoEnabled()
 	bctx.BuildTags = strings.Split(*tags, ",")
 	filenames, err := filterFiles(bctx, flags.Args())
 	if err != nil {
@@ -239,16 +723,33 @@ func run(args []string) error {
 		Vars: map[string]*CoverVar{},
 	}
 	cases := Cases{
-		Package: *pkg,
-		RunDir:  *runDir,
-		Cover:   []coverInfo{ci},
+		Package:   *pkg,
+		RunDir:    *runDir,
+		Workspace: *workspace,
+		Cover:     []coverInfo{ci},
 	}
+	// Files in an external test package are compiled separately and imported
+	// with a different name, unless they are the only files in the target.
+	_, externalFiles, err := splitXTest(filenames)
+	if err != nil {
+		return err
+	}
+	isExternal := make(map[string]bool)
+	for _, f := range externalFiles {
+		isExternal[f] = true
+	}
+
 	testFileSet := token.NewFileSet()
 	for _, f := range filenames {
-		coverVar := extractCoverVar(f)
-		if coverVar != "" {
+		alias := "undertest"
+		if isExternal[f] {
+			alias = "externaltest"
+		}
+		// Sources filtered out above aren't compiled, so they have no
+		// coverage variables.
+		if coverVar, ok := vars[f]; ok {
 			ci.Vars[f] = &CoverVar{
-				File: f,
+				File: coverSourcePath(*binDir, f),
 				Var:  coverVar,
 			}
 		}
@@ -258,6 +759,20 @@ func run(args []string) error {
 			return fmt.Errorf("ParseFile(%q): %v", f, err)
 		}
 
+		// Examples without an output comment are compiled, but not run.
+		for _, ex := range doc.Examples(parse) {
+			if ex.Output == "" && !ex.EmptyOutput {
+				continue
+			}
+			cases.Examples = append(cases.Examples, Example{
+				Alias:     alias,
+				Name:      "Example" + ex.Name,
+				Output:    ex.Output,
+				Unordered: ex.Unordered,
+			})
+			cases.markImported(alias)
+		}
+
 		for _, d := range parse.Decls {
 			fn, ok := d.(*ast.FuncDecl)
 			if !ok {
@@ -267,9 +782,22 @@ func run(args []string) error {
 				continue
 			}
 			if fn.Name.Name == "TestMain" {
-				// TestMain is not, itself, a test
-				cases.HasTestMain = true
-				continue
+				// TestMain is not, itself, a test, unless it takes a
+				// *testing.T, as with go test.
+				switch testParamType(fn) {
+				case "M":
+					if cases.HasTestMain {
+						return fmt.Errorf("%s: multiple definitions of TestMain", testFileSet.Position(fn.Pos()))
+					}
+					cases.HasTestMain = true
+					cases.TestMainAlias = alias
+					cases.markImported(alias)
+					continue
+				case "T":
+					// Checked below, like other tests.
+				default:
+					return fmt.Errorf("%s: wrong signature for TestMain, must be: func TestMain(m *testing.M)", testFileSet.Position(fn.Pos()))
+				}
 			}
 
 			// Here we check the signature of the Test* function. To
@@ -305,13 +833,21 @@ func run(args []string) error {
 				if selExpr.Sel.Name != "T" {
 					continue
 				}
-				cases.TestNames = append(cases.TestNames, fn.Name.Name)
+				cases.Tests = append(cases.Tests, TestCase{Alias: alias, Name: fn.Name.Name})
+				cases.markImported(alias)
 			}
 			if strings.HasPrefix(fn.Name.Name, "Benchmark") {
 				if selExpr.Sel.Name != "B" {
 					continue
 				}
-				cases.BenchmarkNames = append(cases.BenchmarkNames, fn.Name.Name)
+				cases.Benchmarks = append(cases.Benchmarks, TestCase{Alias: alias, Name: fn.Name.Name})
+				cases.markImported(alias)
+			}
+			if strings.HasPrefix(fn.Name.Name, "Fuzz") {
+				if selExpr.Sel.Name != "F" {
+					continue
+				}
+				cases.FuzzTargets = append(cases.FuzzTargets, TestCase{Alias: alias, Name: fn.Name.Name})
 			}
 		}
 	}
@@ -328,6 +864,15 @@ func run(args []string) error {
 		cases.Version17 = true
 	} else {
 		cases.Version18OrNewer = true
+		cases.Version118OrNewer = !goVersion.Less(version{1, 18})
+	}
+	if cases.Version118OrNewer {
+		for _, f := range cases.FuzzTargets {
+			cases.markImported(f.Alias)
+		}
+	} else {
+		// Fuzz targets can't be run by older versions, so they're left out.
+		cases.FuzzTargets = nil
 	}
 
 	tpl := template.Must(template.New("source").Parse(codeTpl))
@@ -337,6 +882,25 @@ func run(args []string) error {
 	return nil
 }
 
+// testParamType returns the name of the type fn takes a pointer to, like
+// "T" for func TestFoo(t *testing.T), if it's the only parameter and fn
+// returns nothing. The package the type is from isn't checked, since the
+// testing package may be imported with another name.
+func testParamType(fn *ast.FuncDecl) string {
+	if len(fn.Type.Params.List) != 1 || len(fn.Type.Params.List[0].Names) > 1 || fn.Type.Results != nil {
+		return ""
+	}
+	starExpr, ok := fn.Type.Params.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return ""
+	}
+	selExpr, ok := starExpr.X.(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	return selExpr.Sel.Name
+}
+
 type version []int
 
 func parseVersion(s string) (version, error) {
@@ -366,12 +930,16 @@ func (x version) Less(y version) bool {
 	return len(x) < len(y)
 }
 
-func extractCoverVar(fn string) string {
-	res := coverFileRegexp.FindStringSubmatch(fn)
-	if len(res) > 1 {
-		return res[1]
+// coverSourcePath returns the path coverage of the source file fn is
+// reported for. Generated sources are written to binDir, which is trimmed,
+// so like other sources, their paths are relative to the execution root, as
+// Bazel expects.
+func coverSourcePath(binDir, fn string) string {
+	binDir, fn = filepath.ToSlash(binDir), filepath.ToSlash(fn)
+	if binDir != "" && strings.HasPrefix(fn, binDir+"/") {
+		fn = fn[len(binDir)+1:]
 	}
-	return ""
+	return fn
 }
 
 func main() {
@@ -0,0 +1,155 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// go_path lays out Go sources in a GOPATH-style directory tree, or in a zip
+// archive of one. It is invoked by the go_path rule.
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// pathFile is a file to lay out, at the path dst in the tree.
+type pathFile struct {
+	dst, src string
+}
+
+// zipTime is the modification time of every file in an archive, so the
+// archive only changes when its files do.
+var zipTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writeArchive writes files to a zip archive at out, sorted by path.
+func writeArchive(out string, files []pathFile) (err error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	w := zip.NewWriter(f)
+	for _, file := range sortedFiles(files) {
+		header := &zip.FileHeader{Name: file.dst, Method: zip.Deflate}
+		header.SetModTime(zipTime)
+		header.SetMode(0644)
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := copyFrom(fw, file.src); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// copyTree copies files into the directory root.
+func copyTree(root string, files []pathFile) error {
+	for _, file := range files {
+		dst := filepath.Join(root, filepath.FromSlash(file.dst))
+		if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+			return err
+		}
+		if err := copyFile(dst, file.src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(dst, src string) (err error) {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return copyFrom(f, src)
+}
+
+func copyFrom(w io.Writer, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func sortedFiles(files []pathFile) []pathFile {
+	sorted := append([]pathFile(nil), files...)
+	sort.Sort(byDst(sorted))
+	return sorted
+}
+
+type byDst []pathFile
+
+func (s byDst) Len() int           { return len(s) }
+func (s byDst) Less(i, j int) bool { return s[i].dst < s[j].dst }
+func (s byDst) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func run(args []string) error {
+	args, err := expandParamsFiles(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("go_path", flag.ExitOnError)
+	mode := flags.String("mode", "archive", "How to lay out the tree: archive or copy.")
+	out := flags.String("out", "", "The archive to write, or the directory to copy files into.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("-out must be set")
+	}
+	// The remaining arguments are pairs of a path in the tree, and the path
+	// of the file laid out there.
+	if flags.NArg()%2 != 0 {
+		return fmt.Errorf("expected pairs of tree paths and file paths, got %d arguments", flags.NArg())
+	}
+	var files []pathFile
+	for i := 0; i < flags.NArg(); i += 2 {
+		files = append(files, pathFile{dst: flags.Arg(i), src: flags.Arg(i + 1)})
+	}
+	switch *mode {
+	case "archive":
+		return writeArchive(*out, files)
+	case "copy":
+		return copyTree(*out, files)
+	default:
+		return fmt.Errorf("unknown mode %q", *mode)
+	}
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("go_path: ")
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
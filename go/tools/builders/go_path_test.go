@@ -0,0 +1,95 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeSources(t *testing.T, dir string) []pathFile {
+	var files []pathFile
+	for _, f := range []struct{ dst, content string }{
+		{"src/example.com/b/b.go", "package b\n"},
+		{"src/example.com/a/a.go", "package a\n"},
+		{"src/example.com/a/static/index.html", "<html>\n"},
+	} {
+		src := filepath.Join(dir, filepath.Base(f.dst))
+		if err := ioutil.WriteFile(src, []byte(f.content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, pathFile{dst: f.dst, src: src})
+	}
+	return files
+}
+
+func TestWriteArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWriteArchive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := writeSources(t, dir)
+
+	out := filepath.Join(dir, "path.zip")
+	if err := writeArchive(out, files); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		if !f.ModTime().Equal(zipTime) {
+			t.Errorf("%s: got modification time %v; want %v", f.Name, f.ModTime(), zipTime)
+		}
+	}
+	want := []string{
+		"src/example.com/a/a.go",
+		"src/example.com/a/static/index.html",
+		"src/example.com/b/b.go",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %q; want %q", names, want)
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCopyTree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := writeSources(t, dir)
+
+	root := filepath.Join(dir, "gopath")
+	if err := copyTree(root, files); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, "src", "example.com", "a", "static", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "<html>\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
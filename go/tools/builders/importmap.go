@@ -0,0 +1,40 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseImportMap parses -importmap entries, importpath=importmap, into a map
+// from the path each package is imported by to the path it was compiled as.
+// Its archive is named after the latter, so packages with the same import
+// path, like vendored copies, don't collide when they're linked together.
+func parseImportMap(entries []string) (map[string]string, error) {
+	importmap := make(map[string]string)
+	for _, entry := range entries {
+		i := strings.Index(entry, "=")
+		if i <= 0 || i == len(entry)-1 {
+			return nil, fmt.Errorf("-importmap %q is not importpath=importmap", entry)
+		}
+		importpath, path := entry[:i], entry[i+1:]
+		if other, ok := importmap[importpath]; ok && other != path {
+			return nil, fmt.Errorf("%s is imported as both %s and %s", importpath, other, path)
+		}
+		importmap[importpath] = path
+	}
+	return importmap, nil
+}
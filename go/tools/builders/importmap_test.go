@@ -0,0 +1,52 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseImportMap(t *testing.T) {
+	for _, c := range []struct {
+		entries []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{entries: nil, want: map[string]string{}},
+		{
+			entries: []string{"github.com/a/b=example.com/vendor/github.com/a/b", "c=d=e"},
+			want:    map[string]string{"github.com/a/b": "example.com/vendor/github.com/a/b", "c": "d=e"},
+		},
+		{entries: []string{"a=b", "a=b"}, want: map[string]string{"a": "b"}},
+		{entries: []string{"a"}, wantErr: true},
+		{entries: []string{"=b"}, wantErr: true},
+		{entries: []string{"a="}, wantErr: true},
+		{entries: []string{"a=b", "a=c"}, wantErr: true},
+	} {
+		got, err := parseImportMap(c.entries)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseImportMap(%q): got %v, want error", c.entries, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseImportMap(%q): %v", c.entries, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseImportMap(%q): got %v, want %v", c.entries, got, c.want)
+		}
+	}
+}
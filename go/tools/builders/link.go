@@ -26,6 +26,7 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"regexp"
 	"strings"
 )
 
@@ -36,6 +37,9 @@ func run(args []string) error {
 	}
 	gotool := args[0]
 	args = args[1:]
+	if err := absGoroot(); err != nil {
+		return err
+	}
 	linkargs := []string{}
 	goopts := []string{}
 	bctx := build.Default
@@ -52,7 +56,7 @@ func run(args []string) error {
 	stamps := multiFlag{}
 	linkstamps := multiFlag{}
 	flags := flag.NewFlagSet("link", flag.ExitOnError)
-	flags.Var(&xdefs, "X", "A link xdef that may need stamping.")
+	flags.Var(&xdefs, "X", "A link xdef whose value refers to workspace status keys, like {KEY}.")
 	flags.Var(&stamps, "stamp", "The name of a file with stamping values.")
 	flags.Var(&linkstamps, "linkstamp", "A package that requires link stamping.")
 	if err := flags.Parse(args); err != nil {
@@ -81,16 +85,16 @@ func run(args []string) error {
 			}
 		}
 	}
-	// generate any additional link options we need
+	// Set the variables whose values refer to workspace status keys. They're
+	// left unset if a key has no value, or if stamping is off, in which case
+	// there are no stamp files.
 	for _, xdef := range xdefs {
 		split := strings.SplitN(xdef, "=", 2)
 		if len(split) != 2 {
 			continue
 		}
-		name := split[0]
-		key := split[1]
-		if value, found := stampmap[key]; found {
-			goargs = append(goargs, "-X", fmt.Sprintf("%s=%s", name, value))
+		if value, ok := expandStamp(split[1], stampmap); ok {
+			goargs = append(goargs, "-X", fmt.Sprintf("%s=%s", split[0], value))
 		}
 	}
 	for _, linkstamp := range linkstamps {
@@ -110,8 +114,29 @@ func run(args []string) error {
 	return nil
 }
 
+var stampKeyPattern = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// expandStamp replaces references to workspace status keys in value, like
+// {BUILD_SCM_REVISION}, with their values in stampmap. It returns false if
+// one of the keys has no value.
+func expandStamp(value string, stampmap map[string]string) (string, bool) {
+	ok := true
+	expanded := stampKeyPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		stamp, found := stampmap[ref[1:len(ref)-1]]
+		if !found {
+			ok = false
+		}
+		return stamp
+	})
+	return expanded, ok
+}
+
 func main() {
-	if err := run(os.Args[1:]); err != nil {
+	args, err := expandParamsFiles(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(args); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,40 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestExpandStamp(t *testing.T) {
+	stampmap := map[string]string{
+		"BUILD_SCM_REVISION": "abc123",
+		"BUILD_USER":         "gopher",
+		"EMPTY":              "",
+	}
+	for _, c := range []struct {
+		value, want string
+		ok          bool
+	}{
+		{value: "{BUILD_SCM_REVISION}", want: "abc123", ok: true},
+		{value: "v1.0-{BUILD_SCM_REVISION} by {BUILD_USER}", want: "v1.0-abc123 by gopher", ok: true},
+		{value: "{EMPTY}", want: "", ok: true},
+		{value: "{not a key}", want: "{not a key}", ok: true},
+		{value: "v1.0-{UNDEFINED}", ok: false},
+	} {
+		got, ok := expandStamp(c.value, stampmap)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("expandStamp(%q) = %q, %v; want %q, %v", c.value, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// nogoConfig is the configuration of the analyzers nogo runs, read from the
+// JSON file given to the nogo rule. It maps analyzer names to their
+// configuration. Analyzers that aren't listed report issues in every file.
+type nogoConfig map[string]*analyzerConfig
+
+// analyzerConfig is the configuration of one analyzer.
+type analyzerConfig struct {
+	// Disabled turns off the analyzer. It still runs if another analyzer
+	// requires its results, but it doesn't report issues.
+	Disabled bool `json:"disabled"`
+
+	// OnlyFiles maps regular expressions to comments explaining them. If it
+	// isn't empty, issues are only reported in files whose paths match one
+	// of them.
+	OnlyFiles map[string]string `json:"only_files"`
+
+	// ExcludeFiles maps regular expressions to comments explaining them.
+	// Issues aren't reported in files whose paths match any of them.
+	ExcludeFiles map[string]string `json:"exclude_files"`
+
+	// ExcludeGenerated, if true, turns off reports in generated files, which
+	// have a "// Code generated ... DO NOT EDIT." comment.
+	ExcludeGenerated bool `json:"exclude_generated"`
+
+	onlyFiles, excludeFiles []*regexp.Regexp
+}
+
+// loadNogoConfig reads the configuration in the file at path and compiles
+// its regular expressions. analyzers are the names of the analyzers nogo was
+// built with; other names in the configuration are reported as errors, so
+// typos don't go unnoticed.
+func loadNogoConfig(path string, analyzers []string) (nogoConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c nogoConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	known := make(map[string]bool)
+	for _, name := range analyzers {
+		known[name] = true
+	}
+	for name, ac := range c {
+		if !known[name] {
+			return nil, fmt.Errorf("%s: unknown analyzer %q", path, name)
+		}
+		if ac == nil {
+			return nil, fmt.Errorf("%s: analyzer %q has no configuration", path, name)
+		}
+		if ac.onlyFiles, err = compileRegexps(ac.OnlyFiles); err != nil {
+			return nil, fmt.Errorf("%s: in only_files of analyzer %q: %v", path, name, err)
+		}
+		if ac.excludeFiles, err = compileRegexps(ac.ExcludeFiles); err != nil {
+			return nil, fmt.Errorf("%s: in exclude_files of analyzer %q: %v", path, name, err)
+		}
+	}
+	return c, nil
+}
+
+func compileRegexps(patterns map[string]string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// enabled returns whether the analyzer named name reports issues anywhere.
+func (c nogoConfig) enabled(name string) bool {
+	ac := c[name]
+	return ac == nil || !ac.Disabled
+}
+
+// reports returns whether the analyzer named name reports issues in the file
+// at path, which is generated if generated is true.
+func (c nogoConfig) reports(name, path string, generated bool) bool {
+	ac := c[name]
+	if ac == nil {
+		return true
+	}
+	if ac.Disabled || (ac.ExcludeGenerated && generated) {
+		return false
+	}
+	if len(ac.onlyFiles) > 0 && !matchAny(ac.onlyFiles, path) {
+		return false
+	}
+	return !matchAny(ac.excludeFiles, path)
+}
+
+func matchAny(res []*regexp.Regexp, path string) bool {
+	for _, re := range res {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isGenerated returns whether f has a comment marking it as generated, as
+// described in https://golang.org/s/generatedcode. The comment must come
+// before the package clause.
+func isGenerated(f *ast.File) bool {
+	for _, group := range f.Comments {
+		if group.Pos() >= f.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "// Code generated ") && strings.HasSuffix(comment.Text, " DO NOT EDIT.") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "nogo_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestNogoConfigReports(t *testing.T) {
+	path, cleanup := writeConfig(t, `{
+  "bools": {"disabled": true},
+  "copylock": {
+    "exclude_files": {"^external/": "third-party code"},
+    "exclude_generated": true
+  },
+  "shift": {"only_files": {"^pkg/new/": "fixed so far"}}
+}`)
+	defer cleanup()
+	c, err := loadNogoConfig(path, []string{"bools", "copylock", "shift", "tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		analyzer, path  string
+		generated, want bool
+	}{
+		{"bools", "pkg/a.go", false, false},
+		{"copylock", "pkg/a.go", false, true},
+		{"copylock", "external/foo/a.go", false, false},
+		{"copylock", "pkg/a.pb.go", true, false},
+		{"shift", "pkg/new/a.go", false, true},
+		{"shift", "pkg/old/a.go", false, false},
+		{"tests", "pkg/a.pb.go", true, true},
+	} {
+		if got := c.reports(tc.analyzer, tc.path, tc.generated); got != tc.want {
+			t.Errorf("reports(%q, %q, %v): got %v; want %v", tc.analyzer, tc.path, tc.generated, got, tc.want)
+		}
+	}
+	if c.enabled("bools") || !c.enabled("copylock") || !c.enabled("tests") {
+		t.Errorf("only bools should be disabled")
+	}
+}
+
+func TestNogoConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc, content, want string
+	}{
+		{"unknown analyzer", `{"bool": {}}`, `unknown analyzer "bool"`},
+		{"bad regexp", `{"bools": {"exclude_files": {"(": ""}}}`, "in exclude_files"},
+		{"bad json", `{"bools": }`, "error parsing"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			path, cleanup := writeConfig(t, tc.content)
+			defer cleanup()
+			if _, err := loadNogoConfig(path, []string{"bools"}); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v; want error containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsGenerated(t *testing.T) {
+	for _, tc := range []struct {
+		desc, src string
+		want      bool
+	}{
+		{"generated", "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage a\n", true},
+		{"handwritten", "// Package a does things.\npackage a\n", false},
+		{"after package", "package a\n\n// Code generated by hand. DO NOT EDIT.\n", false},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := parser.ParseFile(token.NewFileSet(), "a.go", tc.src, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := isGenerated(f); got != tc.want {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
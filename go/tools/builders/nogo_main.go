@@ -0,0 +1,287 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// nogo runs static analyzers on a Go package. It is built by the nogo rule,
+// together with a generated file that declares the analyzers, and it is
+// invoked by the compile builder after a package is compiled.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func run(args []string) error {
+	var search, importmaps stringList
+	flags := flag.NewFlagSet("nogo", flag.ContinueOnError)
+	flags.Var(&search, "I", "A directory to search for the archives of imported packages")
+	flags.Var(&importmaps, "importmap", "An imported package whose archive is named after another path, as importpath=importmap")
+	installSuffix := flags.String("installsuffix", "", "The suffix of the standard library's directory in GOROOT/pkg")
+	configPath := flags.String("config", "", "A JSON file configuring which analyzers report issues in which files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no sources to check")
+	}
+	importmap, err := parseImportMap(importmaps)
+	if err != nil {
+		return err
+	}
+	var config nogoConfig
+	if *configPath != "" {
+		var names []string
+		for _, a := range analyzers {
+			names = append(names, a.Name)
+		}
+		var err error
+		if config, err = loadNogoConfig(*configPath, names); err != nil {
+			return err
+		}
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, src := range flags.Args() {
+		f, err := parser.ParseFile(fset, src, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	// The compiler isn't told the package's import path, so the package is
+	// checked under its name.
+	name := files[0].Name.Name
+
+	imp := &archiveImporter{search: search, importmap: importmap, installSuffix: *installSuffix}
+	typesConfig := types.Config{Importer: importer.For("gc", imp.lookup)}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+	pkg, err := typesConfig.Check(name, fset, files, info)
+	if err != nil {
+		return fmt.Errorf("type-checking %s: %v", name, err)
+	}
+
+	diagnostics, err := checkPackage(fset, files, pkg, info, config)
+	if err != nil {
+		return err
+	}
+	if len(diagnostics) == 0 {
+		return nil
+	}
+	for _, d := range diagnostics {
+		fmt.Fprintln(os.Stderr, d)
+	}
+	return fmt.Errorf("%d issues found by nogo in package %s", len(diagnostics), name)
+}
+
+// checkPackage runs the analyzers config enables, and the analyzers they
+// require, on a type-checked package. It returns the findings config lets
+// them report, formatted and sorted by position. Facts aren't read from
+// dependencies, so analyzers that depend on facts about other packages only
+// see the facts they export themselves.
+func checkPackage(fset *token.FileSet, files []*ast.File, pkg *types.Package, info *types.Info, config nogoConfig) ([]string, error) {
+	results := make(map[*analysis.Analyzer]interface{})
+	var diagnostics []diagnostic
+	var visit func(a *analysis.Analyzer) error
+	visit = func(a *analysis.Analyzer) error {
+		if _, ok := results[a]; ok {
+			return nil
+		}
+		resultOf := make(map[*analysis.Analyzer]interface{})
+		for _, req := range a.Requires {
+			if err := visit(req); err != nil {
+				return err
+			}
+			resultOf[req] = results[req]
+		}
+		objectFacts := make(map[types.Object][]analysis.Fact)
+		var packageFacts []analysis.Fact
+		pass := &analysis.Pass{
+			Analyzer:   a,
+			Fset:       fset,
+			Files:      files,
+			Pkg:        pkg,
+			TypesInfo:  info,
+			TypesSizes: sizes(),
+			ResultOf:   resultOf,
+			Report: func(d analysis.Diagnostic) {
+				diagnostics = append(diagnostics, diagnostic{analyzer: a.Name, pos: fset.Position(d.Pos), message: d.Message})
+			},
+			ImportObjectFact: func(obj types.Object, fact analysis.Fact) bool {
+				return importFact(objectFacts[obj], fact)
+			},
+			ExportObjectFact: func(obj types.Object, fact analysis.Fact) {
+				objectFacts[obj] = append(objectFacts[obj], fact)
+			},
+			ImportPackageFact: func(p *types.Package, fact analysis.Fact) bool {
+				return p == pkg && importFact(packageFacts, fact)
+			},
+			ExportPackageFact: func(fact analysis.Fact) {
+				packageFacts = append(packageFacts, fact)
+			},
+		}
+		result, err := a.Run(pass)
+		if err != nil {
+			return fmt.Errorf("analyzer %s failed: %v", a.Name, err)
+		}
+		results[a] = result
+		return nil
+	}
+	for _, a := range analyzers {
+		if !config.enabled(a.Name) {
+			continue
+		}
+		if err := visit(a); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the analyzers nogo was built with report findings. Those they
+	// require are run for their results.
+	reporting := make(map[string]bool)
+	for _, a := range analyzers {
+		reporting[a.Name] = true
+	}
+	generated := make(map[string]bool)
+	for _, f := range files {
+		generated[fset.File(f.Pos()).Name()] = isGenerated(f)
+	}
+	sort.Sort(byPosition(diagnostics))
+	var formatted []string
+	for _, d := range diagnostics {
+		if reporting[d.analyzer] && config.reports(d.analyzer, d.pos.Filename, generated[d.pos.Filename]) {
+			formatted = append(formatted, d.String())
+		}
+	}
+	return formatted, nil
+}
+
+// importFact copies the last fact in facts with the same type as fact into
+// it, and reports whether there was one.
+func importFact(facts []analysis.Fact, fact analysis.Fact) bool {
+	for i := len(facts) - 1; i >= 0; i-- {
+		if reflect.TypeOf(facts[i]) == reflect.TypeOf(fact) {
+			reflect.ValueOf(fact).Elem().Set(reflect.ValueOf(facts[i]).Elem())
+			return true
+		}
+	}
+	return false
+}
+
+type diagnostic struct {
+	analyzer string
+	pos      token.Position
+	message  string
+}
+
+func (d diagnostic) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.pos, d.message, d.analyzer)
+}
+
+type byPosition []diagnostic
+
+func (ds byPosition) Len() int      { return len(ds) }
+func (ds byPosition) Swap(i, j int) { ds[i], ds[j] = ds[j], ds[i] }
+func (ds byPosition) Less(i, j int) bool {
+	if ds[i].pos.Filename != ds[j].pos.Filename {
+		return ds[i].pos.Filename < ds[j].pos.Filename
+	}
+	if ds[i].pos.Line != ds[j].pos.Line {
+		return ds[i].pos.Line < ds[j].pos.Line
+	}
+	return ds[i].pos.Column < ds[j].pos.Column
+}
+
+// archiveImporter finds the archives of imported packages in the search
+// paths the compiler was given, or in the standard library. Packages in
+// importmap are found by the path they were compiled as.
+type archiveImporter struct {
+	search        []string
+	importmap     map[string]string
+	installSuffix string
+}
+
+func (i *archiveImporter) lookup(path string) (io.ReadCloser, error) {
+	if mapped, ok := i.importmap[path]; ok {
+		path = mapped
+	}
+	for _, dir := range i.search {
+		if f, err := os.Open(filepath.Join(dir, filepath.FromSlash(path)+".a")); err == nil {
+			return f, nil
+		}
+	}
+	pkgDir := goEnv("GOOS", runtime.GOOS) + "_" + goEnv("GOARCH", runtime.GOARCH)
+	if i.installSuffix != "" {
+		pkgDir += "_" + i.installSuffix
+	}
+	return os.Open(filepath.Join(goEnv("GOROOT", runtime.GOROOT()), "pkg", pkgDir, filepath.FromSlash(path)+".a"))
+}
+
+func goEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// sizes returns the sizes of types on the target architecture.
+func sizes() types.Sizes {
+	switch goEnv("GOARCH", runtime.GOARCH) {
+	case "386", "arm", "mips", "mipsle":
+		return &types.StdSizes{WordSize: 4, MaxAlign: 4}
+	default:
+		return &types.StdSizes{WordSize: 8, MaxAlign: 8}
+	}
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("nogo: ")
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
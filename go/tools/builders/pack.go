@@ -0,0 +1,64 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// pack adds object files to a copy of a package archive, using "go tool
+// pack". It is invoked by the Go rules as an action, for packages with cgo
+// objects.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+func run(args []string) error {
+	args, err := expandParamsFiles(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("pack", flag.ExitOnError)
+	gotool := flags.String("go", "", "The path of the go command.")
+	in := flags.String("in", "", "The archive to add the objects to.")
+	out := flags.String("out", "", "The archive to write.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *gotool == "" || *in == "" || *out == "" {
+		return fmt.Errorf("-go, -in and -out must be set")
+	}
+	if err := absGoroot(); err != nil {
+		return err
+	}
+	if err := copyFile(*in, *out); err != nil {
+		return err
+	}
+	cmd := exec.Command(*gotool, append([]string{"tool", "pack", "r", *out}, flags.Args()...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running pack: %v", err)
+	}
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("pack: ")
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
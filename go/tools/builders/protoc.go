@@ -0,0 +1,154 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// protoc runs the protocol buffer compiler with a plugin that generates Go
+// code, and moves the files it generates to the paths Bazel expects. It is
+// invoked by go_proto_library, once for each of its compilers.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// collectGenerated returns the files generated in dir, by base name. Plugins
+// write files to directories named after their Go packages or .proto files,
+// which Bazel doesn't know before they run.
+func collectGenerated(dir string) (map[string][]string, error) {
+	generated := make(map[string][]string)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			base := filepath.Base(p)
+			generated[base] = append(generated[base], p)
+		}
+		return nil
+	})
+	return generated, err
+}
+
+// packageName returns the name of the package with importpath, for the files
+// a plugin didn't generate.
+func packageName(importpath string) string {
+	name := path.Base(importpath)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// moveGenerated moves each of the expected files from the files generated in
+// dir with the same base name. Plugins like protoc-gen-go-grpc generate
+// nothing for .proto files they have no code for, so for those, a file that
+// only declares package pkg is written instead.
+func moveGenerated(dir string, expected []string, pkg string) error {
+	generated, err := collectGenerated(dir)
+	if err != nil {
+		return err
+	}
+	for _, out := range expected {
+		base := filepath.Base(out)
+		matches := generated[base]
+		if len(matches) > 1 {
+			return fmt.Errorf("%s was generated more than once: %s", base, strings.Join(matches, ", "))
+		}
+		if err := os.MkdirAll(filepath.Dir(out), 0777); err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			if err := ioutil.WriteFile(out, []byte("package "+pkg+"\n"), 0666); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(matches[0], out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func run(args []string) error {
+	args, err := expandParamsFiles(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("protoc", flag.ExitOnError)
+	protoc := flags.String("protoc", "", "The protocol buffer compiler.")
+	plugin := flags.String("plugin", "", "The protoc plugin that generates Go code.")
+	importpath := flags.String("importpath", "", "The import path of the generated package.")
+	var options, imports, protoPaths, expected multiFlag
+	flags.Var(&options, "option", "An option for the plugin.")
+	flags.Var(&imports, "import", "A file=importpath mapping for a .proto file the sources may import.")
+	flags.Var(&protoPaths, "proto_path", "A path=file mapping from the path a .proto file is imported by to the file, passed to protoc with -I.")
+	flags.Var(&expected, "expected", "A file the plugin generates, with the base name it generates it with.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *protoc == "" || *plugin == "" || *importpath == "" {
+		return fmt.Errorf("-protoc, -plugin and -importpath must be set")
+	}
+	// The remaining arguments are the sources, by the paths they're imported
+	// by.
+	sources := flags.Args()
+
+	tmp, err := ioutil.TempDir("", "go_proto")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	pluginOptions := append([]string{}, options...)
+	pluginOptions = append(pluginOptions, "import_path="+*importpath)
+	for _, source := range sources {
+		pluginOptions = append(pluginOptions, fmt.Sprintf("M%s=%s", source, *importpath))
+	}
+	for _, imp := range imports {
+		pluginOptions = append(pluginOptions, "M"+imp)
+	}
+	protocArgs := []string{
+		"--plugin=protoc-gen-bazel=" + abs(*plugin),
+		"--bazel_out=" + strings.Join(pluginOptions, ",") + ":" + tmp,
+	}
+	for _, p := range protoPaths {
+		protocArgs = append(protocArgs, "-I"+p)
+	}
+	protocArgs = append(protocArgs, sources...)
+	cmd := exec.Command(*protoc, protocArgs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running protoc: %v", err)
+	}
+	return moveGenerated(tmp, expected, packageName(*importpath))
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("go_protoc: ")
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
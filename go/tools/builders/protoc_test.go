@@ -0,0 +1,84 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackageName(t *testing.T) {
+	for _, c := range []struct {
+		importpath, want string
+	}{
+		{"example.com/foo", "foo"},
+		{"example.com/foo-bar", "foo_bar"},
+		{"example.com/foo.v1", "foo_v1"},
+		{"foo", "foo"},
+	} {
+		if got := packageName(c.importpath); got != c.want {
+			t.Errorf("packageName(%q): got %q, want %q", c.importpath, got, c.want)
+		}
+	}
+}
+
+func TestMoveGenerated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protoc_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gen := filepath.Join(dir, "gen")
+	out := filepath.Join(dir, "out")
+	write := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(gen, "example.com", "foo", "a.pb.go"), "package foo // a\n")
+	write(filepath.Join(gen, "b", "b.pb.go"), "package foo // b\n")
+
+	expected := []string{
+		filepath.Join(out, "a.pb.go"),
+		filepath.Join(out, "b.pb.go"),
+		filepath.Join(out, "c.pb.go"),
+	}
+	if err := moveGenerated(gen, expected, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		expected[0]: "package foo // a\n",
+		expected[1]: "package foo // b\n",
+		expected[2]: "package foo\n",
+	} {
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+
+	write(filepath.Join(gen, "x", "d.pb.go"), "package foo\n")
+	write(filepath.Join(gen, "y", "d.pb.go"), "package foo\n")
+	if err := moveGenerated(gen, []string{filepath.Join(out, "d.pb.go")}, "foo"); err == nil {
+		t.Error("got no error for a file generated twice")
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// release packages binaries built by go_release into tar.gz or zip archives,
+// and writes the SHA-256 checksums of those archives.
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// releaseFile is a file to package, at the path dst in the archive.
+type releaseFile struct {
+	dst, src   string
+	executable bool
+}
+
+// releaseTime is the modification time of every file in an archive, so the
+// archive only changes when its files do.
+var releaseTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (f releaseFile) mode() os.FileMode {
+	if f.executable {
+		return 0755
+	}
+	return 0644
+}
+
+// writeTarGz writes files to a gzipped tar archive at out, in order.
+func writeTarGz(out string, files []releaseFile) (err error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, file := range files {
+		info, err := os.Stat(file.src)
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{
+			Name:     file.dst,
+			Mode:     int64(file.mode()),
+			Size:     info.Size(),
+			ModTime:  releaseTime,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := copyReleaseFile(tw, file.src); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// writeZip writes files to a zip archive at out, in order.
+func writeZip(out string, files []releaseFile) (err error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	w := zip.NewWriter(f)
+	for _, file := range files {
+		header := &zip.FileHeader{Name: file.dst, Method: zip.Deflate}
+		header.SetModTime(releaseTime)
+		header.SetMode(file.mode())
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := copyReleaseFile(fw, file.src); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// writeChecksums writes the SHA-256 checksum of each file to out, one line
+// per file in the format of sha256sum, sorted by base name.
+func writeChecksums(out string, srcs []string) (err error) {
+	sorted := append([]string(nil), srcs...)
+	sort.Sort(byBase(sorted))
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	for _, src := range sorted {
+		hash := sha256.New()
+		if err := copyReleaseFile(hash, src); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(f, "%x  %s\n", hash.Sum(nil), filepath.Base(src)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyReleaseFile(w io.Writer, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+type byBase []string
+
+func (s byBase) Len() int           { return len(s) }
+func (s byBase) Less(i, j int) bool { return filepath.Base(s[i]) < filepath.Base(s[j]) }
+func (s byBase) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func run(args []string) error {
+	args, err := expandParamsFiles(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("release", flag.ExitOnError)
+	mode := flags.String("mode", "archive", "What to write: archive or checksums.")
+	format := flags.String("format", "tar.gz", "The format of the archive: tar.gz or zip.")
+	out := flags.String("out", "", "The archive or checksums file to write.")
+	var executables multiFlag
+	flags.Var(&executables, "executable", "A path in the archive of a file that is executable. May be repeated.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("-out must be set")
+	}
+	if *mode == "checksums" {
+		// The remaining arguments are the files to checksum.
+		return writeChecksums(*out, flags.Args())
+	}
+	if *mode != "archive" {
+		return fmt.Errorf("unknown mode %q", *mode)
+	}
+	// The remaining arguments are pairs of a path in the archive, and the
+	// path of the file packaged there.
+	if flags.NArg()%2 != 0 {
+		return fmt.Errorf("expected pairs of archive paths and file paths, got %d arguments", flags.NArg())
+	}
+	isExecutable := make(map[string]bool)
+	for _, e := range executables {
+		isExecutable[e] = true
+	}
+	var files []releaseFile
+	for i := 0; i < flags.NArg(); i += 2 {
+		dst := flags.Arg(i)
+		files = append(files, releaseFile{dst: dst, src: flags.Arg(i + 1), executable: isExecutable[dst]})
+	}
+	switch *format {
+	case "tar.gz":
+		return writeTarGz(*out, files)
+	case "zip":
+		return writeZip(*out, files)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("release: ")
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
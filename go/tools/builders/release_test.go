@@ -0,0 +1,151 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeReleaseFiles(t *testing.T, dir string) []releaseFile {
+	var files []releaseFile
+	for _, f := range []struct {
+		dst, content string
+		executable   bool
+	}{
+		{"hello", "binary\n", true},
+		{"LICENSE", "license\n", false},
+	} {
+		src := filepath.Join(dir, "src_"+f.dst)
+		if err := ioutil.WriteFile(src, []byte(f.content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, releaseFile{dst: f.dst, src: src, executable: f.executable})
+	}
+	return files
+}
+
+func TestWriteTarGz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWriteTarGz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := writeReleaseFiles(t, dir)
+
+	out := filepath.Join(dir, "hello.tar.gz")
+	if err := writeTarGz(out, files); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	var names []string
+	modes := make(map[string]int64)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+		modes[h.Name] = h.Mode
+		if !h.ModTime.Equal(releaseTime) {
+			t.Errorf("%s: got modification time %v; want %v", h.Name, h.ModTime, releaseTime)
+		}
+	}
+	if want := []string{"hello", "LICENSE"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %q; want %q", names, want)
+	}
+	if modes["hello"] != 0755 || modes["LICENSE"] != 0644 {
+		t.Errorf("got modes %o and %o; want 755 and 644", modes["hello"], modes["LICENSE"])
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWriteZip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := writeReleaseFiles(t, dir)
+
+	out := filepath.Join(dir, "hello.zip")
+	if err := writeZip(out, files); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		if f.Name == "hello" && f.Mode()&0111 == 0 {
+			t.Errorf("%s: got mode %v; want it to be executable", f.Name, f.Mode())
+		}
+	}
+	if want := []string{"hello", "LICENSE"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %q; want %q", names, want)
+	}
+}
+
+func TestWriteChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWriteChecksums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var srcs []string
+	for _, name := range []string{"b.zip", "a.tar.gz"} {
+		src := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(src, []byte("hello\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		srcs = append(srcs, src)
+	}
+
+	out := filepath.Join(dir, "checksums.txt")
+	if err := writeChecksums(out, srcs); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The SHA-256 checksum of "hello\n", as printed by sha256sum.
+	sum := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	want := sum + "  a.tar.gz\n" + sum + "  b.zip\n"
+	if got := string(data); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// sbom writes a license inventory of the external repositories a Go binary
+// is built from, and a software bill of materials for it, in SPDX or
+// CycloneDX JSON. It is invoked by the go_sbom rule.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// repositoryMetadata describes a go_repository, as go_repository_metadata
+// records it.
+type repositoryMetadata struct {
+	Importpath string   `json:"importpath"`
+	Version    string   `json:"version"`
+	Remote     string   `json:"remote"`
+	URLs       []string `json:"urls"`
+}
+
+// component is an external repository the binary is built from.
+type component struct {
+	Repository string   `json:"repository"`
+	Importpath string   `json:"importpath"`
+	Version    string   `json:"version"`
+	Download   string   `json:"download"`
+	License    string   `json:"license"`
+	Packages   []string `json:"packages"`
+}
+
+// noAssertion is what SPDX documents say when a value isn't known.
+const noAssertion = "NOASSERTION"
+
+// licensePatterns identify licenses by their texts, as SPDX identifiers. They
+// are tried in order, so more specific licenses come first.
+var licensePatterns = []struct {
+	id string
+	re *regexp.Regexp
+}{
+	{"Apache-2.0", regexp.MustCompile(`(?i)apache license,?\s+version 2\.0`)},
+	{"MPL-2.0", regexp.MustCompile(`(?i)mozilla public license,?\s+version 2\.0`)},
+	{"AGPL-3.0", regexp.MustCompile(`(?i)gnu affero general public license\s+version 3`)},
+	{"LGPL-3.0", regexp.MustCompile(`(?i)gnu lesser general public license\s+version 3`)},
+	{"LGPL-2.1", regexp.MustCompile(`(?i)gnu lesser general public license\s+version 2\.1`)},
+	{"GPL-3.0", regexp.MustCompile(`(?i)gnu general public license\s+version 3`)},
+	{"GPL-2.0", regexp.MustCompile(`(?i)gnu general public license\s+version 2`)},
+	{"BSD-3-Clause", regexp.MustCompile(`(?is)redistribution and use in source and binary forms.*neither the name`)},
+	{"BSD-2-Clause", regexp.MustCompile(`(?i)redistribution and use in source and binary forms`)},
+	{"ISC", regexp.MustCompile(`(?i)permission to use, copy, modify, and(/or)? distribute this software for any`)},
+	{"MIT", regexp.MustCompile(`(?i)permission is hereby granted, free of charge`)},
+	{"Unlicense", regexp.MustCompile(`(?i)this is free and unencumbered software released into the public domain`)},
+}
+
+// identifyLicense returns the SPDX identifier of the license in text, the
+// license files of a repository, or NOASSERTION if it isn't recognized.
+func identifyLicense(text string) string {
+	for _, p := range licensePatterns {
+		if p.re.MatchString(text) {
+			return p.id
+		}
+	}
+	return noAssertion
+}
+
+// collectComponents returns the components the packages come from, sorted by
+// import path. packages are "repository=importpath" strings; packages in the
+// main repository, with an empty repository name, aren't components.
+// licenses are the texts of the license files of each repository.
+func collectComponents(packages []string, metadata map[string]repositoryMetadata, licenses map[string]string) ([]*component, error) {
+	byRepository := make(map[string]*component)
+	for _, p := range packages {
+		i := strings.Index(p, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("package %q is not repository=importpath", p)
+		}
+		repo, importpath := p[:i], p[i+1:]
+		if repo == "" {
+			continue
+		}
+		c, ok := byRepository[repo]
+		if !ok {
+			m, ok := metadata[repo]
+			if !ok {
+				// Repositories that aren't go_repository rules, like
+				// http_archive, have no metadata.
+				m = repositoryMetadata{Importpath: importpath}
+			}
+			c = &component{
+				Repository: repo,
+				Importpath: m.Importpath,
+				Version:    m.Version,
+				Download:   downloadLocation(m),
+				License:    identifyLicense(licenses[repo]),
+			}
+			byRepository[repo] = c
+		}
+		c.Packages = append(c.Packages, importpath)
+	}
+	var components []*component
+	for _, c := range byRepository {
+		sort.Strings(c.Packages)
+		c.Packages = dedupe(c.Packages)
+		components = append(components, c)
+	}
+	sort.Sort(byImportpath(components))
+	return components, nil
+}
+
+// byImportpath sorts components by import path, then by repository.
+type byImportpath []*component
+
+func (s byImportpath) Len() int      { return len(s) }
+func (s byImportpath) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byImportpath) Less(i, j int) bool {
+	if s[i].Importpath != s[j].Importpath {
+		return s[i].Importpath < s[j].Importpath
+	}
+	return s[i].Repository < s[j].Repository
+}
+
+// downloadLocation returns where a repository was fetched from.
+func downloadLocation(m repositoryMetadata) string {
+	if m.Remote != "" {
+		return m.Remote
+	}
+	if len(m.URLs) > 0 {
+		return m.URLs[0]
+	}
+	if m.Importpath != "" && m.Version != "" {
+		return "https://" + m.Importpath
+	}
+	return noAssertion
+}
+
+// dedupe removes adjacent duplicates from sorted strings.
+func dedupe(s []string) []string {
+	var out []string
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// spdxIDRe matches the characters SPDX element IDs may not contain.
+var spdxIDRe = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// spdxID returns an SPDX element ID for c, which may only contain letters,
+// digits, "." and "-".
+func spdxID(c *component) string {
+	return "SPDXRef-Package-" + spdxIDRe.ReplaceAllString(c.Repository, "-")
+}
+
+// spdxDocument returns an SPDX 2.2 document for the binary name built from
+// components. It has no creation time of its own, so it only changes when
+// the components do.
+func spdxDocument(name string, components []*component) interface{} {
+	type pkg struct {
+		SPDXID           string `json:"SPDXID"`
+		Name             string `json:"name"`
+		VersionInfo      string `json:"versionInfo,omitempty"`
+		DownloadLocation string `json:"downloadLocation"`
+		FilesAnalyzed    bool   `json:"filesAnalyzed"`
+		LicenseConcluded string `json:"licenseConcluded"`
+		LicenseDeclared  string `json:"licenseDeclared"`
+		CopyrightText    string `json:"copyrightText"`
+	}
+	type relationship struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}
+	root := "SPDXRef-Package-binary"
+	packages := []pkg{{
+		SPDXID:           root,
+		Name:             name,
+		DownloadLocation: noAssertion,
+		LicenseConcluded: noAssertion,
+		LicenseDeclared:  noAssertion,
+		CopyrightText:    noAssertion,
+	}}
+	relationships := []relationship{{"SPDXRef-DOCUMENT", "DESCRIBES", root}}
+	for _, c := range components {
+		id := spdxID(c)
+		packages = append(packages, pkg{
+			SPDXID:           id,
+			Name:             c.Importpath,
+			VersionInfo:      c.Version,
+			DownloadLocation: c.Download,
+			LicenseConcluded: noAssertion,
+			LicenseDeclared:  c.License,
+			CopyrightText:    noAssertion,
+		})
+		relationships = append(relationships, relationship{root, "DEPENDS_ON", id})
+	}
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.2",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              name,
+		"documentNamespace": "https://spdx.org/spdxdocs/" + strings.Trim(spdxIDRe.ReplaceAllString(name, "-"), "-"),
+		"creationInfo": map[string]interface{}{
+			"created":  "1970-01-01T00:00:00Z",
+			"creators": []string{"Tool: rules_go-go_sbom"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+// cycloneDXDocument returns a CycloneDX 1.4 BOM for the binary name built
+// from components.
+func cycloneDXDocument(name string, components []*component) interface{} {
+	var entries []map[string]interface{}
+	for _, c := range components {
+		entry := map[string]interface{}{
+			"type": "library",
+			"name": c.Importpath,
+		}
+		if c.Version != "" {
+			entry["version"] = c.Version
+			entry["purl"] = "pkg:golang/" + c.Importpath + "@" + c.Version
+		} else {
+			entry["purl"] = "pkg:golang/" + c.Importpath
+		}
+		if c.License != noAssertion {
+			entry["licenses"] = []interface{}{map[string]interface{}{
+				"license": map[string]string{"id": c.License},
+			}}
+		}
+		entries = append(entries, entry)
+	}
+	return map[string]interface{}{
+		"bomFormat":   "CycloneDX",
+		"specVersion": "1.4",
+		"version":     1,
+		"metadata": map[string]interface{}{
+			"component": map[string]string{"type": "application", "name": name},
+		},
+		"components": entries,
+	}
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0666)
+}
+
+func run(args []string) error {
+	args, err := expandParamsFiles(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("sbom", flag.ExitOnError)
+	name := flags.String("name", "", "The name of the binary the SBOM describes.")
+	format := flags.String("format", "spdx", "The format of the SBOM: spdx or cyclonedx.")
+	metadataPath := flags.String("metadata", "", "The go_repository metadata, written by go_repository_metadata.")
+	out := flags.String("out", "", "The SBOM to write.")
+	inventory := flags.String("inventory", "", "The license inventory to write.")
+	var packages, licenseFiles multiFlag
+	flags.Var(&packages, "package", "A repository=importpath package the binary is built from.")
+	flags.Var(&licenseFiles, "license", "A repository=file mapping to the license files of a repository.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" || *out == "" || *inventory == "" {
+		return fmt.Errorf("-name, -out and -inventory must be set")
+	}
+
+	metadata := make(map[string]repositoryMetadata)
+	if *metadataPath != "" {
+		data, err := ioutil.ReadFile(*metadataPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return fmt.Errorf("%s: %v", *metadataPath, err)
+		}
+	}
+	licenses := make(map[string]string)
+	for _, l := range licenseFiles {
+		i := strings.Index(l, "=")
+		if i < 0 {
+			return fmt.Errorf("license %q is not repository=file", l)
+		}
+		data, err := ioutil.ReadFile(l[i+1:])
+		if err != nil {
+			return err
+		}
+		licenses[l[:i]] = string(data)
+	}
+
+	components, err := collectComponents(packages, metadata, licenses)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	switch *format {
+	case "spdx":
+		doc = spdxDocument(*name, components)
+	case "cyclonedx":
+		doc = cycloneDXDocument(*name, components)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err := writeJSON(*out, doc); err != nil {
+		return err
+	}
+	if components == nil {
+		components = []*component{}
+	}
+	return writeJSON(*inventory, components)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("sbom: ")
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIdentifyLicense(t *testing.T) {
+	for _, c := range []struct {
+		text, want string
+	}{
+		{"Apache License\nVersion 2.0, January 2004", "Apache-2.0"},
+		{"Redistribution and use in source and binary forms, with or without\nmodification...\n* Neither the name of Google Inc.", "BSD-3-Clause"},
+		{"Redistribution and use in source and binary forms, with or without\nmodification, are permitted", "BSD-2-Clause"},
+		{"The MIT License\n\nPermission is hereby granted, free of charge, to any person", "MIT"},
+		{"Mozilla Public License Version 2.0", "MPL-2.0"},
+		{"", "NOASSERTION"},
+		{"All rights reserved.", "NOASSERTION"},
+	} {
+		if got := identifyLicense(c.text); got != c.want {
+			t.Errorf("identifyLicense(%q): got %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestCollectComponents(t *testing.T) {
+	metadata := map[string]repositoryMetadata{
+		"com_github_foo_bar": {Importpath: "github.com/foo/bar", Version: "v1.0.0"},
+		"org_example_baz":    {Importpath: "example.org/baz", Version: "abc123", Remote: "https://git.example.org/baz"},
+	}
+	licenses := map[string]string{
+		"com_github_foo_bar": "Apache License\nVersion 2.0",
+	}
+	packages := []string{
+		"=example.com/main",
+		"com_github_foo_bar=github.com/foo/bar/b",
+		"com_github_foo_bar=github.com/foo/bar/a",
+		"com_github_foo_bar=github.com/foo/bar/a",
+		"org_example_baz=example.org/baz",
+		"com_google_protobuf=github.com/google/protobuf/ptypes",
+	}
+	got, err := collectComponents(packages, metadata, licenses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*component{
+		{
+			Repository: "org_example_baz",
+			Importpath: "example.org/baz",
+			Version:    "abc123",
+			Download:   "https://git.example.org/baz",
+			License:    "NOASSERTION",
+			Packages:   []string{"example.org/baz"},
+		}, {
+			Repository: "com_github_foo_bar",
+			Importpath: "github.com/foo/bar",
+			Version:    "v1.0.0",
+			Download:   "https://github.com/foo/bar",
+			License:    "Apache-2.0",
+			Packages:   []string{"github.com/foo/bar/a", "github.com/foo/bar/b"},
+		}, {
+			Repository: "com_google_protobuf",
+			Importpath: "github.com/google/protobuf/ptypes",
+			Download:   "NOASSERTION",
+			License:    "NOASSERTION",
+			Packages:   []string{"github.com/google/protobuf/ptypes"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for _, c := range got {
+			t.Logf("%+v", *c)
+		}
+		t.Errorf("collectComponents: got %v, want %v", got, want)
+	}
+
+	if _, err := collectComponents([]string{"github.com/foo/bar"}, metadata, licenses); err == nil {
+		t.Error("got no error for a package without a repository")
+	}
+}
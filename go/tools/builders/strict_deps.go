@@ -0,0 +1,189 @@
+// Copyright 2017 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// checkDirectDeps checks that the packages imported by sources are provided
+// by direct dependencies of the target being compiled, whose import paths
+// are deps. Packages provided only by transitive dependencies are reported,
+// like those that aren't provided at all, with the label of the target that
+// probably provides them. label is the target being compiled, and prefix is
+// its Go prefix, which is used to guess labels. Standard library packages are
+// those with archives in stdlib, or with sources in GOROOT if it's empty.
+func checkDirectDeps(bctx build.Context, stdlib string, sources, deps []string, label, prefix string) error {
+	depSet := make(map[string]bool)
+	for _, d := range deps {
+		depSet[d] = true
+	}
+
+	var errs depsError
+	fs := token.NewFileSet()
+	for _, s := range sources {
+		f, err := parser.ParseFile(fs, s, nil, parser.ImportsOnly)
+		if err != nil {
+			// Let the compiler report parse errors.
+			continue
+		}
+		for _, i := range f.Imports {
+			path, err := strconv.Unquote(i.Path.Value)
+			if err != nil {
+				// Should never happen, but let the compiler deal with it.
+				continue
+			}
+			if path == "C" || isStandard(bctx, stdlib, path) || isRelative(path) {
+				// Standard paths don't need to be listed as dependencies (for now).
+				// Relative paths aren't supported yet. We don't emit errors here, but
+				// they will certainly break something else.
+				continue
+			}
+			if !depSet[path] {
+				target := "the target"
+				if label != "" {
+					target = label
+				}
+				errs = append(errs, fmt.Errorf("%s: import of %q, which is not provided by a direct dependency of %s; add a dependency on %s", s, path, target, suggestLabel(prefix, path)))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+type depsError []error
+
+var _ error = depsError(nil)
+
+func (e depsError) Error() string {
+	errorStrings := make([]string, len(e))
+	for i, err := range e {
+		errorStrings[i] = err.Error()
+	}
+	return "missing strict dependencies:\n\t" + strings.Join(errorStrings, "\n\t")
+}
+
+// suggestLabel guesses the label of the go_library that provides the package
+// importpath, following the conventions Gazelle uses. Packages within prefix
+// are in the main repository. Others are in a repository named after the root
+// of their import path, like @com_github_foo_bar for github.com/foo/bar.
+func suggestLabel(prefix, importpath string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && (importpath == prefix || strings.HasPrefix(importpath, prefix+"/")) {
+		rel := strings.TrimPrefix(strings.TrimPrefix(importpath, prefix), "/")
+		return fmt.Sprintf("//%s:go_default_library", rel)
+	}
+
+	root, rel := importpath, ""
+	components := strings.Split(importpath, "/")
+	rootLen := 0
+	switch {
+	case components[0] == "github.com" || components[0] == "bitbucket.org":
+		rootLen = 3
+	case components[0] == "golang.org" && len(components) > 1 && components[1] == "x":
+		rootLen = 3
+	case components[0] == "gopkg.in" || components[0] == "google.golang.org":
+		rootLen = 2
+	}
+	if rootLen > 0 && len(components) > rootLen {
+		root = strings.Join(components[:rootLen], "/")
+		rel = strings.Join(components[rootLen:], "/")
+	}
+	return fmt.Sprintf("@%s//%s:go_default_library", repoName(root), rel)
+}
+
+// repoName returns the name Gazelle gives the repository for the import path
+// root: the components of the domain are reversed, and the path is joined
+// with underscores.
+func repoName(root string) string {
+	components := strings.Split(root, "/")
+	labels := strings.Split(components[0], ".")
+	var reversed []string
+	for i := range labels {
+		reversed = append(reversed, labels[len(labels)-i-1])
+	}
+	name := strings.Join(append(reversed, components[1:]...), "_")
+	return strings.NewReplacer("-", "_", ".", "_").Replace(name)
+}
+
+// unusedDeps returns the labels of the deps that no file in sources imports.
+// depLabels maps the import paths of the deps declared by the target being
+// compiled to their labels. Deps of embedded libraries aren't in it, since
+// they aren't declared by the target. Every source is read, including those
+// excluded by build constraints, so deps that are only imported on other
+// platforms aren't reported.
+func unusedDeps(sources []string, depLabels map[string]string) []string {
+	imported := make(map[string]bool)
+	fs := token.NewFileSet()
+	for _, s := range sources {
+		f, err := parser.ParseFile(fs, s, nil, parser.ImportsOnly)
+		if err != nil {
+			// Let the compiler report parse errors.
+			continue
+		}
+		for _, i := range f.Imports {
+			if path, err := strconv.Unquote(i.Path.Value); err == nil {
+				imported[path] = true
+			}
+		}
+	}
+	var unused []string
+	for importpath, label := range depLabels {
+		if !imported[importpath] {
+			unused = append(unused, label)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
+
+// reportUnusedDeps prints a warning for each unused dep of the target label,
+// and writes the buildozer commands that remove them to the file at path, so
+// they can be run as a script.
+func reportUnusedDeps(path, label string, unused []string) error {
+	var commands []string
+	for _, dep := range unused {
+		command := fmt.Sprintf("buildozer 'remove deps %s' %s", dep, label)
+		fmt.Fprintf(os.Stderr, "warning: %s does not import %s. To remove it, run:\n\t%s\n", label, dep, command)
+		commands = append(commands, command+"\n")
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(commands, "")), 0666)
+}
+
+func isStandard(bctx build.Context, stdlib, path string) bool {
+	if stdlib != "" {
+		st, err := os.Stat(filepath.Join(stdlib, filepath.FromSlash(path)+".a"))
+		return err == nil && !st.IsDir()
+	}
+	rootPath := filepath.Join(bctx.GOROOT, "src", filepath.FromSlash(path))
+	st, err := os.Stat(rootPath)
+	return err == nil && st.IsDir()
+}
+
+func isRelative(path string) bool {
+	return strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../")
+}
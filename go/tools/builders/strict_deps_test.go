@@ -0,0 +1,158 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSuggestLabel(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, importpath, want string
+	}{
+		{"example.com/repo", "example.com/repo/lib", "//lib:go_default_library"},
+		{"example.com/repo/", "example.com/repo", "//:go_default_library"},
+		{"example.com/repo", "example.com/repository", "@com_example_repository//:go_default_library"},
+		{"", "github.com/foo/bar-baz/sub/pkg", "@com_github_foo_bar_baz//sub/pkg:go_default_library"},
+		{"", "github.com/foo/bar", "@com_github_foo_bar//:go_default_library"},
+		{"", "golang.org/x/net/context", "@org_golang_x_net//context:go_default_library"},
+		{"", "gopkg.in/yaml.v2", "@in_gopkg_yaml_v2//:go_default_library"},
+		{"", "google.golang.org/grpc/codes", "@org_golang_google_grpc//codes:go_default_library"},
+	} {
+		if got := suggestLabel(tc.prefix, tc.importpath); got != tc.want {
+			t.Errorf("suggestLabel(%q, %q): got %q; want %q", tc.prefix, tc.importpath, got, tc.want)
+		}
+	}
+}
+
+func TestCheckDirectDeps(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "strict_deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "a.go")
+	content := `package a
+
+import (
+	"fmt"
+
+	"example.com/repo/direct"
+	"example.com/repo/transitive"
+)
+`
+	if err := ioutil.WriteFile(src, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkDirectDeps(build.Default, "", []string{src}, []string{"example.com/repo/direct", "example.com/repo/transitive"}, "//a:go_default_library", "example.com/repo"); err != nil {
+		t.Errorf("with all deps: got error %v", err)
+	}
+
+	err = checkDirectDeps(build.Default, "", []string{src}, []string{"example.com/repo/direct"}, "//a:go_default_library", "example.com/repo")
+	if err == nil {
+		t.Fatal("with a missing dep: got no error")
+	}
+	for _, want := range []string{`import of "example.com/repo/transitive"`, "//a:go_default_library", "//transitive:go_default_library"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("with a missing dep: got error %q; want it to contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), `"fmt"`) || strings.Contains(err.Error(), `"example.com/repo/direct"`) {
+		t.Errorf("with a missing dep: got error %q; want only the missing import reported", err)
+	}
+}
+
+func TestUnusedDeps(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "unused_deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"a.go":         "package a\n\nimport \"example.com/repo/used\"\n",
+		"a_windows.go": "package a\n\nimport _ \"example.com/repo/windows\"\n",
+	}
+	var sources []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, path)
+	}
+
+	unused := unusedDeps(sources, map[string]string{
+		"example.com/repo/used":    "//used:go_default_library",
+		"example.com/repo/windows": "//windows:go_default_library",
+		"example.com/repo/unused2": "//unused2:go_default_library",
+		"example.com/repo/unused1": "//unused1:go_default_library",
+	})
+	if want := []string{"//unused1:go_default_library", "//unused2:go_default_library"}; !reflect.DeepEqual(unused, want) {
+		t.Errorf("got unused deps %q; want %q", unused, want)
+	}
+
+	script := filepath.Join(dir, "a.unused_deps")
+	if err := reportUnusedDeps(script, "//a:go_default_library", unused); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `buildozer 'remove deps //unused1:go_default_library' //a:go_default_library
+buildozer 'remove deps //unused2:go_default_library' //a:go_default_library
+`
+	if string(got) != want {
+		t.Errorf("got commands:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestIsStandardArchives(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "stdlib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "net"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"fmt.a", "net.a", "net/http.a"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		path string
+		want bool
+	}{
+		{"fmt", true},
+		{"net", true},
+		{"net/http", true},
+		{"net/url", false},
+		{"example.com/repo/fmt", false},
+	} {
+		if got := isStandard(build.Default, dir, tc.path); got != tc.want {
+			t.Errorf("isStandard(%q): got %v; want %v", tc.path, got, tc.want)
+		}
+	}
+}
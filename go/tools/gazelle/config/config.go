@@ -17,6 +17,9 @@ package config
 
 import (
 	"fmt"
+	"path"
+	"strconv"
+	"strings"
 )
 
 // Config holds information about how Gazelle should run. This is mostly
@@ -28,11 +31,22 @@ type Config struct {
 	// RepoRoot is the absolute path to the root directory of the repository.
 	RepoRoot string
 
+	// RepoName is the name of the Bazel repository build files are generated
+	// for. It is empty for the main repository. Paths relative to the
+	// execution root, like those in cgo flags, are computed with this.
+	RepoName string
+
 	// ValidBuildFileNames is a list of base names that are considered valid
 	// build files. Some repositories may have files named "BUILD" that are not
 	// used by Bazel and should be ignored. Must contain at least one string.
 	ValidBuildFileNames []string
 
+	// SkipDirs is a set of directory base names that Gazelle does not descend
+	// into, for example, "node_modules". Directories beginning with "." or "_"
+	// are always skipped, as are directories beginning with "bazel-" in
+	// RepoRoot. May be nil.
+	SkipDirs map[string]bool
+
 	// GenericTags is a set of build constraints that are true on all platforms.
 	// It should not be nil.
 	GenericTags BuildTags
@@ -45,12 +59,115 @@ type Config struct {
 	// This is used to map imports to labels within the repository.
 	GoPrefix string
 
+	// PrefixDirective is true if GoPrefix is recorded with a "# gazelle:prefix"
+	// directive in the root build file instead of a go_prefix rule. When this
+	// is true, Gazelle does not generate a go_prefix rule; it sets importpath
+	// attributes on generated rules instead.
+	PrefixDirective bool
+
+	// Modules lists the Go modules in the repository, from a go.work file in
+	// the repository root, the -module_roots flag, or "# gazelle:module_root"
+	// directives in the root build file. Packages in a module's directory
+	// use its module path as their prefix instead of GoPrefix, and imports
+	// within any module are resolved to labels in the repository. When
+	// there are modules, importpath attributes are set on generated rules,
+	// since a single go_prefix can't describe them all. May be nil.
+	Modules []Module
+
+	// StdPackages is the set of import paths of the standard library packages
+	// of the Go SDK sources are built with. Imports of these packages don't
+	// need dependencies. If nil, imports whose first path element has no dot
+	// are assumed to be standard, unless they're in GoPrefix.
+	StdPackages map[string]bool
+
 	// DepMode determines how imports outside of GoPrefix are resolved.
 	DepMode DependencyMode
+
+	// MultiplePackageMode determines what Gazelle does in directories that
+	// contain .go files from more than one package.
+	MultiplePackageMode MultiplePackageMode
+
+	// ParseErrorMode determines what Gazelle does with source files that
+	// can't be parsed.
+	ParseErrorMode ParseErrorMode
+
+	// CaseCollisionMode determines what Gazelle does in directories that
+	// contain files or subdirectories whose names differ only by case.
+	CaseCollisionMode CaseCollisionMode
+
+	// ShouldFix determines whether Gazelle attempts to remove and replace
+	// usage of deprecated rules.
+	ShouldFix bool
+
+	// PkgConfigLabels maps names of pkg-config packages to labels of
+	// cc_library rules that provide them. When a cgo pkg-config directive
+	// names one of these packages, the label is added to cdeps. May be nil.
+	PkgConfigLabels map[string]string
+
+	// RunPkgConfig determines whether Gazelle runs pkg-config for packages
+	// not in PkgConfigLabels. If true, the flags pkg-config prints are added
+	// to copts and clinkopts. If false, directives naming such packages are
+	// errors.
+	RunPkgConfig bool
+
+	// MergeTests determines whether internal and external test sources in
+	// a directory are combined into one go_test that embeds the library,
+	// instead of separate go_default_test and go_default_xtest rules.
+	MergeTests bool
+
+	// LibraryAttr is true when build files are generated for a version of
+	// rules_go that doesn't support the embed attribute. Generated binaries
+	// and tests then refer to their library with the library attribute, and
+	// fix doesn't migrate library attributes to embed.
+	LibraryAttr bool
+
+	// Verbose determines whether Gazelle logs extra information, for example,
+	// files that are skipped because of build constraints.
+	Verbose bool
+
+	// Jobs is the maximum number of source files read concurrently. If it is
+	// not positive, GOMAXPROCS is used.
+	Jobs int
+
+	// CacheDir is a directory where Gazelle records the rules it generated for
+	// each build file. When set, the recorded rules are used as a baseline for
+	// three-way merges with existing files. If empty, merges are two-way.
+	CacheDir string
+}
+
+const (
+	// RulesGoDefBzlLabel is the label of the Skylark file which provides Go
+	// rules.
+	RulesGoDefBzlLabel = "@io_bazel_rules_go//go:def.bzl"
+
+	// DefaultLibName is the name of the default go_library rule in a Go
+	// package directory. It must be consistent to DEFAULT_LIB in
+	// go/private/common.bzl.
+	DefaultLibName = "go_default_library"
+
+	// DefaultCgoLibName is the name of the default cgo_library rule in a Go
+	// package directory. Gazelle no longer generates cgo_library rules, but
+	// "gazelle fix" looks for this name when migrating old files.
+	DefaultCgoLibName = "cgo_default_library"
+)
+
+// LoadableKinds is the list of rules that Gazelle may generate or fix which
+// are loaded from RulesGoDefBzlLabel. Keep sorted.
+var LoadableKinds = []string{
+	"cgo_library",
+	"go_binary",
+	"go_library",
+	"go_prefix",
+	"go_test",
 }
 
 var DefaultValidBuildFileNames = []string{"BUILD.bazel", "BUILD"}
 
+// DefaultSkipDirs is a list of directory names that are skipped by default.
+// These directories are large in many repositories and are unlikely to
+// contain Go packages that should be built with Bazel.
+var DefaultSkipDirs = []string{"node_modules", "bower_components"}
+
 func (c *Config) IsValidBuildFileName(name string) bool {
 	for _, n := range c.ValidBuildFileNames {
 		if name == n {
@@ -64,6 +181,96 @@ func (c *Config) DefaultBuildFileName() string {
 	return c.ValidBuildFileNames[0]
 }
 
+// Module is a Go module within the repository.
+type Module struct {
+	// Rel is the slash-separated path from the repository root to the
+	// directory containing the module's go.mod file. It is "" for a module
+	// in the repository root.
+	Rel string
+
+	// Path is the module path declared in go.mod.
+	Path string
+}
+
+// ParseModuleRoot parses a module root of the form prefix=dir, where dir is
+// a slash-separated path relative to the repository root. This is the form
+// used by the -module_roots flag and the "# gazelle:module_root" directive.
+func ParseModuleRoot(s string) (Module, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return Module{}, fmt.Errorf("module root %q is not of the form prefix=dir", s)
+	}
+	prefix, dir := s[:i], s[i+1:]
+	rel := path.Clean(dir)
+	if rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+		return Module{}, fmt.Errorf("module root %q: directory is outside the repository", s)
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return Module{Rel: rel, Path: strings.TrimSuffix(prefix, "/")}, nil
+}
+
+// ModuleForDir returns the innermost module containing the directory rel,
+// a slash-separated path relative to the repository root. The second
+// return value is false if rel is not in any module.
+func (c *Config) ModuleForDir(rel string) (Module, bool) {
+	var best Module
+	found := false
+	for _, m := range c.Modules {
+		if m.Rel != "" && rel != m.Rel && !strings.HasPrefix(rel, m.Rel+"/") {
+			continue
+		}
+		if !found || len(m.Rel) > len(best.Rel) {
+			best, found = m, true
+		}
+	}
+	return best, found
+}
+
+// ModuleForImportPath returns the module with the longest path that
+// contains importpath. The second return value is false if importpath is
+// not in any module.
+func (c *Config) ModuleForImportPath(importpath string) (Module, bool) {
+	var best Module
+	found := false
+	for _, m := range c.Modules {
+		if importpath != m.Path && !strings.HasPrefix(importpath, m.Path+"/") {
+			continue
+		}
+		if !found || len(m.Path) > len(best.Path) {
+			best, found = m, true
+		}
+	}
+	return best, found
+}
+
+// DefaultImportPath returns the import path of a Go rule named name in the
+// package rel, as the Go rules would compute it from the go_prefix rule.
+// This must be consistent with go_importpath in go/private/library.bzl.
+// In a module, the module path is used as the prefix instead.
+func (c *Config) DefaultImportPath(rel, name string) string {
+	prefix := c.GoPrefix
+	if m, ok := c.ModuleForDir(rel); ok {
+		prefix = m.Path
+		rel = strings.TrimPrefix(strings.TrimPrefix(rel, m.Rel), "/")
+	}
+	p := strings.TrimSuffix(prefix, "/")
+	if rel != "" {
+		p += "/" + rel
+	}
+	if name != DefaultLibName {
+		p += "/" + name
+	}
+	if i := strings.LastIndex(p, vendorPrefix); i != -1 {
+		p = p[i+len(vendorPrefix):]
+	}
+	return strings.TrimPrefix(p, "/")
+}
+
+// vendorPrefix must be consistent with VENDOR_PREFIX in go/private/common.bzl.
+const vendorPrefix = "/vendor/"
+
 // BuildTags is a set of build constraints.
 type BuildTags map[string]bool
 
@@ -98,6 +305,79 @@ func (c *Config) PreprocessTags() {
 	}
 }
 
+// ReleaseTags returns the release tags that are satisfied by the given
+// version of Go, for example, "go1.1" through "go1.8" for "1.8.3". The
+// version may have a "go" prefix, as in the VERSION file of a Go
+// distribution. These tags may be added to GenericTags so that release tags
+// are evaluated like other tags. If no release tags are set, Gazelle treats
+// them as unknown and considers them satisfied.
+func ReleaseTags(version string) ([]string, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(version), "go")
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 || parts[0] != "1" {
+		return nil, fmt.Errorf("invalid Go version: %q", version)
+	}
+	// Allow pre-release suffixes like "1.9beta1".
+	minorStr := parts[1]
+	if i := strings.IndexFunc(minorStr, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minorStr = minorStr[:i]
+	}
+	minor, err := strconv.Atoi(minorStr)
+	if err != nil || minor < 1 {
+		return nil, fmt.Errorf("invalid Go version: %q", version)
+	}
+	tags := make([]string, minor)
+	for i := range tags {
+		tags[i] = fmt.Sprintf("go1.%d", i+1)
+	}
+	return tags, nil
+}
+
+// RulesGoSupportsEmbed reports whether version of rules_go, for example,
+// "0.5.0", supports the embed attribute of go_library, go_binary, and
+// go_test. Releases up to 0.5.0 only support the library attribute.
+func RulesGoSupportsEmbed(version string) (bool, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return false, fmt.Errorf("invalid rules_go version: %q", version)
+	}
+	nums := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return false, fmt.Errorf("invalid rules_go version: %q", version)
+		}
+		nums[i] = n
+	}
+	lastWithoutEmbed := []int{0, 5, 0}
+	for i := range nums {
+		if nums[i] != lastWithoutEmbed[i] {
+			return nums[i] > lastWithoutEmbed[i], nil
+		}
+	}
+	return false, nil
+}
+
+// ParseStdPackages returns the set of standard library packages in list,
+// which has an import path on each line, like the std_packages.txt file of
+// @io_bazel_rules_go_toolchain. An empty list means the packages aren't
+// known, so nil is returned.
+func ParseStdPackages(list string) map[string]bool {
+	var packages map[string]bool
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if packages == nil {
+			packages = make(map[string]bool)
+		}
+		packages[line] = true
+	}
+	return packages
+}
+
 // DependencyMode determines how imports of packages outside of the prefix
 // are resolved.
 type DependencyMode int
@@ -112,6 +392,105 @@ const (
 	VendorMode
 )
 
+// MultiplePackageMode determines what Gazelle does in a directory containing
+// .go files from more than one package. External test packages (with the
+// suffix "_test") are not counted separately.
+type MultiplePackageMode int
+
+const (
+	// PickDefaultPackage indicates Gazelle should generate rules for the
+	// package whose name matches the directory name and ignore the others.
+	// If no package matches, an error is reported, and no rules are generated.
+	PickDefaultPackage MultiplePackageMode = iota
+
+	// ErrorOnMultiplePackages indicates Gazelle should report an error listing
+	// the files in each package, and no rules should be generated.
+	ErrorOnMultiplePackages
+
+	// SplitPackages indicates Gazelle should generate rules for every package.
+	// The package whose name matches the directory name gets the default rule
+	// names. The rules of the others are named after their packages, for
+	// example, "foo_library", "foo_test", and for package main, a go_binary
+	// named "main".
+	SplitPackages
+)
+
+// MultiplePackageModeFromString converts a string from the command line to
+// a MultiplePackageMode. Valid strings are "pick", "error", and "split". An
+// error will be returned for an invalid string.
+func MultiplePackageModeFromString(s string) (MultiplePackageMode, error) {
+	switch s {
+	case "pick":
+		return PickDefaultPackage, nil
+	case "error":
+		return ErrorOnMultiplePackages, nil
+	case "split":
+		return SplitPackages, nil
+	default:
+		return 0, fmt.Errorf("unrecognized multiple package mode: %q", s)
+	}
+}
+
+// ParseErrorMode determines what Gazelle does when a source file in
+// a package can't be parsed. In all modes, errors are logged, and other
+// packages are still generated.
+type ParseErrorMode int
+
+const (
+	// SkipFilesWithErrors indicates Gazelle should leave files that can't be
+	// parsed out of the generated rules and use the other files in the
+	// package.
+	SkipFilesWithErrors ParseErrorMode = iota
+
+	// SkipPackagesWithErrors indicates Gazelle should not generate rules for
+	// a package if any of its source files can't be parsed.
+	SkipPackagesWithErrors
+)
+
+// ParseErrorModeFromString converts a string from the command line to
+// a ParseErrorMode. Valid strings are "file" and "package". An error will be
+// returned for an invalid string.
+func ParseErrorModeFromString(s string) (ParseErrorMode, error) {
+	switch s {
+	case "file":
+		return SkipFilesWithErrors, nil
+	case "package":
+		return SkipPackagesWithErrors, nil
+	default:
+		return 0, fmt.Errorf("unrecognized parse error mode: %q", s)
+	}
+}
+
+// CaseCollisionMode determines what Gazelle does when names of files or
+// subdirectories in a directory differ only by case. Such names can't be
+// checked out together on case-insensitive file systems, as are common on
+// macOS and Windows. In all modes, a warning is logged.
+type CaseCollisionMode int
+
+const (
+	// WarnOnCaseCollisions indicates Gazelle should generate rules for the
+	// package in the directory as usual.
+	WarnOnCaseCollisions CaseCollisionMode = iota
+
+	// SkipCaseCollisions indicates Gazelle should not generate rules for the
+	// package in the directory. Subdirectories are still visited.
+	SkipCaseCollisions
+)
+
+// CaseCollisionModeFromString converts a string from the command line to
+// a CaseCollisionMode. Valid strings are "warn" and "skip". An error will be
+// returned for an invalid string.
+func CaseCollisionModeFromString(s string) (CaseCollisionMode, error) {
+	switch s {
+	case "warn":
+		return WarnOnCaseCollisions, nil
+	case "skip":
+		return SkipCaseCollisions, nil
+	default:
+		return 0, fmt.Errorf("unrecognized case collision mode: %q", s)
+	}
+}
+
 // DependencyModeFromString converts a string from the command line
 // to a DependencyMode. Valid strings are "external", "vendor". An error will
 // be returned for an invalid string.
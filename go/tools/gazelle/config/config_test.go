@@ -15,7 +15,10 @@ limitations under the License.
 
 package config
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestPreprocessTags(t *testing.T) {
 	c := &Config{
@@ -46,3 +49,183 @@ func TestPreprocessTags(t *testing.T) {
 		}
 	}
 }
+
+func TestDefaultImportPath(t *testing.T) {
+	for _, tc := range []struct {
+		desc, prefix, rel, name, want string
+	}{
+		{
+			desc:   "root library",
+			prefix: "example.com/repo",
+			name:   DefaultLibName,
+			want:   "example.com/repo",
+		}, {
+			desc:   "sub library",
+			prefix: "example.com/repo/",
+			rel:    "foo/bar",
+			name:   DefaultLibName,
+			want:   "example.com/repo/foo/bar",
+		}, {
+			desc:   "non-default name",
+			prefix: "example.com/repo",
+			rel:    "foo",
+			name:   "go_default_test",
+			want:   "example.com/repo/foo/go_default_test",
+		}, {
+			desc:   "vendor",
+			prefix: "example.com/repo",
+			rel:    "vendor/golang.org/x/net/context",
+			name:   DefaultLibName,
+			want:   "golang.org/x/net/context",
+		}, {
+			desc: "empty prefix",
+			rel:  "foo",
+			name: DefaultLibName,
+			want: "foo",
+		},
+	} {
+		c := &Config{GoPrefix: tc.prefix}
+		if got := c.DefaultImportPath(tc.rel, tc.name); got != tc.want {
+			t.Errorf("%s: got %q; want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	c := &Config{
+		GoPrefix: "example.com/repo",
+		Modules: []Module{
+			{Rel: "", Path: "example.com/repo"},
+			{Rel: "tools", Path: "example.com/tools"},
+			{Rel: "tools/nested", Path: "example.com/nested"},
+			{Rel: "api", Path: "example.com/repo/api"},
+		},
+	}
+	for _, tc := range []struct {
+		rel, name, want string
+	}{
+		{"", DefaultLibName, "example.com/repo"},
+		{"lib", DefaultLibName, "example.com/repo/lib"},
+		{"tools", DefaultLibName, "example.com/tools"},
+		{"tools/cmd", "go_default_test", "example.com/tools/cmd/go_default_test"},
+		{"tools/nested/x", DefaultLibName, "example.com/nested/x"},
+		{"toolsx", DefaultLibName, "example.com/repo/toolsx"},
+		{"tools/vendor/golang.org/x/net", DefaultLibName, "golang.org/x/net"},
+	} {
+		if got := c.DefaultImportPath(tc.rel, tc.name); got != tc.want {
+			t.Errorf("DefaultImportPath(%q, %q): got %q; want %q", tc.rel, tc.name, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		importpath string
+		wantRel    string
+		wantOk     bool
+	}{
+		{"example.com/repo/lib", "", true},
+		{"example.com/repo/api/v1", "api", true},
+		{"example.com/tools", "tools", true},
+		{"example.com/toolsx", "", false},
+		{"github.com/foo/bar", "", false},
+	} {
+		m, ok := c.ModuleForImportPath(tc.importpath)
+		if ok != tc.wantOk || m.Rel != tc.wantRel {
+			t.Errorf("ModuleForImportPath(%q): got %q, %v; want %q, %v", tc.importpath, m.Rel, ok, tc.wantRel, tc.wantOk)
+		}
+	}
+}
+
+func TestParseModuleRoot(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want Module
+	}{
+		{"example.com/tools=tools", Module{Rel: "tools", Path: "example.com/tools"}},
+		{"example.com/api/=./services/api/", Module{Rel: "services/api", Path: "example.com/api"}},
+		{"example.com/repo=.", Module{Rel: "", Path: "example.com/repo"}},
+	} {
+		got, err := ParseModuleRoot(tc.s)
+		if err != nil {
+			t.Errorf("%s: %v", tc.s, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %#v; want %#v", tc.s, got, tc.want)
+		}
+	}
+
+	for _, s := range []string{"", "example.com/tools", "=tools", "example.com/tools=", "example.com/x=../x", "example.com/x=/x"} {
+		if _, err := ParseModuleRoot(s); err == nil {
+			t.Errorf("%s: got success; want error", s)
+		}
+	}
+}
+
+func TestReleaseTags(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		want    []string
+	}{
+		{"1.1", []string{"go1.1"}},
+		{"1.8.3", []string{"go1.1", "go1.2", "go1.3", "go1.4", "go1.5", "go1.6", "go1.7", "go1.8"}},
+		{"go1.3\n", []string{"go1.1", "go1.2", "go1.3"}},
+		{"1.2rc1", []string{"go1.1", "go1.2"}},
+	} {
+		got, err := ReleaseTags(tc.version)
+		if err != nil {
+			t.Errorf("%q: %v", tc.version, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %q; want %q", tc.version, got, tc.want)
+		}
+	}
+
+	for _, version := range []string{"", "1", "2.0", "1.x", "1.0", "devel +abc"} {
+		if _, err := ReleaseTags(version); err == nil {
+			t.Errorf("%q: got success; want error", version)
+		}
+	}
+}
+
+func TestRulesGoSupportsEmbed(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		want    bool
+	}{
+		{"0.4.4", false},
+		{"0.5", false},
+		{"0.5.0", false},
+		{"v0.5.0", false},
+		{"0.5.1", true},
+		{"0.6.0\n", true},
+		{"1", true},
+	} {
+		got, err := RulesGoSupportsEmbed(tc.version)
+		if err != nil {
+			t.Errorf("%q: %v", tc.version, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %v; want %v", tc.version, got, tc.want)
+		}
+	}
+
+	for _, version := range []string{"", "master", "0.x", "0.5.0.1", "0.-1"} {
+		if _, err := RulesGoSupportsEmbed(version); err == nil {
+			t.Errorf("%q: got success; want error", version)
+		}
+	}
+}
+
+func TestParseStdPackages(t *testing.T) {
+	got := ParseStdPackages("fmt\nencoding/json\r\n\nnet/http\n")
+	want := map[string]bool{"fmt": true, "encoding/json": true, "net/http": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	if got := ParseStdPackages("\n"); got != nil {
+		t.Errorf("empty list: got %v; want nil", got)
+	}
+}
@@ -0,0 +1,83 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"regexp"
+
+	bf "github.com/bazelbuild/buildtools/build"
+)
+
+// Directive is a key-value pair extracted from a top-level comment in
+// a build file. Directives have the form "# gazelle:key value".
+type Directive struct {
+	Key, Value string
+}
+
+// knownTopLevelDirectives is the set of directive keys Gazelle recognizes.
+// Top-level directives apply to the whole build file.
+var knownTopLevelDirectives = map[string]bool{
+	"exclude_generated": true,
+	"ignore":            true,
+	"module_root":       true,
+	"prefix":            true,
+}
+
+var directiveRe = regexp.MustCompile(`^#\s*gazelle:(\w+)\s*(.*?)\s*$`)
+
+// ParseDirectives scans the top-level comments in f for Gazelle directives
+// and returns them in the order they appear. Unrecognized directives are
+// ignored.
+func ParseDirectives(f *bf.File) []Directive {
+	var directives []Directive
+	parseComment := func(com bf.Comment) {
+		if d, ok := ParseDirective(com); ok {
+			directives = append(directives, d)
+		}
+	}
+
+	for _, s := range f.Stmt {
+		coms := s.Comment()
+		for _, com := range coms.Before {
+			parseComment(com)
+		}
+		for _, com := range coms.After {
+			parseComment(com)
+		}
+	}
+	return directives
+}
+
+// ParseDirective returns the directive in the comment com. The second
+// return value is false if com is not a directive Gazelle recognizes.
+func ParseDirective(com bf.Comment) (Directive, bool) {
+	match := directiveRe.FindStringSubmatch(com.Token)
+	if match == nil {
+		return Directive{}, false
+	}
+	key, value := match[1], match[2]
+	if !knownTopLevelDirectives[key] {
+		return Directive{}, false
+	}
+	return Directive{key, value}, true
+}
+
+// PrefixDirectiveComment returns a comment which records prefix as the Go
+// prefix for the repository. It may be placed at the top of the root
+// build file.
+func PrefixDirectiveComment(prefix string) bf.Comment {
+	return bf.Comment{Token: "# gazelle:prefix " + prefix}
+}
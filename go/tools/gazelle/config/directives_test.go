@@ -0,0 +1,61 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	bf "github.com/bazelbuild/buildtools/build"
+)
+
+func TestParseDirectives(t *testing.T) {
+	for _, tc := range []struct {
+		desc, content string
+		want          []Directive
+	}{
+		{
+			desc: "empty file",
+		}, {
+			desc: "prefix before load",
+			content: `# gazelle:prefix example.com/repo
+
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+`,
+			want: []Directive{{"prefix", "example.com/repo"}},
+		}, {
+			desc: "comment block",
+			content: `# gazelle:ignore
+
+# gazelle:prefix example.com/repo
+`,
+			want: []Directive{{"ignore", ""}, {"prefix", "example.com/repo"}},
+		}, {
+			desc: "unknown directive and ordinary comment",
+			content: `# gazelle:foo bar
+# not a directive
+`,
+		},
+	} {
+		f, err := bf.Parse(tc.desc, []byte(tc.content))
+		if err != nil {
+			t.Fatalf("%s: parse error: %v", tc.desc, err)
+		}
+		if got := ParseDirectives(f); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v; want %#v", tc.desc, got, tc.want)
+		}
+	}
+}
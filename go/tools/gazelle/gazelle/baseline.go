@@ -0,0 +1,66 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	bf "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+// baselineFileName is the name of the files in the cache directory that
+// record the rules Gazelle generated for each directory.
+const baselineFileName = "BUILD.baseline"
+
+// baselinePath returns the path where the rules generated for the directory
+// rel are recorded.
+func baselinePath(c *config.Config, rel string) string {
+	return filepath.Join(c.CacheDir, filepath.FromSlash(rel), baselineFileName)
+}
+
+// loadBaseline reads the rules Gazelle generated for the directory rel the
+// last time it ran. nil is returned if no cache directory is configured
+// or nothing was recorded for rel.
+func loadBaseline(c *config.Config, rel string) (*bf.File, error) {
+	if c.CacheDir == "" {
+		return nil, nil
+	}
+	path := baselinePath(c, rel)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bf.Parse(path, data)
+}
+
+// saveBaseline records the formatted content of the file generated for the
+// directory rel, so it can be used as a baseline the next time Gazelle runs.
+func saveBaseline(c *config.Config, rel string, content []byte) error {
+	if c.CacheDir == "" {
+		return nil
+	}
+	path := baselinePath(c, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
@@ -0,0 +1,81 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBaselineKeepsUserDeletions(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repoDir := filepath.Join(dir, "repo")
+	cacheDir := filepath.Join(dir, "cache")
+	if err := os.Mkdir(repoDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bar.go", "foo.go"} {
+		path := filepath.Join(repoDir, name)
+		if err := ioutil.WriteFile(path, []byte("package lib\n"), 0600); err != nil {
+			t.Fatalf("error writing file %q: %v", path, err)
+		}
+	}
+
+	c := defaultConfig(repoDir)
+	c.CacheDir = cacheDir
+	run(c, fixFile)
+
+	if _, err := os.Stat(filepath.Join(cacheDir, baselineFileName)); err != nil {
+		t.Fatalf("baseline not recorded: %v", err)
+	}
+
+	// Remove foo.go from srcs by hand. Gazelle should not add it back, since
+	// the baseline shows it was generated before.
+	buildPath := filepath.Join(repoDir, "BUILD.bazel")
+	data, err := ioutil.ReadFile(buildPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(string(data), `        "foo.go",`+"\n", "", 1)
+	if edited == string(data) {
+		t.Fatalf("foo.go not found in generated file:\n%s", data)
+	}
+	if err := ioutil.WriteFile(buildPath, []byte(edited), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	run(c, fixFile)
+
+	data, err = ioutil.ReadFile(buildPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"foo.go"`) {
+		t.Errorf("foo.go was added back after the user removed it:\n%s", data)
+	}
+	if !strings.Contains(string(data), `"bar.go"`) {
+		t.Errorf("bar.go missing:\n%s", data)
+	}
+}
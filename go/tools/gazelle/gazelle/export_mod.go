@@ -0,0 +1,276 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	bf "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
+)
+
+// goRepo is a revision of an external repository pinned by a go_repository
+// rule.
+type goRepo struct {
+	importpath, version string
+}
+
+// semverRe matches semantic versions with a "v" prefix, the only versions
+// go.mod files may require. Pre-release and build suffixes are allowed.
+var semverRe = regexp.MustCompile(`^v(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+type byImportPath []goRepo
+
+func (rs byImportPath) Len() int           { return len(rs) }
+func (rs byImportPath) Less(i, j int) bool { return rs[i].importpath < rs[j].importpath }
+func (rs byImportPath) Swap(i, j int)      { rs[i], rs[j] = rs[j], rs[i] }
+
+// exportMod implements the export-mod command. It writes a go.mod file
+// requiring the repositories pinned in WORKSPACE, and optionally a go.sum
+// file.
+func exportMod(args []string) error {
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	// Flag will call this on any parse error. Don't print usage unless
+	// -h or -help were passed explicitly.
+	fs.Usage = func() {}
+
+	repoRoot := fs.String("repo_root", "", "path to the directory containing the WORKSPACE file, otherwise gazelle searches for it.")
+	goPrefix := fs.String("go_prefix", "", "module path to declare. If not set, it's found as it is for update.")
+	mode := fs.String("mode", "fix", "print: prints go.mod (and go.sum) instead of writing them\n\tfix: writes go.mod (and go.sum) in the repository root")
+	goSum := fs.Bool("go_sum", false, "also write go.sum, keeping entries from the existing go.sum for the required versions")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			exportModUsage(fs)
+			os.Exit(0)
+		}
+		// flag already prints the error; don't print it again.
+		log.Fatal("Try -help for more information.")
+	}
+	if fs.NArg() != 0 {
+		return errors.New("export-mod takes no arguments. Try -help for more information.")
+	}
+	if *mode != "fix" && *mode != "print" {
+		return fmt.Errorf("unrecognized emit mode: %q", *mode)
+	}
+
+	c := &config.Config{
+		RepoRoot:            *repoRoot,
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+	}
+	if c.RepoRoot == "" {
+		cwd, err := filepath.Abs(".")
+		if err != nil {
+			return err
+		}
+		c.RepoRoot, err = wspace.Find(cwd)
+		if err != nil {
+			return fmt.Errorf("-repo_root not specified, and WORKSPACE cannot be found: %v", err)
+		}
+	}
+
+	c.GoPrefix = *goPrefix
+	if c.GoPrefix == "" {
+		rootFile, err := loadRootBuildFile(c)
+		if err != nil {
+			return err
+		}
+		if c.GoPrefix, _, err = loadGoPrefix(rootFile); err != nil {
+			return err
+		}
+	}
+	if c.GoPrefix == "" {
+		var err error
+		if c.GoPrefix, err = detectGoPrefix(c); err != nil {
+			return err
+		}
+	}
+	if c.GoPrefix == "" {
+		return errors.New("-go_prefix not set, and the prefix could not be found in the root build file, go.mod, or import comments")
+	}
+
+	path := filepath.Join(c.RepoRoot, workspaceFileName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := bf.Parse(path, data)
+	if err != nil {
+		return err
+	}
+	repos := reposFromWorkspace(c, f)
+
+	outputs := []struct {
+		path string
+		data []byte
+	}{{filepath.Join(c.RepoRoot, "go.mod"), formatGoMod(c.GoPrefix, repos)}}
+	if *goSum {
+		sumPath := filepath.Join(c.RepoRoot, "go.sum")
+		oldSum, err := ioutil.ReadFile(sumPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		sum, missing := filterGoSum(oldSum, repos)
+		for _, r := range missing {
+			log.Printf("go.sum: no entry for %s %s; run \"go mod download\" to add it", r.importpath, r.version)
+		}
+		outputs = append(outputs, struct {
+			path string
+			data []byte
+		}{sumPath, sum})
+	}
+
+	for i, o := range outputs {
+		if *mode == "print" {
+			// When both files are printed, label each one, so they can be told
+			// apart.
+			if len(outputs) > 1 {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("==> %s <==\n", filepath.Base(o.path))
+			}
+			if _, err := os.Stdout.Write(o.data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(o.path, o.data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reposFromWorkspace returns the repositories pinned by go_repository rules
+// in the WORKSPACE file f, sorted by import path. Import paths within the
+// prefix are left out, since they're part of this module. Rules that aren't
+// pinned to a semantic version tag are logged and skipped: go.mod can only
+// require commits by pseudo-version, which needs the commit time, and rules
+// without a commit or tag, like those that download archives, have no
+// version at all.
+func reposFromWorkspace(c *config.Config, f *bf.File) []goRepo {
+	var repos []goRepo
+	for _, s := range f.Stmt {
+		call, ok := s.(*bf.CallExpr)
+		if !ok {
+			continue
+		}
+		r := bf.Rule{Call: call}
+		if r.Kind() != "go_repository" {
+			continue
+		}
+		importpath := r.AttrString("importpath")
+		if importpath == "" || importpath == c.GoPrefix || strings.HasPrefix(importpath, c.GoPrefix+"/") {
+			continue
+		}
+		version := r.AttrString("tag")
+		switch {
+		case version != "" && !semverRe.MatchString(version):
+			log.Printf("%s: go_repository %q has tag %q, which is not a semantic version; not adding it to go.mod", f.Path, r.Name(), version)
+			continue
+		case version == "" && r.AttrString("commit") != "":
+			log.Printf("%s: go_repository %q is pinned to a commit, which go.mod can only require by pseudo-version; not adding it to go.mod", f.Path, r.Name())
+			continue
+		case version == "":
+			log.Printf("%s: go_repository %q has no commit or tag; not adding it to go.mod", f.Path, r.Name())
+			continue
+		}
+		repos = append(repos, goRepo{importpath: importpath, version: version})
+	}
+	sort.Sort(byImportPath(repos))
+	return repos
+}
+
+// formatGoMod returns the content of a go.mod file declaring the module
+// modulePath, which requires repos. Versions are written as they appear in
+// WORKSPACE.
+func formatGoMod(modulePath string, repos []goRepo) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "// Generated by gazelle export-mod from go_repository rules in WORKSPACE.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintf(&buf, "module %s\n", modulePath)
+	if len(repos) == 0 {
+		return buf.Bytes()
+	}
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "require (")
+	for _, r := range repos {
+		fmt.Fprintf(&buf, "\t%s %s\n", r.importpath, r.version)
+	}
+	fmt.Fprintln(&buf, ")")
+	return buf.Bytes()
+}
+
+// filterGoSum returns the lines of the go.sum file oldSum that record
+// hashes for the versions in repos. Gazelle can't compute hashes itself, so
+// repos with no entries are returned as missing.
+func filterGoSum(oldSum []byte, repos []goRepo) ([]byte, []goRepo) {
+	want := make(map[string]bool)
+	for _, r := range repos {
+		want[r.importpath+" "+r.version] = true
+	}
+	found := make(map[string]bool)
+	var buf bytes.Buffer
+	for _, line := range strings.Split(string(oldSum), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		key := fields[0] + " " + strings.TrimSuffix(fields[1], "/go.mod")
+		if want[key] {
+			found[key] = true
+			fmt.Fprintln(&buf, strings.Join(fields, " "))
+		}
+	}
+	var missing []goRepo
+	for _, r := range repos {
+		if !found[r.importpath+" "+r.version] {
+			missing = append(missing, r)
+		}
+	}
+	return buf.Bytes(), missing
+}
+
+func exportModUsage(fs *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, `usage: gazelle export-mod [flags...]
+
+export-mod writes a go.mod file in the repository root declaring the
+module at the Go prefix. It requires each repository pinned by
+a go_repository rule in WORKSPACE, at the tag given there, so tools that
+don't use Bazel see the same versions. Rules pinned to commits or to tags
+that aren't semantic versions are skipped with a warning, since go.mod can
+only require them by pseudo-version, which Gazelle can't compute.
+
+With -go_sum, go.sum is written as well. Gazelle can't compute module
+hashes, so only entries from the existing go.sum for the required versions
+are kept. Missing entries are reported. With -mode print, each file is
+preceded by a "==> name <==" line when both are printed.
+
+FLAGS:
+`)
+	fs.PrintDefaults()
+}
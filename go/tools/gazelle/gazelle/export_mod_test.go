@@ -0,0 +1,119 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	bf "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+func TestReposFromWorkspace(t *testing.T) {
+	f, err := bf.Parse("WORKSPACE", []byte(`
+go_repository(
+    name = "com_github_foo_bar",
+    importpath = "github.com/foo/bar",
+    tag = "v1.2.0",
+)
+
+go_repository(
+    name = "com_github_foo_commit",
+    commit = "0123456789abcdef0123456789abcdef01234567",
+    importpath = "github.com/foo/commit",
+)
+
+go_repository(
+    name = "com_github_foo_release",
+    importpath = "github.com/foo/release",
+    tag = "release-1.0",
+)
+
+go_repository(
+    name = "com_github_foo_archive",
+    importpath = "github.com/foo/archive",
+    urls = ["https://example.com/archive.zip"],
+)
+
+go_repository(
+    name = "com_example_repo_sub",
+    importpath = "example.com/repo/sub",
+    tag = "v1.0.0",
+)
+
+go_repository(
+    name = "org_golang_x_tools",
+    importpath = "golang.org/x/tools",
+    tag = "v0.1.0-rc.1",
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &config.Config{GoPrefix: "example.com/repo"}
+	got := reposFromWorkspace(c, f)
+	want := []goRepo{
+		{importpath: "github.com/foo/bar", version: "v1.2.0"},
+		{importpath: "golang.org/x/tools", version: "v0.1.0-rc.1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestFormatGoMod(t *testing.T) {
+	repos := []goRepo{
+		{importpath: "github.com/foo/bar", version: "v1.2.0"},
+		{importpath: "golang.org/x/tools", version: "v0.1.0-rc.1"},
+	}
+	got := string(formatGoMod("example.com/repo", repos))
+	want := `// Generated by gazelle export-mod from go_repository rules in WORKSPACE.
+
+module example.com/repo
+
+require (
+	github.com/foo/bar v1.2.0
+	golang.org/x/tools v0.1.0-rc.1
+)
+`
+	if got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestFilterGoSum(t *testing.T) {
+	oldSum := []byte(`github.com/foo/bar v1.1.0 h1:old=
+github.com/foo/bar v1.1.0/go.mod h1:oldmod=
+github.com/foo/bar v1.2.0 h1:new=
+github.com/foo/bar v1.2.0/go.mod h1:newmod=
+github.com/unused/dep v1.0.0 h1:unused=
+`)
+	repos := []goRepo{
+		{importpath: "github.com/foo/bar", version: "v1.2.0"},
+		{importpath: "golang.org/x/tools", version: "v0.1.0-rc.1"},
+	}
+	sum, missing := filterGoSum(oldSum, repos)
+	wantSum := `github.com/foo/bar v1.2.0 h1:new=
+github.com/foo/bar v1.2.0/go.mod h1:newmod=
+`
+	if string(sum) != wantSum {
+		t.Errorf("got %s; want %s", sum, wantSum)
+	}
+	if wantMissing := repos[1:]; !reflect.DeepEqual(missing, wantMissing) {
+		t.Errorf("got missing %v; want %v", missing, wantMissing)
+	}
+}
@@ -0,0 +1,260 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	bf "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+// importRewrite replaces the import path from, and import paths of packages
+// under it, with to.
+type importRewrite struct {
+	from, to string
+}
+
+// rewrite returns the new import path for imp and true if imp is from or
+// a path under from. Otherwise, it returns imp and false.
+func (r importRewrite) rewrite(imp string) (string, bool) {
+	if imp == r.from {
+		return r.to, true
+	}
+	if strings.HasPrefix(imp, r.from+"/") {
+		return r.to + imp[len(r.from):], true
+	}
+	return imp, false
+}
+
+// fixImports implements the fix-imports command. Import paths in .go files
+// are rewritten, then build files are updated as they are by the update
+// command.
+func fixImports(args []string) error {
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	from := fs.String("from", "", "import path to replace. Paths of packages under it are replaced too.")
+	to := fs.String("to", "", "import path to replace -from with")
+	c, emit, err := newConfiguration(fs, args)
+	if err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return errors.New("fix-imports: -from and -to must both be set")
+	}
+	// Go files are always rewritten in place, so build files must be too.
+	if m := fs.Lookup("mode").Value.String(); m != "fix" {
+		return fmt.Errorf("fix-imports: -mode=%s is not supported; only fix is", m)
+	}
+	r := importRewrite{
+		from: strings.TrimSuffix(*from, "/"),
+		to:   strings.TrimSuffix(*to, "/"),
+	}
+
+	for _, dir := range c.Dirs {
+		if err := rewriteGoFiles(c, dir, r); err != nil {
+			return err
+		}
+	}
+	if err := rewritePrefix(c, r); err != nil {
+		return err
+	}
+	run(c, emit)
+	return nil
+}
+
+// rewriteGoFiles rewrites imports and canonical import comments in .go
+// files in dir and its subdirectories. Directories Gazelle doesn't visit,
+// including vendor and testdata directories, are skipped. Files that fail
+// to parse are logged and left alone.
+func rewriteGoFiles(c *config.Config, dir string, r importRewrite) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		base := info.Name()
+		if info.IsDir() {
+			if p != dir && (strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") || base == "vendor" || base == "testdata" || c.SkipDirs[base]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(base, ".go") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		newData, err := rewriteGoFile(p, data, r)
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+		if newData == nil {
+			return nil
+		}
+		return ioutil.WriteFile(p, newData, info.Mode())
+	})
+}
+
+// rewriteGoFile returns the content of a .go file with imports rewritten
+// by r. Only the quoted paths are changed; the rest of the file, including
+// formatting and comments, is kept. nil is returned if nothing changed.
+func rewriteGoFile(path string, data []byte, r importRewrite) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, data, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	// Canonical import comments are on the same line as the package clause,
+	// so they come before imports. Edits must be in order.
+	line := fset.Position(f.Name.End()).Line
+	for _, g := range f.Comments {
+		for _, cm := range g.List {
+			if fset.Position(cm.Pos()).Line != line || cm.Pos() < f.Name.End() {
+				continue
+			}
+			i := strings.Index(cm.Text, `import "`)
+			if i < 0 {
+				continue
+			}
+			start := i + len("import ")
+			end := strings.Index(cm.Text[start+1:], `"`)
+			if end < 0 {
+				continue
+			}
+			end += start + 2
+			imp, err := strconv.Unquote(cm.Text[start:end])
+			if err != nil {
+				continue
+			}
+			if newImp, ok := r.rewrite(imp); ok {
+				offset := fset.Position(cm.Pos()).Offset
+				edits = append(edits, edit{
+					start: offset + start,
+					end:   offset + end,
+					text:  strconv.Quote(newImp),
+				})
+			}
+		}
+	}
+
+	for _, spec := range f.Imports {
+		imp, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		if newImp, ok := r.rewrite(imp); ok {
+			edits = append(edits, edit{
+				start: fset.Position(spec.Path.Pos()).Offset,
+				end:   fset.Position(spec.Path.End()).Offset,
+				text:  strconv.Quote(newImp),
+			})
+		}
+	}
+
+	if len(edits) == 0 {
+		return nil, nil
+	}
+	var buf []byte
+	last := 0
+	for _, e := range edits {
+		buf = append(buf, data[last:e.start]...)
+		buf = append(buf, e.text...)
+		last = e.end
+	}
+	buf = append(buf, data[last:]...)
+	return buf, nil
+}
+
+// rewritePrefix applies r to the Go prefix and module roots in c. If the
+// root build file records the prefix with a directive or go_prefix rule, or
+// has module_root directives, it's rewritten too.
+func rewritePrefix(c *config.Config, r importRewrite) error {
+	c.GoPrefix, _ = r.rewrite(c.GoPrefix)
+	for i := range c.Modules {
+		c.Modules[i].Path, _ = r.rewrite(c.Modules[i].Path)
+	}
+
+	f, err := loadRootBuildFile(c)
+	if err != nil || f == nil {
+		return err
+	}
+	changed := false
+	rewriteComments := func(coms []bf.Comment) {
+		for i, com := range coms {
+			d, ok := config.ParseDirective(com)
+			if !ok {
+				continue
+			}
+			value := d.Value
+			switch d.Key {
+			case "prefix":
+				if newValue, ok := r.rewrite(value); ok {
+					coms[i].Token = "# gazelle:prefix " + newValue
+					changed = true
+				}
+			case "module_root":
+				m, err := config.ParseModuleRoot(value)
+				if err != nil {
+					continue
+				}
+				if newPath, ok := r.rewrite(m.Path); ok {
+					coms[i].Token = "# gazelle:module_root " + newPath + value[strings.Index(value, "="):]
+					changed = true
+				}
+			}
+		}
+	}
+	for _, s := range f.Stmt {
+		coms := s.Comment()
+		rewriteComments(coms.Before)
+		rewriteComments(coms.After)
+		call, ok := s.(*bf.CallExpr)
+		if !ok || len(call.List) != 1 {
+			continue
+		}
+		if l, ok := call.X.(*bf.LiteralExpr); !ok || l.Token != "go_prefix" {
+			continue
+		}
+		if v, ok := call.List[0].(*bf.StringExpr); ok {
+			if newValue, ok := r.rewrite(v.Value); ok {
+				v.Value = newValue
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return fixFile(c, f)
+}
@@ -0,0 +1,80 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRewriteGoFile(t *testing.T) {
+	r := importRewrite{from: "example.com/old", to: "example.com/new/repo"}
+	for _, tc := range []struct {
+		desc, src, want string
+	}{
+		{
+			desc: "imports",
+			src: `package foo // import "example.com/old/foo"
+
+import (
+	"fmt"
+
+	old "example.com/old"
+	"example.com/old/bar" // comment
+	_ "example.com/older"
+)
+
+var _ = "example.com/old/baz"
+`,
+			want: `package foo // import "example.com/new/repo/foo"
+
+import (
+	"fmt"
+
+	old "example.com/new/repo"
+	"example.com/new/repo/bar" // comment
+	_ "example.com/older"
+)
+
+var _ = "example.com/old/baz"
+`,
+		}, {
+			desc: "single import",
+			src: `package foo
+
+import "example.com/old/bar"
+`,
+			want: `package foo
+
+import "example.com/new/repo/bar"
+`,
+		}, {
+			desc: "unchanged",
+			src: `package foo
+
+import "example.com/other"
+`,
+		},
+	} {
+		got, err := rewriteGoFile(tc.desc+".go", []byte(tc.src), r)
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: got %s; want %s", tc.desc, got, tc.want)
+		}
+	}
+}
@@ -35,6 +35,24 @@ import (
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
 )
 
+type command int
+
+const (
+	updateCmd command = iota
+	fixCmd
+	updateReposCmd
+	fixImportsCmd
+	exportModCmd
+)
+
+var commandFromName = map[string]command{
+	"export-mod":   exportModCmd,
+	"fix":          fixCmd,
+	"fix-imports":  fixImportsCmd,
+	"update":       updateCmd,
+	"update-repos": updateReposCmd,
+}
+
 type emitFunc func(*config.Config, *bf.File) error
 
 var modeFromName = map[string]emitFunc{
@@ -47,20 +65,30 @@ func run(c *config.Config, emit emitFunc) {
 	g := rules.NewGenerator(c)
 	shouldProcessRoot := false
 	didProcessRoot := false
+	var parseErrs []error
 	for _, dir := range c.Dirs {
 		if c.RepoRoot == dir {
 			shouldProcessRoot = true
 		}
-		packages.Walk(c, dir, func(pkg *packages.Package, oldFile *bf.File) {
+		err := packages.Walk(c, dir, func(pkg *packages.Package, oldFile *bf.File) {
 			if pkg.Rel == "" {
 				didProcessRoot = true
 			}
 			processPackage(c, g, emit, pkg, oldFile)
 		})
+		if errs, ok := err.(*packages.ParseErrors); ok {
+			parseErrs = append(parseErrs, errs.Errors...)
+		}
+	}
+	if len(parseErrs) > 0 {
+		// Each error was logged when it was found. Summarize them here so
+		// they aren't lost among other messages.
+		defer log.Print(&packages.ParseErrors{Errors: parseErrs})
 	}
 	if shouldProcessRoot && !didProcessRoot {
 		// We did not process a package at the repository root. We need to put
-		// a go_prefix rule there, even if there are no .go files in that directory.
+		// a go_prefix rule or prefix directive there, even if there are no .go
+		// files in that directory.
 		pkg := &packages.Package{Dir: c.RepoRoot}
 		var oldFile *bf.File
 		var oldData []byte
@@ -90,18 +118,40 @@ func run(c *config.Config, emit emitFunc) {
 
 func processPackage(c *config.Config, g rules.Generator, emit emitFunc, pkg *packages.Package, oldFile *bf.File) {
 	genFile := g.Generate(pkg)
+	// Format the generated file now, since merging may modify it.
+	genContent := bf.Format(genFile)
+
+	if oldFile == nil && c.ShouldFix && pkg.Rel == "" {
+		// The prefix directive must be recorded in the root build file, even if
+		// there is no existing file to fix.
+		oldFile = &bf.File{Path: genFile.Path}
+	}
 
 	if oldFile == nil {
 		// No existing file, so no merge required.
 		bf.Rewrite(genFile, nil) // have buildifier 'format' our rules.
 		if err := emit(c, genFile); err != nil {
 			log.Print(err)
+			return
+		}
+		if err := saveBaseline(c, pkg.Rel, genContent); err != nil {
+			log.Print(err)
 		}
 		return
 	}
 
+	if c.ShouldFix {
+		oldFile = merger.FixFile(c, pkg.Rel, oldFile)
+	}
+
+	baseFile, err := loadBaseline(c, pkg.Rel)
+	if err != nil {
+		// Without a baseline, the merge is two-way, as if none was recorded.
+		log.Print(err)
+	}
+
 	// Existing file, so merge and replace the old one.
-	mergedFile := merger.MergeWithExisting(genFile, oldFile)
+	mergedFile := merger.MergeWithBaseline(genFile, oldFile, baseFile)
 	if mergedFile == nil {
 		// Ignored file. Don't emit.
 		return
@@ -112,10 +162,13 @@ func processPackage(c *config.Config, g rules.Generator, emit emitFunc, pkg *pac
 		log.Print(err)
 		return
 	}
+	if err := saveBaseline(c, pkg.Rel, genContent); err != nil {
+		log.Print(err)
+	}
 }
 
 func usage(fs *flag.FlagSet) {
-	fmt.Fprintln(os.Stderr, `usage: gazelle [flags...] [package-dirs...]
+	fmt.Fprintln(os.Stderr, `usage: gazelle <command> [flags...] [package-dirs...]
 
 Gazelle is a BUILD file generator for Go projects.
 
@@ -129,6 +182,25 @@ It recursively traverses its subpackages.
 All the directories must be under the directory specified in -repo_root.
 [if -repo_root is not given, gazelle searches $pwd and up for the WORKSPACE file]
 
+There are five commands:
+
+update - Gazelle will create new BUILD files or update existing BUILD files
+    if needed.
+fix - in addition to the changes made in update, Gazelle will make potentially
+    breaking changes. For example, it may delete obsolete rules or rename
+    existing rules.
+update-repos - Gazelle will add, update, or remove go_repository rules in
+    the WORKSPACE file. Run "gazelle update-repos -help" for details.
+fix-imports - Gazelle will replace the import path given with -from, and
+    paths under it, with the one given with -to in .go files, then update
+    BUILD files as update does. This is useful after changing the prefix
+    or moving packages.
+export-mod - Gazelle will write a go.mod file requiring the versions pinned
+    by go_repository rules in WORKSPACE. Run "gazelle export-mod -help" for
+    details.
+
+update is the default command. It may be omitted.
+
 There are several modes of gazelle.
 In print mode, gazelle prints reconciled BUILD files to stdout.
 In fix mode, gazelle creates BUILD files or updates existing ones.
@@ -143,26 +215,77 @@ func main() {
 	log.SetPrefix("gazelle: ")
 	log.SetFlags(0) // don't print timestamps
 
-	c, emit, err := newConfiguration(os.Args[1:])
+	args := os.Args[1:]
+	cmd := updateCmd
+	if len(args) > 0 {
+		if c, ok := commandFromName[args[0]]; ok {
+			cmd = c
+			args = args[1:]
+		}
+	}
+
+	if cmd == updateReposCmd {
+		if err := updateRepos(args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if cmd == exportModCmd {
+		if err := exportMod(args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if cmd == fixImportsCmd {
+		if err := fixImports(args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	c, emit, err := newConfiguration(flag.NewFlagSet("gazelle", flag.ContinueOnError), args)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	c.ShouldFix = cmd == fixCmd
+	if c.ShouldFix {
+		// fix replaces the go_prefix rule with a directive.
+		c.PrefixDirective = true
+	}
+
 	run(c, emit)
 }
 
-func newConfiguration(args []string) (*config.Config, emitFunc, error) {
-	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+// newConfiguration parses the flags shared by the update, fix, and
+// fix-imports commands from args. Command-specific flags may be defined in
+// fs before it's called.
+func newConfiguration(fs *flag.FlagSet, args []string) (*config.Config, emitFunc, error) {
 	// Flag will call this on any parse error. Don't print usage unless
 	// -h or -help were passed explicitly.
 	fs.Usage = func() {}
 
 	buildFileName := fs.String("build_file_name", "BUILD.bazel,BUILD", "comma-separated list of valid build file names.\nThe first element of the list is the name of output build files to generate.")
 	buildTags := fs.String("build_tags", "", "comma-separated list of build tags. If not specified, Gazelle will not\n\tfilter sources with build constraints.")
+	skipDirs := fs.String("skip_dirs", strings.Join(config.DefaultSkipDirs, ","), "comma-separated list of directory names that Gazelle will not descend into.\n\tDirectories starting with \".\" or \"_\" are always skipped.")
+	goVersion := fs.String("go_version", "", "version of Go that sources will be built with, for example, 1.8.3. Used to\n\tevaluate release tags like go1.8. If not specified, release tags are\n\tconsidered satisfied.")
+	stdPackages := fs.String("std_packages", "", "file listing the import paths of the standard library packages of the Go SDK\n\tsources are built with, one per line. If not specified or empty, imports\n\twithout a dot in their first path element are assumed to be standard.")
 	external := fs.String("external", "external", "external: resolve external packages with go_repository\n\tvendored: resolve external packages as packages in vendor/")
+	multiplePackages := fs.String("multiple_packages", "pick", "pick: in directories with multiple packages, use the one matching the directory name\n\terror: report an error listing the files in each package\n\tsplit: generate rules for each package, named after the package unless it matches the directory name")
+	parseErrors := fs.String("parse_errors", "file", "file: leave source files that can't be parsed out of generated rules\n\tpackage: don't generate rules for packages with source files that can't be parsed")
+	caseCollisions := fs.String("case_collisions", "warn", "warn: log names of files and directories that differ only by case\n\tskip: also don't generate rules for packages in directories with such names")
 	goPrefix := fs.String("go_prefix", "", "go_prefix of the target workspace")
 	repoRoot := fs.String("repo_root", "", "path to a directory which corresponds to go_prefix, otherwise gazelle searches for it.")
+	repoName := fs.String("repo_name", "", "name of the external repository build files are generated for. Leave empty\n\tfor the main repository, or when the repo root is external/<name>. Used to\n\texpand ${SRCDIR} in cgo flags.")
+	pkgConfigLabels := fs.String("pkg_config_labels", "", "comma-separated list of name=label pairs mapping pkg-config packages named in\n\tcgo directives to cc_library rules, which are added to cdeps")
+	moduleRoots := fs.String("module_roots", "", "comma-separated list of prefix=dir pairs. Packages in each directory (relative\n\tto the repository root) and its subdirectories use the prefix instead of\n\t-go_prefix")
+	rulesGoVersion := fs.String("rules_go_version", "", "version of rules_go that build files are generated for, for example, 0.5.0.\n\tReleases up to 0.5.0 don't support embed, so generated rules use library\n\tinstead, and fix leaves library attributes alone. If not specified, the\n\tlatest version is assumed.")
+	mergeTests := fs.Bool("merge_tests", false, "generate one go_test for both internal and external (package foo_test) test files,\n\tinstead of go_default_test and go_default_xtest")
+	runPkgConfig := fs.Bool("run_pkg_config", false, "run pkg-config for packages not in -pkg_config_labels and copy the flags it\n\tprints into copts and clinkopts")
 	mode := fs.String("mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff")
+	jobs := fs.Int("jobs", 0, "maximum number of source files to read concurrently; if zero, GOMAXPROCS is used")
+	verbose := fs.Bool("verbose", false, "log extra information, for example, files excluded by build constraints")
+	cacheDir := fs.String("cache_dir", "", "directory where generated rules are recorded. When set, existing BUILD files\n\tare merged using the rules generated by the previous run as a baseline.\n\tOnly valid with -mode=fix.")
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
 			usage(fs)
@@ -187,9 +310,12 @@ func newConfiguration(args []string) (*config.Config, emitFunc, error) {
 	}
 
 	if *repoRoot != "" {
-		c.RepoRoot = *repoRoot
+		c.RepoRoot, err = filepath.Abs(*repoRoot)
+		if err != nil {
+			return nil, nil, err
+		}
 	} else if len(c.Dirs) == 1 {
-		c.RepoRoot, err = wspace.Find(c.Dirs[0])
+		c.RepoRoot, err = findRepoRoot(c.Dirs[0])
 		if err != nil {
 			return nil, nil, fmt.Errorf("-repo_root not specified, and WORKSPACE cannot be found: %v", err)
 		}
@@ -198,16 +324,31 @@ func newConfiguration(args []string) (*config.Config, emitFunc, error) {
 		if err != nil {
 			return nil, nil, err
 		}
-		c.RepoRoot, err = wspace.Find(cwd)
+		c.RepoRoot, err = findRepoRoot(cwd)
 		if err != nil {
 			return nil, nil, fmt.Errorf("-repo_root not specified, and WORKSPACE cannot be found: %v", err)
 		}
 	}
 
-	for _, dir := range c.Dirs {
-		if !isDescendingDir(dir, c.RepoRoot) {
+	for i, dir := range c.Dirs {
+		if isDescendingDir(dir, c.RepoRoot) {
+			continue
+		}
+		// External repositories are often reached through symlinks, for
+		// example, execroot/<workspace>/external/<name>. Compare real paths
+		// before giving up, but keep paths under the repo root as given.
+		rel, ok := realRel(dir, c.RepoRoot)
+		if !ok {
 			return nil, nil, fmt.Errorf("dir %q is not a subdirectory of repo root %q", dir, c.RepoRoot)
 		}
+		c.Dirs[i] = filepath.Join(c.RepoRoot, rel)
+	}
+
+	c.RepoName = *repoName
+	if c.RepoName == "" {
+		if root, name, err := wspace.FindExternal(c.RepoRoot); err == nil && root == c.RepoRoot {
+			c.RepoName = name
+		}
 	}
 
 	c.ValidBuildFileNames = strings.Split(*buildFileName, ",")
@@ -215,6 +356,13 @@ func newConfiguration(args []string) (*config.Config, emitFunc, error) {
 		return nil, nil, fmt.Errorf("no valid build file names specified")
 	}
 
+	c.SkipDirs = make(map[string]bool)
+	for _, d := range strings.Split(*skipDirs, ",") {
+		if d != "" {
+			c.SkipDirs[d] = true
+		}
+	}
+
 	c.GenericTags = make(config.BuildTags)
 	for _, t := range strings.Split(*buildTags, ",") {
 		if strings.HasPrefix(t, "!") {
@@ -222,15 +370,111 @@ func newConfiguration(args []string) (*config.Config, emitFunc, error) {
 		}
 		c.GenericTags[t] = true
 	}
+	if *goVersion != "" {
+		releaseTags, err := config.ReleaseTags(*goVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, t := range releaseTags {
+			c.GenericTags[t] = true
+		}
+	}
+	if *stdPackages != "" {
+		data, err := ioutil.ReadFile(*stdPackages)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.StdPackages = config.ParseStdPackages(string(data))
+	}
 	c.Platforms = config.DefaultPlatformTags
 	c.PreprocessTags()
 
+	c.Modules, err = loadModules(&c)
+	if err != nil {
+		return nil, nil, err
+	}
+	rootFile, err := loadRootBuildFile(&c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rootFile != nil {
+		for _, d := range config.ParseDirectives(rootFile) {
+			if d.Key != "module_root" {
+				continue
+			}
+			m, err := config.ParseModuleRoot(d.Value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", rootFile.Path, err)
+			}
+			c.Modules = addModule(c.Modules, m)
+		}
+	}
+	for _, r := range strings.Split(*moduleRoots, ",") {
+		if r == "" {
+			continue
+		}
+		m, err := config.ParseModuleRoot(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("-module_roots: %v", err)
+		}
+		c.Modules = addModule(c.Modules, m)
+	}
+
 	c.GoPrefix = *goPrefix
 	if c.GoPrefix == "" {
-		c.GoPrefix, err = loadGoPrefix(&c)
+		c.GoPrefix, c.PrefixDirective, err = loadGoPrefix(rootFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.GoPrefix == "" && len(c.Modules) == 0 {
+		c.GoPrefix, err = detectGoPrefix(&c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.GoPrefix == "" {
+			return nil, nil, errors.New("-go_prefix not set, and the prefix could not be found in the root build file, go.mod, or import comments")
+		}
+		log.Printf("-go_prefix not set; using %q", c.GoPrefix)
+	}
+
+	c.PkgConfigLabels = make(map[string]string)
+	for _, pair := range strings.Split(*pkgConfigLabels, ",") {
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i <= 0 || i == len(pair)-1 {
+			return nil, nil, fmt.Errorf("-pkg_config_labels: %q is not of the form name=label", pair)
+		}
+		c.PkgConfigLabels[pair[:i]] = pair[i+1:]
+	}
+	c.RunPkgConfig = *runPkgConfig
+	c.MergeTests = *mergeTests
+	if *rulesGoVersion != "" {
+		embed, err := config.RulesGoSupportsEmbed(*rulesGoVersion)
 		if err != nil {
-			return nil, nil, fmt.Errorf("-go_prefix not set and not root BUILD file found")
+			return nil, nil, err
 		}
+		c.LibraryAttr = !embed
+	}
+
+	c.Verbose = *verbose
+	c.Jobs = *jobs
+
+	c.MultiplePackageMode, err = config.MultiplePackageModeFromString(*multiplePackages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.ParseErrorMode, err = config.ParseErrorModeFromString(*parseErrors)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.CaseCollisionMode, err = config.CaseCollisionModeFromString(*caseCollisions)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	c.DepMode, err = config.DependencyModeFromString(*external)
@@ -243,6 +487,17 @@ func newConfiguration(args []string) (*config.Config, emitFunc, error) {
 		return nil, nil, fmt.Errorf("unrecognized emit mode: %q", *mode)
 	}
 
+	if *cacheDir != "" {
+		// Baselines must match the files that were actually written.
+		if *mode != "fix" {
+			return nil, nil, fmt.Errorf("-cache_dir may only be used with -mode=fix")
+		}
+		c.CacheDir, err = filepath.Abs(*cacheDir)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	return &c, emit, err
 }
 
@@ -263,18 +518,36 @@ func findBuildFile(c *config.Config, dir string) (string, error) {
 	return "", os.ErrNotExist
 }
 
-func loadGoPrefix(c *config.Config) (string, error) {
+// loadRootBuildFile reads and parses the build file in the repository root.
+// nil is returned without an error if there is no such file.
+func loadRootBuildFile(c *config.Config) (*bf.File, error) {
 	p, err := findBuildFile(c, c.RepoRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	b, err := ioutil.ReadFile(p)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	f, err := bf.Parse(p, b)
-	if err != nil {
-		return "", err
+	return bf.Parse(p, b)
+}
+
+// loadGoPrefix reads the Go prefix from the root build file f. The prefix
+// may be recorded with a "# gazelle:prefix" directive or a go_prefix rule.
+// If both are present, the directive takes precedence. The second return
+// value is true if the prefix came from a directive. If f is nil, or it
+// doesn't set the prefix, the empty string is returned without an error.
+func loadGoPrefix(f *bf.File) (string, bool, error) {
+	if f == nil {
+		return "", false, nil
+	}
+	for _, d := range config.ParseDirectives(f) {
+		if d.Key == "prefix" {
+			return d.Value, true, nil
+		}
 	}
 	for _, s := range f.Stmt {
 		c, ok := s.(*bf.CallExpr)
@@ -289,15 +562,27 @@ func loadGoPrefix(c *config.Config) (string, error) {
 			continue
 		}
 		if len(c.List) != 1 {
-			return "", fmt.Errorf("found go_prefix(%v) with too many args", c.List)
+			return "", false, fmt.Errorf("found go_prefix(%v) with too many args", c.List)
 		}
 		v, ok := c.List[0].(*bf.StringExpr)
 		if !ok {
-			return "", fmt.Errorf("found go_prefix(%v) which is not a string", c.List)
+			return "", false, fmt.Errorf("found go_prefix(%v) which is not a string", c.List)
+		}
+		return v.Value, false, nil
+	}
+	return "", false, nil
+}
+
+// addModule adds m to modules. If there is already a module in the same
+// directory, it is replaced.
+func addModule(modules []config.Module, m config.Module) []config.Module {
+	for i := range modules {
+		if modules[i].Rel == m.Rel {
+			modules[i] = m
+			return modules
 		}
-		return v.Value, nil
 	}
-	return "", errors.New("-go_prefix not set, and no go_prefix in root BUILD file")
+	return append(modules, m)
 }
 
 func isDescendingDir(dir, root string) bool {
@@ -306,3 +591,35 @@ func isDescendingDir(dir, root string) bool {
 	}
 	return strings.HasPrefix(dir, fmt.Sprintf("%s%c", root, filepath.Separator))
 }
+
+// realRel returns the path of dir relative to root after resolving
+// symbolic links in both, and whether dir is root or a subdirectory of it.
+func realRel(dir, root string) (string, bool) {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", false
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil || !isDescendingDir(realDir, realRoot) {
+		return "", false
+	}
+	rel, err := filepath.Rel(realRoot, realDir)
+	if err != nil {
+		return "", false
+	}
+	return rel, true
+}
+
+// findRepoRoot returns the root of the repository containing dir. This is
+// normally the closest directory with a WORKSPACE file. If dir is in
+// a Bazel external repository, external/<name> in the output base or
+// execution root, that directory is the root, even if it has no WORKSPACE
+// file or there's one farther up. A workspace's own "external" directory
+// doesn't count.
+func findRepoRoot(dir string) (string, error) {
+	root, err := wspace.Find(dir)
+	if extRoot, _, extErr := wspace.FindExternal(dir); extErr == nil && (err != nil || isDescendingDir(extRoot, root)) {
+		return extRoot, nil
+	}
+	return root, err
+}
@@ -0,0 +1,226 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+// errFound is used to stop filepath.Walk once a prefix has been found.
+var errFound = errors.New("found")
+
+// detectGoPrefix guesses the Go prefix for a repository that doesn't set
+// one in its root build file. The module path in go.mod in the repository
+// root is used if there is one. Otherwise, the prefix is derived from the
+// first canonical import comment (package foo // import "example.com/foo")
+// found in the repository. The empty string is returned if neither is
+// available.
+func detectGoPrefix(c *config.Config) (string, error) {
+	prefix, err := readModulePath(filepath.Join(c.RepoRoot, "go.mod"))
+	if err != nil || prefix != "" {
+		return prefix, err
+	}
+	return findImportCommentPrefix(c)
+}
+
+// readModulePath returns the module path from the "module" line in the
+// go.mod file at path. The empty string is returned if the file doesn't
+// exist or has no module line.
+func readModulePath(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		mod := fields[1]
+		if strings.HasPrefix(mod, `"`) || strings.HasPrefix(mod, "`") {
+			if mod, err = strconv.Unquote(mod); err != nil {
+				return "", err
+			}
+		}
+		return mod, nil
+	}
+	return "", scanner.Err()
+}
+
+// loadModules reads the go.work file in the repository root and returns the
+// modules it lists with "use" directives. Each module's path is read from
+// the go.mod file in its directory. nil is returned if there is no go.work
+// file.
+func loadModules(c *config.Config) ([]config.Module, error) {
+	workPath := filepath.Join(c.RepoRoot, "go.work")
+	f, err := os.Open(workPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dirs []string
+	inUseBlock := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inUseBlock && fields[0] == ")":
+			inUseBlock = false
+		case inUseBlock:
+			dirs = append(dirs, fields[0])
+		case fields[0] == "use" && len(fields) == 2 && fields[1] == "(":
+			inUseBlock = true
+		case fields[0] == "use" && len(fields) == 2:
+			dirs = append(dirs, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var modules []config.Module
+	for _, dir := range dirs {
+		if strings.HasPrefix(dir, `"`) || strings.HasPrefix(dir, "`") {
+			if dir, err = strconv.Unquote(dir); err != nil {
+				return nil, fmt.Errorf("%s: %v", workPath, err)
+			}
+		}
+		rel := filepath.ToSlash(filepath.Clean(filepath.FromSlash(dir)))
+		if rel == ".." || strings.HasPrefix(rel, "../") || filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("%s: module directory %q is outside the repository", workPath, dir)
+		}
+		if rel == "." {
+			rel = ""
+		}
+		modPath := filepath.Join(c.RepoRoot, filepath.FromSlash(rel), "go.mod")
+		mod, err := readModulePath(modPath)
+		if err != nil {
+			return nil, err
+		}
+		if mod == "" {
+			return nil, fmt.Errorf("%s: module %q has no module path in %s", workPath, dir, modPath)
+		}
+		modules = append(modules, config.Module{Rel: rel, Path: mod})
+	}
+	return modules, nil
+}
+
+// findImportCommentPrefix walks the repository looking for a .go file with
+// a canonical import comment. The prefix is the import path with the file's
+// directory, relative to the repository root, trimmed from the end. Import
+// comments that don't end with the directory are ignored. Directories that
+// Gazelle doesn't visit, as well as vendor and testdata directories, are
+// skipped.
+func findImportCommentPrefix(c *config.Config) (string, error) {
+	var prefix string
+	err := filepath.Walk(c.RepoRoot, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		base := info.Name()
+		if info.IsDir() {
+			if p != c.RepoRoot && (strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") || base == "vendor" || base == "testdata" || c.SkipDirs[base]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(base, ".go") || strings.HasSuffix(base, "_test.go") {
+			return nil
+		}
+		imp := readImportComment(p)
+		if imp == "" {
+			return nil
+		}
+		rel, err := filepath.Rel(c.RepoRoot, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			prefix = imp
+			return errFound
+		}
+		if strings.HasSuffix(imp, "/"+rel) {
+			prefix = strings.TrimSuffix(imp, "/"+rel)
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return "", err
+	}
+	return prefix, nil
+}
+
+// readImportComment returns the import path in the canonical import comment
+// on the package clause of the file at path. The empty string is returned
+// if there is no import comment or the file can't be parsed.
+func readImportComment(path string) string {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return ""
+	}
+	line := fset.Position(f.Name.End()).Line
+	for _, g := range f.Comments {
+		for _, cm := range g.List {
+			if fset.Position(cm.Pos()).Line != line || cm.Pos() < f.Name.End() {
+				continue
+			}
+			text := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(cm.Text, "//"), "/*"))
+			text = strings.TrimSpace(strings.TrimSuffix(text, "*/"))
+			if !strings.HasPrefix(text, "import ") {
+				continue
+			}
+			imp, err := strconv.Unquote(strings.TrimSpace(strings.TrimPrefix(text, "import ")))
+			if err != nil {
+				return ""
+			}
+			return imp
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+func TestDetectGoPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		files map[string]string
+		want  string
+	}{
+		{
+			desc: "go.mod",
+			files: map[string]string{
+				"go.mod":     "// comment\nmodule example.com/mod // comment\n",
+				"foo/foo.go": `package foo // import "example.com/other/foo"`,
+			},
+			want: "example.com/mod",
+		}, {
+			desc: "quoted go.mod",
+			files: map[string]string{
+				"go.mod": `module "example.com/mod"`,
+			},
+			want: "example.com/mod",
+		}, {
+			desc: "import comment in root",
+			files: map[string]string{
+				"root.go": `package root // import "example.com/root"`,
+			},
+			want: "example.com/root",
+		}, {
+			desc: "import comment in subdirectory",
+			files: map[string]string{
+				"a/b/b.go": "package b /* import \"example.com/repo/a/b\" */\n",
+			},
+			want: "example.com/repo",
+		}, {
+			desc: "mismatched and skipped import comments",
+			files: map[string]string{
+				"a/a.go":        `package a // import "example.com/elsewhere"`,
+				"a/a_test.go":   `package a // import "example.com/test/a"`,
+				"b/b.go":        "package b\n\n// import \"example.com/notclause/b\"\n",
+				"vendor/v/v.go": `package v // import "example.com/vendor/v"`,
+				"c/c.go":        `package c // import "example.com/repo/c"`,
+			},
+			want: "example.com/repo",
+		}, {
+			desc: "none",
+			files: map[string]string{
+				"a/a.go": "package a\n",
+			},
+			want: "",
+		},
+	} {
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for name, content := range tc.files {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		c := &config.Config{RepoRoot: dir}
+		got, err := detectGoPrefix(c)
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q; want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestLoadModules(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"go.work": `go 1.18
+
+use . // root module
+use (
+	./tools
+	"./api"
+)
+`,
+		"go.mod":       "module example.com/repo\n",
+		"tools/go.mod": "module example.com/tools\n",
+		"api/go.mod":   "module example.com/api\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.Config{RepoRoot: dir}
+	got, err := loadModules(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Module{
+		{Rel: "", Path: "example.com/repo"},
+		{Rel: "tools", Path: "example.com/tools"},
+		{Rel: "api", Path: "example.com/api"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	bf "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/merger"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
+)
+
+const workspaceFileName = "WORKSPACE"
+
+// commitRe matches full git commit hashes. Other versions are treated as
+// tags.
+var commitRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// updateRepos adds, updates, or removes go_repository rules in the
+// WORKSPACE file, according to the command line arguments in args.
+func updateRepos(args []string) error {
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	// Flag will call this on any parse error. Don't print usage unless
+	// -h or -help were passed explicitly.
+	fs.Usage = func() {}
+
+	repoRoot := fs.String("repo_root", "", "path to the directory containing the WORKSPACE file, otherwise gazelle searches for it.")
+	mode := fs.String("mode", "fix", "print: prints the updated WORKSPACE file\n\tfix: rewrites the WORKSPACE file in place\n\tdiff: computes the rewrite but then just does a diff")
+	remove := fs.Bool("remove", false, "remove the go_repository rules for the given import paths instead of adding or updating them")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			updateReposUsage(fs)
+			os.Exit(0)
+		}
+		// flag already prints the error; don't print it again.
+		log.Fatal("Try -help for more information.")
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no repositories given. Try -help for more information.")
+	}
+
+	emit, ok := modeFromName[*mode]
+	if !ok {
+		return fmt.Errorf("unrecognized emit mode: %q", *mode)
+	}
+
+	c := &config.Config{
+		RepoRoot:            *repoRoot,
+		ValidBuildFileNames: []string{workspaceFileName},
+	}
+	if c.RepoRoot == "" {
+		cwd, err := filepath.Abs(".")
+		if err != nil {
+			return err
+		}
+		c.RepoRoot, err = wspace.Find(cwd)
+		if err != nil {
+			return fmt.Errorf("-repo_root not specified, and WORKSPACE cannot be found: %v", err)
+		}
+	}
+
+	var genRules []*bf.CallExpr
+	var removeNames []string
+	for _, arg := range fs.Args() {
+		if *remove {
+			removeNames = append(removeNames, rules.ImportPathToBazelRepoName(arg))
+			continue
+		}
+		r, err := repositoryRuleFromArg(arg)
+		if err != nil {
+			return err
+		}
+		genRules = append(genRules, r)
+	}
+
+	path := filepath.Join(c.RepoRoot, workspaceFileName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	oldFile, err := bf.Parse(path, data)
+	if err != nil {
+		return err
+	}
+	mergedFile := merger.MergeWorkspace(genRules, removeNames, oldFile)
+	return emit(c, mergedFile)
+}
+
+// repositoryRuleFromArg builds a go_repository rule from an argument of the
+// form importpath@version. The import path must be the root of
+// a repository. The version may be a full commit hash or a tag.
+func repositoryRuleFromArg(arg string) (*bf.CallExpr, error) {
+	i := strings.LastIndex(arg, "@")
+	if i <= 0 || i == len(arg)-1 {
+		return nil, fmt.Errorf("%q: argument must have the form importpath@version", arg)
+	}
+	importpath, version := arg[:i], arg[i+1:]
+
+	r := &bf.Rule{Call: &bf.CallExpr{X: &bf.LiteralExpr{Token: "go_repository"}}}
+	r.SetAttr("name", &bf.StringExpr{Value: rules.ImportPathToBazelRepoName(importpath)})
+	if commitRe.MatchString(version) {
+		r.SetAttr("commit", &bf.StringExpr{Value: version})
+	} else {
+		r.SetAttr("tag", &bf.StringExpr{Value: version})
+	}
+	r.SetAttr("importpath", &bf.StringExpr{Value: importpath})
+	return r.Call, nil
+}
+
+func updateReposUsage(fs *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, `usage: gazelle update-repos [flags...] importpath@version...
+       gazelle update-repos -remove [flags...] importpath...
+
+update-repos adds or updates go_repository rules in the WORKSPACE file. Each
+argument names the root import path of a repository and a version, which may
+be a full commit hash or a tag. Rules are named after the import path, for
+example, com_github_foo_bar for github.com/foo/bar. With -remove, the rules for
+the given import paths are deleted instead.
+
+Comments, other attributes of existing rules, and unrelated rules in the
+WORKSPACE file are preserved. Rules marked with a "# keep" comment are not
+changed.
+
+FLAGS:
+`)
+	fs.PrintDefaults()
+}
@@ -0,0 +1,103 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bf "github.com/bazelbuild/buildtools/build"
+)
+
+func TestRepositoryRuleFromArg(t *testing.T) {
+	for _, tc := range []struct {
+		arg, want string
+	}{
+		{
+			arg: "github.com/foo/bar@v1.0",
+			want: `go_repository(
+    name = "com_github_foo_bar",
+    tag = "v1.0",
+    importpath = "github.com/foo/bar",
+)`,
+		}, {
+			arg: "golang.org/x/tools@0123456789abcdef0123456789abcdef01234567",
+			want: `go_repository(
+    name = "org_golang_x_tools",
+    commit = "0123456789abcdef0123456789abcdef01234567",
+    importpath = "golang.org/x/tools",
+)`,
+		},
+	} {
+		r, err := repositoryRuleFromArg(tc.arg)
+		if err != nil {
+			t.Errorf("%s: %v", tc.arg, err)
+			continue
+		}
+		if got := bf.FormatString(r); got != tc.want {
+			t.Errorf("%s: got %s; want %s", tc.arg, got, tc.want)
+		}
+	}
+
+	for _, arg := range []string{"github.com/foo/bar", "github.com/foo/bar@", "@v1.0"} {
+		if _, err := repositoryRuleFromArg(arg); err == nil {
+			t.Errorf("%s: got success; want error", arg)
+		}
+	}
+}
+
+func TestUpdateRepos(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "WORKSPACE")
+	old := `# Go dependencies
+go_repository(
+    name = "com_github_foo_bar",
+    importpath = "github.com/foo/bar",
+    tag = "v1.0",
+)
+`
+	if err := ioutil.WriteFile(path, []byte(old), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateRepos([]string{"-repo_root", dir, "github.com/foo/bar@v1.1", "github.com/foo/baz@v2.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateRepos([]string{"-repo_root", dir, "-remove", "github.com/foo/baz"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Adding com_github_foo_baz also loaded go_repository, which the file
+	// didn't.
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_repository")
+
+` + strings.Replace(old, "v1.0", "v1.1", 1)
+	if got := string(data); got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
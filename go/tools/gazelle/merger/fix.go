@@ -0,0 +1,703 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package merger
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	bf "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+// FixFile updates rules in oldFile that were generated by an older version of
+// Gazelle to a newer form that can be merged with freshly generated rules.
+// rel is the slash-separated path of the file's directory, relative to the
+// repository root. oldFile is not modified; a new file is returned if any
+// changes are needed.
+func FixFile(c *config.Config, rel string, oldFile *bf.File) *bf.File {
+	fixedFile := squashCgoLibrary(oldFile)
+	if !c.LibraryAttr {
+		fixedFile = migrateLibraryToEmbed(fixedFile)
+	}
+	fixedFile = migrateGoPrefix(c, rel, fixedFile)
+	fixedFile = removeObsoleteProtoRules(fixedFile)
+	fixedFile = fixLoadPaths(fixedFile)
+	return fixLoads(fixedFile)
+}
+
+// squashCgoLibrary removes the cgo_library rule with the default name and
+// merges its attributes into the go_library rule with the default name.
+// The go_library rule is marked with cgo = True, and its library attribute
+// (which pointed to the cgo_library) is removed. If there is no go_library,
+// a new one is created in place of the cgo_library.
+//
+// cgo_library rules marked with "# keep" are left alone.
+func squashCgoLibrary(oldFile *bf.File) *bf.File {
+	// Find the default cgo_library and go_library rules.
+	var cgoLibrary, goLibrary *bf.Rule
+	cgoLibraryIndex := -1
+	goLibraryIndex := -1
+
+	for i, stmt := range oldFile.Stmt {
+		c, ok := stmt.(*bf.CallExpr)
+		if !ok {
+			continue
+		}
+		r := &bf.Rule{Call: c}
+		if r.Kind() == "cgo_library" && r.Name() == config.DefaultCgoLibName && !shouldKeep(c) {
+			if cgoLibrary != nil {
+				log.Printf("%s: when fixing existing file, multiple cgo_library rules with default name found", oldFile.Path)
+				continue
+			}
+			cgoLibrary = r
+			cgoLibraryIndex = i
+			continue
+		}
+		if r.Kind() == "go_library" && r.Name() == config.DefaultLibName {
+			if goLibrary != nil {
+				log.Printf("%s: when fixing existing file, multiple go_library rules with default name found", oldFile.Path)
+				continue
+			}
+			goLibrary = r
+			goLibraryIndex = i
+		}
+	}
+
+	if cgoLibrary == nil {
+		return oldFile
+	}
+
+	// If go_library has a '# keep' comment, just delete cgo_library.
+	if goLibrary != nil && shouldKeep(goLibrary.Call) {
+		fixedFile := *oldFile
+		fixedFile.Stmt = append(oldFile.Stmt[:cgoLibraryIndex:cgoLibraryIndex], oldFile.Stmt[cgoLibraryIndex+1:]...)
+		return &fixedFile
+	}
+
+	// Copy the comments and attributes from cgo_library into go_library. If no
+	// go_library exists, create an empty one.
+	var fixedGoLibraryExpr bf.CallExpr
+	fixedGoLibrary := &bf.Rule{Call: &fixedGoLibraryExpr}
+	if goLibrary == nil {
+		fixedGoLibraryExpr.X = &bf.LiteralExpr{Token: "go_library"}
+		fixedGoLibrary.SetAttr("name", &bf.StringExpr{Value: config.DefaultLibName})
+		if vis := cgoLibrary.Attr("visibility"); vis != nil {
+			fixedGoLibrary.SetAttr("visibility", vis)
+		}
+	} else {
+		fixedGoLibraryExpr = *goLibrary.Call
+		fixedGoLibraryExpr.List = append([]bf.Expr{}, goLibrary.Call.List...)
+	}
+
+	fixedGoLibrary.DelAttr("library")
+	fixedGoLibrary.SetAttr("cgo", &bf.LiteralExpr{Token: "True"})
+
+	fixedGoLibraryExpr.Comments.Before = append(fixedGoLibraryExpr.Comments.Before, cgoLibrary.Call.Comments.Before...)
+	fixedGoLibraryExpr.Comments.Suffix = append(fixedGoLibraryExpr.Comments.Suffix, cgoLibrary.Call.Comments.Suffix...)
+	fixedGoLibraryExpr.Comments.After = append(fixedGoLibraryExpr.Comments.After, cgoLibrary.Call.Comments.After...)
+
+	for _, key := range []string{"cdeps", "clinkopts", "copts", "data", "deps", "gc_goopts", "srcs"} {
+		goLibraryAttr := fixedGoLibrary.Attr(key)
+		cgoLibraryAttr := cgoLibrary.Attr(key)
+		if cgoLibraryAttr == nil {
+			continue
+		}
+		fixedAttr, err := squashExpr(goLibraryAttr, cgoLibraryAttr)
+		if err != nil {
+			log.Printf("%s: could not merge %q attribute of cgo_library into go_library: %v", oldFile.Path, key, err)
+			continue
+		}
+		fixedGoLibrary.SetAttr(key, fixedAttr)
+	}
+
+	// Rebuild the file with the cgo_library removed and the go_library replaced.
+	// If the go_library didn't already exist, it will replace cgo_library.
+	fixedFile := *oldFile
+	if goLibrary == nil {
+		fixedFile.Stmt = append([]bf.Expr{}, oldFile.Stmt...)
+		fixedFile.Stmt[cgoLibraryIndex] = &fixedGoLibraryExpr
+	} else {
+		fixedFile.Stmt = make([]bf.Expr, 0, len(oldFile.Stmt)-1)
+		for i, stmt := range oldFile.Stmt {
+			switch i {
+			case cgoLibraryIndex:
+				continue
+			case goLibraryIndex:
+				fixedFile.Stmt = append(fixedFile.Stmt, &fixedGoLibraryExpr)
+			default:
+				fixedFile.Stmt = append(fixedFile.Stmt, stmt)
+			}
+		}
+	}
+	return &fixedFile
+}
+
+// migrateLibraryToEmbed replaces the library attribute of go_library,
+// go_binary, and go_test rules with an equivalent embed attribute. For
+// example, library = ":go_default_library" becomes
+// embed = [":go_default_library"]. If a rule already has an embed attribute,
+// the library is appended to it.
+//
+// Rules marked with "# keep" are left alone.
+func migrateLibraryToEmbed(oldFile *bf.File) *bf.File {
+	fixedFile := *oldFile
+	fixedFile.Stmt = make([]bf.Expr, len(oldFile.Stmt))
+	changed := false
+	for i, stmt := range oldFile.Stmt {
+		fixedFile.Stmt[i] = stmt
+		c, ok := stmt.(*bf.CallExpr)
+		if !ok || shouldKeep(c) {
+			continue
+		}
+		switch kind(c) {
+		case "go_binary", "go_library", "go_test":
+		default:
+			continue
+		}
+		r := &bf.Rule{Call: c}
+		libraryAttr := r.AttrDefn("library")
+		if libraryAttr == nil {
+			continue
+		}
+
+		// If there is already an embed attribute, the library is appended to it.
+		// Otherwise, library is replaced in place, so embed appears in the
+		// same position.
+		embedValue := bf.Expr(&bf.ListExpr{List: []bf.Expr{libraryAttr.Y}})
+		embedAttr := r.AttrDefn("embed")
+		if embedAttr != nil {
+			squashed, err := squashExpr(embedAttr.Y, embedValue)
+			if err != nil {
+				log.Printf("%s: could not migrate library attribute of %s to embed: %v", oldFile.Path, r.Name(), err)
+				continue
+			}
+			embedValue = squashed
+		}
+		fixedCall := *c
+		fixedCall.List = nil
+		for _, arg := range c.List {
+			switch {
+			case arg == libraryAttr && embedAttr != nil:
+				continue
+			case arg == libraryAttr:
+				fixedAttr := *libraryAttr
+				fixedAttr.X = &bf.LiteralExpr{Token: "embed"}
+				fixedAttr.Y = embedValue
+				fixedCall.List = append(fixedCall.List, &fixedAttr)
+			case arg == embedAttr:
+				fixedAttr := *embedAttr
+				fixedAttr.Y = embedValue
+				fixedCall.List = append(fixedCall.List, &fixedAttr)
+			default:
+				fixedCall.List = append(fixedCall.List, arg)
+			}
+		}
+		fixedFile.Stmt[i] = &fixedCall
+		changed = true
+	}
+	if !changed {
+		return oldFile
+	}
+	return &fixedFile
+}
+
+// migrateGoPrefix removes the go_prefix rule from the root build file and
+// records the prefix with a "# gazelle:prefix" directive in its place. If the
+// root build file has neither, the directive is added at the top.
+//
+// Since the Go rules can't compute import paths without a go_prefix rule,
+// an importpath attribute is added to each go_library, go_binary, and go_test
+// rule that doesn't already have one. The value is the same one the rules
+// would have derived from go_prefix. Rules marked with "# keep" are
+// left alone.
+//
+// When modules from go.work or module roots are configured, they define the
+// import paths, so no directive is written, and without modules or a prefix,
+// there's nothing to migrate.
+func migrateGoPrefix(c *config.Config, rel string, oldFile *bf.File) *bf.File {
+	if c.GoPrefix == "" && len(c.Modules) == 0 {
+		return oldFile
+	}
+	fixedFile := *oldFile
+	fixedFile.Stmt = make([]bf.Expr, 0, len(oldFile.Stmt)+1)
+	changed := false
+
+	writeDirective := rel == "" && c.GoPrefix != "" && len(c.Modules) == 0
+	if writeDirective {
+		for _, d := range config.ParseDirectives(oldFile) {
+			if d.Key == "prefix" {
+				writeDirective = false
+				break
+			}
+		}
+	}
+
+	for _, stmt := range oldFile.Stmt {
+		call, ok := stmt.(*bf.CallExpr)
+		if !ok || shouldKeep(call) {
+			fixedFile.Stmt = append(fixedFile.Stmt, stmt)
+			continue
+		}
+		switch kind(call) {
+		case "go_prefix":
+			if rel != "" {
+				break
+			}
+			// Keep comments attached to the rule; they may include an
+			// existing directive.
+			changed = true
+			comments := append([]bf.Comment{}, call.Comments.Before...)
+			if writeDirective {
+				comments = append(comments, config.PrefixDirectiveComment(c.GoPrefix))
+				writeDirective = false
+			}
+			if len(comments) > 0 {
+				fixedFile.Stmt = append(fixedFile.Stmt, &bf.CommentBlock{
+					Comments: bf.Comments{Before: comments},
+				})
+			}
+			continue
+
+		case "go_binary", "go_library", "go_test":
+			r := &bf.Rule{Call: call}
+			if r.Attr("importpath") != nil {
+				break
+			}
+			fixedCall := *call
+			fixedCall.List = append([]bf.Expr{}, call.List...)
+			fixedRule := &bf.Rule{Call: &fixedCall}
+			fixedRule.SetAttr("importpath", &bf.StringExpr{Value: c.DefaultImportPath(rel, r.Name())})
+			fixedFile.Stmt = append(fixedFile.Stmt, &fixedCall)
+			changed = true
+			continue
+		}
+		fixedFile.Stmt = append(fixedFile.Stmt, stmt)
+	}
+
+	if writeDirective {
+		directive := &bf.CommentBlock{
+			Comments: bf.Comments{Before: []bf.Comment{config.PrefixDirectiveComment(c.GoPrefix)}},
+		}
+		fixedFile.Stmt = append([]bf.Expr{directive}, fixedFile.Stmt...)
+		changed = true
+	}
+
+	if !changed {
+		return oldFile
+	}
+	return &fixedFile
+}
+
+// removeObsoleteProtoRules deletes rules that are made redundant by
+// go_proto_library rules in the same file. For each go_proto_library named N,
+// the filegroup named N_protos is removed, since the go_proto_library macro
+// declares that target itself. A go_library named N is also removed if its
+// srcs are all .pb.go or .twirp.go files, since it would build the same
+// package from checked-in generated code.
+//
+// Rules marked with "# keep" are left alone.
+func removeObsoleteProtoRules(oldFile *bf.File) *bf.File {
+	protoNames := protoLibraryNames(oldFile)
+	if len(protoNames) == 0 {
+		return oldFile
+	}
+
+	fixedFile := *oldFile
+	fixedFile.Stmt = make([]bf.Expr, 0, len(oldFile.Stmt))
+	for _, stmt := range oldFile.Stmt {
+		if c, ok := stmt.(*bf.CallExpr); ok && !shouldKeep(c) && replacedByProtoLibrary(protoNames, c) {
+			if kind(c) == "filegroup" || isPbGoOnly(c) {
+				continue
+			}
+			log.Printf("%s: %s %q has the same name as a go_proto_library but has sources other than .pb.go and .twirp.go files; not removing it", oldFile.Path, kind(c), name(c))
+		}
+		fixedFile.Stmt = append(fixedFile.Stmt, stmt)
+	}
+	if len(fixedFile.Stmt) == len(oldFile.Stmt) {
+		return oldFile
+	}
+	return &fixedFile
+}
+
+// isPbGoOnly returns whether c has a non-empty srcs list containing only
+// .pb.go and .twirp.go files.
+func isPbGoOnly(c *bf.CallExpr) bool {
+	srcs, ok := (&bf.Rule{Call: c}).Attr("srcs").(*bf.ListExpr)
+	if !ok || len(srcs.List) == 0 {
+		return false
+	}
+	for _, src := range srcs.List {
+		if s := stringValue(src); !strings.HasSuffix(s, ".pb.go") && !strings.HasSuffix(s, ".twirp.go") {
+			return false
+		}
+	}
+	return true
+}
+
+// squashExpr combines two expressions. Unlike mergeExpr, squashExpr does not
+// discard information from an "old" expression. It does not sort or
+// de-duplicate elements. The following kinds of expressions are recognized:
+//
+//   * nil
+//   * strings (can only be merged with strings)
+//   * lists of strings
+//   * a call to select with a dict argument. The dict keys must be strings,
+//     and the values must be lists of strings.
+//   * a list of strings combined with a select call using +. The list must
+//     be the left operand.
+//
+// An error is returned if the expressions can't be merged, for example
+// because they are not in one of the above formats.
+func squashExpr(x, y bf.Expr) (bf.Expr, error) {
+	if x == nil {
+		return y, nil
+	}
+	if y == nil {
+		return x, nil
+	}
+	if _, ok := x.(*bf.StringExpr); ok {
+		if _, ok := y.(*bf.StringExpr); ok {
+			// Multiple strings can't be combined; keep the first one.
+			return x, nil
+		}
+	}
+
+	xList, xDict, err := exprListAndDict(x)
+	if err != nil {
+		return nil, err
+	}
+	yList, yDict, err := exprListAndDict(y)
+	if err != nil {
+		return nil, err
+	}
+
+	squashedList := squashList(xList, yList)
+	squashedDict, err := squashDict(xDict, yDict)
+	if err != nil {
+		return nil, err
+	}
+
+	var squashedSelect bf.Expr
+	if squashedDict != nil {
+		squashedSelect = &bf.CallExpr{
+			X:    &bf.LiteralExpr{Token: "select"},
+			List: []bf.Expr{squashedDict},
+		}
+	}
+
+	if squashedList == nil {
+		return squashedSelect, nil
+	}
+	if squashedSelect == nil {
+		return squashedList, nil
+	}
+	squashedList.ForceMultiLine = true
+	return &bf.BinaryExpr{
+		X:  squashedList,
+		Op: "+",
+		Y:  squashedSelect,
+	}, nil
+}
+
+func squashList(x, y *bf.ListExpr) *bf.ListExpr {
+	if x == nil {
+		return y
+	}
+	if y == nil {
+		return x
+	}
+	squashed := *x
+	squashed.List = append(append([]bf.Expr{}, x.List...), y.List...)
+	return &squashed
+}
+
+func squashDict(x, y *bf.DictExpr) (*bf.DictExpr, error) {
+	if x == nil {
+		return y, nil
+	}
+	if y == nil {
+		return x, nil
+	}
+
+	squashed := *x
+	squashed.List = nil
+
+	var keys []string
+	cases := make(map[string]*bf.KeyValueExpr)
+	addCase := func(e bf.Expr) error {
+		kv, ok := e.(*bf.KeyValueExpr)
+		if !ok {
+			return fmt.Errorf("dict entry was not a key-value pair: %#v", e)
+		}
+		key, ok := kv.Key.(*bf.StringExpr)
+		if !ok {
+			return fmt.Errorf("dict key was not string: %#v", kv.Key)
+		}
+		if c, ok := cases[key.Value]; ok {
+			if sq, err := squashExpr(c.Value, kv.Value); err != nil {
+				return err
+			} else {
+				c.Value = sq
+			}
+		} else {
+			kvCopy := *kv
+			cases[key.Value] = &kvCopy
+			keys = append(keys, key.Value)
+		}
+		return nil
+	}
+
+	for _, e := range x.List {
+		if err := addCase(e); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range y.List {
+		if err := addCase(e); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, k := range keys {
+		squashed.List = append(squashed.List, cases[k])
+	}
+	return &squashed, nil
+}
+
+// loadedSymbol identifies a symbol loaded from a .bzl file.
+type loadedSymbol struct {
+	label, symbol string
+}
+
+// movedSymbols maps symbols that have been moved or renamed in past releases
+// of the Go rules to their current locations. Symbols loaded from private
+// files were never supported, but many BUILD files load them anyway.
+var movedSymbols = map[loadedSymbol]loadedSymbol{
+	{"@io_bazel_rules_go//go/private:binary.bzl", "go_binary"}:                {config.RulesGoDefBzlLabel, "go_binary"},
+	{"@io_bazel_rules_go//go/private:cgo.bzl", "cgo_genrule"}:                 {config.RulesGoDefBzlLabel, "cgo_genrule"},
+	{"@io_bazel_rules_go//go/private:cgo.bzl", "cgo_library"}:                 {config.RulesGoDefBzlLabel, "cgo_library"},
+	{"@io_bazel_rules_go//go/private:gazelle.bzl", "gazelle"}:                 {config.RulesGoDefBzlLabel, "gazelle"},
+	{"@io_bazel_rules_go//go/private:go_prefix.bzl", "go_prefix"}:             {config.RulesGoDefBzlLabel, "go_prefix"},
+	{"@io_bazel_rules_go//go/private:go_repository.bzl", "go_repository"}:     {config.RulesGoDefBzlLabel, "go_repository"},
+	{"@io_bazel_rules_go//go/private:go_repository.bzl", "new_go_repository"}: {config.RulesGoDefBzlLabel, "go_repository"},
+	{"@io_bazel_rules_go//go/private:library.bzl", "go_library"}:              {config.RulesGoDefBzlLabel, "go_library"},
+	{"@io_bazel_rules_go//go/private:repositories.bzl", "go_repositories"}:    {config.RulesGoDefBzlLabel, "go_repositories"},
+	{"@io_bazel_rules_go//go/private:test.bzl", "go_test"}:                    {config.RulesGoDefBzlLabel, "go_test"},
+	{config.RulesGoDefBzlLabel, "new_go_repository"}:                          {config.RulesGoDefBzlLabel, "go_repository"},
+}
+
+// fixLoadPaths rewrites load statements for symbols listed in movedSymbols,
+// so they load from the symbols' current locations. Moved symbols are added
+// to an existing load statement for the new file if there is one; otherwise,
+// a new load statement is added in place of the old one. Old load statements
+// with no remaining symbols are removed. Calls to renamed symbols are renamed
+// as well.
+func fixLoadPaths(oldFile *bf.File) *bf.File {
+	// Find the symbols that need to move. Remember which symbols stay and
+	// which labels are already loaded.
+	kept := make(map[int][]bf.Expr)
+	loadIndex := make(map[string]int)
+	var movedLabels []string
+	movedSyms := make(map[string][]string)
+	renames := make(map[string]string)
+	for i, stmt := range oldFile.Stmt {
+		c, ok := stmt.(*bf.CallExpr)
+		if !ok || kind(c) != "load" || len(c.List) == 0 {
+			continue
+		}
+		label := stringValue(c.List[0])
+		if j, ok := loadIndex[label]; !ok || j < 0 {
+			loadIndex[label] = i
+		}
+		var keep []bf.Expr
+		for _, arg := range c.List[1:] {
+			sym := stringValue(arg)
+			to, ok := movedSymbols[loadedSymbol{label, sym}]
+			if !ok {
+				keep = append(keep, arg)
+				continue
+			}
+			if _, ok := movedSyms[to.label]; !ok {
+				movedLabels = append(movedLabels, to.label)
+			}
+			movedSyms[to.label] = append(movedSyms[to.label], to.symbol)
+			if to.symbol != sym {
+				renames[sym] = to.symbol
+			}
+			if _, ok := loadIndex[to.label]; !ok {
+				// The new load will be inserted after this statement, unless the new
+				// file is loaded somewhere else.
+				loadIndex[to.label] = -i - 1
+			}
+		}
+		if len(keep) < len(c.List)-1 {
+			kept[i] = keep
+		}
+	}
+	if len(movedLabels) == 0 {
+		return oldFile
+	}
+
+	fixedFile := *oldFile
+	fixedFile.Stmt = make([]bf.Expr, 0, len(oldFile.Stmt)+len(movedLabels))
+	for i, stmt := range oldFile.Stmt {
+		c, ok := stmt.(*bf.CallExpr)
+		if !ok {
+			fixedFile.Stmt = append(fixedFile.Stmt, stmt)
+			continue
+		}
+		if kind(c) != "load" || len(c.List) == 0 {
+			if to, ok := renames[kind(c)]; ok {
+				renamed := *c
+				renamed.X = &bf.LiteralExpr{Token: to}
+				stmt = &renamed
+			}
+			fixedFile.Stmt = append(fixedFile.Stmt, stmt)
+			continue
+		}
+
+		// Add moved symbols to the existing load for their new file.
+		label := stringValue(c.List[0])
+		args, isFixed := kept[i]
+		if !isFixed {
+			args = append([]bf.Expr{}, c.List[1:]...)
+		}
+		if loadIndex[label] == i && len(movedSyms[label]) > 0 {
+			args = appendLoadSymbols(args, movedSyms[label])
+			isFixed = true
+		}
+		if !isFixed {
+			fixedFile.Stmt = append(fixedFile.Stmt, stmt)
+		} else if len(args) > 0 {
+			fixedLoad := *c
+			fixedLoad.List = append([]bf.Expr{c.List[0]}, args...)
+			fixedFile.Stmt = append(fixedFile.Stmt, &fixedLoad)
+		}
+
+		// Insert new loads for files that weren't loaded before.
+		for _, to := range movedLabels {
+			if loadIndex[to] != -i-1 {
+				continue
+			}
+			newLoad := &bf.CallExpr{
+				X:            &bf.LiteralExpr{Token: "load"},
+				List:         []bf.Expr{&bf.StringExpr{Value: to}},
+				ForceCompact: true,
+			}
+			newLoad.List = append(newLoad.List, appendLoadSymbols(nil, movedSyms[to])...)
+			fixedFile.Stmt = append(fixedFile.Stmt, newLoad)
+		}
+	}
+	return &fixedFile
+}
+
+// appendLoadSymbols appends string expressions for syms to args. Symbols that
+// are already present are skipped.
+func appendLoadSymbols(args []bf.Expr, syms []string) []bf.Expr {
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		seen[stringValue(arg)] = true
+	}
+	for _, sym := range syms {
+		if !seen[sym] {
+			seen[sym] = true
+			args = append(args, &bf.StringExpr{Value: sym})
+		}
+	}
+	return args
+}
+
+// fixLoads updates the load statement for the Go rules, so that it loads
+// exactly the rules that are used in the file. Symbols that are no longer used
+// (for example, cgo_library after squashCgoLibrary) are removed, and rules
+// that are used but not loaded are added. If no Go rules are used, the load
+// statement is removed. If there is no load statement but Go rules are used,
+// one is inserted at the top of the file.
+func fixLoads(oldFile *bf.File) *bf.File {
+	loadIndex := -1
+	var oldLoad *bf.CallExpr
+	for i, stmt := range oldFile.Stmt {
+		c, ok := stmt.(*bf.CallExpr)
+		if ok && kind(c) == "load" && len(c.List) > 0 && stringValue(c.List[0]) == config.RulesGoDefBzlLabel {
+			loadIndex = i
+			oldLoad = c
+			break
+		}
+	}
+
+	// Collect the symbols that should be loaded. Reuse the existing arguments
+	// so comments are preserved.
+	args := make(map[string]bf.Expr)
+	if oldLoad != nil {
+		for _, arg := range oldLoad.List[1:] {
+			if sym := stringValue(arg); ruleUsed(sym, oldFile) {
+				args[sym] = arg
+			}
+		}
+	}
+	for _, k := range config.LoadableKinds {
+		if _, ok := args[k]; !ok && ruleUsed(k, oldFile) {
+			args[k] = &bf.StringExpr{Value: k}
+		}
+	}
+
+	var fixedLoad *bf.CallExpr
+	if len(args) > 0 {
+		syms := make([]string, 0, len(args))
+		for sym := range args {
+			syms = append(syms, sym)
+		}
+		sort.Strings(syms)
+		if oldLoad != nil {
+			l := *oldLoad
+			fixedLoad = &l
+		} else {
+			fixedLoad = &bf.CallExpr{
+				X:            &bf.LiteralExpr{Token: "load"},
+				ForceCompact: true,
+			}
+		}
+		fixedLoad.List = []bf.Expr{&bf.StringExpr{Value: config.RulesGoDefBzlLabel}}
+		if oldLoad != nil {
+			fixedLoad.List[0] = oldLoad.List[0]
+		}
+		for _, sym := range syms {
+			fixedLoad.List = append(fixedLoad.List, args[sym])
+		}
+		if oldLoad != nil && bf.FormatString(fixedLoad) == bf.FormatString(oldLoad) {
+			return oldFile
+		}
+	} else if oldLoad == nil {
+		return oldFile
+	}
+
+	fixedFile := *oldFile
+	fixedFile.Stmt = make([]bf.Expr, 0, len(oldFile.Stmt)+1)
+	if oldLoad == nil {
+		fixedFile.Stmt = append(fixedFile.Stmt, fixedLoad)
+	}
+	for i, stmt := range oldFile.Stmt {
+		if i != loadIndex {
+			fixedFile.Stmt = append(fixedFile.Stmt, stmt)
+		} else if fixedLoad != nil {
+			fixedFile.Stmt = append(fixedFile.Stmt, fixedLoad)
+		}
+	}
+	return &fixedFile
+}
@@ -0,0 +1,688 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package merger
+
+import (
+	"testing"
+
+	bf "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+type fixTestCase struct {
+	desc, old, want string
+}
+
+func TestSquashCgoLibrary(t *testing.T) {
+	for _, tc := range []fixTestCase{
+		{
+			desc: "no cgo_library",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+)
+`,
+		}, {
+			desc: "non-default cgo_library not removed",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library")
+
+cgo_library(
+    name = "something_else",
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library")
+
+cgo_library(
+    name = "something_else",
+)
+`,
+		}, {
+			desc: "keep cgo_library",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library", "go_library")
+
+cgo_library(
+    name = "cgo_default_library",
+)  # keep
+
+go_library(
+    name = "go_default_library",
+    library = ":cgo_default_library",
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library", "go_library")
+
+cgo_library(
+    name = "cgo_default_library",
+)  # keep
+
+go_library(
+    name = "go_default_library",
+    library = ":cgo_default_library",
+)
+`,
+		}, {
+			desc: "keep go_library",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library", "go_library")
+
+cgo_library(
+    name = "cgo_default_library",
+)
+
+go_library(
+    name = "go_default_library",
+)  # keep
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library", "go_library")
+
+go_library(
+    name = "go_default_library",
+)  # keep
+`,
+		}, {
+			desc: "squash cgo_library into go_library",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library", "go_library")
+
+# before comment
+cgo_library(
+    name = "cgo_default_library",
+    srcs = ["cgo.go"],
+    copts = ["-O1"],
+    visibility = ["//visibility:private"],
+    deps = ["//foo"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["pure.go"],
+    library = ":cgo_default_library",
+    visibility = ["//visibility:public"],
+    deps = ["//bar"],
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# before comment
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "pure.go",
+        "cgo.go",
+    ],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//bar",
+        "//foo",
+    ],
+    cgo = True,
+    copts = ["-O1"],
+)
+`,
+		}, {
+			desc: "cgo_library replaced with go_library",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library")
+
+cgo_library(
+    name = "cgo_default_library",
+    srcs = ["cgo.go"],
+    visibility = ["//visibility:private"],
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library")
+
+go_library(
+    name = "go_default_library",
+    visibility = ["//visibility:private"],
+    cgo = True,
+    srcs = ["cgo.go"],
+)
+`,
+		},
+	} {
+		testFix(t, tc, squashCgoLibrary)
+	}
+}
+
+func TestMigrateLibraryToEmbed(t *testing.T) {
+	for _, tc := range []fixTestCase{
+		{
+			desc: "no library",
+			old: `go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+)
+`,
+			want: `go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+)
+`,
+		}, {
+			desc: "library replaced in place",
+			old: `go_binary(
+    name = "bin",
+    library = ":go_default_library",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["bin_test.go"],
+    library = ":go_default_library",
+)
+`,
+			want: `go_binary(
+    name = "bin",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["bin_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+		}, {
+			desc: "library appended to embed",
+			old: `go_library(
+    name = "go_default_library",
+    embed = [":a"],
+    library = ":b",
+)
+`,
+			want: `go_library(
+    name = "go_default_library",
+    embed = [
+        ":a",
+        ":b",
+    ],
+)
+`,
+		}, {
+			desc: "keep",
+			old: `go_test(
+    name = "go_default_test",
+    library = ":go_default_library",
+)  # keep
+`,
+			want: `go_test(
+    name = "go_default_test",
+    library = ":go_default_library",
+)  # keep
+`,
+		}, {
+			desc: "other rules untouched",
+			old: `foo_library(
+    name = "foo",
+    library = ":bar",
+)
+`,
+			want: `foo_library(
+    name = "foo",
+    library = ":bar",
+)
+`,
+		},
+	} {
+		testFix(t, tc, migrateLibraryToEmbed)
+	}
+}
+
+func TestMigrateGoPrefix(t *testing.T) {
+	c := &config.Config{GoPrefix: "example.com/repo"}
+	for _, tc := range []struct {
+		fixTestCase
+		rel string
+	}{
+		{
+			fixTestCase: fixTestCase{
+				desc: "go_prefix replaced with directive",
+				old: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_prefix")
+
+# prefix comment
+go_prefix("example.com/repo")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+)
+`,
+				want: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_prefix")
+
+# prefix comment
+# gazelle:prefix example.com/repo
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo",
+)
+`,
+			},
+		}, {
+			fixTestCase: fixTestCase{
+				desc: "directive added to empty root",
+				old: `filegroup(
+    name = "all",
+)
+`,
+				want: `# gazelle:prefix example.com/repo
+
+filegroup(
+    name = "all",
+)
+`,
+			},
+		}, {
+			fixTestCase: fixTestCase{
+				desc: "existing directive",
+				old: `# gazelle:prefix example.com/repo
+
+go_prefix("example.com/repo")
+`,
+				want: `# gazelle:prefix example.com/repo
+`,
+			},
+		}, {
+			fixTestCase: fixTestCase{
+				desc: "importpath added in subdirectory",
+				old: `go_binary(
+    name = "cmd",
+    embed = [":go_default_library"],
+)
+
+go_test(
+    name = "go_default_test",
+    importpath = "example.com/custom",
+)
+
+go_test(
+    name = "go_default_xtest",
+)  # keep
+`,
+				want: `go_binary(
+    name = "cmd",
+    embed = [":go_default_library"],
+    importpath = "example.com/repo/foo/cmd",
+)
+
+go_test(
+    name = "go_default_test",
+    importpath = "example.com/custom",
+)
+
+go_test(
+    name = "go_default_xtest",
+)  # keep
+`,
+			},
+			rel: "foo",
+		},
+	} {
+		testFix(t, tc.fixTestCase, func(f *bf.File) *bf.File {
+			return migrateGoPrefix(c, tc.rel, f)
+		})
+	}
+}
+
+func TestMigrateGoPrefixWithoutDirective(t *testing.T) {
+	for _, tc := range []struct {
+		fixTestCase
+		c *config.Config
+	}{
+		{
+			fixTestCase: fixTestCase{
+				desc: "no prefix",
+				old: `go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+)
+`,
+				want: `go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+)
+`,
+			},
+			c: &config.Config{},
+		}, {
+			fixTestCase: fixTestCase{
+				desc: "modules",
+				old: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+)
+`,
+				want: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/root",
+)
+`,
+			},
+			c: &config.Config{Modules: []config.Module{{Path: "example.com/root"}}},
+		},
+	} {
+		testFix(t, tc.fixTestCase, func(f *bf.File) *bf.File {
+			return migrateGoPrefix(tc.c, "", f)
+		})
+	}
+}
+
+func TestRemoveObsoleteProtoRules(t *testing.T) {
+	for _, tc := range []fixTestCase{
+		{
+			desc: "no go_proto_library",
+			old: `go_library(
+    name = "go_default_library",
+    srcs = ["foo.pb.go"],
+)
+
+filegroup(
+    name = "go_default_library_protos",
+    srcs = ["foo.proto"],
+)
+`,
+			want: `go_library(
+    name = "go_default_library",
+    srcs = ["foo.pb.go"],
+)
+
+filegroup(
+    name = "go_default_library_protos",
+    srcs = ["foo.proto"],
+)
+`,
+		}, {
+			desc: "filegroup and pb.go library removed",
+			old: `go_proto_library(
+    name = "go_default_library",
+    srcs = ["foo.proto"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.pb.go"],
+)
+
+filegroup(
+    name = "go_default_library_protos",
+    srcs = ["foo.proto"],
+)
+
+filegroup(
+    name = "other_protos",
+    srcs = ["bar.proto"],
+)
+`,
+			want: `go_proto_library(
+    name = "go_default_library",
+    srcs = ["foo.proto"],
+)
+
+filegroup(
+    name = "other_protos",
+    srcs = ["bar.proto"],
+)
+`,
+		}, {
+			desc: "pb.go and twirp.go library removed",
+			old: `go_proto_library(
+    name = "go_default_library",
+    srcs = ["foo.proto"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.pb.go",
+        "foo.twirp.go",
+    ],
+)
+`,
+			want: `go_proto_library(
+    name = "go_default_library",
+    srcs = ["foo.proto"],
+)
+`,
+		}, {
+			desc: "library with other sources kept",
+			old: `go_proto_library(
+    name = "go_default_library",
+    srcs = ["foo.proto"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.go",
+        "foo.pb.go",
+    ],
+)
+
+filegroup(
+    name = "go_default_library_protos",
+    srcs = ["foo.proto"],
+)  # keep
+`,
+			want: `go_proto_library(
+    name = "go_default_library",
+    srcs = ["foo.proto"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.go",
+        "foo.pb.go",
+    ],
+)
+
+filegroup(
+    name = "go_default_library_protos",
+    srcs = ["foo.proto"],
+)  # keep
+`,
+		},
+	} {
+		testFix(t, tc, removeObsoleteProtoRules)
+	}
+}
+
+func TestFixLoadPaths(t *testing.T) {
+	for _, tc := range []fixTestCase{
+		{
+			desc: "moved into existing load",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//go/private:test.bzl", "go_test")
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+`,
+		}, {
+			desc: "new load replaces old",
+			old: `load("@io_bazel_rules_go//go/private:binary.bzl", "go_binary")
+
+go_binary(
+    name = "cmd",
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_binary")
+
+go_binary(
+    name = "cmd",
+)
+`,
+		}, {
+			desc: "unmoved symbols kept",
+			old: `load("@io_bazel_rules_go//go/private:cgo.bzl", "cgo_library", "foo")
+`,
+			want: `load("@io_bazel_rules_go//go/private:cgo.bzl", "foo")
+load("@io_bazel_rules_go//go:def.bzl", "cgo_library")
+`,
+		}, {
+			desc: "renamed symbol",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "new_go_repository")
+
+new_go_repository(
+    name = "org_golang_x_net",
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_repository")
+
+go_repository(
+    name = "org_golang_x_net",
+)
+`,
+		}, {
+			desc: "other loads untouched",
+			old: `load("//:foo.bzl", "foo_library")
+`,
+			want: `load("//:foo.bzl", "foo_library")
+`,
+		},
+	} {
+		testFix(t, tc, fixLoadPaths)
+	}
+}
+
+func TestFixLoads(t *testing.T) {
+	for _, tc := range []fixTestCase{
+		{
+			desc: "unused symbols removed",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+)
+`,
+		}, {
+			desc: "empty load removed",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library")
+
+filegroup(
+    name = "all",
+)
+`,
+			want: `filegroup(
+    name = "all",
+)
+`,
+		}, {
+			desc: "missing symbols added",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library")
+
+go_library(
+    name = "go_default_library",
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+)
+`,
+		}, {
+			desc: "load inserted",
+			old: `go_test(
+    name = "go_default_test",
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+)
+`,
+		}, {
+			desc: "other loads untouched",
+			old: `load("//:foo.bzl", "foo_library")
+`,
+			want: `load("//:foo.bzl", "foo_library")
+`,
+		},
+	} {
+		testFix(t, tc, fixLoads)
+	}
+}
+
+func TestFixFileLibraryAttr(t *testing.T) {
+	c := &config.Config{GoPrefix: "example.com/repo", LibraryAttr: true}
+	tc := fixTestCase{
+		desc: "library kept",
+		old: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["bin.go"],
+    visibility = ["//visibility:private"],
+)
+
+go_binary(
+    name = "bin",
+    library = ":go_default_library",
+    visibility = ["//visibility:public"],
+)
+`,
+		// The go_prefix migration still adds importpath attributes.
+		want: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["bin.go"],
+    visibility = ["//visibility:private"],
+    importpath = "example.com/repo/bin",
+)
+
+go_binary(
+    name = "bin",
+    library = ":go_default_library",
+    visibility = ["//visibility:public"],
+    importpath = "example.com/repo/bin/bin",
+)
+`,
+	}
+	testFix(t, tc, func(f *bf.File) *bf.File {
+		return FixFile(c, "bin", f)
+	})
+}
+
+func testFix(t *testing.T, tc fixTestCase, fix func(*bf.File) *bf.File) {
+	f, err := bf.Parse(tc.desc, []byte(tc.old))
+	if err != nil {
+		t.Fatalf("%s: parse error: %v", tc.desc, err)
+	}
+	fixed := fix(f)
+	if got := string(bf.Format(fixed)); got != tc.want {
+		t.Errorf("%s: got %s; want %s", tc.desc, got, tc.want)
+	}
+}
@@ -28,12 +28,18 @@ import (
 const (
 	gazelleIgnore = "# gazelle:ignore" // marker in a BUILD file to ignore it.
 	keep          = "# keep"           // marker in srcs or deps to tell gazelle to preserve.
+	protosSuffix  = "_protos"          // suffix of the filegroup declared by go_proto_library.
+
+	// legacyProtoBzl is the file that declares the go_proto_library macro,
+	// which the go_proto_library rule in proto/def.bzl replaces.
+	legacyProtoBzl = "@io_bazel_rules_go//proto:go_proto_library.bzl"
 )
 
 var (
 	mergeableFields = map[string]bool{
 		"srcs":    true,
 		"deps":    true,
+		"embed":   true,
 		"library": true,
 	}
 )
@@ -48,6 +54,20 @@ var (
 // a "# gazelle:ignore" comment, nil will be returned. If an error occurs,
 // it will be logged, and nil will be returned.
 func MergeWithExisting(genFile, oldFile *bf.File) *bf.File {
+	return MergeWithBaseline(genFile, oldFile, nil)
+}
+
+// MergeWithBaseline is like MergeWithExisting, but it performs a three-way
+// merge. "baseFile" is the file Gazelle generated the last time it ran in
+// the same directory. It may be nil if nothing was recorded, in which case
+// the merge is the same as MergeWithExisting.
+//
+// The baseline lets Gazelle tell user edits apart from stale generated
+// content. Rules, attributes, and list elements that were generated last
+// time but were removed from "oldFile" are not added back. Those that are
+// the same in "oldFile" and "baseFile" are updated or removed to match
+// "genFile". Anything added to "oldFile" by hand is kept.
+func MergeWithBaseline(genFile, oldFile, baseFile *bf.File) *bf.File {
 	if oldFile == nil {
 		return genFile
 	}
@@ -61,36 +81,121 @@ func MergeWithExisting(genFile, oldFile *bf.File) *bf.File {
 		mergedFile.Stmt[i] = oldFile.Stmt[i]
 	}
 
+	protoNames := protoLibraryNames(oldFile)
+	legacyProto := usesLegacyProtoMacro(oldFile)
+	matched := make(map[int]bool)
+	droppedProtos := make(map[string]bool)
 	var newStmt []bf.Expr
 	for _, s := range genFile.Stmt {
 		genRule, ok := s.(*bf.CallExpr)
 		if !ok {
 			log.Panicf("got %v expected only CallExpr in %q", s, genFile.Path)
 		}
+		if legacyProto && isProtoRule(genRule) {
+			// The go_proto_library macro takes .proto files instead of a
+			// proto_library, so it can't be merged with the generated rules.
+			continue
+		}
+		var baseRule *bf.CallExpr
+		if baseFile != nil {
+			_, baseRule = match(baseFile, genRule)
+		}
 		i, oldRule := match(&mergedFile, genRule)
 		if oldRule == nil {
+			if baseRule != nil && kind(baseRule) != "load" {
+				// The rule was generated last time, and the user deleted it.
+				continue
+			}
+			if replacedByProtoLibrary(protoNames, genRule) {
+				continue
+			}
+			if hasOtherProtoRule(oldFile, genRule, droppedProtos) {
+				if kind(genRule) == "proto_library" {
+					// The go_proto_library generated with it would refer to
+					// a target that doesn't exist.
+					droppedProtos[":"+name(genRule)] = true
+				}
+				continue
+			}
 			newStmt = append(newStmt, genRule)
 			continue
 		}
+		matched[i] = true
 
 		var mergedRule bf.Expr
 		if kind(oldRule) == "load" {
 			mergedRule = mergeLoad(genRule, oldRule, oldFile)
 		} else {
-			mergedRule = mergeRule(genRule, oldRule)
+			mergedRule = mergeRule(genRule, oldRule, baseRule)
 		}
 		mergedFile.Stmt[i] = mergedRule
 	}
 
+	if baseFile != nil {
+		// Remove rules that were generated last time and not modified since, but
+		// which are no longer generated.
+		var keptStmt []bf.Expr
+		for i, s := range mergedFile.Stmt {
+			if oldRule, ok := s.(*bf.CallExpr); ok && !matched[i] && kind(oldRule) != "load" {
+				if _, baseRule := match(baseFile, oldRule); baseRule != nil && bf.FormatString(baseRule) == bf.FormatString(oldRule) {
+					continue
+				}
+			}
+			keptStmt = append(keptStmt, s)
+		}
+		mergedFile.Stmt = keptStmt
+	}
+
 	mergedFile.Stmt = append(mergedFile.Stmt, newStmt...)
 	return &mergedFile
 }
 
+// MergeData is like MergeWithExisting, but it operates on the contents of
+// build files instead of syntax trees. This is useful for callers that
+// don't read or write files on disk, for example, when reading from stdin.
+//
+// "path" is used for error messages and as the path of the merged file.
+// "genData" is the content of the generated file. "oldData" is the content
+// of the existing file; it may be nil if there is no existing file. The
+// merged file is formatted in the same way Gazelle formats files it writes.
+// If the existing file contains a "# gazelle:ignore" comment, "oldData" is
+// returned unchanged. "genData" may only contain rule and load calls, like
+// the files Gazelle generates; anything else is an error.
+func MergeData(path string, genData, oldData []byte) ([]byte, error) {
+	genFile, err := bf.Parse(path, genData)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range genFile.Stmt {
+		if _, ok := s.(*bf.CallExpr); !ok {
+			return nil, fmt.Errorf("%s: generated content may only contain calls, got %s", path, strings.TrimSpace(bf.FormatString(s)))
+		}
+	}
+	var oldFile *bf.File
+	if oldData != nil {
+		oldFile, err = bf.Parse(path, oldData)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mergedFile := MergeWithExisting(genFile, oldFile)
+	if mergedFile == nil {
+		return oldData, nil
+	}
+	bf.Rewrite(mergedFile, nil)
+	return bf.Format(mergedFile), nil
+}
+
 // merge combines information from gen and old and returns an updated rule.
 // Both rules must be non-nil and must have the same kind and same name.
-func mergeRule(gen, old *bf.CallExpr) *bf.CallExpr {
+// base is the rule generated the last time Gazelle ran. It may be nil.
+func mergeRule(gen, old, base *bf.CallExpr) *bf.CallExpr {
 	genRule := bf.Rule{Call: gen}
 	oldRule := bf.Rule{Call: old}
+	var baseRule *bf.Rule
+	if base != nil {
+		baseRule = &bf.Rule{Call: base}
+	}
 	merged := *old
 	merged.List = nil
 	mergedRule := bf.Rule{Call: &merged}
@@ -110,14 +215,30 @@ func mergeRule(gen, old *bf.CallExpr) *bf.CallExpr {
 	// Assume generated attributes have no comments.
 	for _, k := range oldRule.AttrKeys() {
 		oldAttr := oldRule.AttrDefn(k)
+		var baseExpr bf.Expr
+		if baseRule != nil {
+			baseExpr = baseRule.Attr(k)
+		}
 		if !mergeableFields[k] {
-			merged.List = append(merged.List, oldAttr)
+			// Attributes that haven't changed since they were generated are
+			// replaced by the new generated value.
+			if baseExpr == nil || bf.FormatString(baseExpr) != bf.FormatString(oldAttr.Y) {
+				merged.List = append(merged.List, oldAttr)
+			} else if genExpr := genRule.Attr(k); genExpr != nil {
+				mergedAttr := *oldAttr
+				mergedAttr.Y = genExpr
+				merged.List = append(merged.List, &mergedAttr)
+			}
 			continue
 		}
 
+		if baseRule != nil && baseExpr == nil {
+			// The attribute was added by the user.
+			baseExpr = &bf.ListExpr{}
+		}
 		oldExpr := oldAttr.Y
 		genExpr := genRule.Attr(k)
-		mergedExpr, err := mergeExpr(genExpr, oldExpr)
+		mergedExpr, err := mergeExpr(genExpr, oldExpr, baseExpr)
 		if err != nil {
 			// TODO: add a verbose mode and log errors like this.
 			mergedExpr = genExpr
@@ -130,7 +251,11 @@ func mergeRule(gen, old *bf.CallExpr) *bf.CallExpr {
 	}
 
 	// Merge attributes from genRule that we haven't processed already.
+	// Attributes the user deleted since the last run are not added back.
 	for _, k := range genRule.AttrKeys() {
+		if baseRule != nil && baseRule.Attr(k) != nil && oldRule.Attr(k) == nil {
+			continue
+		}
 		if mergedRule.Attr(k) == nil {
 			mergedRule.SetAttr(k, genRule.Attr(k))
 		}
@@ -152,11 +277,18 @@ func mergeRule(gen, old *bf.CallExpr) *bf.CallExpr {
 //
 // An error is returned if the expressions can't be merged, for example
 // because they are not in one of the above formats.
-func mergeExpr(gen, old bf.Expr) (bf.Expr, error) {
+//
+// base is the expression generated the last time Gazelle ran. It may be nil.
+// If it is not in one of the above formats, it is ignored.
+func mergeExpr(gen, old, base bf.Expr) (bf.Expr, error) {
 	if _, ok := gen.(*bf.StringExpr); ok {
 		if shouldKeep(old) {
 			return old, nil
 		}
+		if base != nil && bf.FormatString(base) != bf.FormatString(old) {
+			// The user changed the value since it was generated.
+			return old, nil
+		}
 		return gen, nil
 	}
 
@@ -168,9 +300,13 @@ func mergeExpr(gen, old bf.Expr) (bf.Expr, error) {
 	if err != nil {
 		return nil, err
 	}
+	baseList, baseDict, err := exprListAndDict(base)
+	if err != nil {
+		baseList, baseDict = nil, nil
+	}
 
-	mergedList := mergeList(genList, oldList)
-	mergedDict, err := mergeDict(genDict, oldDict)
+	mergedList := mergeList(genList, oldList, baseList)
+	mergedDict, err := mergeDict(genDict, oldDict, baseDict)
 	if err != nil {
 		return nil, err
 	}
@@ -238,30 +374,42 @@ func exprListAndDict(expr bf.Expr) (*bf.ListExpr, *bf.DictExpr, error) {
 	return nil, nil, fmt.Errorf("expression could not be matched")
 }
 
-func mergeList(gen, old *bf.ListExpr) *bf.ListExpr {
+// mergeList combines gen and old. Elements from old are kept if they have
+// a "# keep" comment. If base is not nil, elements from old that are not in
+// base were added by the user and are also kept, and elements from gen that
+// are in base but not old were removed by the user and are not added back.
+func mergeList(gen, old, base *bf.ListExpr) *bf.ListExpr {
 	if old == nil {
+		if base != nil {
+			gen = subtractList(gen, base)
+		}
 		return gen
 	}
 	if gen == nil {
 		gen = &bf.ListExpr{List: []bf.Expr{}}
 	}
 
-	// Build a list of elements from the old list with "# keep" comments. We
-	// must not duplicate these elements, since duplicate elements will be
-	// removed when we rewrite the AST.
+	inBase := listSet(base)
+	inOld := listSet(old)
+
+	// Build a list of elements from the old list with "# keep" comments and
+	// elements added by the user. We must not duplicate these elements, since
+	// duplicate elements will be removed when we rewrite the AST.
 	var merged []bf.Expr
 	kept := make(map[string]bool)
 	for _, v := range old.List {
-		if shouldKeep(v) {
+		s := stringValue(v)
+		if shouldKeep(v) || (base != nil && !inBase[s]) {
 			merged = append(merged, v)
-			if s := stringValue(v); s != "" {
+			if s != "" {
 				kept[s] = true
 			}
 		}
 	}
 
 	for _, v := range gen.List {
-		if s := stringValue(v); kept[s] {
+		s := stringValue(v)
+		if kept[s] || (inBase[s] && !inOld[s]) {
 			continue
 		}
 		merged = append(merged, v)
@@ -273,10 +421,44 @@ func mergeList(gen, old *bf.ListExpr) *bf.ListExpr {
 	return &bf.ListExpr{List: merged}
 }
 
-func mergeDict(gen, old *bf.DictExpr) (*bf.DictExpr, error) {
-	if old == nil {
+// listSet returns the set of string values in l. l may be nil.
+func listSet(l *bf.ListExpr) map[string]bool {
+	set := make(map[string]bool)
+	if l != nil {
+		for _, v := range l.List {
+			if s := stringValue(v); s != "" {
+				set[s] = true
+			}
+		}
+	}
+	return set
+}
+
+// subtractList returns the elements of x that are not in y.
+func subtractList(x, y *bf.ListExpr) *bf.ListExpr {
+	if x == nil {
+		return nil
+	}
+	inY := listSet(y)
+	var list []bf.Expr
+	for _, v := range x.List {
+		if !inY[stringValue(v)] {
+			list = append(list, v)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return &bf.ListExpr{List: list}
+}
+
+func mergeDict(gen, old, base *bf.DictExpr) (*bf.DictExpr, error) {
+	if old == nil && base == nil {
 		return gen, nil
 	}
+	if old == nil {
+		old = &bf.DictExpr{List: []bf.Expr{}}
+	}
 	if gen == nil {
 		gen = &bf.DictExpr{List: []bf.Expr{}}
 	}
@@ -311,10 +493,22 @@ func mergeDict(gen, old *bf.DictExpr) (*bf.DictExpr, error) {
 		e.genValue = v
 	}
 
+	if base != nil {
+		for _, kv := range base.List {
+			k, v, err := dictEntryKeyValue(kv)
+			if err != nil {
+				continue
+			}
+			if e, ok := entryMap[k]; ok {
+				e.baseValue = v
+			}
+		}
+	}
+
 	keys := make([]string, 0, len(entries))
 	haveDefault := false
 	for _, e := range entries {
-		e.mergedValue = mergeList(e.genValue, e.oldValue)
+		e.mergedValue = mergeList(e.genValue, e.oldValue, e.baseValue)
 		if e.key == "//conditions:default" {
 			// Keep the default case, even if it's empty.
 			haveDefault = true
@@ -347,8 +541,8 @@ func mergeDict(gen, old *bf.DictExpr) (*bf.DictExpr, error) {
 }
 
 type dictEntry struct {
-	key                             string
-	oldValue, genValue, mergedValue *bf.ListExpr
+	key                                        string
+	oldValue, genValue, baseValue, mergedValue *bf.ListExpr
 }
 
 func dictEntryKeyValue(e bf.Expr) (string, *bf.ListExpr, error) {
@@ -417,6 +611,118 @@ func shouldKeep(e bf.Expr) bool {
 	return len(c.Suffix) > 0 && strings.HasPrefix(c.Suffix[0].Token, keep)
 }
 
+// hasOtherProtoRule returns whether c is a proto_library or go_proto_library
+// whose work is already done by a rule with a different name in f:
+// a proto_library with any of the same srcs, or a go_proto_library with the
+// same proto or with a proto in dropped. Rules for .proto files are often
+// written by hand with names Gazelle doesn't generate; adding another rule
+// for the same files would build them twice. Unrelated rules of the same
+// kind don't count.
+func hasOtherProtoRule(f *bf.File, c *bf.CallExpr, dropped map[string]bool) bool {
+	r := &bf.Rule{Call: c}
+	switch r.Kind() {
+	case "proto_library":
+		srcs := make(map[string]bool)
+		for _, src := range stringList(r.Attr("srcs")) {
+			srcs[src] = true
+		}
+		for _, other := range f.Rules("proto_library") {
+			for _, src := range stringList(other.Attr("srcs")) {
+				if srcs[src] {
+					return true
+				}
+			}
+		}
+	case "go_proto_library":
+		proto := r.AttrString("proto")
+		if dropped[proto] {
+			return true
+		}
+		for _, other := range f.Rules("go_proto_library") {
+			if proto != "" && other.AttrString("proto") == proto {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stringList returns the strings in e if it's a list, or nil otherwise.
+func stringList(e bf.Expr) []string {
+	l, ok := e.(*bf.ListExpr)
+	if !ok {
+		return nil
+	}
+	var strs []string
+	for _, elem := range l.List {
+		if s := stringValue(elem); s != "" {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+// usesLegacyProtoMacro returns whether f loads the go_proto_library macro
+// from legacyProtoBzl.
+func usesLegacyProtoMacro(f *bf.File) bool {
+	for _, stmt := range f.Stmt {
+		c, ok := stmt.(*bf.CallExpr)
+		if !ok || kind(c) != "load" || len(c.List) == 0 || stringValue(c.List[0]) != legacyProtoBzl {
+			continue
+		}
+		for _, arg := range c.List[1:] {
+			if stringValue(arg) == "go_proto_library" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isProtoRule returns whether c is a proto_library or go_proto_library rule,
+// or a load statement for go_proto_library.
+func isProtoRule(c *bf.CallExpr) bool {
+	switch kind(c) {
+	case "proto_library", "go_proto_library":
+		return true
+	case "load":
+		if len(c.List) == 0 {
+			return false
+		}
+		for _, arg := range c.List[1:] {
+			if stringValue(arg) == "go_proto_library" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// protoLibraryNames returns the names of go_proto_library rules in f.
+func protoLibraryNames(f *bf.File) map[string]bool {
+	names := make(map[string]bool)
+	for _, r := range f.Rules("go_proto_library") {
+		names[r.Name()] = true
+	}
+	return names
+}
+
+// replacedByProtoLibrary returns whether c is a go_library or a filegroup
+// whose target would collide with a go_proto_library named in protoNames.
+// The go_proto_library macro declares a go_library with its own name and
+// a filegroup with the suffix "_protos".
+func replacedByProtoLibrary(protoNames map[string]bool, c *bf.CallExpr) bool {
+	switch kind(c) {
+	case "go_library":
+		return protoNames[name(c)]
+	case "filegroup":
+		n := name(c)
+		return strings.HasSuffix(n, protosSuffix) && protoNames[strings.TrimSuffix(n, protosSuffix)]
+	default:
+		return false
+	}
+}
+
 func ruleUsed(rule string, oldfile *bf.File) bool {
 	return len(oldfile.Rules(rule)) != 0
 }
@@ -1,6 +1,7 @@
 package merger
 
 import (
+	"strings"
 	"testing"
 
 	bf "github.com/bazelbuild/buildtools/build"
@@ -422,6 +423,161 @@ go_library(
     # merged attr
     srcs = ["foo.go"],
 )
+`,
+	}, {
+		desc: "rules replaced by go_proto_library not added",
+		previous: `
+load("@io_bazel_rules_go//proto:go_proto_library.bzl", "go_proto_library")
+
+go_proto_library(
+    name = "go_default_library",
+    srcs = ["foo.proto"],
+)
+`,
+		current: `
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.pb.go"],
+)
+
+filegroup(
+    name = "go_default_library_protos",
+    srcs = ["foo.proto"],
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//proto:go_proto_library.bzl", "go_proto_library")
+
+go_proto_library(
+    name = "go_default_library",
+    srcs = ["foo.proto"],
+)
+`,
+	}, {
+		desc: "go_proto_library macro not merged with go_proto_library rule",
+		previous: `
+load("@io_bazel_rules_go//proto:go_proto_library.bzl", "go_proto_library")
+
+go_proto_library(
+    name = "go_default_library",
+    srcs = ["foo.proto"],
+    has_services = 1,
+)
+`,
+		current: `
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+)
+
+go_proto_library(
+    name = "go_default_library",
+    compilers = ["@io_bazel_rules_go//proto:go_grpc"],
+    proto = ":foo_proto",
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//proto:go_proto_library.bzl", "go_proto_library")
+
+go_proto_library(
+    name = "go_default_library",
+    srcs = ["foo.proto"],
+    has_services = 1,
+)
+`,
+	}, {
+		desc: "hand-written proto rules for the same files kept instead of generated rules",
+		previous: `
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "my_proto",
+    srcs = ["foo.proto"],
+)
+
+go_proto_library(
+    name = "my_go_proto",
+    proto = ":my_proto",
+)
+`,
+		current: `
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+)
+
+go_proto_library(
+    name = "go_default_library",
+    proto = ":foo_proto",
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "my_proto",
+    srcs = ["foo.proto"],
+)
+
+go_proto_library(
+    name = "my_go_proto",
+    proto = ":my_proto",
+)
+`,
+	}, {
+		desc: "unrelated proto rules don't prevent generated rules",
+		previous: `
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "other_proto",
+    srcs = ["other.proto"],
+)
+
+go_proto_library(
+    name = "other_go_proto",
+    proto = ":other_proto",
+)
+`,
+		current: `
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+)
+
+go_proto_library(
+    name = "go_default_library",
+    proto = ":foo_proto",
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "other_proto",
+    srcs = ["other.proto"],
+)
+
+go_proto_library(
+    name = "other_go_proto",
+    proto = ":other_proto",
+)
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+)
+
+go_proto_library(
+    name = "go_default_library",
+    proto = ":foo_proto",
+)
 `,
 	},
 }
@@ -469,3 +625,234 @@ func TestMergeWithExistingDifferentName(t *testing.T) {
 		t.Errorf("got %q; want %q", got, want)
 	}
 }
+
+func TestMergeWithBaseline(t *testing.T) {
+	for _, tc := range []struct {
+		desc, previous, baseline, current, expected string
+	}{
+		{
+			desc: "user deletions respected",
+			previous: `
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+)
+`,
+			baseline: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "a.go",
+        "b.go",
+    ],
+    deps = ["//foo:go_default_library"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["a_test.go"],
+)
+`,
+			current: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "a.go",
+        "b.go",
+        "c.go",
+    ],
+    deps = ["//foo:go_default_library"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["a_test.go"],
+)
+`,
+			expected: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "a.go",
+        "c.go",
+    ],
+)
+`,
+		}, {
+			desc: "user additions kept without keep comments",
+			previous: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "a.go",
+        "gen.go",
+    ],
+    deps = ["//extra:go_default_library"],
+)
+`,
+			baseline: `
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+)
+`,
+			current: `
+go_library(
+    name = "go_default_library",
+    srcs = ["b.go"],
+)
+`,
+			expected: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "gen.go",
+        "b.go",
+    ],
+    deps = ["//extra:go_default_library"],
+)
+`,
+		}, {
+			desc: "stale rules removed and unedited attributes updated",
+			previous: `
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_binary(
+    name = "stale",
+    embed = [":go_default_library"],
+)
+
+go_binary(
+    name = "edited",
+    embed = [":go_default_library"],
+    x_defs = {"a": "b"},
+)
+`,
+			baseline: `
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_binary(
+    name = "stale",
+    embed = [":go_default_library"],
+)
+
+go_binary(
+    name = "edited",
+    embed = [":go_default_library"],
+)
+`,
+			current: `
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    visibility = ["//visibility:private"],
+)
+`,
+			expected: `
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    visibility = ["//visibility:private"],
+)
+
+go_binary(
+    name = "edited",
+    embed = [":go_default_library"],
+    x_defs = {"a": "b"},
+)
+`,
+		},
+	} {
+		var files [3]*bf.File
+		for i, content := range []string{tc.current, tc.previous, tc.baseline} {
+			f, err := bf.Parse(tc.desc, []byte(content))
+			if err != nil {
+				t.Fatalf("%s: %v", tc.desc, err)
+			}
+			files[i] = f
+		}
+		mergedFile := MergeWithBaseline(files[0], files[1], files[2])
+		want := strings.TrimPrefix(tc.expected, "\n")
+		if got := string(bf.Format(mergedFile)); got != want {
+			t.Errorf("%s: got %s; want %s", tc.desc, got, want)
+		}
+	}
+}
+
+func TestMergeData(t *testing.T) {
+	gen := []byte(`go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+)
+`)
+	old := []byte(`go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    visibility = ["//visibility:public"],
+)
+`)
+	ignored := []byte(`# gazelle:ignore
+
+go_library(
+    name = "go_default_library",
+)
+`)
+	for _, tc := range []struct {
+		desc     string
+		old      []byte
+		expected string
+	}{
+		{
+			desc: "no old file",
+			expected: `go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+)
+`,
+		}, {
+			desc: "merged",
+			old:  old,
+			expected: `go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			desc:     "ignored",
+			old:      ignored,
+			expected: string(ignored),
+		},
+	} {
+		got, err := MergeData("BUILD", gen, tc.old)
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if string(got) != tc.expected {
+			t.Errorf("%s: got %s; want %s", tc.desc, got, tc.expected)
+		}
+	}
+
+	if _, err := MergeData("BUILD", gen, []byte("go_library(")); err == nil {
+		t.Errorf("parse error: got nil error; want error")
+	}
+	for _, badGen := range []string{
+		"# A comment block on its own.\n",
+		"x = 1\n",
+	} {
+		for _, old := range [][]byte{nil, ignored} {
+			if _, err := MergeData("BUILD", []byte(badGen), old); err == nil {
+				t.Errorf("generated %q: got nil error; want error", badGen)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package merger
+
+import (
+	bf "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+// repoVersionAttrs are the attributes of go_repository that select
+// a revision. Only one of them may be set on a rule.
+var repoVersionAttrs = []string{"commit", "tag"}
+
+// MergeWorkspace merges the go_repository rules in "genRules" into the
+// WORKSPACE file "oldFile" and returns the merged file. "oldFile" is
+// not modified.
+//
+// Rules are matched by name. Attributes set in a generated rule replace
+// the same attributes in the matching existing rule; other attributes and
+// comments are preserved. If a generated rule sets a revision (commit or
+// tag), any other revision attribute is removed from the existing rule.
+// Generated rules without a match are inserted after the last go_repository
+// rule in the file, or at the end if there are none. When rules are
+// inserted, go_repository is added to the load of the Go rules if nothing
+// loads it yet, or a load is inserted before the first go_repository rule.
+// Existing go_repository rules named in "removeNames" are deleted. Rules
+// marked with "# keep" and all other statements are left alone.
+func MergeWorkspace(genRules []*bf.CallExpr, removeNames []string, oldFile *bf.File) *bf.File {
+	genByName := make(map[string]*bf.CallExpr)
+	for _, r := range genRules {
+		genByName[name(r)] = r
+	}
+	remove := make(map[string]bool)
+	for _, n := range removeNames {
+		remove[n] = true
+	}
+
+	mergedFile := *oldFile
+	mergedFile.Stmt = make([]bf.Expr, 0, len(oldFile.Stmt)+len(genRules))
+	seen := make(map[string]bool)
+	insertIndex := -1
+	for _, stmt := range oldFile.Stmt {
+		c, ok := stmt.(*bf.CallExpr)
+		if !ok || kind(c) != "go_repository" {
+			mergedFile.Stmt = append(mergedFile.Stmt, stmt)
+			continue
+		}
+		n := name(c)
+		seen[n] = true
+		if !shouldKeep(c) {
+			if remove[n] {
+				continue
+			}
+			if gen, ok := genByName[n]; ok {
+				stmt = mergeRepository(gen, c)
+			}
+		}
+		mergedFile.Stmt = append(mergedFile.Stmt, stmt)
+		insertIndex = len(mergedFile.Stmt)
+	}
+
+	var newStmt []bf.Expr
+	for _, r := range genRules {
+		if !seen[name(r)] {
+			newStmt = append(newStmt, r)
+		}
+	}
+	if len(newStmt) == 0 {
+		return &mergedFile
+	}
+	if insertIndex < 0 {
+		mergedFile.Stmt = append(mergedFile.Stmt, newStmt...)
+	} else {
+		tail := append(newStmt, mergedFile.Stmt[insertIndex:]...)
+		mergedFile.Stmt = append(mergedFile.Stmt[:insertIndex], tail...)
+	}
+	mergedFile.Stmt = loadRepository(mergedFile.Stmt)
+	return &mergedFile
+}
+
+// loadRepository returns stmts with go_repository loaded before it's
+// called. If no load has the symbol, it's added to the load of the Go
+// rules; if there is none, a load is inserted before the first
+// go_repository rule, since WORKSPACE can only load the Go rules after
+// io_bazel_rules_go is declared. Loads in stmts aren't modified; they're
+// replaced with copies.
+func loadRepository(stmts []bf.Expr) []bf.Expr {
+	defIndex := -1
+	repoIndex := -1
+	for i, stmt := range stmts {
+		c, ok := stmt.(*bf.CallExpr)
+		if !ok {
+			continue
+		}
+		if kind(c) == "go_repository" {
+			if repoIndex < 0 {
+				repoIndex = i
+			}
+			continue
+		}
+		if kind(c) != "load" || len(c.List) == 0 {
+			continue
+		}
+		for _, arg := range c.List[1:] {
+			if stringValue(arg) == "go_repository" {
+				return stmts
+			}
+		}
+		if defIndex < 0 && stringValue(c.List[0]) == config.RulesGoDefBzlLabel {
+			defIndex = i
+		}
+	}
+	if defIndex >= 0 {
+		old := stmts[defIndex].(*bf.CallExpr)
+		load := *old
+		load.List = append([]bf.Expr{old.List[0]}, appendLoadSymbols(append([]bf.Expr{}, old.List[1:]...), []string{"go_repository"})...)
+		stmts[defIndex] = &load
+		return stmts
+	}
+	if repoIndex < 0 {
+		return stmts
+	}
+	load := &bf.CallExpr{
+		X:            &bf.LiteralExpr{Token: "load"},
+		List:         []bf.Expr{&bf.StringExpr{Value: config.RulesGoDefBzlLabel}, &bf.StringExpr{Value: "go_repository"}},
+		ForceCompact: true,
+	}
+	tail := append([]bf.Expr{load}, stmts[repoIndex:]...)
+	return append(stmts[:repoIndex], tail...)
+}
+
+// mergeRepository combines the attributes of the go_repository rules gen
+// and old and returns an updated rule. Attributes in gen take precedence.
+// Comments on old attributes are preserved.
+func mergeRepository(gen, old *bf.CallExpr) *bf.CallExpr {
+	genRule := bf.Rule{Call: gen}
+	oldRule := bf.Rule{Call: old}
+	merged := *old
+	merged.List = nil
+	mergedRule := bf.Rule{Call: &merged}
+
+	genHasVersion := false
+	for _, k := range repoVersionAttrs {
+		if genRule.Attr(k) != nil {
+			genHasVersion = true
+		}
+	}
+	isVersionAttr := make(map[string]bool)
+	for _, k := range repoVersionAttrs {
+		isVersionAttr[k] = true
+	}
+
+	for _, k := range oldRule.AttrKeys() {
+		oldAttr := oldRule.AttrDefn(k)
+		if genExpr := genRule.Attr(k); genExpr != nil {
+			mergedAttr := *oldAttr
+			mergedAttr.Y = genExpr
+			merged.List = append(merged.List, &mergedAttr)
+			continue
+		}
+		if genHasVersion && isVersionAttr[k] {
+			continue
+		}
+		merged.List = append(merged.List, oldAttr)
+	}
+	for _, k := range genRule.AttrKeys() {
+		if mergedRule.Attr(k) == nil {
+			mergedRule.SetAttr(k, genRule.Attr(k))
+		}
+	}
+	return &merged
+}
@@ -0,0 +1,230 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package merger
+
+import (
+	"strings"
+	"testing"
+
+	bf "github.com/bazelbuild/buildtools/build"
+)
+
+func TestMergeWorkspace(t *testing.T) {
+	for _, tc := range []struct {
+		desc, gen, old, want string
+		remove               []string
+	}{
+		{
+			desc: "insert after last go_repository",
+			gen: `
+go_repository(
+    name = "org_golang_x_tools",
+    commit = "abc",
+    importpath = "golang.org/x/tools",
+)
+`,
+			old: `
+load("@io_bazel_rules_go//go:def.bzl", "go_repositories", "go_repository")
+
+# Dependencies
+go_repository(
+    name = "com_github_foo_bar",
+    importpath = "github.com/foo/bar",
+    tag = "v1.0",
+)
+
+go_repositories()
+`,
+			want: `
+load("@io_bazel_rules_go//go:def.bzl", "go_repositories", "go_repository")
+
+# Dependencies
+go_repository(
+    name = "com_github_foo_bar",
+    importpath = "github.com/foo/bar",
+    tag = "v1.0",
+)
+
+go_repository(
+    name = "org_golang_x_tools",
+    commit = "abc",
+    importpath = "golang.org/x/tools",
+)
+
+go_repositories()
+`,
+		}, {
+			desc: "insert at end",
+			gen: `
+go_repository(
+    name = "org_golang_x_tools",
+    commit = "abc",
+    importpath = "golang.org/x/tools",
+)
+`,
+			old: `
+workspace(name = "foo")
+
+http_archive(
+    name = "io_bazel_rules_go",
+    url = "https://example.com/rules_go.tar.gz",
+)
+`,
+			want: `
+workspace(name = "foo")
+
+http_archive(
+    name = "io_bazel_rules_go",
+    url = "https://example.com/rules_go.tar.gz",
+)
+
+load("@io_bazel_rules_go//go:def.bzl", "go_repository")
+
+go_repository(
+    name = "org_golang_x_tools",
+    commit = "abc",
+    importpath = "golang.org/x/tools",
+)
+`,
+		}, {
+			desc: "add to existing load",
+			gen: `
+go_repository(
+    name = "org_golang_x_tools",
+    commit = "abc",
+    importpath = "golang.org/x/tools",
+)
+`,
+			old: `
+load("@io_bazel_rules_go//go:def.bzl", "go_repositories")
+
+go_repositories()
+`,
+			want: `
+load("@io_bazel_rules_go//go:def.bzl", "go_repositories", "go_repository")
+
+go_repositories()
+
+go_repository(
+    name = "org_golang_x_tools",
+    commit = "abc",
+    importpath = "golang.org/x/tools",
+)
+`,
+		}, {
+			desc: "update",
+			gen: `
+go_repository(
+    name = "com_github_foo_bar",
+    commit = "def",
+    importpath = "github.com/foo/bar",
+)
+`,
+			old: `
+go_repository(
+    name = "com_github_foo_bar",
+    build_file_name = "BUILD.bazel",  # comment
+    importpath = "github.com/foo/bar",
+    tag = "v1.0",
+)
+`,
+			want: `
+go_repository(
+    name = "com_github_foo_bar",
+    build_file_name = "BUILD.bazel",  # comment
+    importpath = "github.com/foo/bar",
+    commit = "def",
+)
+`,
+		}, {
+			desc: "remove",
+			old: `
+go_repository(
+    name = "com_github_foo_bar",
+    commit = "abc",
+    importpath = "github.com/foo/bar",
+)
+
+# Comment on baz
+go_repository(
+    name = "com_github_foo_baz",
+    commit = "abc",
+    importpath = "github.com/foo/baz",
+)
+
+# Unrelated comment
+`,
+			remove: []string{"com_github_foo_bar"},
+			want: `
+# Comment on baz
+go_repository(
+    name = "com_github_foo_baz",
+    commit = "abc",
+    importpath = "github.com/foo/baz",
+)
+
+# Unrelated comment
+`,
+		}, {
+			desc: "keep",
+			gen: `
+go_repository(
+    name = "com_github_foo_bar",
+    commit = "def",
+    importpath = "github.com/foo/bar",
+)
+`,
+			old: `
+go_repository(
+    name = "com_github_foo_bar",
+    commit = "abc",
+    importpath = "github.com/foo/bar",
+)  # keep
+`,
+			remove: []string{"com_github_foo_bar"},
+			want: `
+go_repository(
+    name = "com_github_foo_bar",
+    commit = "abc",
+    importpath = "github.com/foo/bar",
+)  # keep
+`,
+		},
+	} {
+		genFile, err := bf.Parse("gen", []byte(tc.gen))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		var genRules []*bf.CallExpr
+		for _, s := range genFile.Stmt {
+			genRules = append(genRules, s.(*bf.CallExpr))
+		}
+		oldFile, err := bf.Parse("WORKSPACE", []byte(tc.old))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		oldContent := string(bf.Format(oldFile))
+
+		mergedFile := MergeWorkspace(genRules, tc.remove, oldFile)
+		want := strings.TrimPrefix(tc.want, "\n")
+		if got := string(bf.Format(mergedFile)); got != want {
+			t.Errorf("%s: got %s; want %s", tc.desc, got, want)
+		}
+		if got := string(bf.Format(oldFile)); got != oldContent {
+			t.Errorf("%s: old file was modified: got %s; want %s", tc.desc, got, oldContent)
+		}
+	}
+}
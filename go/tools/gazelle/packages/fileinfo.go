@@ -24,8 +24,10 @@ import (
 	"go/parser"
 	"go/token"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"unicode"
@@ -62,6 +64,14 @@ type fileInfo struct {
 	// isCgo is true for .go files that import "C".
 	isCgo bool
 
+	// isGenerated is true for .go files with a "// Code generated ...
+	// DO NOT EDIT." comment before the package declaration.
+	isGenerated bool
+
+	// hasMainFunc is true for non-test .go files in package main that
+	// declare a main function.
+	hasMainFunc bool
+
 	// goos and goarch contain the OS and architecture suffixes in the filename,
 	// if they were present.
 	goos, goarch string
@@ -70,9 +80,29 @@ type fileInfo struct {
 	// a line after a "+build" prefix.
 	tags []string
 
+	// goBuild is the trimmed text after a "//go:build" prefix, if there was
+	// one. When it is set, tags are ignored, as in go/build.
+	goBuild string
+
 	// copts and clinkopts contain flags that are part of CFLAGS, CPPFLAGS,
-	// CXXFLAGS, and LDFLAGS directives in cgo comments.
+	// CXXFLAGS, and LDFLAGS directives in cgo comments. Flags printed by
+	// pkg-config are included if c.RunPkgConfig is set.
 	copts, clinkopts []taggedOpts
+
+	// cdeps contains labels of cc_library rules for packages named in
+	// pkg-config directives in cgo comments. Labels are taken from
+	// c.PkgConfigLabels.
+	cdeps []taggedOpts
+
+	// includes is a list of paths named in #include "..." directives in
+	// assembly and header files. Paths in angle brackets are not included.
+	includes []string
+
+	// protoImports is a list of files imported by a .proto file.
+	protoImports []string
+
+	// hasServices is true for .proto files that define a service.
+	hasServices bool
 }
 
 // taggedOpts a list of compile or link options which should only be applied
@@ -96,7 +126,7 @@ const (
 	// goExt is applied to .go files.
 	goExt
 
-	// cExt is applied to C and C++ files.
+	// cExt is applied to C, C++, Objective-C, and Objective-C++ files.
 	cExt
 
 	// hExt is applied to header files. If cgo code is present, these may be
@@ -112,6 +142,10 @@ const (
 
 	// protoExt is applied to .proto files.
 	protoExt
+
+	// sysoExt is applied to pre-built object files ending with .syso. These
+	// are packed into the library archive and linked into binaries.
+	sysoExt
 )
 
 // fileNameInfo returns information that can be inferred from the name of
@@ -124,7 +158,7 @@ func fileNameInfo(dir, name string) fileInfo {
 	switch ext {
 	case ".go":
 		category = goExt
-	case ".c", ".cc", ".cpp", ".cxx":
+	case ".c", ".cc", ".cpp", ".cxx", ".m", ".mm":
 		category = cExt
 	case ".h", ".hh", ".hpp", ".hxx":
 		category = hExt
@@ -134,19 +168,26 @@ func fileNameInfo(dir, name string) fileInfo {
 		category = csExt
 	case ".proto":
 		category = protoExt
-	case ".m", ".f", ".F", ".for", ".f90", ".swig", ".swigcxx", ".syso":
+	case ".syso":
+		category = sysoExt
+	case ".f", ".F", ".for", ".f90", ".swig", ".swigcxx":
 		category = unsupportedExt
 	default:
 		category = ignoredExt
 	}
 
 	// Determine test, goos, and goarch. This is intended to match the logic
-	// in goodOSArchFile in go/build.
-	var isTest bool
+	// in goodOSArchFile in go/build. Like go/build, we ignore everything after
+	// the first dot when looking for suffixes, so "foo_linux.pb.go" is only
+	// built on linux.
+	isTest := category == goExt && strings.HasSuffix(name, "_test.go")
 	var goos, goarch string
-	l := strings.Split(name[:len(name)-len(ext)], "_")
+	stem := name
+	if dot := strings.Index(stem, "."); dot >= 0 {
+		stem = stem[:dot]
+	}
+	l := strings.Split(stem, "_")
 	if len(l) >= 2 && l[len(l)-1] == "test" {
-		isTest = category == goExt
 		l = l[:len(l)-1]
 	}
 	switch {
@@ -183,6 +224,7 @@ func goFileInfo(c *config.Config, dir, name string) (fileInfo, error) {
 	}
 
 	info.packageName = pf.Name.Name
+	info.isGenerated = hasGeneratedComment(pf)
 	if info.isTest && strings.HasSuffix(info.packageName, "_test") {
 		info.isXTest = true
 		info.packageName = info.packageName[:len(info.packageName)-len("_test")]
@@ -205,38 +247,58 @@ func goFileInfo(c *config.Config, dir, name string) (fileInfo, error) {
 			}
 
 			if path == "C" {
-				if info.isTest {
-					return fileInfo{}, fmt.Errorf("%s: use of cgo in test not supported", info.path)
-				}
 				info.isCgo = true
 				cg := spec.Doc
 				if cg == nil && len(d.Specs) == 1 {
 					cg = d.Doc
 				}
 				if cg != nil {
-					if err := saveCgo(&info, cg); err != nil {
+					if err := saveCgo(c, &info, cg, cgoSrcDir(c, info.dir)); err != nil {
 						return fileInfo{}, err
 					}
 				}
-			} else if !isStandard(c.GoPrefix, path) {
+			} else if _, inModule := c.ModuleForImportPath(path); inModule || !isStandardImport(c, path) {
 				info.imports = append(info.imports, path)
 			}
 		}
 	}
 
-	tags, err := readTags(info.path)
+	if info.packageName == "main" && !info.isTest {
+		// Only imports were parsed above. Parse the rest of the file to find
+		// out whether it declares main. This is only needed for commands.
+		// Since the imports parsed, an error here is in a later declaration,
+		// which the compiler will report. Assume main is declared then, so
+		// the file and binary aren't dropped.
+		pf, err := parser.ParseFile(fset, info.path, nil, 0)
+		info.hasMainFunc = err != nil || hasMainFunc(pf)
+	}
+
+	tags, goBuild, err := readTags(info.path)
 	if err != nil {
 		return fileInfo{}, err
 	}
 	info.tags = tags
+	info.goBuild = goBuild
 
 	return info, nil
 }
 
+// hasMainFunc returns whether the file "pf" declares a function named main
+// without a receiver.
+func hasMainFunc(pf *ast.File) bool {
+	for _, decl := range pf.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Recv == nil && fd.Name.Name == "main" {
+			return true
+		}
+	}
+	return false
+}
+
 // saveCgo extracts CFLAGS, CPPFLAGS, CXXFLAGS, and LDFLAGS directives
 // from a comment above a "C" import. This is intended to match logic in
-// go/build.Context.saveCgo.
-func saveCgo(info *fileInfo, cg *ast.CommentGroup) error {
+// go/build.Context.saveCgo. ${SRCDIR} is replaced with srcDir. pkg-config
+// directives are translated with translatePkgConfig.
+func saveCgo(c *config.Config, info *fileInfo, cg *ast.CommentGroup, srcDir string) error {
 	text := cg.Text()
 	for _, line := range strings.Split(text, "\n") {
 		orig := line
@@ -270,9 +332,15 @@ func saveCgo(info *fileInfo, cg *ast.CommentGroup) error {
 		if err != nil {
 			return fmt.Errorf("%s: invalid #cgo line: %s", info.path, orig)
 		}
+		if verb == "pkg-config" {
+			if err := translatePkgConfig(c, info, tags, opts); err != nil {
+				return fmt.Errorf("%s: %v: %s", info.path, err, orig)
+			}
+			continue
+		}
 		var ok bool
 		for i, opt := range opts {
-			if opt, ok = expandSrcDir(opt, info.dir); !ok {
+			if opt, ok = expandSrcDir(opt, srcDir); !ok {
 				return fmt.Errorf("%s: malformed #cgo argument: %s", info.path, orig)
 			}
 			opts[i] = opt
@@ -284,8 +352,6 @@ func saveCgo(info *fileInfo, cg *ast.CommentGroup) error {
 			info.copts = append(info.copts, taggedOpts{tags, opts})
 		case "LDFLAGS":
 			info.clinkopts = append(info.clinkopts, taggedOpts{tags, opts})
-		case "pkg-config":
-			return fmt.Errorf("%s: pkg-config not supported: %s", info.path, orig)
 		default:
 			return fmt.Errorf("%s: invalid #cgo verb: %s", info.path, orig)
 		}
@@ -293,6 +359,79 @@ func saveCgo(info *fileInfo, cg *ast.CommentGroup) error {
 	return nil
 }
 
+// translatePkgConfig adds the pkg-config packages in pkgs to info. Packages
+// with labels in c.PkgConfigLabels are added to cdeps. Other packages are
+// passed to pkg-config if c.RunPkgConfig is set, and the flags it prints are
+// added to copts and clinkopts. Otherwise, an error is returned. tags are
+// the build constraints for the directive.
+func translatePkgConfig(c *config.Config, info *fileInfo, tags string, pkgs []string) error {
+	var labels, unmapped []string
+	for _, pkg := range pkgs {
+		if label, ok := c.PkgConfigLabels[pkg]; ok {
+			labels = append(labels, label)
+		} else {
+			unmapped = append(unmapped, pkg)
+		}
+	}
+	if len(labels) > 0 {
+		info.cdeps = append(info.cdeps, taggedOpts{tags, labels})
+	}
+	if len(unmapped) == 0 {
+		return nil
+	}
+	if !c.RunPkgConfig {
+		return fmt.Errorf("no labels for pkg-config packages %s; set -pkg_config_labels or -run_pkg_config", strings.Join(unmapped, ", "))
+	}
+	cflags, err := runPkgConfig("--cflags", unmapped)
+	if err != nil {
+		return err
+	}
+	ldflags, err := runPkgConfig("--libs", unmapped)
+	if err != nil {
+		return err
+	}
+	if len(cflags) > 0 {
+		info.copts = append(info.copts, taggedOpts{tags, cflags})
+	}
+	if len(ldflags) > 0 {
+		info.clinkopts = append(info.clinkopts, taggedOpts{tags, ldflags})
+	}
+	return nil
+}
+
+// runPkgConfig runs pkg-config with the given flag for the packages in pkgs
+// and returns the flags it prints. This is a variable so tests can
+// replace it.
+var runPkgConfig = func(flag string, pkgs []string) ([]string, error) {
+	args := append([]string{flag, "--"}, pkgs...)
+	out, err := exec.Command("pkg-config", args...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return nil, fmt.Errorf("pkg-config %s: %v\n%s", strings.Join(args, " "), err, ee.Stderr)
+		}
+		return nil, fmt.Errorf("pkg-config %s: %v", strings.Join(args, " "), err)
+	}
+	return splitQuoted(string(out))
+}
+
+// cgoSrcDir returns the path that ${SRCDIR} in cgo flags should expand to
+// for files in dir. The go tool uses the absolute path of the directory.
+// Bazel runs compilers and linkers in the execution root, so this is the
+// path of dir relative to the execution root: the path relative to the
+// repository root, prefixed with "external/<name>" for external
+// repositories. If dir is not in the repository, it is returned unchanged.
+func cgoSrcDir(c *config.Config, dir string) string {
+	rel, err := filepath.Rel(c.RepoRoot, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return dir
+	}
+	rel = filepath.ToSlash(rel)
+	if c.RepoName != "" {
+		rel = path.Join("external", c.RepoName, rel)
+	}
+	return rel
+}
+
 // splitQuoted splits the string s around each instance of one or more consecutive
 // white space characters while taking into account quotes and escaping, and
 // returns an array of substrings of s or an empty list if s contains only white space.
@@ -406,12 +545,42 @@ func safeCgoName(s string, spaces bool) bool {
 	return true
 }
 
+// isStandardImport determines if importpath is a standard library package of
+// the Go SDK described by c. When the SDK's packages aren't known, it's
+// guessed from the import path.
+func isStandardImport(c *config.Config, importpath string) bool {
+	if c.StdPackages != nil {
+		return c.StdPackages[importpath]
+	}
+	return isStandard(c.GoPrefix, importpath)
+}
+
 // isStandard determines if importpath points a Go standard package.
 func isStandard(goPrefix, importpath string) bool {
 	seg := strings.SplitN(importpath, "/", 2)[0]
 	return !strings.Contains(seg, ".") && !strings.HasPrefix(importpath, goPrefix+"/")
 }
 
+// generatedRe matches the comment that marks a file as generated, following
+// the convention in https://golang.org/s/generatedcode.
+var generatedRe = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// hasGeneratedComment returns whether a comment before the package
+// declaration in pf marks the file as generated.
+func hasGeneratedComment(pf *ast.File) bool {
+	for _, cg := range pf.Comments {
+		if cg.Pos() > pf.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if generatedRe.MatchString(c.Text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // otherFileInfo returns information about a non-.go file. It will parse
 // part of the file to determine build tags.
 func otherFileInfo(dir, name string) (fileInfo, error) {
@@ -422,11 +591,32 @@ func otherFileInfo(dir, name string) (fileInfo, error) {
 	if info.category == unsupportedExt {
 		return fileInfo{}, fmt.Errorf("%s: file extension not yet supported", name)
 	}
+	if info.category == sysoExt {
+		// Like go/build, only the file name is used to select .syso files.
+		// They are binary files without build constraints.
+		return info, nil
+	}
 
-	if tags, err := readTags(info.path); err != nil {
+	if tags, goBuild, err := readTags(info.path); err != nil {
 		return fileInfo{}, err
 	} else {
 		info.tags = tags
+		info.goBuild = goBuild
+	}
+	if info.category == sExt || info.category == csExt || info.category == hExt {
+		includes, err := readIncludes(info.path)
+		if err != nil {
+			return fileInfo{}, err
+		}
+		info.includes = includes
+	}
+	if info.category == protoExt {
+		imports, hasServices, err := readProto(info.path)
+		if err != nil {
+			return fileInfo{}, err
+		}
+		info.protoImports = imports
+		info.hasServices = hasServices
 	}
 	return info, nil
 }
@@ -450,12 +640,14 @@ func init() {
 // readTags reads and extracts build tags from the block of comments and
 // newlines and blank lines at the start of a file which is separated from the
 // rest of the file by a blank line. Each string in the returned slice is
-// the trimmed text of a line after a "+build" prefix.
+// the trimmed text of a line after a "+build" prefix. The returned string is
+// the trimmed text of the first line after a "//go:build" prefix, or "" if
+// there is no such line.
 // Based on go/build.Context.shouldBuild.
-func readTags(path string) ([]string, error) {
+func readTags(path string) ([]string, string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer f.Close()
 	scanner := bufio.NewScanner(f)
@@ -477,33 +669,81 @@ func readTags(path string) ([]string, error) {
 		break
 	}
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	lines = lines[:end]
 
 	// Pass 2: Process each line in the run.
 	var buildComments []string
+	var goBuild string
 	for _, line := range lines {
+		if strings.HasPrefix(line, goBuildPrefix) {
+			if goBuild == "" {
+				goBuild = strings.TrimSpace(line[len(goBuildPrefix):])
+			}
+			continue
+		}
 		fields := strings.Fields(line)
 		if len(fields) > 0 && fields[0] == "+build" {
 			buildComments = append(buildComments, strings.Join(fields[1:], " "))
 		}
 	}
-	return buildComments, nil
+	return buildComments, goBuild, nil
+}
+
+// readIncludes returns the paths named in #include "..." directives in the
+// file at "path". Like the C preprocessor, whitespace is allowed after "#".
+func readIncludes(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var includes []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(line[len("#"):])
+		if !strings.HasPrefix(line, "include") {
+			continue
+		}
+		line = strings.TrimSpace(line[len("include"):])
+		if len(line) < 2 || line[0] != '"' {
+			continue
+		}
+		if end := strings.IndexByte(line[1:], '"'); end >= 0 {
+			includes = append(includes, line[1:end+1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return includes, nil
 }
 
+// goBuildPrefix starts a "//go:build" line, after the comment marker has been
+// removed. Unlike "+build", no space is allowed after the comment marker.
+const goBuildPrefix = "go:build "
+
 // hasConstraints returns true if a file has goos, goarch filename suffixes
 // or build tags.
 func (fi *fileInfo) hasConstraints() bool {
-	return fi.goos != "" || fi.goarch != "" || len(fi.tags) > 0
+	return fi.goos != "" || fi.goarch != "" || len(fi.tags) > 0 || fi.goBuild != ""
 }
 
 // checkConstraints determines whether a file should be built on a platform
 // with the given tags. It returns true for files without constraints.
 func (fi *fileInfo) checkConstraints(tags map[string]bool) bool {
-	// TODO: linux should match on android.
 	if fi.goos != "" {
-		if _, ok := tags[fi.goos]; !ok {
+		_, ok := tags[fi.goos]
+		if !ok && fi.goos == "linux" {
+			// As in go/build, files for linux are also built for android.
+			_, ok = tags["android"]
+		}
+		if !ok {
 			return false
 		}
 	}
@@ -513,6 +753,9 @@ func (fi *fileInfo) checkConstraints(tags map[string]bool) bool {
 		}
 	}
 
+	if fi.goBuild != "" {
+		return checkGoBuild(fi.goBuild, tags)
+	}
 	for _, line := range fi.tags {
 		if !checkTags(line, tags) {
 			return false
@@ -539,9 +782,9 @@ func checkTags(line string, tags map[string]bool) bool {
 			if not {
 				tag = tag[1:]
 			}
-			if isReleaseTag(tag) {
-				// Release tags are treated as "unknown" and are considered true,
-				// whether or not they are negated.
+			if isReleaseTag(tag) && !hasReleaseTags(tags) {
+				// Without a configured Go version, release tags are treated as
+				// "unknown" and are considered true, whether or not they are negated.
 				continue
 			}
 			_, ok := tags[tag]
@@ -552,6 +795,129 @@ func checkTags(line string, tags map[string]bool) bool {
 	return lineOk
 }
 
+// checkGoBuild determines whether the expression on a "//go:build" line is
+// satisfied. The expression may combine tags with "!", "&&", "||", and
+// parentheses. As in checkTags, release tags are considered true whether or
+// not they are negated if tags contains no release tags. Malformed
+// expressions are never satisfied.
+func checkGoBuild(expr string, tags map[string]bool) bool {
+	tokens, ok := tokenizeGoBuild(expr)
+	if !ok || len(tokens) == 0 {
+		return false
+	}
+	p := goBuildParser{tokens: tokens, tags: tags}
+	v, ok := p.or()
+	return ok && p.pos == len(p.tokens) && v
+}
+
+// tokenizeGoBuild splits a "//go:build" expression into tags and operators.
+// It returns false if the expression contains an invalid character.
+func tokenizeGoBuild(expr string) ([]string, bool) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')' || c == '!':
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case isTagChar(c):
+			j := i
+			for j < len(expr) && isTagChar(expr[j]) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		default:
+			return nil, false
+		}
+	}
+	return tokens, true
+}
+
+func isTagChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '.'
+}
+
+// goBuildParser evaluates a tokenized "//go:build" expression with recursive
+// descent. Each method returns the value of the expression it parsed and
+// false if the expression is malformed.
+type goBuildParser struct {
+	tokens []string
+	pos    int
+	tags   map[string]bool
+}
+
+func (p *goBuildParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *goBuildParser) or() (bool, bool) {
+	v, ok := p.and()
+	for ok && p.peek() == "||" {
+		p.pos++
+		var w bool
+		w, ok = p.and()
+		v = v || w
+	}
+	return v, ok
+}
+
+func (p *goBuildParser) and() (bool, bool) {
+	v, ok := p.not()
+	for ok && p.peek() == "&&" {
+		p.pos++
+		var w bool
+		w, ok = p.not()
+		v = v && w
+	}
+	return v, ok
+}
+
+func (p *goBuildParser) not() (bool, bool) {
+	switch tok := p.peek(); tok {
+	case "!":
+		p.pos++
+		if tag := p.peek(); isReleaseTag(tag) && !hasReleaseTags(p.tags) {
+			p.pos++
+			return true, true
+		}
+		v, ok := p.not()
+		return !v, ok
+	case "(":
+		p.pos++
+		v, ok := p.or()
+		if !ok || p.peek() != ")" {
+			return false, false
+		}
+		p.pos++
+		return v, true
+	case "", ")", "&&", "||":
+		return false, false
+	default:
+		p.pos++
+		if isReleaseTag(tok) && !hasReleaseTags(p.tags) {
+			return true, true
+		}
+		_, ok := p.tags[tok]
+		return ok, true
+	}
+}
+
+// hasReleaseTags returns whether tags contains release tags. This is true
+// when a Go version is configured, since every version of Go satisfies
+// "go1.1".
+func hasReleaseTags(tags map[string]bool) bool {
+	_, ok := tags["go1.1"]
+	return ok
+}
+
 // isReleaseTag returns whether the tag matches the pattern "go[0-9]\.[0-9]+".
 func isReleaseTag(tag string) bool {
 	if len(tag) < 5 || !strings.HasPrefix(tag, "go") {
@@ -567,3 +933,32 @@ func isReleaseTag(tag string) bool {
 	}
 	return true
 }
+
+var (
+	protoImportRe  = regexp.MustCompile(`^\s*import\s+(?:public\s+|weak\s+)?"([^"]+)"\s*;`)
+	protoServiceRe = regexp.MustCompile(`^\s*service\s+\w+`)
+)
+
+// readProto returns the files imported by the .proto file at "path" and
+// whether it defines any services. Only the start of each line is
+// examined; this is enough for files formatted in the usual way.
+func readProto(path string) (imports []string, hasServices bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := protoImportRe.FindStringSubmatch(line); match != nil {
+			imports = append(imports, match[1])
+		} else if protoServiceRe.MatchString(line) {
+			hasServices = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	return imports, hasServices, nil
+}
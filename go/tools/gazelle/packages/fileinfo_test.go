@@ -61,6 +61,19 @@ func TestGoFileInfo(t *testing.T) {
 				isXTest:     false,
 			},
 		},
+		{
+			"cgo in test",
+			"foo_test.go",
+			`package foo
+
+import "C"
+`,
+			fileInfo{
+				packageName: "foo",
+				isTest:      true,
+				isCgo:       true,
+			},
+		},
 		{
 			"single import",
 			"foo.go",
@@ -141,6 +154,41 @@ package route
 				tags:        []string{"darwin dragonfly freebsd netbsd openbsd"},
 			},
 		},
+		{
+			"generated",
+			"foo.pb.go",
+			`// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: foo.proto
+
+package foo
+`,
+			fileInfo{
+				packageName: "foo",
+				isGenerated: true,
+			},
+		},
+		{
+			"generated comment after package",
+			"foo.go",
+			`package foo
+
+// Code generated by hand. DO NOT EDIT.
+`,
+			fileInfo{
+				packageName: "foo",
+			},
+		},
+		{
+			"generated comment without period",
+			"foo.go",
+			`// Code generated by protoc-gen-go. DO NOT EDIT
+
+package foo
+`,
+			fileInfo{
+				packageName: "foo",
+			},
+		},
 	} {
 		if err := ioutil.WriteFile(tc.name, []byte(tc.source), 0600); err != nil {
 			t.Fatal(err)
@@ -159,6 +207,7 @@ package route
 			isXTest:     got.isXTest,
 			imports:     got.imports,
 			isCgo:       got.isCgo,
+			isGenerated: got.isGenerated,
 			tags:        got.tags,
 		}
 
@@ -190,15 +239,6 @@ import "C"
 `,
 			"invalid #cgo line",
 		},
-		{
-			"cgo in test",
-			"foo_test.go",
-			`package foo
-
-import "C"
-`,
-			"use of cgo in test not supported",
-		},
 	} {
 		if err := ioutil.WriteFile(tc.name, []byte(tc.source), 0600); err != nil {
 			t.Fatal(err)
@@ -216,6 +256,51 @@ import "C"
 	}
 }
 
+func TestGoFileInfoMainFunc(t *testing.T) {
+	c := &config.Config{}
+	dir := "."
+	for _, tc := range []struct {
+		desc, source string
+		want         bool
+	}{
+		{
+			"main",
+			"package main\n\nfunc main() {}\n",
+			true,
+		},
+		{
+			"method named main",
+			"package main\n\ntype T struct{}\n\nfunc (T) main() {}\n",
+			false,
+		},
+		{
+			"syntax error after imports",
+			`package main
+
+import "fmt"
+
+func main() {
+	fmt.Println(
+}
+`,
+			true,
+		},
+	} {
+		if err := ioutil.WriteFile("main.go", []byte(tc.source), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := goFileInfo(c, dir, "main.go")
+		os.Remove("main.go")
+		if err != nil {
+			t.Errorf("case %q: %v", tc.desc, err)
+			continue
+		}
+		if got.hasMainFunc != tc.want {
+			t.Errorf("case %q: got hasMainFunc %v; want %v", tc.desc, got.hasMainFunc, tc.want)
+		}
+	}
+}
+
 func TestOtherFileInfo(t *testing.T) {
 	dir := "."
 	for _, tc := range []struct {
@@ -237,6 +322,14 @@ func TestOtherFileInfo(t *testing.T) {
 `,
 			[]string{"foo bar", "baz,!ignore"},
 		},
+		{
+			"syso file",
+			"foo.syso",
+			`// +build foo
+
+`,
+			nil,
+		},
 	} {
 		if err := ioutil.WriteFile(tc.name, []byte(tc.source), 0600); err != nil {
 			t.Fatal(err)
@@ -269,7 +362,7 @@ func TestOtherFileInfoFailures(t *testing.T) {
 		},
 		{
 			"unsupported file",
-			"foo.m",
+			"foo.f",
 			"",
 			"file extension not yet supported",
 		},
@@ -387,6 +480,25 @@ func TestFileNameInfo(t *testing.T) {
 				goos:     "linux",
 			},
 		},
+		{
+			"goos with extra extension",
+			"foo_linux.pb.go",
+			fileInfo{
+				ext:      ".go",
+				category: goExt,
+				goos:     "linux",
+			},
+		},
+		{
+			"goos goarch test with extra extension",
+			"foo_linux_amd64_test.pb.go",
+			fileInfo{
+				ext:      ".go",
+				category: goExt,
+				goos:     "linux",
+				goarch:   "amd64",
+			},
+		},
 		{
 			"goos source",
 			"linux.go",
@@ -461,10 +573,37 @@ func TestFileNameInfo(t *testing.T) {
 			},
 		},
 		{
-			"unsupported file",
-			"foo.m",
+			"objective-c file",
+			"foo_darwin.m",
 			fileInfo{
 				ext:      ".m",
+				category: cExt,
+				goos:     "darwin",
+			},
+		},
+		{
+			"objective-c++ file",
+			"foo.mm",
+			fileInfo{
+				ext:      ".mm",
+				category: cExt,
+			},
+		},
+		{
+			"syso file with goos and goarch",
+			"rsrc_windows_amd64.syso",
+			fileInfo{
+				ext:      ".syso",
+				category: sysoExt,
+				goos:     "windows",
+				goarch:   "amd64",
+			},
+		},
+		{
+			"unsupported file",
+			"foo.f",
+			fileInfo{
+				ext:      ".f",
 				category: unsupportedExt,
 			},
 		},
@@ -642,10 +781,19 @@ import "C"
 			"unsupported cgo verb",
 			`package foo
 
+// #cgo FOOFLAGS: foo
+import "C"
+`,
+			"invalid #cgo verb",
+		},
+		{
+			"pkg-config package without label",
+			`package foo
+
 // #cgo pkg-config: foo
 import "C"
 `,
-			"not supported",
+			"no labels for pkg-config packages foo",
 		},
 		{
 			"bad cgo quoting",
@@ -674,6 +822,105 @@ import "C"
 	}
 }
 
+func TestCgoPkgConfig(t *testing.T) {
+	ranPkgConfig := false
+	defer func(old func(string, []string) ([]string, error)) { runPkgConfig = old }(runPkgConfig)
+	runPkgConfig = func(flag string, pkgs []string) ([]string, error) {
+		ranPkgConfig = true
+		if !reflect.DeepEqual(pkgs, []string{"zstd"}) {
+			t.Errorf("pkg-config %s: got packages %q; want [zstd]", flag, pkgs)
+		}
+		if flag == "--cflags" {
+			return []string{"-I/usr/include/zstd"}, nil
+		}
+		return []string{"-lzstd"}, nil
+	}
+
+	source := `package foo
+
+// #cgo pkg-config: sqlite3
+// #cgo linux pkg-config: zstd
+import "C"
+`
+	path := "TestCgoPkgConfig.go"
+	if err := ioutil.WriteFile(path, []byte(source), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	c := &config.Config{
+		PkgConfigLabels: map[string]string{"sqlite3": "//third_party/sqlite"},
+		RunPkgConfig:    true,
+	}
+	got, err := goFileInfo(c, ".", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = fileInfo{isCgo: got.isCgo, copts: got.copts, clinkopts: got.clinkopts, cdeps: got.cdeps}
+	want := fileInfo{
+		isCgo:     true,
+		copts:     []taggedOpts{{tags: "linux", opts: []string{"-I/usr/include/zstd"}}},
+		clinkopts: []taggedOpts{{tags: "linux", opts: []string{"-lzstd"}}},
+		cdeps:     []taggedOpts{{opts: []string{"//third_party/sqlite"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+	if !ranPkgConfig {
+		t.Errorf("pkg-config was not run")
+	}
+}
+
+func TestCgoSrcDir(t *testing.T) {
+	repoRoot := filepath.Join(string(filepath.Separator)+"projects", "repo")
+	for _, tc := range []struct {
+		desc, repoName, dir, want string
+	}{
+		{"root", "", repoRoot, "."},
+		{"subdir", "", filepath.Join(repoRoot, "foo", "bar"), "foo/bar"},
+		{"external root", "com_example_repo", repoRoot, "external/com_example_repo"},
+		{"external subdir", "com_example_repo", filepath.Join(repoRoot, "foo"), "external/com_example_repo/foo"},
+		{"outside", "", filepath.Join(string(filepath.Separator)+"projects", "other"), filepath.Join(string(filepath.Separator)+"projects", "other")},
+	} {
+		c := &config.Config{RepoRoot: repoRoot, RepoName: tc.repoName}
+		if got := cgoSrcDir(c, tc.dir); got != tc.want {
+			t.Errorf("%s: got %q; want %q", tc.desc, got, tc.want)
+		}
+	}
+
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "TestCgoSrcDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pkgDir := filepath.Join(dir, "sqlite")
+	if err := os.Mkdir(pkgDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	content := `package sqlite
+
+// #cgo CFLAGS: -I${SRCDIR}/include
+// #cgo linux LDFLAGS: -L${SRCDIR}/lib -lsqlite3
+import "C"
+`
+	if err := ioutil.WriteFile(filepath.Join(pkgDir, "sqlite.go"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := &config.Config{RepoRoot: dir}
+	info, err := goFileInfo(c, pkgDir, "sqlite.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCopts := []taggedOpts{{opts: []string{"-Isqlite/include"}}}
+	wantClinkopts := []taggedOpts{{tags: "linux", opts: []string{"-Lsqlite/lib", "-lsqlite3"}}}
+	if !reflect.DeepEqual(info.copts, wantCopts) {
+		t.Errorf("got copts %#v; want %#v", info.copts, wantCopts)
+	}
+	if !reflect.DeepEqual(info.clinkopts, wantClinkopts) {
+		t.Errorf("got clinkopts %#v; want %#v", info.clinkopts, wantClinkopts)
+	}
+}
+
 // Copied from go/build build_test.go
 var (
 	expandSrcDirPath = filepath.Join(string(filepath.Separator)+"projects", "src", "add")
@@ -756,20 +1003,54 @@ func TestIsStandard(t *testing.T) {
 	}
 }
 
+func TestStdPackages(t *testing.T) {
+	source := `package foo
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"internal/testenv"
+	"mycorp/lib"
+)
+`
+	path := "TestStdPackages.go"
+	if err := ioutil.WriteFile(path, []byte(source), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	c := &config.Config{
+		StdPackages: map[string]bool{"fmt": true, "internal/testenv": true},
+	}
+	got, err := goFileInfo(c, ".", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Imports without dots aren't assumed to be standard when the SDK's
+	// packages are known.
+	want := []string{"golang.org/x/net/context", "mycorp/lib"}
+	if !reflect.DeepEqual(got.imports, want) {
+		t.Errorf("got %q; want %q", got.imports, want)
+	}
+}
+
 func TestReadTags(t *testing.T) {
 	for _, tc := range []struct {
 		desc, source string
 		want         []string
+		wantGoBuild  string
 	}{
 		{
 			"empty file",
 			"",
 			nil,
+			"",
 		},
 		{
 			"single comment without blank line",
 			"// +build foo\npackage main",
 			nil,
+			"",
 		},
 		{
 			"multiple comments without blank link",
@@ -780,11 +1061,13 @@ package main
 
 `,
 			[]string{"foo"},
+			"",
 		},
 		{
 			"single comment",
 			"// +build foo\n\n",
 			[]string{"foo"},
+			"",
 		},
 		{
 			"multiple comments",
@@ -793,6 +1076,7 @@ package main
 
 package main`,
 			[]string{"foo", "bar"},
+			"",
 		},
 		{
 			"multiple comments with blank",
@@ -802,16 +1086,37 @@ package main`,
 
 package main`,
 			[]string{"foo", "bar"},
+			"",
 		},
 		{
 			"comment with space",
 			"  //   +build   foo   bar  \n\n",
 			[]string{"foo bar"},
+			"",
 		},
 		{
 			"slash star comment",
 			"/* +build foo */\n\n",
 			nil,
+			"",
+		},
+		{
+			"go:build line",
+			"//go:build ignore\n\npackage main",
+			nil,
+			"ignore",
+		},
+		{
+			"go:build and +build lines",
+			"//go:build linux && !cgo\n// +build linux,!cgo\n\npackage main",
+			[]string{"linux,!cgo"},
+			"linux && !cgo",
+		},
+		{
+			"go:build with space",
+			"// go:build ignore\n\npackage main",
+			nil,
+			"",
 		},
 	} {
 		f, err := ioutil.TempFile(".", "TestReadTags")
@@ -827,10 +1132,10 @@ package main`,
 			t.Fatal(err)
 		}
 
-		if got, err := readTags(path); err != nil {
+		if got, gotGoBuild, err := readTags(path); err != nil {
 			t.Fatal(err)
-		} else if !reflect.DeepEqual(got, tc.want) {
-			t.Errorf("case %q: got %#v; want %#v", tc.desc, got, tc.want)
+		} else if !reflect.DeepEqual(got, tc.want) || gotGoBuild != tc.wantGoBuild {
+			t.Errorf("case %q: got %#v, %q; want %#v, %q", tc.desc, got, gotGoBuild, tc.want, tc.wantGoBuild)
 		}
 	}
 }
@@ -860,6 +1165,18 @@ func TestCheckConstraints(t *testing.T) {
 			"darwin",
 			false,
 		},
+		{
+			"linux goos satisfied on android",
+			fileInfo{goos: "linux"},
+			"android",
+			true,
+		},
+		{
+			"android goos unsatisfied on linux",
+			fileInfo{goos: "android"},
+			"linux",
+			false,
+		},
 		{
 			"goarch satisfied",
 			fileInfo{goarch: "amd64"},
@@ -902,6 +1219,18 @@ func TestCheckConstraints(t *testing.T) {
 			"darwin,foo",
 			false,
 		},
+		{
+			"go:build overrides tags",
+			fileInfo{goBuild: "foo", tags: []string{"bar"}},
+			"foo",
+			true,
+		},
+		{
+			"go:build ignore",
+			fileInfo{goBuild: "ignore"},
+			"linux,amd64",
+			false,
+		},
 	} {
 		if got := tc.fi.checkConstraints(parseTags(tc.tags)); got != tc.want {
 			t.Errorf("case %q: got %#v; want %#v", tc.desc, got, tc.want)
@@ -1016,6 +1345,24 @@ func TestCheckTags(t *testing.T) {
 			"",
 			true,
 		},
+		{
+			"release tag satisfied by version",
+			"go1.7",
+			"go1.1,go1.7",
+			true,
+		},
+		{
+			"release tag unsatisfied by version",
+			"go1.8",
+			"go1.1,go1.7",
+			false,
+		},
+		{
+			"release tag negated with version",
+			"!go1.8",
+			"go1.1,go1.7",
+			true,
+		},
 	} {
 		if got := checkTags(tc.line, parseTags(tc.tags)); got != tc.want {
 			t.Errorf("case %q: got %#v; want %#v", tc.desc, got, tc.want)
@@ -1023,6 +1370,36 @@ func TestCheckTags(t *testing.T) {
 	}
 }
 
+func TestCheckGoBuild(t *testing.T) {
+	for _, tc := range []struct {
+		desc, expr, tags string
+		want             bool
+	}{
+		{"single satisfied", "foo", "foo", true},
+		{"single unsatisfied", "foo", "bar", false},
+		{"NOT", "!foo", "", true},
+		{"double NOT", "!!foo", "foo", true},
+		{"AND", "foo && bar", "foo,bar", true},
+		{"AND unsatisfied", "foo && bar", "foo", false},
+		{"OR", "foo || bar", "bar", true},
+		{"precedence", "foo || bar && baz", "foo", true},
+		{"parentheses", "(foo || bar) && baz", "foo", false},
+		{"release tag unknown", "go1.9", "", true},
+		{"release tag negated unknown", "!go1.9", "", true},
+		{"release tag known", "go1.9", "go1.1,go1.8", false},
+		{"release tag negated known", "!go1.9", "go1.1,go1.8", true},
+		{"empty", "", "", false},
+		{"unbalanced", "(foo", "foo", false},
+		{"missing operand", "foo &&", "foo", false},
+		{"trailing tag", "foo bar", "foo,bar", false},
+		{"bad character", "foo,bar", "foo,bar", false},
+	} {
+		if got := checkGoBuild(tc.expr, parseTags(tc.tags)); got != tc.want {
+			t.Errorf("case %q: got %#v; want %#v", tc.desc, got, tc.want)
+		}
+	}
+}
+
 func parseTags(tags string) map[string]bool {
 	tagMap := make(map[string]bool)
 	for _, t := range strings.Split(tags, ",") {
@@ -16,7 +16,6 @@ limitations under the License.
 package packages
 
 import (
-	"fmt"
 	"sort"
 	"strings"
 
@@ -39,17 +38,74 @@ type Package struct {
 	// Components in Rel are separated with slashes.
 	Rel string
 
-	Library, CgoLibrary, Binary, Test, XTest Target
+	Library, Binary, Test, XTest Target
 
 	Protos      []string
 	HasPbGo     bool
 	HasTestdata bool
+
+	// ProtoImports lists the files imported by .proto files in Protos, for
+	// example, "google/protobuf/any.proto". HasServices is true if any of
+	// the .proto files define a service. HasTwirp is true if the directory
+	// has .twirp.go files generated by protoc-gen-twirp, even if they were
+	// excluded, so services are generated with Twirp rather than gRPC.
+	ProtoImports []string
+	HasServices  bool
+	HasTwirp     bool
+
+	// HasMainFunc is true if a buildable .go file in package main declares
+	// a main function. Without one, a go_binary can't be linked, so only
+	// a library is generated.
+	HasMainFunc bool
+
+	// Generated is a list of .go files in the package that are marked with
+	// a "// Code generated ... DO NOT EDIT." comment. These files are also
+	// listed in the targets they belong to.
+	Generated []string
+
+	// RuleName, if set, is the name the rules generated for the package are
+	// named after, instead of the default names. It's set when a directory
+	// has more than one package and c.MultiplePackageMode is
+	// config.SplitPackages, on the packages whose names don't match the
+	// directory.
+	RuleName string
+
+	// Others lists the other packages in the directory in that case, each
+	// with its own RuleName. Only .go files are in their targets; the other
+	// sources in the directory belong to this package.
+	Others []*Package
 }
 
 // Target contains metadata about a buildable Go target in a package.
 type Target struct {
 	Sources, Imports PlatformStrings
 	COpts, CLinkOpts PlatformStrings
+
+	// CDeps is a list of labels of cc_library rules for packages named in
+	// cgo pkg-config directives.
+	CDeps PlatformStrings
+
+	// Cgo is true if at least one .go source file in the target imports "C".
+	// C, C++, and assembly files are built with the C toolchain in this case.
+	Cgo bool
+}
+
+// Union returns a target with the sources, imports, and flags of both t and
+// other. It is cgo-enabled if either target is. The result shares no
+// slices with t or other. Strings from t come before strings from other.
+//
+// This is used for internal tests that use cgo in packages whose libraries
+// also use cgo. cgo can only process a package once, so the test can't embed
+// the library; it's built from the sources of both instead.
+func (t Target) Union(other Target) Target {
+	return Target{
+		Sources:   t.Sources.concat(other.Sources),
+		Imports:   t.Imports.concat(other.Imports),
+		COpts:     t.COpts.concat(other.COpts),
+		CLinkOpts: t.CLinkOpts.concat(other.CLinkOpts),
+		CDeps:     t.CDeps.union(other.CDeps),
+		Cgo:       t.Cgo || other.Cgo,
+	}
 }
 
 // PlatformStrings contains a set of strings associated with a buildable
@@ -64,6 +120,12 @@ type PlatformStrings struct {
 	Platform map[string][]string
 }
 
+// IsProtoOnly returns true if the package has .proto files but no .go
+// files. Rules are generated to compile the .proto files into a library.
+func (p *Package) IsProtoOnly() bool {
+	return !p.HasGo() && len(p.Protos) > 0
+}
+
 // IsCommand returns true if the package name is "main".
 func (p *Package) IsCommand() bool {
 	return p.Name == "main"
@@ -73,35 +135,13 @@ func (p *Package) IsCommand() bool {
 // .go source file. If a package does not contain Go code, Gazelle will
 // not generate rules for it.
 func (p *Package) HasGo() bool {
-	return p.Library.HasGo() || p.CgoLibrary.HasGo() || p.Binary.HasGo() || p.Test.HasGo() || p.XTest.HasGo()
-}
-
-// firstGoFile returns the name of a .go file if the package contains at least
-// one .go file, or "" otherwise. Used by HasGo and for error reporting.
-func (p *Package) firstGoFile() string {
-	if f := p.Library.firstGoFile(); f != "" {
-		return f
-	}
-	if f := p.CgoLibrary.firstGoFile(); f != "" {
-		return f
-	}
-	if f := p.Binary.firstGoFile(); f != "" {
-		return f
-	}
-	if f := p.Test.firstGoFile(); f != "" {
-		return f
-	}
-	return p.XTest.firstGoFile()
+	return p.Library.HasGo() || p.Binary.HasGo() || p.Test.HasGo() || p.XTest.HasGo()
 }
 
 func (t *Target) HasGo() bool {
 	return t.Sources.HasGo()
 }
 
-func (t *Target) firstGoFile() string {
-	return t.Sources.firstGoFile()
-}
-
 func (ts *PlatformStrings) HasGo() bool {
 	return ts.firstGoFile() != ""
 }
@@ -138,46 +178,74 @@ func (ts *PlatformStrings) firstGoFile() string {
 // the file is buildable.
 //
 // "cgo" tells whether a ".go" file in the package contains cgo code. This
-// affects whether C files are added to targets.
+// affects whether C files are added to targets. C files are added to the
+// first target that uses cgo; see cgoTarget.
 //
-// An error is returned if a file is buildable but invalid (for example, a
-// test .go file containing cgo code). Files that are not buildable will not
-// be added to any target (for example, .txt files).
+// An error is returned if a file is buildable but invalid. Files that are
+// not buildable will not be added to any target (for example, .txt files).
 func (p *Package) addFile(c *config.Config, info fileInfo, cgo bool) error {
 	switch {
 	case info.category == ignoredExt || info.category == unsupportedExt:
 		return nil
 	case info.isXTest:
-		if info.isCgo {
-			return fmt.Errorf("%s: use of cgo in test not supported", info.path)
-		}
 		p.XTest.addFile(c, info)
+		p.XTest.Cgo = p.XTest.Cgo || info.isCgo
 	case info.isTest:
-		if info.isCgo {
-			return fmt.Errorf("%s: use of cgo in test not supported", info.path)
-		}
 		p.Test.addFile(c, info)
-	case info.isCgo || cgo && (info.category == cExt || info.category == hExt || info.category == csExt):
-		p.CgoLibrary.addFile(c, info)
-	case info.category == goExt || info.category == sExt || info.category == hExt:
+		p.Test.Cgo = p.Test.Cgo || info.isCgo
+	case info.isCgo:
+		p.Library.addFile(c, info)
+		p.Library.Cgo = true
+	case cgo && (info.category == cExt || info.category == hExt || info.category == csExt):
+		p.cgoTarget().addFile(c, info)
+	case info.category == goExt || info.category == sExt || info.category == hExt || info.category == sysoExt:
 		p.Library.addFile(c, info)
 	case info.category == protoExt:
 		p.Protos = append(p.Protos, info.name)
+		p.ProtoImports = append(p.ProtoImports, info.protoImports...)
+		p.HasServices = p.HasServices || info.hasServices
 	}
 
-	if strings.HasSuffix(info.name, ".pb.go") {
+	if strings.HasSuffix(info.name, ".pb.go") || strings.HasSuffix(info.name, ".twirp.go") {
 		p.HasPbGo = true
 	}
+	if strings.HasSuffix(info.name, ".twirp.go") {
+		p.HasTwirp = true
+	}
+	if info.isGenerated {
+		p.Generated = append(p.Generated, info.name)
+	}
+	if info.hasMainFunc {
+		p.HasMainFunc = true
+	}
 
 	return nil
 }
 
+// cgoTarget returns the target that C, C++, and header files in the package
+// should be added to. This is the library if it uses cgo. If only tests use
+// cgo, it's the internal test, or the external test if the internal test
+// doesn't use cgo either.
+func (p *Package) cgoTarget() *Target {
+	switch {
+	case p.Library.Cgo:
+		return &p.Library
+	case p.Test.Cgo:
+		return &p.Test
+	case p.XTest.Cgo:
+		return &p.XTest
+	default:
+		return &p.Library
+	}
+}
+
 func (t *Target) addFile(c *config.Config, info fileInfo) {
-	if !info.hasConstraints() || info.checkConstraints(c.GenericTags) {
+	if isGeneric(c, info) {
 		t.Sources.addGenericStrings(info.name)
 		t.Imports.addGenericStrings(info.imports...)
 		t.COpts.addGenericOpts(c.Platforms, info.copts)
 		t.CLinkOpts.addGenericOpts(c.Platforms, info.clinkopts)
+		t.CDeps.addGenericOpts(c.Platforms, info.cdeps)
 		return
 	}
 
@@ -187,10 +255,46 @@ func (t *Target) addFile(c *config.Config, info fileInfo) {
 			t.Imports.addPlatformStrings(name, info.imports...)
 			t.COpts.addTaggedOpts(name, info.copts, tags)
 			t.CLinkOpts.addTaggedOpts(name, info.clinkopts, tags)
+			t.CDeps.addTaggedOpts(name, info.cdeps, tags)
 		}
 	}
 }
 
+// isGeneric returns whether the file described by "info" is built on all
+// platforms. A file with constraints is only generic if the constraints are
+// satisfied by the generic tags and by the tags of every platform. For
+// example, a file with "+build !windows" is not generic, even though
+// "windows" is not a generic tag.
+func isGeneric(c *config.Config, info fileInfo) bool {
+	if !info.hasConstraints() {
+		return true
+	}
+	if !info.checkConstraints(c.GenericTags) {
+		return false
+	}
+	for _, tags := range c.Platforms {
+		if !info.checkConstraints(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// isBuildable returns whether the file described by "info" is built on at
+// least one platform. Files that are not buildable, like generators marked
+// with "+build ignore", are not added to any target.
+func isBuildable(c *config.Config, info fileInfo) bool {
+	if isGeneric(c, info) {
+		return true
+	}
+	for _, tags := range c.Platforms {
+		if info.checkConstraints(tags) {
+			return true
+		}
+	}
+	return false
+}
+
 func (ps *PlatformStrings) addGenericStrings(ss ...string) {
 	ps.Generic = append(ps.Generic, ss...)
 }
@@ -261,6 +365,34 @@ func (ps *PlatformStrings) Clean() {
 	}
 }
 
+// union returns a new PlatformStrings containing the strings in ps and
+// other, sorted and de-duplicated as in Clean.
+func (ps PlatformStrings) union(other PlatformStrings) PlatformStrings {
+	u := ps.concat(other)
+	u.Clean()
+	return u
+}
+
+// concat returns a new PlatformStrings containing the strings in ps followed
+// by the strings in other. Order is preserved, and duplicates are not
+// removed, so this is suitable for flags.
+func (ps PlatformStrings) concat(other PlatformStrings) PlatformStrings {
+	var c PlatformStrings
+	c.Generic = append(append([]string(nil), ps.Generic...), other.Generic...)
+	for _, p := range []map[string][]string{ps.Platform, other.Platform} {
+		for name, ss := range p {
+			if c.Platform == nil {
+				c.Platform = make(map[string][]string)
+			}
+			c.Platform[name] = append(c.Platform[name], ss...)
+		}
+	}
+	if len(c.Generic) == 0 {
+		c.Generic = nil
+	}
+	return c
+}
+
 func remove(ss []string, remove map[string]bool) []string {
 	var r, w int
 	for r, w = 0, 0; r < len(ss); r++ {
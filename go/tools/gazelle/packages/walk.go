@@ -16,14 +16,18 @@ limitations under the License.
 package packages
 
 import (
+	"bytes"
+	"fmt"
 	"go/build"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 
 	bf "github.com/bazelbuild/buildtools/build"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
@@ -44,117 +48,488 @@ type WalkFunc func(pkg *Package, oldFile *bf.File)
 // directory contains one package with any name, "f" will be called with that
 // package. If a directory contains multiple packages and one of the package
 // names matches the directory name, "f" will be called on that package and the
-// other packages will be silently ignored. If none of the package names match
-// the directory name, or if some other error occurs, an error will be logged,
-// and "f" will not be called.
-func Walk(c *config.Config, dir string, f WalkFunc) {
-	// visit walks the directory tree in post-order. It returns whether the
-	// the directory it was called on or any subdirectory contains a Bazel
-	// package. This affects whether "testdata" directories are considered
-	// data dependencies.
-	var visit func(string) bool
-	visit = func(path string) bool {
-		// Look for an existing BUILD file. Directives in this file may influence
-		// the rest of the process.
-		var oldFile *bf.File
-		haveError := false
-		for _, base := range c.ValidBuildFileNames {
-			oldPath := filepath.Join(path, base)
-			st, err := os.Stat(oldPath)
-			if os.IsNotExist(err) || err == nil && st.IsDir() {
-				continue
+// other packages will be silently ignored, unless c.MultiplePackageMode is
+// config.ErrorOnMultiplePackages. With config.SplitPackages, "f" is called
+// once, with the other packages in the Others field of the one it gets. If
+// none of the package names match the directory name (outside that mode), or
+// if some other error occurs, an error will be logged, and "f" will not be
+// called. For multiple packages, the error is a *MultiplePackageError
+// listing the files in each package.
+//
+// Like the go tool, Walk does not treat "testdata" directories or their
+// subdirectories as packages, and it doesn't read source files in them.
+// Their contents are data for the package in the parent directory.
+//
+// Symbolic links to directories are followed, except for the "bazel-"
+// convenience links in the repository root. A directory is only visited once,
+// so cycles of links are skipped with a warning. Directories named in
+// c.SkipDirs are not visited, and their contents are not listed.
+//
+// Names of files and subdirectories that differ only by case are logged,
+// since they can't be checked out together on case-insensitive file systems.
+// If c.CaseCollisionMode is config.SkipCaseCollisions, no package is built
+// for such a directory.
+//
+// Source files are read concurrently, but "f" is always called from the
+// goroutine that called Walk, in the same order each time.
+//
+// Source files that can't be parsed don't stop the walk. Each error is
+// logged, and the file (or its package, depending on c.ParseErrorMode) is
+// skipped. After the walk is complete, if any files couldn't be parsed,
+// Walk returns a *ParseErrors listing all of them.
+func Walk(c *config.Config, dir string, f WalkFunc) error {
+	// Walking happens in three passes. First, directories are listed, and
+	// existing build files are read. Second, source files in all directories
+	// are read concurrently, since this dominates the run time in large
+	// repositories. Third, packages are built and passed to "f" in post-order,
+	// in the same order as if everything were done sequentially.
+	root := listDirs(c, dir)
+	if root == nil {
+		return nil
+	}
+	scanFiles(c, root)
+
+	var parseErrs []error
+
+	// visit returns whether the directory it was called on or any
+	// subdirectory contains a Bazel package. This affects whether "testdata"
+	// directories are considered data dependencies.
+	var visit func(*dirInfo) bool
+	visit = func(d *dirInfo) bool {
+		hasTestdata := false
+		subdirHasPackage := false
+		for _, sub := range d.subdirs {
+			hasPackage := visit(sub)
+			if filepath.Base(sub.path) == "testdata" && !hasPackage {
+				hasTestdata = true
 			}
-			oldData, err := ioutil.ReadFile(oldPath)
-			if err != nil {
-				log.Print(err)
-				haveError = true
-				continue
+			subdirHasPackage = subdirHasPackage || hasPackage
+		}
+
+		hasPackage := subdirHasPackage || d.oldFile != nil
+		pkg, errs := d.buildPackage(c, hasTestdata)
+		parseErrs = append(parseErrs, errs...)
+		if pkg != nil {
+			f(pkg, d.oldFile)
+			hasPackage = true
+		}
+		return hasPackage
+	}
+
+	visit(root)
+	if len(parseErrs) > 0 {
+		return &ParseErrors{Errors: parseErrs}
+	}
+	return nil
+}
+
+// ParseErrors is returned by Walk when some source files could not be parsed.
+type ParseErrors struct {
+	// Errors contains an error for each file, in the order the files
+	// were visited.
+	Errors []error
+}
+
+func (e *ParseErrors) Error() string {
+	var buf bytes.Buffer
+	if len(e.Errors) == 1 {
+		buf.WriteString("1 source file could not be parsed:")
+	} else {
+		fmt.Fprintf(&buf, "%d source files could not be parsed:", len(e.Errors))
+	}
+	for _, err := range e.Errors {
+		fmt.Fprintf(&buf, "\n\t%v", err)
+	}
+	return buf.String()
+}
+
+// ScanDir builds a package from the files in "dir", which must be
+// c.RepoRoot or one of its subdirectories. The existing build file in dir is
+// read and returned, or nil is returned if there isn't one. Subdirectories
+// aren't visited, except to check whether a "testdata" subdirectory belongs
+// to the package. This is much faster than Walk when only one directory has
+// changed.
+//
+// The package is nil if dir has no buildable Go code or if Walk would skip
+// it. As with Walk, if any source files couldn't be parsed, the error is
+// a *ParseErrors listing them.
+func ScanDir(c *config.Config, dir string) (*Package, *bf.File, error) {
+	inTestdata := false
+	if rel, err := filepath.Rel(c.RepoRoot, dir); err == nil {
+		for _, component := range strings.Split(filepath.ToSlash(rel), "/") {
+			if component == "testdata" {
+				inTestdata = true
 			}
-			if oldFile != nil {
-				log.Printf("in directory %s, multiple Bazel files are present: %s, %s",
-					path, filepath.Base(oldFile.Path), base)
-				haveError = true
-				continue
+		}
+	}
+
+	d, subdirs := listDir(c, dir, inTestdata)
+	if d == nil {
+		return nil, nil, nil
+	}
+	scanFiles(c, d)
+
+	hasTestdata := false
+	for _, base := range subdirs {
+		if base == "testdata" {
+			hasTestdata = !containsBuildFile(c, filepath.Join(dir, base))
+		}
+	}
+	pkg, parseErrs := d.buildPackage(c, hasTestdata)
+	if len(parseErrs) > 0 {
+		return pkg, d.oldFile, &ParseErrors{Errors: parseErrs}
+	}
+	return pkg, d.oldFile, nil
+}
+
+// containsBuildFile returns whether "dir" or any of its subdirectories that
+// Walk would list have a build file.
+func containsBuildFile(c *config.Config, dir string) bool {
+	for _, base := range c.ValidBuildFileNames {
+		if st, err := os.Stat(filepath.Join(dir, base)); err == nil && !st.IsDir() {
+			return true
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		base := f.Name()
+		if base[0] == '.' || base[0] == '_' || skipDir(c, dir, base) {
+			continue
+		}
+		if sub := filepath.Join(dir, base); isDir(sub) && containsBuildFile(c, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirInfo holds information about a directory gathered before packages are
+// built.
+type dirInfo struct {
+	path    string
+	oldFile *bf.File
+
+	// haveError is true if the existing build file could not be read. No
+	// package is built for the directory in this case.
+	haveError bool
+
+	excluded         map[string]bool
+	excludeGenerated bool
+
+	// inTestdata is true for "testdata" directories and their
+	// subdirectories. Like the go tool, Gazelle doesn't treat these as
+	// packages, so source files in them are not listed or read.
+	inTestdata bool
+
+	// hasCaseCollision is true if the names of some files or subdirectories
+	// differ only by case.
+	hasCaseCollision bool
+
+	goFiles, otherFiles []string
+	subdirs             []*dirInfo
+
+	// goResults and otherResults hold the results of reading each file in
+	// goFiles and otherFiles. They are filled in by scanFiles.
+	goResults, otherResults []fileResult
+}
+
+// fileResult is the result of reading a source file.
+type fileResult struct {
+	info fileInfo
+	err  error
+}
+
+// buildPackage builds a package from the files listed in d, which were
+// read by scanFiles. "hasTestdata" tells whether d has a "testdata"
+// subdirectory without any Bazel packages. Errors for source files that
+// couldn't be parsed are returned. No package is built if d is in
+// a "testdata" directory, if its build file couldn't be read, or if it has
+// names that differ only by case and c.CaseCollisionMode says to skip it.
+func (d *dirInfo) buildPackage(c *config.Config, hasTestdata bool) (*Package, []error) {
+	if d.haveError || d.inTestdata {
+		return nil, nil
+	}
+	if d.hasCaseCollision && c.CaseCollisionMode == config.SkipCaseCollisions {
+		log.Printf("%s: skipping package because some names differ only by case", d.path)
+		return nil, nil
+	}
+	var parseErrs []error
+	for _, results := range [][]fileResult{d.goResults, d.otherResults} {
+		for _, r := range results {
+			if r.err != nil {
+				parseErrs = append(parseErrs, r.err)
 			}
-			oldFile, err = bf.Parse(oldPath, oldData)
-			if err != nil {
-				log.Print(err)
-				haveError = true
+		}
+	}
+
+	var genGoFiles []string
+	if d.oldFile != nil {
+		genGoFiles = findGenGoFiles(d.oldFile, d.excluded)
+	}
+	pkg := buildPackage(c, d.path, d.oldFile, d.goFiles, d.goResults, genGoFiles, d.otherResults, hasTestdata, d.excludeGenerated)
+	return pkg, parseErrs
+}
+
+// listDirs reads the existing build file and lists the files in dir and
+// its subdirectories. Symbolic links to directories are followed, except for
+// the "bazel-" links in the repository root. Directories for which skipDir
+// is true are not listed. A directory is only listed once, so cycles of
+// links are skipped with a warning. Links are followed after everything
+// else has been listed, so a directory is listed through its own path
+// rather than a link to it. Only subdirectories and build files are listed
+// in "testdata" directories. nil is returned if dir can't be listed.
+func listDirs(c *config.Config, dir string) *dirInfo {
+	// visited maps the real paths of directories that have been listed to
+	// the paths they were first listed through.
+	visited := make(map[string]string)
+
+	// links holds the symbolic links to directories found so far, with the
+	// directories they were found in.
+	type link struct {
+		parent     *dirInfo
+		path       string
+		inTestdata bool
+	}
+	var links []link
+
+	var list func(string, bool) *dirInfo
+	list = func(path string, inTestdata bool) *dirInfo {
+		realPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+		if prev, ok := visited[realPath]; ok {
+			log.Printf("skipping %s: same directory as %s", path, prev)
+			return nil
+		}
+		visited[realPath] = path
+
+		d, subdirs := listDir(c, path, inTestdata)
+		if d == nil {
+			return nil
+		}
+		for _, base := range subdirs {
+			subPath := filepath.Join(path, base)
+			subInTestdata := inTestdata || base == "testdata"
+			if st, err := os.Lstat(subPath); err == nil && st.Mode()&os.ModeSymlink != 0 {
+				links = append(links, link{d, subPath, subInTestdata})
 				continue
 			}
+			if sub := list(subPath, subInTestdata); sub != nil {
+				d.subdirs = append(d.subdirs, sub)
+			}
 		}
+		return d
+	}
 
-		var excluded map[string]bool
-		if oldFile != nil {
-			excluded = findExcludedFiles(oldFile)
+	root := list(dir, false)
+	for len(links) > 0 {
+		l := links[0]
+		links = links[1:]
+		sub := list(l.path, l.inTestdata)
+		if sub == nil {
+			continue
 		}
+		// Keep subdirectories sorted, as they would be without links.
+		subdirs := l.parent.subdirs
+		i := sort.Search(len(subdirs), func(i int) bool { return subdirs[i].path > sub.path })
+		subdirs = append(subdirs, nil)
+		copy(subdirs[i+1:], subdirs[i:])
+		subdirs[i] = sub
+		l.parent.subdirs = subdirs
+	}
+	return root
+}
 
-		// List files and subdirectories.
-		files, err := ioutil.ReadDir(path)
+// listDir reads the existing build file in "path" and lists its files. Names
+// of subdirectories to visit are returned separately, except for those for
+// which skipDir is true. Only subdirectories and build files are listed in
+// "testdata" directories. nil is returned if path can't be listed.
+func listDir(c *config.Config, path string, inTestdata bool) (*dirInfo, []string) {
+	d := &dirInfo{path: path, inTestdata: inTestdata}
+	var subdirs []string
+
+	// Look for an existing BUILD file. Directives in this file may influence
+	// the rest of the process.
+	for _, base := range c.ValidBuildFileNames {
+		oldPath := filepath.Join(path, base)
+		st, err := os.Stat(oldPath)
+		if os.IsNotExist(err) || err == nil && st.IsDir() {
+			continue
+		}
+		oldData, err := ioutil.ReadFile(oldPath)
+		if err != nil {
+			log.Print(err)
+			d.haveError = true
+			continue
+		}
+		if d.oldFile != nil {
+			log.Printf("in directory %s, multiple Bazel files are present: %s, %s",
+				path, filepath.Base(d.oldFile.Path), base)
+			d.haveError = true
+			continue
+		}
+		d.oldFile, err = bf.Parse(oldPath, oldData)
 		if err != nil {
 			log.Print(err)
-			return false
+			d.haveError = true
+			continue
 		}
+	}
 
-		var goFiles, otherFiles, subdirs []string
-		for _, f := range files {
-			base := f.Name()
-			switch {
-			case base == "" || base[0] == '.' || base[0] == '_' || excluded != nil && excluded[base]:
-				continue
+	if d.oldFile != nil {
+		d.excluded = findExcludedFiles(d.oldFile)
+		d.excludeGenerated = hasExcludeGeneratedDirective(d.oldFile)
+	}
 
-			case f.IsDir():
-				subdirs = append(subdirs, base)
+	// List files and subdirectories.
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		log.Print(err)
+		return nil, nil
+	}
+	for _, names := range caseCollisions(files) {
+		log.Printf("%s: names differ only by case: %s", path, strings.Join(names, ", "))
+		d.hasCaseCollision = true
+	}
 
-			case strings.HasSuffix(base, ".go"):
-				goFiles = append(goFiles, base)
+	for _, f := range files {
+		base := f.Name()
+		switch {
+		case base == "" || base[0] == '.' || base[0] == '_' || d.excluded != nil && d.excluded[base]:
+			continue
 
-			default:
-				otherFiles = append(otherFiles, base)
-			}
-		}
+		case (f.IsDir() || f.Mode()&os.ModeSymlink != 0) && skipDir(c, path, base):
+			// Skip these without following links or listing their
+			// contents. They may be large.
+			continue
 
-		// Recurse into subdirectories.
-		hasTestdata := false
-		subdirHasPackage := false
-		for _, sub := range subdirs {
-			hasPackage := visit(filepath.Join(path, sub))
-			if sub == "testdata" && !hasPackage {
-				hasTestdata = true
-			}
-			subdirHasPackage = subdirHasPackage || hasPackage
+		case f.IsDir(), f.Mode()&os.ModeSymlink != 0 && isDir(filepath.Join(path, base)):
+			subdirs = append(subdirs, base)
+
+		case inTestdata:
+			continue
+
+		case strings.HasSuffix(base, ".go"):
+			d.goFiles = append(d.goFiles, base)
+
+		default:
+			d.otherFiles = append(d.otherFiles, base)
 		}
+	}
+	return d, subdirs
+}
 
-		hasPackage := subdirHasPackage || oldFile != nil
-		if haveError {
-			return hasPackage
+// caseCollisions returns groups of names in "files" that differ only by
+// case. Names keep their order from "files", which ReadDir sorts.
+func caseCollisions(files []os.FileInfo) [][]string {
+	var keys []string
+	groups := make(map[string][]string)
+	for _, f := range files {
+		key := strings.ToLower(f.Name())
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
 		}
+		groups[key] = append(groups[key], f.Name())
+	}
+	var collisions [][]string
+	for _, key := range keys {
+		if names := groups[key]; len(names) > 1 {
+			collisions = append(collisions, names)
+		}
+	}
+	return collisions
+}
+
+// scanFiles reads the source files in root and its subdirectories
+// concurrently. At most c.Jobs files are read at a time, or GOMAXPROCS if
+// c.Jobs is not positive. Results are stored in the goResults and
+// otherResults fields of each dirInfo.
+func scanFiles(c *config.Config, root *dirInfo) {
+	jobs := c.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+
+	// Each task writes to its own element of a results slice, so no locking
+	// is needed.
+	tasks := make(chan func())
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				task()
+			}
+		}()
+	}
 
-		// Build a package from files in this directory.
-		var genGoFiles []string
-		if oldFile != nil {
-			genGoFiles = findGenGoFiles(oldFile, excluded)
+	var send func(*dirInfo)
+	send = func(d *dirInfo) {
+		d.goResults = make([]fileResult, len(d.goFiles))
+		for i, name := range d.goFiles {
+			r, name := &d.goResults[i], name
+			dir := d.path
+			tasks <- func() {
+				r.info, r.err = goFileInfo(c, dir, name)
+			}
 		}
-		pkg := buildPackage(c, path, oldFile, goFiles, genGoFiles, otherFiles, hasTestdata)
-		if pkg != nil {
-			f(pkg, oldFile)
-			hasPackage = true
+		d.otherResults = make([]fileResult, len(d.otherFiles))
+		for i, name := range d.otherFiles {
+			r, name := &d.otherResults[i], name
+			dir := d.path
+			tasks <- func() {
+				r.info, r.err = otherFileInfo(dir, name)
+			}
+		}
+		for _, sub := range d.subdirs {
+			send(sub)
 		}
-		return hasPackage
 	}
+	send(root)
+	close(tasks)
+	wg.Wait()
+}
 
-	visit(dir)
+// skipDir returns whether the directory or link named base in dir should
+// be skipped without being listed. This is true for directories named in
+// c.SkipDirs and for the links Bazel creates to its output directories
+// in the repository root.
+func skipDir(c *config.Config, dir, base string) bool {
+	if dir == c.RepoRoot && strings.HasPrefix(base, "bazel-") {
+		return true
+	}
+	return c.SkipDirs[base]
+}
+
+// isDir returns whether path is a directory, following symbolic links.
+func isDir(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
 }
 
 // buildPackage reads source files in a given directory and returns a Package
 // containing information about those files and how to build them.
 //
-// If no buildable .go files are found in the directory, nil will be returned.
-// If the directory contains multiple buildable packages, the package whose
-// name matches the directory base name will be returned. If there is no such
+// "goResults" and "otherResults" hold the results of reading each file in
+// "goFiles" and "otherFiles". Errors are logged here, so that they appear
+// in a deterministic order.
+//
+// If "excludeGenerated" is true, .go files marked with a "Code generated"
+// comment are skipped.
+//
+// If no buildable .go files are found in the directory, nil will be returned,
+// unless there are .proto files; see buildProtoPackage. If the directory
+// contains multiple buildable packages, the package whose name matches the
+// directory base name will be returned. If there is no such
 // package or if an error occurs, an error will be logged, and nil will be
 // returned.
-func buildPackage(c *config.Config, dir string, oldFile *bf.File, goFiles, genGoFiles, otherFiles []string, hasTestdata bool) *Package {
+func buildPackage(c *config.Config, dir string, oldFile *bf.File, goFiles []string, goResults []fileResult, genGoFiles []string, otherResults []fileResult, hasTestdata, excludeGenerated bool) *Package {
 	rel, err := filepath.Rel(c.RepoRoot, dir)
 	if err != nil {
 		log.Print(err)
@@ -165,15 +540,46 @@ func buildPackage(c *config.Config, dir string, oldFile *bf.File, goFiles, genGo
 		rel = ""
 	}
 
+	if c.ParseErrorMode == config.SkipPackagesWithErrors {
+		haveParseError := false
+		for _, results := range [][]fileResult{goResults, otherResults} {
+			for _, r := range results {
+				if r.err != nil {
+					log.Print(r.err)
+					haveParseError = true
+				}
+			}
+		}
+		if haveParseError {
+			log.Printf("%s: skipping package because some files could not be parsed", dir)
+			return nil
+		}
+	}
+
 	// Process the .go files first.
 	packageMap := make(map[string]*Package)
+	packageFiles := make(map[string][]string)
 	cgo := false
-	for _, goFile := range goFiles {
-		info, err := goFileInfo(c, dir, goFile)
+	for i, goFile := range goFiles {
+		info, err := goResults[i].info, goResults[i].err
 		if err != nil {
 			log.Print(err)
 			continue
 		}
+		if !isBuildable(c, info) {
+			// Files excluded on every platform may belong to another package
+			// or use cgo, so don't look at them any further.
+			if c.Verbose {
+				log.Printf("%s: excluded by build constraints", info.path)
+			}
+			continue
+		}
+		if excludeGenerated && info.isGenerated {
+			if c.Verbose {
+				log.Printf("%s: excluded because it is generated", info.path)
+			}
+			continue
+		}
 		if info.packageName == "documentation" {
 			// go/build ignores this package
 			continue
@@ -193,15 +599,21 @@ func buildPackage(c *config.Config, dir string, oldFile *bf.File, goFiles, genGo
 		if err != nil {
 			log.Print(err)
 		}
+		packageFiles[info.packageName] = append(packageFiles[info.packageName], goFile)
 	}
 
 	// Select a package to generate rules for.
-	pkg, err := selectPackage(c, dir, packageMap)
+	pkg, err := selectPackage(c, dir, packageMap, packageFiles)
 	if err != nil {
 		if _, ok := err.(*build.NoGoError); !ok {
 			log.Print(err)
+			return nil
 		}
-		return nil
+		hasTwirp := false
+		for _, goFile := range goFiles {
+			hasTwirp = hasTwirp || strings.HasSuffix(goFile, ".twirp.go")
+		}
+		return buildProtoPackage(c, dir, rel, otherResults, hasTestdata, hasTwirp)
 	}
 
 	// Process the generated .go files. Note that generated files may have the
@@ -218,25 +630,156 @@ func buildPackage(c *config.Config, dir string, oldFile *bf.File, goFiles, genGo
 		if err != nil {
 			log.Print(err)
 		}
+		if pkg.IsCommand() && !info.isTest {
+			// Generated files can't be read yet, so they might declare main.
+			pkg.HasMainFunc = true
+		}
+	}
+
+	if pkg.IsCommand() && !pkg.HasMainFunc && pkg.Library.HasGo() {
+		log.Printf("%s: package main has no main function, so no go_binary will be generated. Files:\n\t%s",
+			dir, strings.Join(packageFiles[pkg.Name], "\n\t"))
 	}
 
 	// Process the other files.
-	for _, file := range otherFiles {
-		info, err := otherFileInfo(dir, file)
+	var includers []fileInfo
+	for _, r := range otherResults {
+		info, err := r.info, r.err
 		if err != nil {
 			log.Print(err)
 			continue
 		}
+		if info.category != ignoredExt && !isBuildable(c, info) {
+			if c.Verbose {
+				log.Printf("%s: excluded by build constraints", info.path)
+			}
+			continue
+		}
 		err = pkg.addFile(c, info, cgo)
 		if err != nil {
 			log.Print(err)
 		}
+		if len(info.includes) > 0 {
+			includers = append(includers, info)
+		}
 	}
 
+	// Headers in subdirectories may be included by assembly files, so add
+	// them too. Headers in dir itself were added above.
+	for _, info := range includedHeaders(c, dir, includers) {
+		if err := pkg.addFile(c, info, cgo); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return pkg
+}
+
+// buildProtoPackage returns a Package for a directory with .proto files
+// but no buildable .go files. nil is returned if there are no buildable
+// .proto files either. Errors for .proto files are logged. hasTwirp is
+// whether the directory has .twirp.go files, which were excluded.
+func buildProtoPackage(c *config.Config, dir, rel string, otherResults []fileResult, hasTestdata, hasTwirp bool) *Package {
+	pkg := &Package{
+		Name:        defaultPackageName(c, dir),
+		Dir:         dir,
+		Rel:         rel,
+		HasTestdata: hasTestdata,
+		HasTwirp:    hasTwirp,
+	}
+	for _, r := range otherResults {
+		if r.err != nil {
+			log.Print(r.err)
+			continue
+		}
+		if r.info.category != protoExt {
+			continue
+		}
+		if !isBuildable(c, r.info) {
+			if c.Verbose {
+				log.Printf("%s: excluded by build constraints", r.info.path)
+			}
+			continue
+		}
+		if err := pkg.addFile(c, r.info, false); err != nil {
+			log.Print(err)
+		}
+	}
+	if len(pkg.Protos) == 0 {
+		return nil
+	}
 	return pkg
 }
 
-func selectPackage(c *config.Config, dir string, packageMap map[string]*Package) (*Package, error) {
+// includedHeaders returns information about headers in subdirectories of
+// "dir" that are named in #include directives in "includers". Headers that
+// don't exist, like textflag.h, which is provided by the Go toolchain, are
+// skipped. So are headers outside dir and headers in subdirectories with
+// their own build files, since they belong to other Bazel packages.
+//
+// A header without constraints of its own gets the constraints of the file
+// that includes it. If several files include the same header, it gets no
+// constraints.
+func includedHeaders(c *config.Config, dir string, includers []fileInfo) []fileInfo {
+	var names []string
+	headers := make(map[string]fileInfo)
+	for _, includer := range includers {
+		for _, inc := range includer.includes {
+			name := path.Clean(inc)
+			if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") || !strings.Contains(name, "/") {
+				continue
+			}
+			if hdr, ok := headers[name]; ok {
+				hdr.goos, hdr.goarch, hdr.tags, hdr.goBuild = "", "", nil, ""
+				headers[name] = hdr
+				continue
+			}
+			hdr := fileNameInfo(dir, name)
+			if hdr.category != hExt || !isLocalFile(c, dir, name) {
+				continue
+			}
+			if !hdr.hasConstraints() {
+				hdr.goos = includer.goos
+				hdr.goarch = includer.goarch
+				hdr.tags = includer.tags
+				hdr.goBuild = includer.goBuild
+			}
+			names = append(names, name)
+			headers[name] = hdr
+		}
+	}
+
+	infos := make([]fileInfo, len(names))
+	for i, name := range names {
+		infos[i] = headers[name]
+	}
+	return infos
+}
+
+// isLocalFile returns true if "name", a slash-separated path relative to
+// "dir", is a regular file in the same Bazel package as dir. That is, no
+// directory between dir and the file may contain a build file.
+func isLocalFile(c *config.Config, dir, name string) bool {
+	if fi, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	sub := dir
+	components := strings.Split(path.Dir(name), "/")
+	for _, component := range components {
+		sub = filepath.Join(sub, component)
+		for _, base := range c.ValidBuildFileNames {
+			if _, err := os.Stat(filepath.Join(sub, base)); err == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// selectPackage chooses the package in packageMap to generate rules for
+// according to c.MultiplePackageMode. packageFiles lists the .go files for
+// each package and is used for error messages.
+func selectPackage(c *config.Config, dir string, packageMap map[string]*Package, packageFiles map[string][]string) (*Package, error) {
 	packagesWithGo := make(map[string]*Package)
 	for name, pkg := range packageMap {
 		if pkg.HasGo() {
@@ -254,22 +797,74 @@ func selectPackage(c *config.Config, dir string, packageMap map[string]*Package)
 		}
 	}
 
-	if pkg, ok := packagesWithGo[defaultPackageName(c, dir)]; ok {
-		return pkg, nil
+	if c.MultiplePackageMode == config.PickDefaultPackage {
+		if pkg, ok := packagesWithGo[defaultPackageName(c, dir)]; ok {
+			return pkg, nil
+		}
+	}
+	if c.MultiplePackageMode == config.SplitPackages {
+		return splitPackages(c, dir, packagesWithGo), nil
 	}
 
-	err := &build.MultiplePackageError{Dir: dir}
-	for name, pkg := range packagesWithGo {
-		// Add the first file for each package for the error message.
-		// Error() method expects these lists to be the same length. File
-		// lists must be non-empty. These lists are only created by
-		// buildPackage for packages with .go files present.
+	err := &MultiplePackageError{Dir: dir}
+	for name := range packagesWithGo {
 		err.Packages = append(err.Packages, name)
-		err.Files = append(err.Files, pkg.firstGoFile())
+	}
+	sort.Strings(err.Packages)
+	for _, name := range err.Packages {
+		files := append([]string(nil), packageFiles[name]...)
+		sort.Strings(files)
+		err.Files = append(err.Files, files)
 	}
 	return nil, err
 }
 
+// splitPackages returns the package whose name matches dir, with the other
+// packages in Others, for config.SplitPackages. Packages whose names don't
+// match get RuleNames. If no name matches, the first package by name is
+// returned, so no package gets the default rule names.
+func splitPackages(c *config.Config, dir string, packages map[string]*Package) *Package {
+	var names []string
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pkg, ok := packages[defaultPackageName(c, dir)]
+	if !ok {
+		pkg = packages[names[0]]
+		pkg.RuleName = pkg.Name
+	}
+	for _, name := range names {
+		if other := packages[name]; other != pkg {
+			other.RuleName = other.Name
+			pkg.Others = append(pkg.Others, other)
+		}
+	}
+	return pkg
+}
+
+// MultiplePackageError describes a directory containing .go files from more
+// than one package, when Gazelle can't choose one of them.
+type MultiplePackageError struct {
+	// Dir is the absolute path to the directory.
+	Dir string
+
+	// Packages is a sorted list of the package names found in Dir, not
+	// including "_test" suffixes of external tests. Files[i] is a sorted list
+	// of the .go files in package Packages[i].
+	Packages []string
+	Files    [][]string
+}
+
+func (e *MultiplePackageError) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "found multiple packages in %s:", e.Dir)
+	for i, name := range e.Packages {
+		fmt.Fprintf(&buf, "\n\t%s (%s)", name, strings.Join(e.Files[i], ", "))
+	}
+	return buf.String()
+}
+
 func defaultPackageName(c *config.Config, dir string) string {
 	if dir != c.RepoRoot {
 		return filepath.Base(dir)
@@ -310,6 +905,19 @@ func findGenGoFiles(f *bf.File, excluded map[string]bool) []string {
 
 const gazelleExclude = "# gazelle:exclude " // marker in a BUILD file to exclude source files.
 
+// hasExcludeGeneratedDirective returns whether f contains a
+// "# gazelle:exclude_generated" directive, which excludes generated .go
+// files in the same directory from generated rules. This is useful when
+// the files are checked in but also generated by Bazel.
+func hasExcludeGeneratedDirective(f *bf.File) bool {
+	for _, d := range config.ParseDirectives(f) {
+		if d.Key == "exclude_generated" {
+			return true
+		}
+	}
+	return false
+}
+
 func findExcludedFiles(f *bf.File) map[string]bool {
 	excluded := make(map[string]bool)
 	for _, s := range f.Stmt {
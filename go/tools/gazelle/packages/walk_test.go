@@ -16,6 +16,7 @@ limitations under the License.
 package packages_test
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -128,6 +129,62 @@ func TestWalkSimple(t *testing.T) {
 	checkFiles(t, files, "", want)
 }
 
+func TestWalkProtoOnly(t *testing.T) {
+	files := []fileSpec{
+		{
+			path: "protos/foo.proto",
+			content: `syntax = "proto3";
+
+package protos;
+
+import "google/protobuf/any.proto";
+import public "other/bar.proto";
+
+service Foo {
+  rpc Get(google.protobuf.Any) returns (google.protobuf.Any);
+}
+`,
+		},
+	}
+	want := []*packages.Package{
+		{
+			Name:         "protos",
+			Rel:          "protos",
+			Protos:       []string{"foo.proto"},
+			ProtoImports: []string{"google/protobuf/any.proto", "other/bar.proto"},
+			HasServices:  true,
+		},
+	}
+	checkFiles(t, files, "", want)
+}
+
+func TestWalkProtoOnlyTwirp(t *testing.T) {
+	files := []fileSpec{
+		{path: "protos/BUILD", content: "# gazelle:exclude_generated\n"},
+		{
+			path: "protos/foo.proto",
+			content: `syntax = "proto3";
+
+package protos;
+
+service Foo {}
+`,
+		},
+		{path: "protos/foo.pb.go", content: "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage protos"},
+		{path: "protos/foo.twirp.go", content: "// Code generated by protoc-gen-twirp. DO NOT EDIT.\n\npackage protos"},
+	}
+	want := []*packages.Package{
+		{
+			Name:        "protos",
+			Rel:         "protos",
+			Protos:      []string{"foo.proto"},
+			HasServices: true,
+			HasTwirp:    true,
+		},
+	}
+	checkFiles(t, files, "", want)
+}
+
 func TestWalkNested(t *testing.T) {
 	files := []fileSpec{
 		{path: "a/foo.go", content: "package a"},
@@ -202,6 +259,137 @@ func TestMultiplePackagesWithoutDefault(t *testing.T) {
 	}
 }
 
+func TestMultiplePackagesError(t *testing.T) {
+	files := []fileSpec{
+		{path: "a/a.go", content: "package a"},
+		{path: "a/b.go", content: "package b"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.Config{
+		RepoRoot:            dir,
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+		MultiplePackageMode: config.ErrorOnMultiplePackages,
+	}
+	var got []*packages.Package
+	packages.Walk(c, dir, func(pkg *packages.Package, _ *bf.File) {
+		got = append(got, pkg)
+	})
+	if len(got) > 0 {
+		t.Errorf("got %v; want empty slice", got)
+	}
+}
+
+func walkSplitPackages(t *testing.T, files []fileSpec) (string, []*packages.Package) {
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	c := &config.Config{
+		RepoRoot:            dir,
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+		MultiplePackageMode: config.SplitPackages,
+	}
+	var got []*packages.Package
+	packages.Walk(c, dir, func(pkg *packages.Package, _ *bf.File) {
+		got = append(got, pkg)
+	})
+	return dir, got
+}
+
+func TestMultiplePackagesSplit(t *testing.T) {
+	dir, got := walkSplitPackages(t, []fileSpec{
+		{path: "a/a.go", content: "package a"},
+		{path: "a/a_test.go", content: "package a"},
+		{path: "a/gen.go", content: "package main\n\nfunc main() {}\n"},
+		{path: "a/b.go", content: "package b"},
+	})
+	defer os.RemoveAll(dir)
+
+	pkgDir := filepath.Join(dir, "a")
+	b := &packages.Package{
+		Name:     "b",
+		Dir:      pkgDir,
+		Rel:      "a",
+		RuleName: "b",
+		Library: packages.Target{
+			Sources: packages.PlatformStrings{Generic: []string{"b.go"}},
+		},
+	}
+	cmd := &packages.Package{
+		Name:        "main",
+		Dir:         pkgDir,
+		Rel:         "a",
+		RuleName:    "main",
+		HasMainFunc: true,
+		Library: packages.Target{
+			Sources: packages.PlatformStrings{Generic: []string{"gen.go"}},
+		},
+	}
+	want := []*packages.Package{
+		{
+			Name: "a",
+			Dir:  pkgDir,
+			Rel:  "a",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{Generic: []string{"a.go"}},
+			},
+			Test: packages.Target{
+				Sources: packages.PlatformStrings{Generic: []string{"a_test.go"}},
+			},
+			Others: []*packages.Package{b, cmd},
+		},
+	}
+	checkPackages(t, got, want)
+}
+
+func TestMultiplePackagesSplitWithoutDefault(t *testing.T) {
+	dir, got := walkSplitPackages(t, []fileSpec{
+		{path: "a/b.go", content: "package b"},
+		{path: "a/c.go", content: "package c"},
+	})
+	defer os.RemoveAll(dir)
+
+	pkgDir := filepath.Join(dir, "a")
+	want := []*packages.Package{
+		{
+			Name:     "b",
+			Dir:      pkgDir,
+			Rel:      "a",
+			RuleName: "b",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{Generic: []string{"b.go"}},
+			},
+			Others: []*packages.Package{{
+				Name:     "c",
+				Dir:      pkgDir,
+				Rel:      "a",
+				RuleName: "c",
+				Library: packages.Target{
+					Sources: packages.PlatformStrings{Generic: []string{"c.go"}},
+				},
+			}},
+		},
+	}
+	checkPackages(t, got, want)
+}
+
+func TestMultiplePackageErrorMessage(t *testing.T) {
+	err := &packages.MultiplePackageError{
+		Dir:      "/repo/a",
+		Packages: []string{"a", "b"},
+		Files:    [][]string{{"a.go", "a_test.go"}, {"b.go"}},
+	}
+	want := "found multiple packages in /repo/a:\n\ta (a.go, a_test.go)\n\tb (b.go)"
+	if got := err.Error(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
 func TestRootWithPrefix(t *testing.T) {
 	files := []fileSpec{
 		{path: "a.go", content: "package a"},
@@ -237,6 +425,125 @@ func TestRootWithoutPrefix(t *testing.T) {
 	}
 }
 
+func TestIgnoredFiles(t *testing.T) {
+	files := []fileSpec{
+		{path: "lib.go", content: "package lib"},
+		{
+			path: "gen.go",
+			content: `// +build ignore
+
+package main
+
+import "C"
+`,
+		},
+		{path: "gen_test.go", content: "//go:build ignore\n\npackage main"},
+		{path: "gen.c", content: "// +build ignore\n\n"},
+	}
+	want := []*packages.Package{
+		{
+			Name: "lib",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"lib.go"},
+				},
+			},
+		},
+	}
+	checkFiles(t, files, "", want)
+}
+
+func TestCgoSources(t *testing.T) {
+	files := []fileSpec{
+		{path: "lib.go", content: "package lib\n\nimport \"C\""},
+		{path: "a.c"},
+		{path: "b.cc"},
+		{path: "c.cpp"},
+		{path: "d.h"},
+		{path: "e.m"},
+		{path: "f.mm"},
+	}
+	want := []*packages.Package{
+		{
+			Name: "lib",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"lib.go", "a.c", "b.cc", "c.cpp", "d.h", "e.m", "f.mm"},
+				},
+				Cgo: true,
+			},
+		},
+	}
+	checkFiles(t, files, "", want)
+}
+
+func TestAsmIncludes(t *testing.T) {
+	files := []fileSpec{
+		{path: "lib.go", content: "package lib"},
+		{
+			path: "asm.s",
+			content: `#include "textflag.h"
+#include "include/defs.h"
+# include "include/../include/more.h"
+#include "sub/other.h"
+#include "../outside.h"
+#include <include/angle.h>
+`,
+		},
+		{path: "include/defs.h"},
+		{path: "include/more.h"},
+		{path: "include/angle.h"},
+		{path: "sub/BUILD"},
+		{path: "sub/other.h"},
+	}
+	want := []*packages.Package{
+		{
+			Name: "lib",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"lib.go", "asm.s", "include/defs.h", "include/more.h"},
+				},
+			},
+		},
+	}
+	checkFiles(t, files, "", want)
+}
+
+func TestMainFunc(t *testing.T) {
+	files := []fileSpec{
+		{path: "bin/bin.go", content: "package main\n\nfunc main() {}"},
+		{path: "bin/bin_test.go", content: "package main\n\nfunc main() {}"},
+		{path: "shim/shim.go", content: "package main\n\ntype T struct{}\n\nfunc (T) main() {}"},
+	}
+	want := []*packages.Package{
+		{
+			Name: "main",
+			Rel:  "bin",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"bin.go"},
+				},
+			},
+			Test: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"bin_test.go"},
+				},
+			},
+			HasMainFunc: true,
+		},
+		{
+			Name: "main",
+			Rel:  "shim",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"shim.go"},
+				},
+			},
+		},
+	}
+	checkFiles(t, files, "", want)
+}
+
 func TestTestdata(t *testing.T) {
 	files := []fileSpec{
 		{path: "raw/testdata/"},
@@ -249,6 +556,8 @@ func TestTestdata(t *testing.T) {
 		{path: "with_build_nested/a.go", content: "package with_build_nested"},
 		{path: "with_go/testdata/a.go", content: "package testdata"},
 		{path: "with_go/a.go", content: "package with_go"},
+		{path: "with_broken_go/testdata/x/a.go", content: "pakcage x"},
+		{path: "with_broken_go/a.go", content: "package with_broken_go"},
 	}
 	want := []*packages.Package{
 		{
@@ -262,18 +571,18 @@ func TestTestdata(t *testing.T) {
 			HasTestdata: true,
 		},
 		{
-			Name: "with_build",
-			Rel:  "with_build",
+			Name: "with_broken_go",
+			Rel:  "with_broken_go",
 			Library: packages.Target{
 				Sources: packages.PlatformStrings{
 					Generic: []string{"a.go"},
 				},
 			},
-			HasTestdata: false,
+			HasTestdata: true,
 		},
 		{
-			Name: "with_build_bazel",
-			Rel:  "with_build_bazel",
+			Name: "with_build",
+			Rel:  "with_build",
 			Library: packages.Target{
 				Sources: packages.PlatformStrings{
 					Generic: []string{"a.go"},
@@ -282,8 +591,8 @@ func TestTestdata(t *testing.T) {
 			HasTestdata: false,
 		},
 		{
-			Name: "with_build_nested",
-			Rel:  "with_build_nested",
+			Name: "with_build_bazel",
+			Rel:  "with_build_bazel",
 			Library: packages.Target{
 				Sources: packages.PlatformStrings{
 					Generic: []string{"a.go"},
@@ -292,13 +601,14 @@ func TestTestdata(t *testing.T) {
 			HasTestdata: false,
 		},
 		{
-			Name: "testdata",
-			Rel:  "with_go/testdata",
+			Name: "with_build_nested",
+			Rel:  "with_build_nested",
 			Library: packages.Target{
 				Sources: packages.PlatformStrings{
 					Generic: []string{"a.go"},
 				},
 			},
+			HasTestdata: false,
 		},
 		{
 			Name: "with_go",
@@ -308,7 +618,7 @@ func TestTestdata(t *testing.T) {
 					Generic: []string{"a.go"},
 				},
 			},
-			HasTestdata: false,
+			HasTestdata: true,
 		},
 	}
 	checkFiles(t, files, "", want)
@@ -403,6 +713,308 @@ genrule(
 	checkFiles(t, files, "", want)
 }
 
+func TestGeneratedComment(t *testing.T) {
+	files := []fileSpec{
+		{
+			path: "gen/BUILD",
+			content: `# gazelle:exclude_generated
+
+go_library(name = "go_default_library")
+`,
+		},
+		{path: "gen/gen.go", content: "// Code generated by stringer. DO NOT EDIT.\n\npackage gen"},
+		{path: "gen/real.go", content: "package gen"},
+		{path: "keep/gen.go", content: "// Code generated by stringer. DO NOT EDIT.\n\npackage keep"},
+		{path: "keep/real.go", content: "package keep"},
+	}
+	want := []*packages.Package{
+		{
+			Name: "gen",
+			Rel:  "gen",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"real.go"},
+				},
+			},
+		},
+		{
+			Name: "keep",
+			Rel:  "keep",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"gen.go", "real.go"},
+				},
+			},
+			Generated: []string{"gen.go"},
+		},
+	}
+	checkFiles(t, files, "", want)
+}
+
+func TestSymlinks(t *testing.T) {
+	files := []fileSpec{
+		{path: "repo/a/a.go", content: "package a"},
+		{path: "ext/ext.go", content: "package ext"},
+		{path: "out/out.go", content: "package out"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repoRoot := filepath.Join(dir, "repo")
+	for _, l := range []struct{ old, new string }{
+		{"..", "repo/a/loop"},
+		{"../ext", "repo/b"},
+		{"a", "repo/c"},
+		{"../out", "repo/bazel-out"},
+	} {
+		if err := os.Symlink(l.old, filepath.Join(dir, l.new)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []*packages.Package{
+		{
+			Name: "a",
+			Dir:  filepath.Join(repoRoot, "a"),
+			Rel:  "a",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"a.go"},
+				},
+			},
+		},
+		{
+			Name: "ext",
+			Dir:  filepath.Join(repoRoot, "b"),
+			Rel:  "b",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"ext.go"},
+				},
+			},
+		},
+	}
+	got := walkPackages(repoRoot, "", repoRoot)
+	checkPackages(t, got, want)
+}
+
+func TestSymlinksToLaterDirs(t *testing.T) {
+	files := []fileSpec{
+		{path: "repo/b/b.go", content: "package b"},
+		{path: "repo/d/x/x.go", content: "package x"},
+		{path: "repo/z/z.go", content: "package z"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The links sort before the directories they point to, but the
+	// directories should still be listed through their own paths.
+	repoRoot := filepath.Join(dir, "repo")
+	for _, l := range []struct{ old, new string }{
+		{"b", "repo/a"},
+		{"../z", "repo/d/link"},
+	} {
+		if err := os.Symlink(l.old, filepath.Join(dir, l.new)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []*packages.Package{
+		{
+			Name: "b",
+			Dir:  filepath.Join(repoRoot, "b"),
+			Rel:  "b",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"b.go"},
+				},
+			},
+		},
+		{
+			Name: "x",
+			Dir:  filepath.Join(repoRoot, "d", "x"),
+			Rel:  "d/x",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"x.go"},
+				},
+			},
+		},
+		{
+			Name: "z",
+			Dir:  filepath.Join(repoRoot, "z"),
+			Rel:  "z",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"z.go"},
+				},
+			},
+		},
+	}
+	got := walkPackages(repoRoot, "", repoRoot)
+	checkPackages(t, got, want)
+}
+
+func TestJobs(t *testing.T) {
+	var files []fileSpec
+	for i := 0; i < 20; i++ {
+		d := fmt.Sprintf("d%d", i)
+		files = append(files,
+			fileSpec{path: d + "/a.go", content: "package " + d},
+			fileSpec{path: d + "/a_test.go", content: "package " + d + "\n\nimport \"testing\""},
+			fileSpec{path: d + "/b_linux.go", content: "package " + d},
+			fileSpec{path: d + "/c.c"},
+			fileSpec{path: d + "/sub/x.go", content: "package x"})
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	walk := func(jobs int) []*packages.Package {
+		c := &config.Config{
+			RepoRoot:            dir,
+			ValidBuildFileNames: config.DefaultValidBuildFileNames,
+			Jobs:                jobs,
+		}
+		var pkgs []*packages.Package
+		packages.Walk(c, dir, func(pkg *packages.Package, _ *bf.File) {
+			pkgs = append(pkgs, pkg)
+		})
+		return pkgs
+	}
+	want := walk(1)
+	if len(want) != 40 {
+		t.Fatalf("got %d packages; want 40", len(want))
+	}
+	for _, jobs := range []int{0, 8} {
+		got := walk(jobs)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("with %d jobs, got %#v; want %#v", jobs, got, want)
+		}
+	}
+}
+
+func TestSkipDirs(t *testing.T) {
+	files := []fileSpec{
+		{path: "a/a.go", content: "package a"},
+		{path: "a/node_modules/b/b.go", content: "package b"},
+		{path: "node_modules/c.go", content: "package c"},
+		{path: "bazel-out/d.go", content: "package d"},
+		{path: "e/bazel-e/e.go", content: "package e"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.Config{
+		RepoRoot:            dir,
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+		SkipDirs:            map[string]bool{"node_modules": true},
+	}
+	var got []string
+	packages.Walk(c, dir, func(pkg *packages.Package, _ *bf.File) {
+		got = append(got, pkg.Rel)
+	})
+	want := []string{"a", "e/bazel-e"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestCaseCollisions(t *testing.T) {
+	files := []fileSpec{
+		{path: "a/a.go", content: "package a"},
+		{path: "a/A.go", content: "package a"},
+		{path: "a/sub/sub.go", content: "package sub"},
+		{path: "b/b.go", content: "package b"},
+		{path: "b/x/"},
+		{path: "b/X/"},
+		{path: "c/c.go", content: "package c"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		mode config.CaseCollisionMode
+		want []string
+	}{
+		{config.WarnOnCaseCollisions, []string{"a/sub", "a", "b", "c"}},
+		{config.SkipCaseCollisions, []string{"a/sub", "c"}},
+	} {
+		c := &config.Config{
+			RepoRoot:            dir,
+			ValidBuildFileNames: config.DefaultValidBuildFileNames,
+			CaseCollisionMode:   tc.mode,
+		}
+		var got []string
+		packages.Walk(c, dir, func(pkg *packages.Package, _ *bf.File) {
+			got = append(got, pkg.Rel)
+		})
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("mode %d: got %q; want %q", tc.mode, got, tc.want)
+		}
+	}
+}
+
+func TestScanDir(t *testing.T) {
+	files := []fileSpec{
+		{path: "a/a.go", content: "package a"},
+		{path: "a/sub/sub.go", content: "package sub"},
+		{path: "a/testdata/data.txt"},
+		{path: "b/b.txt"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.Config{
+		RepoRoot:            dir,
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+	}
+	got, oldFile, err := packages.ScanDir(c, filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldFile != nil {
+		t.Errorf("got build file %s; want nil", oldFile.Path)
+	}
+	want := &packages.Package{
+		Name: "a",
+		Dir:  filepath.Join(dir, "a"),
+		Rel:  "a",
+		Library: packages.Target{
+			Sources: packages.PlatformStrings{
+				Generic: []string{"a.go"},
+			},
+		},
+		HasTestdata: true,
+	}
+	checkPackage(t, got, want)
+
+	for _, rel := range []string{"b", "a/testdata"} {
+		if got, _, err := packages.ScanDir(c, filepath.Join(dir, rel)); err != nil {
+			t.Errorf("%s: %v", rel, err)
+		} else if got != nil {
+			t.Errorf("%s: got package %q; want nil", rel, got.Name)
+		}
+	}
+}
+
 func TestMalformedBuildFile(t *testing.T) {
 	files := []fileSpec{
 		{path: "BUILD", content: "????"},
@@ -439,3 +1051,51 @@ func TestMalformedGoFile(t *testing.T) {
 	}
 	checkFiles(t, files, "", want)
 }
+
+func TestParseErrors(t *testing.T) {
+	files := []fileSpec{
+		{path: "a/a.go", content: "pakcage a"},
+		{path: "a/b.go", content: "package a"},
+		{path: "c/c.go", content: "package c"},
+		{path: "d/d.go", content: "package d\n\nimport 1"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		mode config.ParseErrorMode
+		want []string
+	}{
+		{config.SkipFilesWithErrors, []string{"a", "c"}},
+		{config.SkipPackagesWithErrors, []string{"c"}},
+	} {
+		c := &config.Config{
+			RepoRoot:            dir,
+			ValidBuildFileNames: config.DefaultValidBuildFileNames,
+			ParseErrorMode:      tc.mode,
+		}
+		var got []string
+		err := packages.Walk(c, dir, func(pkg *packages.Package, _ *bf.File) {
+			got = append(got, pkg.Rel)
+		})
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("mode %d: got packages %q; want %q", tc.mode, got, tc.want)
+		}
+		errs, ok := err.(*packages.ParseErrors)
+		if !ok {
+			t.Errorf("mode %d: got error %v; want *packages.ParseErrors", tc.mode, err)
+			continue
+		}
+		if len(errs.Errors) != 2 {
+			t.Errorf("mode %d: got %d errors; want 2", tc.mode, len(errs.Errors))
+		}
+		for i, base := range []string{"a.go", "d.go"} {
+			if i < len(errs.Errors) && !strings.Contains(errs.Errors[i].Error(), base) {
+				t.Errorf("mode %d: error %d is %v; want error for %s", tc.mode, i, errs.Errors[i], base)
+			}
+		}
+	}
+}
@@ -13,5 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package rules provides Bazel rule generation for Go build targets.
+// Package rules provides Bazel rule generation for Go build targets. Rules
+// for each kind of source file are generated by a Language; Go and protocol
+// buffers are supported, and others can be added by implementing Language.
 package rules
@@ -28,10 +28,10 @@ import (
 
 const (
 	// goRulesBzl is the label of the Skylark file which provides Go rules
-	goRulesBzl = "@io_bazel_rules_go//go:def.bzl"
+	goRulesBzl = config.RulesGoDefBzlLabel
 	// defaultLibName is the name of the default go_library rule in a Go
 	// package directory. It must be consistent to DEFAULT_LIB in go/private/common.bf.
-	defaultLibName = "go_default_library"
+	defaultLibName = config.DefaultLibName
 	// defaultTestName is a name of an internal test corresponding to
 	// defaultLibName. It does not need to be consistent to something but it
 	// just needs to be unique in the Bazel package
@@ -42,25 +42,76 @@ const (
 	// defaultProtosName is the name of a filegroup created
 	// whenever the library contains .pb.go files
 	defaultProtosName = "go_default_library_protos"
-	// defaultCgoLibName is the name of the default cgo_library rule in a Go package directory.
-	defaultCgoLibName = "cgo_default_library"
 )
 
-// Generator generates Bazel build rules for Go build targets
+// Generator generates Bazel build rules for Go build targets, and for other
+// languages' sources in the same directories.
 type Generator interface {
 	// Generate generates a syntax tree of a BUILD file for "pkg". The file
 	// contains rules for each non-empty target in "pkg". It also contains
 	// "load" statements necessary for the rule constructors. If this is the
-	// top-level package in the repository, the file will contain a
-	// "go_prefix" rule.
+	// top-level package in the repository, and the prefix is not set with
+	// a "# gazelle:prefix" directive, the file will contain a "go_prefix" rule.
 	Generate(pkg *packages.Package) *bf.File
 }
 
-func NewGenerator(c *config.Config) Generator {
+// NewGenerator returns a Generator that generates rules for each of langs
+// in order. If no languages are given, DefaultLanguages are used. Each
+// language is configured with c first; nil is returned if that fails.
+func NewGenerator(c *config.Config, langs ...Language) Generator {
+	if len(langs) == 0 {
+		langs = DefaultLanguages()
+	}
+	for _, l := range langs {
+		if err := l.Configure(c); err != nil {
+			log.Printf("%s: %v", l.Name(), err)
+			return nil
+		}
+	}
+	return &generator{c: c, langs: langs}
+}
+
+type generator struct {
+	c     *config.Config
+	langs []Language
+}
+
+func (g *generator) Generate(pkg *packages.Package) *bf.File {
+	f := &bf.File{
+		Path: filepath.Join(pkg.Dir, g.c.DefaultBuildFileName()),
+	}
+	var loads []bf.Expr
+	var rs []*bf.Rule
+	for _, l := range g.langs {
+		lrs := l.GenerateRules(pkg)
+		if load := l.Load(lrs); load != nil {
+			loads = append(loads, load)
+		}
+		rs = append(rs, lrs...)
+	}
+	f.Stmt = append(f.Stmt, loads...)
+	for _, r := range rs {
+		f.Stmt = append(f.Stmt, r.Call)
+	}
+	return f
+}
+
+// goLanguage generates go_library, go_binary, and go_test rules for .go
+// files, and the go_prefix rule in the repository root.
+type goLanguage struct {
+	c *config.Config
+	r labelResolver
+}
+
+var _ Language = (*goLanguage)(nil)
+
+func (g *goLanguage) Name() string { return "go" }
+
+func (g *goLanguage) Configure(c *config.Config) error {
 	var (
 		// TODO(yugui) Support another resolver to cover the pattern 2 in
 		// https://github.com/bazelbuild/rules_go/issues/16#issuecomment-216010843
-		r = structuredResolver{goPrefix: c.GoPrefix}
+		r = structuredResolver{goPrefix: c.GoPrefix, c: c}
 	)
 
 	var e labelResolver
@@ -70,51 +121,50 @@ func NewGenerator(c *config.Config) Generator {
 	case config.VendorMode:
 		e = vendoredResolver{}
 	default:
-		return nil
+		return fmt.Errorf("unknown dependency mode: %v", c.DepMode)
 	}
 
-	return &generator{
-		c: c,
-		r: resolverFunc(func(importpath, dir string) (label, error) {
-			if importpath != c.GoPrefix && !strings.HasPrefix(importpath, c.GoPrefix+"/") && !isRelative(importpath) {
-				return e.resolve(importpath, dir)
+	g.c = c
+	g.r = resolverFunc(func(importpath, dir string) (label, error) {
+		if _, inModule := c.ModuleForImportPath(importpath); !inModule && importpath != c.GoPrefix && !strings.HasPrefix(importpath, c.GoPrefix+"/") && !isRelative(importpath) {
+			l, err := e.resolve(importpath, dir)
+			if err == nil && c.RepoName != "" && l.repo == c.RepoName {
+				// Build files shouldn't name their own repository. Bazel
+				// treats "@name//pkg" and "//pkg" as different packages.
+				l.repo = ""
 			}
-			return r.resolve(importpath, dir)
-		}),
-	}
-}
-
-type generator struct {
-	c *config.Config
-	r labelResolver
+			return l, err
+		}
+		return r.resolve(importpath, dir)
+	})
+	return nil
 }
 
-func (g *generator) Generate(pkg *packages.Package) *bf.File {
-	f := &bf.File{
-		Path: filepath.Join(pkg.Dir, g.c.DefaultBuildFileName()),
-	}
-	rs := g.generateRules(pkg)
-	if load := g.generateLoad(rs); load != nil {
-		f.Stmt = append(f.Stmt, load)
-	}
-	for _, r := range rs {
-		f.Stmt = append(f.Stmt, r.Call)
+func (g *goLanguage) Resolve(imp, rel string) (string, error) {
+	l, err := g.r.resolve(imp, rel)
+	if err != nil {
+		return "", err
 	}
-	return f
+	return l.String(), nil
 }
 
-func (g *generator) generateRules(pkg *packages.Package) []*bf.Rule {
+func (g *goLanguage) GenerateRules(pkg *packages.Package) []*bf.Rule {
 	var rules []*bf.Rule
-	if pkg.Rel == "" {
+	if pkg.Rel == "" && !g.c.PrefixDirective && (g.c.GoPrefix != "" || len(g.c.Modules) == 0) {
 		rules = append(rules, newRule("go_prefix", []interface{}{g.c.GoPrefix}, nil))
 	}
-
-	cgoLibrary, r := g.generateCgoLib(pkg)
-	if r != nil {
-		rules = append(rules, r)
+	rules = append(rules, g.generatePackageRules(pkg)...)
+	for _, other := range pkg.Others {
+		rules = append(rules, g.generatePackageRules(other)...)
 	}
+	return rules
+}
 
-	library, r := g.generateLib(pkg, cgoLibrary)
+// generatePackageRules generates the rules for one of the Go packages in
+// a directory.
+func (g *goLanguage) generatePackageRules(pkg *packages.Package) []*bf.Rule {
+	var rules []*bf.Rule
+	library, r := g.generateLib(pkg)
 	if r != nil {
 		rules = append(rules, r)
 	}
@@ -138,21 +188,27 @@ func (g *generator) generateRules(pkg *packages.Package) []*bf.Rule {
 	return rules
 }
 
-func (g *generator) generateBin(pkg *packages.Package, library string) *bf.Rule {
-	if !pkg.IsCommand() || pkg.Binary.Sources.IsEmpty() && library == "" {
+func (g *goLanguage) generateBin(pkg *packages.Package, library string) *bf.Rule {
+	if !pkg.IsCommand() || !pkg.HasMainFunc || pkg.Binary.Sources.IsEmpty() && library == "" {
 		return nil
 	}
 	name := filepath.Base(pkg.Dir)
+	if pkg.RuleName != "" {
+		name = pkg.RuleName
+	}
 	visibility := checkInternalVisibility(pkg.Rel, "//visibility:public")
 	return g.generateRule(pkg.Rel, "go_binary", name, visibility, library, false, pkg.Binary)
 }
 
-func (g *generator) generateLib(pkg *packages.Package, cgoName string) (string, *bf.Rule) {
-	if !pkg.Library.HasGo() && cgoName == "" {
+func (g *goLanguage) generateLib(pkg *packages.Package) (string, *bf.Rule) {
+	if !pkg.Library.HasGo() {
 		return "", nil
 	}
 
 	name := defaultLibName
+	if pkg.RuleName != "" {
+		name = pkg.RuleName + "_library"
+	}
 	var visibility string
 	if pkg.IsCommand() {
 		// Libraries made for a go_binary should not be exposed to the public.
@@ -161,28 +217,31 @@ func (g *generator) generateLib(pkg *packages.Package, cgoName string) (string,
 		visibility = checkInternalVisibility(pkg.Rel, "//visibility:public")
 	}
 
-	rule := g.generateRule(pkg.Rel, "go_library", name, visibility, cgoName, false, pkg.Library)
-	return name, rule
-}
-
-func (g *generator) generateCgoLib(pkg *packages.Package) (string, *bf.Rule) {
-	if !pkg.CgoLibrary.HasGo() {
-		return "", nil
-	}
-
-	name := defaultCgoLibName
-	visibility := "//visibility:private"
-	rule := g.generateRule(pkg.Rel, "cgo_library", name, visibility, "", false, pkg.CgoLibrary)
+	rule := g.generateRule(pkg.Rel, "go_library", name, visibility, "", false, pkg.Library)
 	return name, rule
 }
 
 // checkInternalVisibility overrides the given visibility if the package is
-// internal.
+// internal. rel is the slash-separated path of the package relative to the
+// root of the repository build files are generated for, which in external
+// repositories is external/<name>, so the labels are relative to that root.
+//
+// Like the go tool, the last "internal" element of the path determines the
+// packages that may import it: those rooted at its parent. In a vendor
+// tree, this is the parent within the vendored project. Packages in vendor
+// directories that are not in one of their internal directories keep the
+// given visibility. Imports are resolved to vendored packages from anywhere
+// in the repository, so they must be linkable repo-wide, even if the vendor
+// directory itself is inside an internal directory.
 func checkInternalVisibility(rel, visibility string) string {
-	if i := strings.LastIndex(rel, "/internal/"); i >= 0 {
-		visibility = fmt.Sprintf("//%s:__subpackages__", rel[:i])
-	} else if strings.HasPrefix(rel, "internal/") {
-		visibility = "//:__subpackages__"
+	elems := strings.Split(rel, "/")
+	for i := len(elems) - 1; i >= 0; i-- {
+		switch elems[i] {
+		case "vendor":
+			return visibility
+		case "internal":
+			return fmt.Sprintf("//%s:__subpackages__", strings.Join(elems[:i], "/"))
+		}
 	}
 	return visibility
 }
@@ -190,7 +249,7 @@ func checkInternalVisibility(rel, visibility string) string {
 // filegroup is a small hack for directories with pre-generated .pb.go files
 // and also source .proto files.  This creates a filegroup for the .proto in
 // addition to the usual go_library for the .pb.go files.
-func (g *generator) filegroup(pkg *packages.Package) *bf.Rule {
+func (g *goLanguage) filegroup(pkg *packages.Package) *bf.Rule {
 	if !pkg.HasPbGo || len(pkg.Protos) == 0 {
 		return nil
 	}
@@ -201,37 +260,67 @@ func (g *generator) filegroup(pkg *packages.Package) *bf.Rule {
 	})
 }
 
-func (g *generator) generateTest(pkg *packages.Package, library string) *bf.Rule {
-	if !pkg.Test.HasGo() {
+func (g *goLanguage) generateTest(pkg *packages.Package, library string) *bf.Rule {
+	if !pkg.Test.HasGo() && !g.mergesTests(pkg) {
 		return nil
 	}
 
 	var name string
-	if library == "" || library == defaultLibName {
+	switch {
+	case pkg.RuleName != "":
+		name = pkg.RuleName + "_test"
+	case library == "" || library == defaultLibName:
 		name = defaultTestName
-	} else {
+	default:
 		name = library + "_test"
 	}
 
+	if g.mergesTests(pkg) {
+		// External test files import the library under test. The merged test
+		// embeds the library, so that import is dropped from deps below.
+		target := pkg.Test.Union(pkg.XTest)
+		if pkg.Test.Cgo && pkg.Library.Cgo {
+			return g.generateRule(pkg.Rel, "go_test", name, "", "", pkg.HasTestdata, pkg.Library.Union(target))
+		}
+		return g.generateRule(pkg.Rel, "go_test", name, "", library, pkg.HasTestdata, target)
+	}
+
+	if pkg.Test.Cgo && pkg.Library.Cgo {
+		// cgo can only process a package once, so a test that uses cgo can't
+		// embed a library that also uses cgo. Build the test from the sources
+		// of both instead.
+		return g.generateRule(pkg.Rel, "go_test", name, "", "", pkg.HasTestdata, pkg.Library.Union(pkg.Test))
+	}
 	return g.generateRule(pkg.Rel, "go_test", name, "", library, pkg.HasTestdata, pkg.Test)
 }
 
-func (g *generator) generateXTest(pkg *packages.Package, library string) *bf.Rule {
-	if !pkg.XTest.HasGo() {
+func (g *goLanguage) generateXTest(pkg *packages.Package, library string) *bf.Rule {
+	if !pkg.XTest.HasGo() || g.mergesTests(pkg) {
 		return nil
 	}
 
 	var name string
-	if library == "" || library == defaultLibName {
+	switch {
+	case pkg.RuleName != "":
+		name = pkg.RuleName + "_xtest"
+	case library == "" || library == defaultLibName:
 		name = defaultXTestName
-	} else {
+	default:
 		name = library + "_xtest"
 	}
 
 	return g.generateRule(pkg.Rel, "go_test", name, "", "", pkg.HasTestdata, pkg.XTest)
 }
 
-func (g *generator) generateRule(rel, kind, name, visibility, library string, hasTestdata bool, target packages.Target) *bf.Rule {
+// mergesTests returns whether the internal and external tests in pkg are
+// built by one go_test rule. This is only done when c.MergeTests is set and
+// there is an external test. External tests that use cgo are kept
+// separate, since cgo can't process files from two packages at once.
+func (g *goLanguage) mergesTests(pkg *packages.Package) bool {
+	return g.c.MergeTests && pkg.XTest.HasGo() && !pkg.XTest.Cgo
+}
+
+func (g *goLanguage) generateRule(rel, kind, name, visibility, library string, hasTestdata bool, target packages.Target) *bf.Rule {
 	// Construct attrs in the same order that bf.Rewrite uses. See
 	// namePriority in github.com/bazelbuild/buildtools/build/rewrite.go.
 	attrs := []keyvalue{
@@ -240,6 +329,12 @@ func (g *generator) generateRule(rel, kind, name, visibility, library string, ha
 	if !target.Sources.IsEmpty() {
 		attrs = append(attrs, keyvalue{"srcs", target.Sources})
 	}
+	if !target.CDeps.IsEmpty() {
+		attrs = append(attrs, keyvalue{"cdeps", target.CDeps})
+	}
+	if target.Cgo {
+		attrs = append(attrs, keyvalue{"cgo", true})
+	}
 	if !target.CLinkOpts.IsEmpty() {
 		attrs = append(attrs, keyvalue{"clinkopts", target.CLinkOpts})
 	}
@@ -250,36 +345,40 @@ func (g *generator) generateRule(rel, kind, name, visibility, library string, ha
 		glob := globvalue{patterns: []string{"testdata/**"}}
 		attrs = append(attrs, keyvalue{"data", glob})
 	}
-	if library != "" {
+	if library != "" && g.c.LibraryAttr {
 		attrs = append(attrs, keyvalue{"library", ":" + library})
+	} else if library != "" {
+		attrs = append(attrs, keyvalue{"embed", []string{":" + library}})
+	}
+	if g.c.PrefixDirective || len(g.c.Modules) > 0 {
+		// Without a go_prefix rule, the Go rules can't compute import paths,
+		// so they must be set explicitly. The same is true in modules, which
+		// have their own prefixes.
+		attrs = append(attrs, keyvalue{"importpath", g.c.DefaultImportPath(rel, name)})
 	}
 	if visibility != "" {
 		attrs = append(attrs, keyvalue{"visibility", []string{visibility}})
 	}
 	if !target.Imports.IsEmpty() {
 		deps := g.dependencies(target.Imports, rel)
-		attrs = append(attrs, keyvalue{"deps", deps})
+		if library != "" {
+			deps = removeString(deps, ":"+library)
+		}
+		if !deps.IsEmpty() {
+			attrs = append(attrs, keyvalue{"deps", deps})
+		}
 	}
 	return newRule(kind, nil, attrs)
 }
 
-func (g *generator) generateLoad(rs []*bf.Rule) bf.Expr {
-	loadableKinds := []string{
-		// keep sorted
-		"cgo_library",
-		"go_binary",
-		"go_library",
-		"go_prefix",
-		"go_test",
-	}
-
+func (g *goLanguage) Load(rs []*bf.Rule) bf.Expr {
 	kinds := make(map[string]bool)
 	for _, r := range rs {
 		kinds[r.Kind()] = true
 	}
 	args := make([]bf.Expr, 0, len(kinds)+1)
 	args = append(args, &bf.StringExpr{Value: goRulesBzl})
-	for _, k := range loadableKinds {
+	for _, k := range config.LoadableKinds {
 		if kinds[k] {
 			args = append(args, &bf.StringExpr{Value: k})
 		}
@@ -294,7 +393,7 @@ func (g *generator) generateLoad(rs []*bf.Rule) bf.Expr {
 	}
 }
 
-func (g *generator) dependencies(imports packages.PlatformStrings, dir string) packages.PlatformStrings {
+func (g *goLanguage) dependencies(imports packages.PlatformStrings, dir string) packages.PlatformStrings {
 	resolve := func(imp string) (string, error) {
 		if l, err := g.r.resolve(imp, dir); err != nil {
 			return "", fmt.Errorf("in dir %q, could not resolve import path %q: %v", dir, imp, err)
@@ -311,6 +410,29 @@ func (g *generator) dependencies(imports packages.PlatformStrings, dir string) p
 	return deps
 }
 
+// removeString returns a copy of ps without any occurrences of s.
+func removeString(ps packages.PlatformStrings, s string) packages.PlatformStrings {
+	filter := func(ss []string) []string {
+		var result []string
+		for _, t := range ss {
+			if t != s {
+				result = append(result, t)
+			}
+		}
+		return result
+	}
+	result := packages.PlatformStrings{Generic: filter(ps.Generic)}
+	for n, ss := range ps.Platform {
+		if ss = filter(ss); len(ss) > 0 {
+			if result.Platform == nil {
+				result.Platform = make(map[string][]string)
+			}
+			result.Platform[n] = ss
+		}
+	}
+	return result
+}
+
 // isRelative determines if an importpath is relative.
 func isRelative(importpath string) bool {
 	return strings.HasPrefix(importpath, "./") || strings.HasPrefix(importpath, "..")
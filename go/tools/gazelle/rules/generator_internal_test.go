@@ -0,0 +1,43 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"testing"
+)
+
+func TestCheckInternalVisibility(t *testing.T) {
+	for _, tc := range []struct {
+		rel, want string
+	}{
+		{rel: "", want: "//visibility:public"},
+		{rel: "foo/bar", want: "//visibility:public"},
+		{rel: "internal", want: "//:__subpackages__"},
+		{rel: "internal/foo", want: "//:__subpackages__"},
+		{rel: "foo/internal", want: "//foo:__subpackages__"},
+		{rel: "foo/internal/bar", want: "//foo:__subpackages__"},
+		{rel: "foo/internal/bar/internal/baz", want: "//foo/internal/bar:__subpackages__"},
+		{rel: "internalx/foo", want: "//visibility:public"},
+		{rel: "vendor/example.com/repo", want: "//visibility:public"},
+		{rel: "vendor/example.com/repo/internal/foo", want: "//vendor/example.com/repo:__subpackages__"},
+		{rel: "foo/vendor/example.com/repo/internal", want: "//foo/vendor/example.com/repo:__subpackages__"},
+		{rel: "internal/vendor/example.com/repo", want: "//visibility:public"},
+	} {
+		if got := checkInternalVisibility(tc.rel, "//visibility:public"); got != tc.want {
+			t.Errorf("checkInternalVisibility(%q): got %s; want %s", tc.rel, got, tc.want)
+		}
+	}
+}
@@ -18,6 +18,7 @@ package rules_test
 import (
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	bf "github.com/bazelbuild/buildtools/build"
@@ -58,12 +59,15 @@ func TestGenerator(t *testing.T) {
 		"allcgolib",
 		"bin",
 		"bin_with_tests",
+		"cgo_test_only",
 		"cgolib",
 		"cgolib_with_build_tags",
+		"cgolib_with_cgo_tests",
 		"gen_and_exclude",
 		"lib",
 		"lib/internal/deep",
 		"main_test_only",
+		"main_without_main",
 		"platforms",
 		"tests_import_testdata",
 		"tests_with_testdata",
@@ -113,6 +117,278 @@ func TestGeneratorGoPrefixRoot(t *testing.T) {
 	}
 }
 
+func TestGeneratorPrefixDirective(t *testing.T) {
+	repoRoot := filepath.Join(testdata.Dir(), "repo")
+	goPrefix := "example.com/repo"
+	c := testConfig(repoRoot, goPrefix)
+	c.PrefixDirective = true
+	g := rules.NewGenerator(c)
+
+	root := g.Generate(&packages.Package{Dir: repoRoot})
+	if got := findGoPrefix(root); got != "" {
+		t.Errorf("got %q; want no go_prefix rule", got)
+	}
+
+	pkg := packageFromDir(c, filepath.Join(repoRoot, "bin_with_tests"))
+	f := g.Generate(pkg)
+	got := string(bf.Format(f))
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/bin_with_tests",
+    visibility = ["//visibility:private"],
+    deps = ["//lib:go_default_library"],
+)
+
+go_binary(
+    name = "bin_with_tests",
+    embed = [":go_default_library"],
+    importpath = "example.com/repo/bin_with_tests/bin_with_tests",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["bin_test.go"],
+    embed = [":go_default_library"],
+    importpath = "example.com/repo/bin_with_tests/go_default_test",
+)
+`
+	if got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestGeneratorMergeTests(t *testing.T) {
+	repoRoot := filepath.Join(testdata.Dir(), "repo")
+	goPrefix := "example.com/repo"
+	c := testConfig(repoRoot, goPrefix)
+	c.MergeTests = true
+	g := rules.NewGenerator(c)
+
+	pkg := packageFromDir(c, filepath.Join(repoRoot, "lib"))
+	f := g.Generate(pkg)
+	got := string(bf.Format(f))
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "doc.go",
+        "lib.go",
+        "asm.h",
+        "asm.s",
+    ],
+    visibility = ["//visibility:public"],
+    deps = ["//lib/internal/deep:go_default_library"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = [
+        "lib_test.go",
+        "lib_external_test.go",
+    ],
+    embed = [":go_default_library"],
+)
+`
+	if got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestGeneratorRepoName(t *testing.T) {
+	c := testConfig("", "example.com/bar")
+	c.RepoName = "com_github_foo_bar"
+	g := rules.NewGenerator(c)
+
+	// The repository is fetched with a vanity import path as its prefix, but
+	// the package imports another through its canonical path.
+	pkg := &packages.Package{
+		Name: "lib",
+		Rel:  "lib",
+		Library: packages.Target{
+			Sources: packages.PlatformStrings{Generic: []string{"lib.go"}},
+			Imports: packages.PlatformStrings{Generic: []string{
+				"github.com/foo/bar/baz",
+				"github.com/other/dep",
+			}},
+		},
+	}
+	f := g.Generate(pkg)
+	got := string(bf.Format(f))
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//baz:go_default_library",
+        "@com_github_other_dep//:go_default_library",
+    ],
+)
+`
+	if got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestGeneratorLibraryAttr(t *testing.T) {
+	c := testConfig("", "example.com/repo")
+	c.LibraryAttr = true
+	g := rules.NewGenerator(c)
+
+	pkg := &packages.Package{
+		Name: "main",
+		Dir:  "bin",
+		Rel:  "bin",
+		Library: packages.Target{
+			Sources: packages.PlatformStrings{Generic: []string{"bin.go"}},
+		},
+		Test: packages.Target{
+			Sources: packages.PlatformStrings{Generic: []string{"bin_test.go"}},
+		},
+		HasMainFunc: true,
+	}
+	f := g.Generate(pkg)
+	got := string(bf.Format(f))
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["bin.go"],
+    visibility = ["//visibility:private"],
+)
+
+go_binary(
+    name = "bin",
+    library = ":go_default_library",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["bin_test.go"],
+    library = ":go_default_library",
+)
+`
+	if got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestGeneratorSplitPackages(t *testing.T) {
+	c := testConfig("", "example.com/repo")
+	c.MultiplePackageMode = config.SplitPackages
+	g := rules.NewGenerator(c)
+
+	pkg := &packages.Package{
+		Name: "a",
+		Rel:  "a",
+		Library: packages.Target{
+			Sources: packages.PlatformStrings{Generic: []string{"a.go"}},
+		},
+		Others: []*packages.Package{{
+			Name:     "b",
+			Rel:      "a",
+			RuleName: "b",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{Generic: []string{"b.go"}},
+			},
+			Test: packages.Target{
+				Sources: packages.PlatformStrings{Generic: []string{"b_test.go"}},
+			},
+		}},
+	}
+	f := g.Generate(pkg)
+	got := string(bf.Format(f))
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "b_library",
+    srcs = ["b.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "b_test",
+    srcs = ["b_test.go"],
+    embed = [":b_library"],
+)
+`
+	if got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+// fakeLanguage generates a fake_library rule in every directory.
+type fakeLanguage struct {
+	configured bool
+}
+
+func (l *fakeLanguage) Name() string { return "fake" }
+
+func (l *fakeLanguage) Configure(c *config.Config) error {
+	l.configured = true
+	return nil
+}
+
+func (l *fakeLanguage) GenerateRules(pkg *packages.Package) []*bf.Rule {
+	return []*bf.Rule{{Call: &bf.CallExpr{X: &bf.LiteralExpr{Token: "fake_library"}}}}
+}
+
+func (l *fakeLanguage) Load(rs []*bf.Rule) bf.Expr {
+	return &bf.CallExpr{
+		X:    &bf.LiteralExpr{Token: "load"},
+		List: []bf.Expr{&bf.StringExpr{Value: "//fake:def.bzl"}, &bf.StringExpr{Value: "fake_library"}},
+	}
+}
+
+func (l *fakeLanguage) Resolve(imp, rel string) (string, error) {
+	return "", nil
+}
+
+func TestGeneratorLanguages(t *testing.T) {
+	c := testConfig("", "example.com/repo")
+	fake := &fakeLanguage{}
+	g := rules.NewGenerator(c, append(rules.DefaultLanguages(), fake)...)
+	if !fake.configured {
+		t.Errorf("language was not configured")
+	}
+
+	pkg := &packages.Package{
+		Name: "lib",
+		Rel:  "lib",
+		Library: packages.Target{
+			Sources: packages.PlatformStrings{Generic: []string{"lib.go"}},
+		},
+	}
+	f := g.Generate(pkg)
+	var got []string
+	for _, s := range f.Stmt {
+		call, ok := s.(*bf.CallExpr)
+		if !ok {
+			continue
+		}
+		if x, ok := call.X.(*bf.LiteralExpr); ok {
+			got = append(got, x.Token)
+		}
+	}
+	want := []string{"load", "load", "go_library", "fake_library"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
 func findGoPrefix(f *bf.File) string {
 	for _, s := range f.Stmt {
 		c, ok := s.(*bf.CallExpr)
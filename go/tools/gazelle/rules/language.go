@@ -0,0 +1,57 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	bf "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+)
+
+// Language generates rules for one kind of source file. A Generator runs
+// each of its languages on every package, so support for a new kind of
+// file can be added by implementing Language and passing it to
+// NewGenerator along with DefaultLanguages, without changing the others.
+type Language interface {
+	// Name returns a short name for the language, like "go" or "proto". It's
+	// used in error messages.
+	Name() string
+
+	// Configure is called once with the configuration before any rules are
+	// generated. An error is returned if the configuration can't be used.
+	Configure(c *config.Config) error
+
+	// GenerateRules returns rules for the sources of this language in pkg,
+	// in the order they should appear in the build file. nil is returned if
+	// pkg has no such sources.
+	GenerateRules(pkg *packages.Package) []*bf.Rule
+
+	// Load returns a load statement for the rules in rs, which were returned
+	// by GenerateRules, or nil if none is needed.
+	Load(rs []*bf.Rule) bf.Expr
+
+	// Resolve returns the label of the rule that provides imp, an import
+	// of a source file in the directory rel, which is relative to the
+	// repository root. An empty label is returned if imp is provided by
+	// rules in rel itself.
+	Resolve(imp, rel string) (string, error)
+}
+
+// DefaultLanguages returns new instances of the languages Gazelle supports:
+// Go, then protocol buffers.
+func DefaultLanguages() []Language {
+	return []Language{&goLanguage{}, &protoLanguage{}}
+}
@@ -0,0 +1,191 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	bf "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+)
+
+const (
+	// goProtoBzl is the label of the Skylark file which provides
+	// go_proto_library.
+	goProtoBzl = "@io_bazel_rules_go//proto:def.bzl"
+
+	// goGrpcCompiler is the label of the go_proto_compiler that generates
+	// gRPC services along with the messages.
+	goGrpcCompiler = "@io_bazel_rules_go//proto:go_grpc"
+
+	// goProtoCompiler and goTwirpCompiler are the labels of the
+	// go_proto_compilers that generate the messages and Twirp services.
+	goProtoCompiler = "@io_bazel_rules_go//proto:go_proto"
+	goTwirpCompiler = "@io_bazel_rules_go//proto:go_twirp"
+)
+
+// protoLanguage generates proto_library and go_proto_library rules for
+// .proto files.
+type protoLanguage struct {
+	c *config.Config
+}
+
+var _ Language = (*protoLanguage)(nil)
+
+func (g *protoLanguage) Name() string { return "proto" }
+
+func (g *protoLanguage) Configure(c *config.Config) error {
+	g.c = c
+	return nil
+}
+
+// Resolve returns the label of the proto_library that provides the .proto
+// file imp. See resolveProto.
+func (g *protoLanguage) Resolve(imp, rel string) (string, error) {
+	protoDep, _, err := g.resolveProto(imp, rel)
+	return protoDep, err
+}
+
+// GenerateRules generates rules for a directory with .proto files but no .go
+// files: a proto_library, and a go_proto_library that generates and compiles
+// Go code for it, with the gRPC compiler if the files define services, or the
+// Twirp compiler if the directory has .twirp.go files. The go_proto_library
+// is named like a go_library, so Go packages that import it don't need to
+// know it comes from .proto files. Directories with .go files are left to the
+// Go language, so nothing is generated for them.
+func (g *protoLanguage) GenerateRules(pkg *packages.Package) []*bf.Rule {
+	if !pkg.IsProtoOnly() {
+		return nil
+	}
+	visibility := checkInternalVisibility(pkg.Rel, "//visibility:public")
+
+	var protoDeps, goDeps []string
+	for _, imp := range pkg.ProtoImports {
+		protoDep, goDep, err := g.resolveProto(imp, pkg.Rel)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if protoDep != "" {
+			protoDeps = append(protoDeps, protoDep)
+		}
+		if goDep != "" {
+			goDeps = append(goDeps, goDep)
+		}
+	}
+	protoDepStrings := packages.PlatformStrings{Generic: protoDeps}
+	protoDepStrings.Clean()
+	goDepStrings := packages.PlatformStrings{Generic: goDeps}
+	goDepStrings.Clean()
+
+	protoAttrs := []keyvalue{
+		{"name", protoLibraryName(g.c, pkg.Rel)},
+		{"srcs", pkg.Protos},
+		{"visibility", []string{visibility}},
+	}
+	if !protoDepStrings.IsEmpty() {
+		protoAttrs = append(protoAttrs, keyvalue{"deps", protoDepStrings})
+	}
+
+	goAttrs := []keyvalue{
+		{"name", defaultLibName},
+		{"proto", ":" + protoLibraryName(g.c, pkg.Rel)},
+	}
+	if pkg.HasServices && pkg.HasTwirp {
+		goAttrs = append(goAttrs, keyvalue{"compilers", []string{goProtoCompiler, goTwirpCompiler}})
+	} else if pkg.HasServices {
+		goAttrs = append(goAttrs, keyvalue{"compilers", []string{goGrpcCompiler}})
+	}
+	if g.c.PrefixDirective || len(g.c.Modules) > 0 {
+		goAttrs = append(goAttrs, keyvalue{"importpath", g.c.DefaultImportPath(pkg.Rel, defaultLibName)})
+	}
+	goAttrs = append(goAttrs, keyvalue{"visibility", []string{visibility}})
+	if !goDepStrings.IsEmpty() {
+		goAttrs = append(goAttrs, keyvalue{"deps", goDepStrings})
+	}
+
+	return []*bf.Rule{
+		newRule("proto_library", nil, protoAttrs),
+		newRule("go_proto_library", nil, goAttrs),
+	}
+}
+
+// wellKnownProtos are the well-known types which go_proto_library can
+// import. Go code for them is in github.com/golang/protobuf/ptypes.
+var wellKnownProtos = map[string]bool{
+	"any":       true,
+	"duration":  true,
+	"empty":     true,
+	"struct":    true,
+	"timestamp": true,
+	"wrappers":  true,
+}
+
+// resolveProto returns labels of the proto_library and go_proto_library
+// rules that provide the .proto file imp, imported from the directory rel.
+// Imports are paths relative to the repository root, except for the
+// well-known types in google/protobuf. Empty labels are returned for files
+// in rel, since they're in the same rules.
+func (g *protoLanguage) resolveProto(imp, rel string) (protoDep, goDep string, err error) {
+	if strings.HasPrefix(imp, "google/protobuf/") {
+		name := strings.TrimSuffix(strings.TrimPrefix(imp, "google/protobuf/"), ".proto")
+		if !wellKnownProtos[name] {
+			return "", "", fmt.Errorf("in dir %q, import of %q is not supported by go_proto_library", rel, imp)
+		}
+		return "@com_google_protobuf//:" + name + "_proto", "@com_github_golang_protobuf//ptypes/" + name + ":go_default_library", nil
+	}
+
+	dir := path.Dir(imp)
+	if dir == "." {
+		dir = ""
+	}
+	if dir == rel {
+		return "", "", nil
+	}
+	return fmt.Sprintf("//%s:%s", dir, protoLibraryName(g.c, dir)), fmt.Sprintf("//%s:%s", dir, defaultLibName), nil
+}
+
+// protoLibraryName returns the name of the proto_library generated in the
+// directory rel.
+func protoLibraryName(c *config.Config, rel string) string {
+	base := path.Base(rel)
+	if rel == "" {
+		base = path.Base(c.GoPrefix)
+	}
+	return base + "_proto"
+}
+
+// Load returns a load statement for go_proto_library if it's used by any of
+// the rules in rs, or nil if it's not. proto_library is built into Bazel.
+func (g *protoLanguage) Load(rs []*bf.Rule) bf.Expr {
+	for _, r := range rs {
+		if r.Kind() == "go_proto_library" {
+			return &bf.CallExpr{
+				X: &bf.LiteralExpr{Token: "load"},
+				List: []bf.Expr{
+					&bf.StringExpr{Value: goProtoBzl},
+					&bf.StringExpr{Value: "go_proto_library"},
+				},
+				ForceCompact: true,
+			}
+		}
+	}
+	return nil
+}
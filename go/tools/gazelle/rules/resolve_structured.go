@@ -19,19 +19,37 @@ import (
 	"fmt"
 	"path"
 	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
 )
 
 // structuredResolver resolves go_library labels within the same repository as
 // the one of goPrefix.
 type structuredResolver struct {
 	goPrefix string
+
+	// c is used to resolve imports in modules listed in go.work. May be nil.
+	c *config.Config
 }
 
 // resolve takes a Go importpath within the same respository as r.goPrefix
 // and resolves it into a label in Bazel.
 func (r structuredResolver) resolve(importpath, dir string) (label, error) {
 	if isRelative(importpath) {
-		importpath = path.Clean(path.Join(r.goPrefix, dir, importpath))
+		importpath = path.Clean(path.Join(r.importPath(dir), importpath))
+	}
+
+	if r.c != nil {
+		if m, ok := r.c.ModuleForImportPath(importpath); ok {
+			pkg := path.Join(m.Rel, strings.TrimPrefix(strings.TrimPrefix(importpath, m.Path), "/"))
+			if pkg == "." {
+				pkg = ""
+			}
+			if pkg == dir {
+				return label{name: defaultLibName, relative: true}, nil
+			}
+			return label{pkg: pkg, name: defaultLibName}, nil
+		}
 	}
 
 	if importpath == r.goPrefix {
@@ -48,3 +66,14 @@ func (r structuredResolver) resolve(importpath, dir string) (label, error) {
 
 	return label{}, fmt.Errorf("importpath %q does not start with goPrefix %q", importpath, r.goPrefix)
 }
+
+// importPath returns the import path of the package in dir, ignoring
+// vendoring.
+func (r structuredResolver) importPath(dir string) string {
+	if r.c != nil {
+		if m, ok := r.c.ModuleForDir(dir); ok {
+			return path.Join(m.Path, strings.TrimPrefix(strings.TrimPrefix(dir, m.Rel), "/"))
+		}
+	}
+	return path.Join(r.goPrefix, dir)
+}
@@ -18,6 +18,8 @@ package rules
 import (
 	"reflect"
 	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
 )
 
 func TestStructuredResolver(t *testing.T) {
@@ -89,3 +91,53 @@ func TestStructuredResolverError(t *testing.T) {
 		}
 	}
 }
+
+func TestStructuredResolverModules(t *testing.T) {
+	c := &config.Config{
+		Modules: []config.Module{
+			{Rel: "", Path: "example.com/repo"},
+			{Rel: "tools", Path: "example.com/tools"},
+		},
+	}
+	r := structuredResolver{c: c}
+	for _, spec := range []struct {
+		importpath string
+		curPkg     string
+		want       label
+	}{
+		{
+			importpath: "example.com/repo/lib",
+			curPkg:     "tools/cmd",
+			want:       label{pkg: "lib", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/tools/cmd",
+			curPkg:     "lib",
+			want:       label{pkg: "tools/cmd", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/tools",
+			curPkg:     "tools",
+			want:       label{name: defaultLibName, relative: true},
+		},
+		{
+			importpath: "example.com/repo",
+			curPkg:     "tools",
+			want:       label{name: defaultLibName},
+		},
+		{
+			importpath: "./sub",
+			curPkg:     "tools/cmd",
+			want:       label{pkg: "tools/cmd/sub", name: defaultLibName},
+		},
+	} {
+		l, err := r.resolve(spec.importpath, spec.curPkg)
+		if err != nil {
+			t.Errorf("r.resolve(%q) failed with %v; want success", spec.importpath, err)
+			continue
+		}
+		if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("r.resolve(%q) = %s; want %s", spec.importpath, got, want)
+		}
+	}
+}
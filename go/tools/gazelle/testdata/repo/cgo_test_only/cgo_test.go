@@ -0,0 +1,29 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cgo_test_only
+
+/*
+#cgo CFLAGS: -DTEST
+int answer(void);
+*/
+import "C"
+import "testing"
+
+func TestAnswer(t *testing.T) {
+	if got, want := Answer(), int(C.answer()); got != want {
+		t.Errorf("got %d; want %d", got, want)
+	}
+}
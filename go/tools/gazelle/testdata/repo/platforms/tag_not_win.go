@@ -0,0 +1,3 @@
+//+build !windows
+
+package platforms
@@ -18,7 +18,17 @@ import (
 	"path/filepath"
 )
 
-const workspaceFile = "WORKSPACE"
+const (
+	workspaceFile = "WORKSPACE"
+
+	// externalDir is the directory in Bazel's output base where external
+	// repositories are fetched.
+	externalDir = "external"
+
+	// execrootDir is the directory in Bazel's output base holding the
+	// execution root of each workspace.
+	execrootDir = "execroot"
+)
 
 // Find searches from the given dir and up for the WORKSPACE file
 // returning the directory containing it, or an error if none found in the tree.
@@ -39,3 +49,35 @@ func Find(dir string) (string, error) {
 	}
 	return Find(parent)
 }
+
+// FindExternal searches from the given dir and up for the root of a Bazel
+// external repository, a directory named "external/<name>" in Bazel's output
+// base or execution root. It returns the directory and the repository name,
+// or an error if dir is not in an external repository. Fetched repositories
+// don't always have a WORKSPACE file, so this may succeed where Find fails.
+func FindExternal(dir string) (root, name string, err error) {
+	for {
+		if dir == "" || dir == "/" {
+			return "", "", os.ErrNotExist
+		}
+		parent := filepath.Dir(dir)
+		if dir == parent {
+			return "", "", os.ErrNotExist
+		}
+		if filepath.Base(parent) == externalDir && isBazelBase(filepath.Dir(parent)) {
+			return dir, filepath.Base(dir), nil
+		}
+		dir = parent
+	}
+}
+
+// isBazelBase returns whether dir is Bazel's output base, which has an
+// execroot directory next to external, or a workspace's execution root,
+// execroot/<workspace>. A user directory that happens to be named
+// "external" is neither.
+func isBazelBase(dir string) bool {
+	if fi, err := os.Stat(filepath.Join(dir, execrootDir)); err == nil && fi.IsDir() {
+		return true
+	}
+	return filepath.Base(filepath.Dir(dir)) == execrootDir
+}
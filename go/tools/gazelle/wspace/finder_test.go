@@ -55,3 +55,46 @@ func TestFind(t *testing.T) {
 		}
 	}
 }
+
+func TestFindExternal(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	repo := filepath.Join(tmp, "external", "com_example_repo")
+	execRepo := filepath.Join(tmp, "execroot", "ws", "external", "com_example_repo")
+	// A workspace with its own directory named "external" isn't an output
+	// base, so nothing under it is an external repository.
+	userDir := filepath.Join(tmp, "user", "ws", "external", "foo")
+	for _, dir := range []string{repo, execRepo, userDir} {
+		if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []testCase{
+		{tmp, ""},
+		{filepath.Join(tmp, "external"), ""},
+		{repo, repo},
+		{filepath.Join(repo, "sub"), repo},
+		{execRepo, execRepo},
+		{filepath.Join(execRepo, "sub"), execRepo},
+		{userDir, ""},
+		{filepath.Join(userDir, "sub"), ""}} {
+
+		d, name, err := FindExternal(tc.dir)
+		if err != nil {
+			if tc.want != "" {
+				t.Errorf("FindExternal(%q) want %q, got %v", tc.dir, tc.want, err)
+			}
+			continue
+		}
+		if d != tc.want {
+			t.Errorf("FindExternal(%q) got %q, want %q", tc.dir, d, tc.want)
+		}
+		if want := filepath.Base(tc.want); name != want {
+			t.Errorf("FindExternal(%q) got name %q, want %q", tc.dir, name, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:common.bzl", "emit_params_file")
+load("@io_bazel_rules_go//proto:toolchain.bzl", "go_proto_toolchain_type")
+
+GoProtoCompiler = provider()
+"""A protoc plugin that generates Go code: provided by go_proto_compiler.
+
+Fields:
+  deps: A list of the Go libraries the generated code imports.
+  options: A list of options passed to the plugin.
+  suffix: The suffix of the file generated for each .proto file.
+  plugin: The protoc plugin.
+  protoc: The protocol buffer compiler, from the go_proto_toolchain.
+  go_protoc: The builder that runs protoc with the plugin, from the
+    go_proto_toolchain.
+"""
+
+GoProtoImports = provider()
+"""The import paths of the .proto files a go_proto_library was generated
+from: provided by go_proto_library.
+
+Fields:
+  imports: A depset of "path=importpath" strings, one for each .proto file
+    of the library and of the go_proto_libraries it depends on, where path is
+    the path the file is imported by.
+"""
+
+def proto_path(src):
+  """Returns the path a .proto file is imported by: its path in the
+  repository it belongs to."""
+  path = src.short_path
+  if path.startswith("../"):
+    # Files in external repositories start with ../<repository>/.
+    path = path.split("/", 2)[2]
+  return path
+
+def go_proto_compile(ctx, compiler, proto, imports, importpath):
+  """Generates Go code for the .proto files proto provides with compiler, a
+  GoProtoCompiler, and returns the generated files. imports is a depset of
+  "path=importpath" strings for the files they may import. The files are
+  declared under the name of the target and importpath, so each compiler
+  must use a suffix of its own."""
+  go_srcs = []
+  for src in proto.direct_sources:
+    name = src.basename[:-len(".proto")] + compiler.suffix
+    go_srcs += [ctx.new_file("%s/%s/%s" % (ctx.label.name, importpath, name))]
+  args = [
+      "-protoc", compiler.protoc.path,
+      "-plugin", compiler.plugin.path,
+      "-importpath", importpath,
+  ]
+  for option in compiler.options:
+    args += ["-option", option]
+  for out in go_srcs:
+    args += ["-expected", out.path]
+  for imp in imports:
+    args += ["-import", imp]
+  for src in proto.transitive_sources:
+    args += ["-proto_path", "%s=%s" % (proto_path(src), src.path)]
+  args += [proto_path(src) for src in proto.direct_sources]
+  params = emit_params_file(ctx, go_srcs[0], args)
+  ctx.action(
+      inputs = depset([compiler.protoc, compiler.plugin, params]) + proto.transitive_sources,
+      outputs = go_srcs,
+      executable = compiler.go_protoc,
+      arguments = ["@" + params.path],
+      progress_message = "Generating Go code for %s with %s" % (ctx.label, compiler.plugin.basename),
+      mnemonic = "GoProtocGen",
+  )
+  return go_srcs
+
+def _go_proto_compiler_impl(ctx):
+  toolchain = ctx.toolchains[go_proto_toolchain_type]
+  plugin = ctx.executable.plugin
+  if not plugin:
+    plugin = toolchain.plugin
+  return struct(
+      providers = [GoProtoCompiler(
+          deps = ctx.attr.deps,
+          options = ctx.attr.options,
+          suffix = ctx.attr.suffix,
+          plugin = plugin,
+          protoc = toolchain.protoc,
+          go_protoc = toolchain.go_protoc,
+      )],
+  )
+
+go_proto_compiler = rule(
+    _go_proto_compiler_impl,
+    attrs = {
+        "deps": attr.label_list(
+            providers = [
+                "transitive_go_library_paths",
+                "transitive_go_libraries",
+                "transitive_cgo_deps",
+            ],
+        ),
+        "options": attr.string_list(),
+        "suffix": attr.string(default = ".pb.go"),
+        "plugin": attr.label(
+            executable = True,
+            cfg = "host",
+        ),
+    },
+    toolchains = [go_proto_toolchain_type],
+)
+"""Declares a protoc plugin that go_proto_library can generate Go code with,
+for its compilers attribute. deps are the Go libraries the generated code
+imports, which each go_proto_library using the compiler depends on. options
+are passed to the plugin, before the import_path and M options
+go_proto_library sets. protoc, and the plugin if it isn't set, come from the
+go_proto_toolchain selected for the execution platform.
+"""
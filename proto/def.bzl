@@ -0,0 +1,108 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go:def.bzl", "go_context", "go_toolchain_type")
+load("@io_bazel_rules_go//go/private:common.bzl", "go_prefix_default")
+load("@io_bazel_rules_go//go/private:library.bzl", "go_importpath")
+load("@io_bazel_rules_go//proto:compiler.bzl",
+    "GoProtoCompiler",
+    "GoProtoImports",
+    "go_proto_compile",
+    "proto_path",
+    _go_proto_compiler = "go_proto_compiler",
+)
+
+go_proto_compiler = _go_proto_compiler
+
+def _go_proto_library_impl(ctx):
+  go = go_context(ctx)
+  importpath = go_importpath(ctx)
+  proto = ctx.attr.proto.proto
+  imports = depset()
+  for dep in ctx.attr.deps:
+    if GoProtoImports in dep:
+      imports += dep[GoProtoImports].imports
+
+  go_srcs = []
+  # The libraries the generated code imports, from deps and from the
+  # compilers. A library may be listed by both, or by several compilers.
+  deps = []
+  labels = {}
+  for dep in ctx.attr.deps:
+    deps += [dep]
+    labels[str(dep.label)] = True
+  for c in ctx.attr.compilers:
+    compiler = c[GoProtoCompiler]
+    go_srcs += go_proto_compile(ctx, compiler, proto, imports, importpath)
+    for dep in compiler.deps:
+      if str(dep.label) not in labels:
+        deps += [dep]
+        labels[str(dep.label)] = True
+
+  library = go.new_library(go, importpath = importpath, importmap = ctx.attr.importmap)
+  source = go.library_to_source(go, srcs = go_srcs, deps = deps)
+  archive = go.archive(go, library, source)
+  own_imports = ["%s=%s" % (proto_path(src), importpath) for src in proto.direct_sources]
+  return go.library_struct(go, library, archive,
+      providers = [GoProtoImports(imports = imports + own_imports)],
+  )
+
+go_proto_library = rule(
+    _go_proto_library_impl,
+    attrs = {
+        "proto": attr.label(
+            mandatory = True,
+            providers = ["proto"],
+        ),
+        "deps": attr.label_list(
+            providers = [
+                "transitive_go_library_paths",
+                "transitive_go_libraries",
+                "transitive_cgo_deps",
+            ],
+        ),
+        "importpath": attr.string(),
+        "importmap": attr.string(),
+        "compilers": attr.label_list(
+            default = [Label("@io_bazel_rules_go//proto:go_proto")],
+            providers = [GoProtoCompiler],
+        ),
+        "_go_prefix": attr.label(default = go_prefix_default),
+    },
+    toolchains = [go_toolchain_type],
+    fragments = ["cpp"],
+)
+"""Generates Go code from the .proto files of a proto_library, and compiles
+it into a package that go_library, go_binary and go_test targets can depend
+on. The code is generated by each of the compilers, go_proto_compiler
+targets: by default, protoc-gen-go. deps are the go_proto_library targets
+for the proto_library targets it depends on, and any other Go libraries the
+generated code imports; the libraries the compilers' generated code imports
+are added.
+"""
+
+def go_grpc_library(**kwargs):
+  """A go_proto_library for .proto files with gRPC services, which generates
+  the messages with protoc-gen-go and the services with the version of
+  protoc-gen-go-grpc go_proto_repositories pins. It takes the same
+  attributes, except for compilers."""
+  if "compilers" in kwargs:
+    fail("go_grpc_library sets compilers; use go_proto_library for others", "compilers")
+  go_proto_library(
+      compilers = [
+          "@io_bazel_rules_go//proto:go_proto",
+          "@io_bazel_rules_go//proto:go_grpc_v2",
+      ],
+      **kwargs
+  )
@@ -40,6 +40,7 @@ go_proto_library(
 """
 
 load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_repository")
+load("@io_bazel_rules_go//proto:toolchain.bzl", "protoc_download")
 
 _DEFAULT_LIB = "go_default_library"  # matching go_library
 
@@ -111,10 +112,13 @@ def _go_proto_library_gen_impl(ctx):
   """Rule implementation that generates Go using protoc."""
   proto_outs, go_package_name = _check_bazel_style(ctx)
 
-  go_prefix = ctx.attr.go_prefix.go_prefix
-  if go_prefix and ctx.label.package and not go_prefix.endswith("/"):
-    go_prefix = go_prefix + "/"
-  source_go_package = "%s%s%s" % (go_prefix, ctx.label.package, go_package_name)
+  if ctx.attr.importpath:
+    source_go_package = ctx.attr.importpath
+  else:
+    go_prefix = ctx.attr.go_prefix.go_prefix
+    if go_prefix and ctx.label.package and not go_prefix.endswith("/"):
+      go_prefix = go_prefix + "/"
+    source_go_package = "%s%s%s" % (go_prefix, ctx.label.package, go_package_name)
 
   m_imports = ["M%s=%s" % (_drop_external(f.short_path), source_go_package)
                for f in ctx.files.srcs]
@@ -189,12 +193,9 @@ _go_proto_library_gen = rule(
             cfg = "host",
         ),
         "_protos": attr.label_list(default = []),
+        "importpath": attr.string(),
         "go_prefix": attr.label(
             providers = ["go_prefix"],
-            default = Label(
-                "//:go_prefix",
-                relative_to_caller_repository = True,
-            ),
             allow_files = False,
             cfg = "host",
         ),
@@ -220,6 +221,7 @@ def go_proto_library(name, srcs = None, deps = None,
                      has_services = 0,
                      testonly = 0, visibility = None,
                      ignore_go_package_option = 0,
+                     importpath = None,
                      protoc = "@com_github_google_protobuf//:protoc",
                      protoc_gen_go = "@com_github_golang_protobuf//protoc-gen-go",
                      x_net_context = "@org_golang_x_net//context:go_default_library",
@@ -242,6 +244,8 @@ def go_proto_library(name, srcs = None, deps = None,
     visibility: visibility to use on underlying go_library
     ignore_go_package_option: if 1, ignore the "option go_package" statement in
                               the srcs proto files.
+    importpath: the Go import path of the generated library. If not set, it is
+                derived from the go_prefix rule in the root BUILD file.
     protoc: override the default version of protoc.  Most users won't need this.
     protoc_gen_go: override the default version of protoc_gen_go.
                    Most users won't need this.
@@ -278,6 +282,8 @@ def go_proto_library(name, srcs = None, deps = None,
       testonly = testonly,
       visibility = visibility,
       ignore_go_package_option = ignore_go_package_option,
+      importpath = importpath,
+      go_prefix = None if importpath else "//:go_prefix",
       grpc = has_services,
       protoc = protoc,
       protoc_gen_go = protoc_gen_go,
@@ -289,6 +295,7 @@ def go_proto_library(name, srcs = None, deps = None,
       name = name,
       srcs = [":" + name + _PROTOS_SUFFIX],
       deps = deps + grpc_deps + [golang_protobuf],
+      importpath = importpath,
       testonly = testonly,
       visibility = visibility,
       **kwargs
@@ -321,8 +328,15 @@ def go_google_protobuf(name = _GO_GOOGLE_PROTOBUF):
       visibility = ["//visibility:public"],
   )
 
-def go_proto_repositories(shared = 1):
-  """Add this to your WORKSPACE to pull in all of the needed dependencies."""
+def go_proto_repositories(shared = 1, protoc_version = None, protoc_sha256s = {}):
+  """Add this to your WORKSPACE to pull in all of the needed dependencies.
+
+  This also registers the toolchain go_proto_compiler gets protoc from,
+  which builds it from @com_google_protobuf. If protoc_version is set, like
+  "3.5.1", that protoc release is downloaded for the host instead, and its
+  toolchain is registered first. protoc_sha256s is a dict from
+  <goos>_<goarch> to the SHA-256 sum of the release archive for that host.
+  """
   go_repository(
       name = "com_github_golang_protobuf",
       importpath = "github.com/golang/protobuf",
@@ -336,6 +350,14 @@ def go_proto_repositories(shared = 1):
         strip_prefix = "protobuf-3.2.0",
         sha256 = "2a25c2b71c707c5552ec9afdfb22532a93a339e1ca5d38f163fe4107af08c54c",
     )
+    # proto_library and go_proto_compiler use protoc from the repository
+    # Bazel expects it in.
+    native.http_archive(
+        name = "com_google_protobuf",
+        url = "https://github.com/google/protobuf/archive/v3.2.0.tar.gz",
+        strip_prefix = "protobuf-3.2.0",
+        sha256 = "2a25c2b71c707c5552ec9afdfb22532a93a339e1ca5d38f163fe4107af08c54c",
+    )
 
   # Needed for gRPC, only loaded by bazel if used
   go_repository(
@@ -343,8 +365,44 @@ def go_proto_repositories(shared = 1):
       commit = "4971afdc2f162e82d185353533d3cf16188a9f4e",
       importpath = "golang.org/x/net",
   )
+  # go_grpc_library needs gRPC 1.32 or later, which may be declared before
+  # go_proto_repositories is called.
+  if not native.existing_rule("org_golang_google_grpc"):
+    go_repository(
+        name = "org_golang_google_grpc",
+        tag = "v1.0.4",
+        importpath = "google.golang.org/grpc",
+    )
+
+  # Needed for go_grpc_library, only loaded by bazel if used
   go_repository(
-      name = "org_golang_google_grpc",
-      tag = "v1.0.4",
-      importpath = "google.golang.org/grpc",
+      name = "org_golang_google_grpc_cmd_protoc_gen_go_grpc",
+      tag = "cmd/protoc-gen-go-grpc/v1.0.1",
+      importpath = "google.golang.org/grpc/cmd/protoc-gen-go-grpc",
   )
+  go_repository(
+      name = "org_golang_google_protobuf",
+      tag = "v1.25.0",
+      importpath = "google.golang.org/protobuf",
+  )
+
+  # Needed for Twirp, only loaded by bazel if used
+  go_repository(
+      name = "com_github_twitchtv_twirp",
+      tag = "v5.0.0",
+      importpath = "github.com/twitchtv/twirp",
+  )
+  go_repository(
+      name = "com_github_pkg_errors",
+      tag = "v0.8.0",
+      importpath = "github.com/pkg/errors",
+  )
+
+  if protoc_version:
+    protoc_download(
+        name = "io_bazel_rules_go_protoc",
+        version = protoc_version,
+        sha256s = protoc_sha256s,
+    )
+    native.register_toolchains("@io_bazel_rules_go_protoc//:go_proto_toolchain")
+  native.register_toolchains("@io_bazel_rules_go//proto:default_go_proto_toolchain")
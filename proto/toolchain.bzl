@@ -0,0 +1,92 @@
+# Copyright 2017 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load("@io_bazel_rules_go//go/private:toolchain.bzl", "detect_host")
+
+# The type of the toolchains that supply protoc and the default plugin to
+# go_proto_compiler. go_proto_repositories registers them.
+go_proto_toolchain_type = "@io_bazel_rules_go//proto:toolchain"
+
+def _go_proto_toolchain_impl(ctx):
+  return [platform_common.ToolchainInfo(
+      protoc = ctx.executable.protoc,
+      plugin = ctx.executable.plugin,
+      go_protoc = ctx.executable.go_protoc,
+  )]
+
+go_proto_toolchain = rule(
+    _go_proto_toolchain_impl,
+    attrs = {
+        "protoc": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", mandatory = True),
+        "plugin": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", default = Label("@com_github_golang_protobuf//protoc-gen-go")),
+        "go_protoc": attr.label(allow_files = True, single_file = True, executable = True, cfg = "host", default = Label("@io_bazel_rules_go//go/tools/builders:go_protoc")),
+    },
+)
+"""Declares the protocol buffer compiler, the plugin go_proto_compiler
+targets use if they don't set one, and the builder that runs them. It's
+wrapped in a toolchain of go_proto_toolchain_type, so the protoc that runs
+on the execution platform is selected.
+"""
+
+# Hosts protoc release archives exist for, with the platforms in their file
+# names, the name of protoc in them, and the constraints of the platforms
+# they run on.
+_protoc_hosts = {
+    "darwin_amd64": ("osx-x86_64", "protoc", ["@bazel_tools//platforms:osx", "@bazel_tools//platforms:x86_64"]),
+    "linux_386": ("linux-x86_32", "protoc", ["@bazel_tools//platforms:linux", "@bazel_tools//platforms:x86_32"]),
+    "linux_amd64": ("linux-x86_64", "protoc", ["@bazel_tools//platforms:linux", "@bazel_tools//platforms:x86_64"]),
+    "linux_arm64": ("linux-aarch_64", "protoc", ["@bazel_tools//platforms:linux", "@io_bazel_rules_go//go/toolchain:arm64"]),
+    "windows_amd64": ("win32", "protoc.exe", ["@bazel_tools//platforms:windows", "@bazel_tools//platforms:x86_64"]),
+}
+
+def _protoc_download_impl(ctx):
+  goos, goarch = detect_host(ctx)
+  host = goos + "_" + goarch
+  if host not in _protoc_hosts:
+    fail("there is no protoc release for %s" % host)
+  platform, protoc, constraints = _protoc_hosts[host]
+  filename = "protoc-%s-%s.zip" % (ctx.attr.version, platform)
+  ctx.download_and_extract(
+      url = [url.format(version = ctx.attr.version, filename = filename) for url in ctx.attr.urls],
+      sha256 = ctx.attr.sha256s.get(host, ""),
+  )
+  ctx.file("BUILD.bazel", """load("@io_bazel_rules_go//proto:toolchain.bzl", "go_proto_toolchain", "go_proto_toolchain_type")
+
+go_proto_toolchain(
+    name = "go_proto_toolchain-impl",
+    protoc = "bin/{protoc}",
+)
+
+toolchain(
+    name = "go_proto_toolchain",
+    toolchain_type = go_proto_toolchain_type,
+    exec_compatible_with = {constraints},
+    toolchain = ":go_proto_toolchain-impl",
+    visibility = ["//visibility:public"],
+)
+""".format(protoc = protoc, constraints = repr(constraints)))
+
+protoc_download = repository_rule(
+    _protoc_download_impl,
+    attrs = {
+        "version": attr.string(mandatory = True),
+        "sha256s": attr.string_dict(),
+        "urls": attr.string_list(default = ["https://github.com/google/protobuf/releases/download/v{version}/{filename}"]),
+    },
+)
+"""Downloads the protoc release version for the host from urls, templates in
+which {version} and {filename} are substituted, and declares a toolchain for
+it, go_proto_toolchain. sha256s is a dict from <goos>_<goarch> to the SHA-256
+sum of the host's archive; without one, the archive isn't verified.
+"""
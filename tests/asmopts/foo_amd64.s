@@ -0,0 +1,3 @@
+TEXT ·foo(SB),$0-0
+  MOVQ $FOOVAL,RET(FP)
+  RET
@@ -10,6 +10,15 @@ def _bazel_test_script_impl(ctx):
   go_version = ''
   if ctx.attr.go_version:
     go_version = 'go_version = "%s"' % ctx.attr.go_version
+  if ctx.attr.additional_go_versions:
+    if go_version:
+      go_version += ', '
+    go_version += 'additional_versions = [%s]' % ", ".join(['"%s"' % v for v in ctx.attr.additional_go_versions])
+  if ctx.attr.boringcrypto_version:
+    if go_version:
+      go_version += ', '
+    go_version += 'boringcrypto = "go_boringcrypto_sdk"'
+  go_toolchain = ctx.toolchains["@io_bazel_rules_go//go:toolchain"]
   subdir = ""
   if ctx.attr.subdir:
     subdir = ctx.attr.subdir + "/"
@@ -24,16 +33,20 @@ def _bazel_test_script_impl(ctx):
     root = ext.label.workspace_root
     _,_,ws = root.rpartition("/")
     workspace_content += 'local_repository(name = "{0}", path = "{1}/{2}")\n'.format(ws, ctx.attr._execroot.path, root)
-  workspace_content += 'local_repository(name = "{0}", path = "{1}")\n'.format(ctx.attr._go_toolchain.sdk, ctx.attr._go_toolchain.root.path)
+  workspace_content += 'local_repository(name = "{0}", path = "{1}")\n'.format(go_toolchain.sdk, go_toolchain.root.path)
   # finalise the workspace file
-  workspace_content += 'load("@io_bazel_rules_go//go:def.bzl", "go_repositories")\n'
-  workspace_content += 'go_repositories({0})\n'.format(go_version)
+  workspace_content += 'load("@io_bazel_rules_go//go:def.bzl", "go_download_sdk", "go_register_toolchains", "go_repositories")\n'
+  workspace_content += 'go_repositories()\n'
+  if ctx.attr.boringcrypto_version:
+    workspace_content += 'go_download_sdk(name = "go_boringcrypto_sdk", version = "{0}", urls = ["https://go-boringcrypto.storage.googleapis.com/{{}}"], register_toolchains = False)\n'.format(ctx.attr.boringcrypto_version)
+  workspace_content += 'go_register_toolchains({0})\n'.format(go_version)
   if ctx.attr.workspace:
     workspace_content += ctx.attr.workspace
   workspace_file = ctx.new_file(subdir + "WORKSPACE")
   ctx.file_action(output=workspace_file, content=workspace_content)
   # finalise the script
   args += ctx.attr.args + [ctx.attr.target]
+  script_content += 'BAZEL={0}\n'.format(ctx.attr._execroot.bazel)
   script_content += 'BASE=$(pwd)\n'
   script_content += 'cd {0}\n'.format(ctx.label.package)
   script_content += 'PACKAGE=$(pwd)\n'
@@ -69,15 +82,17 @@ _bazel_test_script = rule(
         "target": attr.string(mandatory=True),
         "externals": attr.label_list(allow_files=True),
         "go_version": attr.string(),
+        "additional_go_versions": attr.string_list(),
+        "boringcrypto_version": attr.string(),
         "workspace": attr.string(),
         "prepare": attr.string(),
         "check": attr.string(),
-        "_go_toolchain": attr.label(default = Label("@io_bazel_rules_go_toolchain//:go_toolchain")),
         "_execroot": attr.label(default = Label("@test_environment//:execroot")),
-    }
+    },
+    toolchains = ["@io_bazel_rules_go//go:toolchain"],
 )
 
-def bazel_test(name, batch = None, command = None, args=None, subdir = None, target = None, go_version = None, tags=[], workspace="", prepare="", check=""):
+def bazel_test(name, batch = None, command = None, args=None, subdir = None, target = None, go_version = None, additional_go_versions = [], boringcrypto_version = None, tags=[], workspace="", prepare="", check=""):
   script_name = name+"_script"
   externals = [
       "@io_bazel_rules_go//:README.md",
@@ -93,6 +108,8 @@ def bazel_test(name, batch = None, command = None, args=None, subdir = None, tar
       target = target,
       externals = externals,
       go_version = go_version,
+      additional_go_versions = additional_go_versions,
+      boringcrypto_version = boringcrypto_version,
       workspace = workspace,
       prepare = prepare,
       check = check,
@@ -0,0 +1,19 @@
+package bench
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// BenchmarkUpper reads a data file, so it only passes when it's run in its
+// package directory in the runfiles.
+func BenchmarkUpper(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/input.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		strings.ToUpper(string(data))
+	}
+}
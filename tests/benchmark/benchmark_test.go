@@ -0,0 +1,20 @@
+package benchmark
+
+import (
+	"io/ioutil"
+	"regexp"
+	"testing"
+)
+
+func TestResults(t *testing.T) {
+	data, err := ioutil.ReadFile("bench_results.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With count = 2 and benchmem, the benchmark runs twice, and reports
+	// its allocations.
+	results := regexp.MustCompile(`(?m)^BenchmarkUpper(-\d+)?\s+\d+\s+.* ns/op\s+.* B/op\s+.* allocs/op$`).FindAll(data, -1)
+	if len(results) != 2 {
+		t.Errorf("got %d results; want 2 in:\n%s", len(results), data)
+	}
+}
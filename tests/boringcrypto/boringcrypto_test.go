@@ -0,0 +1,14 @@
+package boringcrypto
+
+import (
+	"runtime"
+	"testing"
+)
+
+// TestVersion checks that the BoringCrypto toolchain was selected for the
+// platform with the boringcrypto constraint, rather than the default one.
+func TestVersion(t *testing.T) {
+	if got, want := runtime.Version(), "go1.8.3b4"; got != want {
+		t.Errorf("got version %s; want %s", got, want)
+	}
+}
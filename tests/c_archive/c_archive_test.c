@@ -0,0 +1,12 @@
+#include <stdio.h>
+
+#include "tests/c_archive/exports.h"
+
+int main() {
+  int got = GoAdd(2, 3);
+  if (got != 5) {
+    fprintf(stderr, "GoAdd(2, 3): got %d; want 5\n", got);
+    return 1;
+  }
+  return 0;
+}
@@ -0,0 +1,10 @@
+package main
+
+import "C"
+
+//export GoAdd
+func GoAdd(a, b C.int) C.int {
+	return a + b
+}
+
+func main() {}
@@ -0,0 +1,10 @@
+package cgo_pure_mix
+
+/*
+extern int native_value();
+*/
+import "C"
+
+func nativeValue() int {
+	return int(C.native_value())
+}
@@ -0,0 +1,9 @@
+package cgo_pure_mix
+
+import "testing"
+
+func TestValue(t *testing.T) {
+	if got, want := Value(), 84; got != want {
+		t.Errorf("got %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,6 @@
+package cgo_pure_mix
+
+// Value returns a value computed in C, doubled in Go.
+func Value() int {
+	return 2 * nativeValue()
+}
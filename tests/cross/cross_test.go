@@ -0,0 +1,28 @@
+package cross
+
+import (
+	"debug/elf"
+	"testing"
+)
+
+func TestMachine(t *testing.T) {
+	for _, tc := range []struct {
+		bin  string
+		want elf.Machine
+	}{
+		{"linux_arm", elf.EM_ARM},
+		{"linux_arm64", elf.EM_AARCH64},
+		{"linux_s390x", elf.EM_S390},
+		{"native_linux_arm64", elf.EM_AARCH64},
+	} {
+		f, err := elf.Open(tc.bin)
+		if err != nil {
+			t.Errorf("%s: %v", tc.bin, err)
+			continue
+		}
+		if f.Machine != tc.want {
+			t.Errorf("%s: got machine %v; want %v", tc.bin, f.Machine, tc.want)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bazelbuild/rules_go/tests/cross/platform"
+)
+
+func main() {
+	fmt.Println(platform.Name)
+}
@@ -0,0 +1,4 @@
+package platform
+
+// Name is the operating system the package was compiled for.
+const Name = "linux"
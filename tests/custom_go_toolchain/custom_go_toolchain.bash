@@ -14,13 +14,13 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-# This script tests custom Go toolchains specified with the go_repositories
-# rule. It downloads an old version of Go, creates a workspace that references
-# it, and verifies that a go_binary can be built in that workspace.
+# This script tests that go_register_toolchains pins the Go version. It
+# creates a workspace that registers the toolchains for an old version of Go,
+# and verifies that a go_binary built in that workspace reports that version.
 #
 # This test is expensive because of the large download, so it is not run as
 # part of continuous integration at this time. Run it manually when
-# go_repositories changes.
+# go_register_toolchains changes.
 
 set -euo pipefail
 
@@ -29,51 +29,20 @@ source "$TEST_DIR/../non_bazel_tests_common.bash"
 
 GO_VERSION=1.7.5
 WORKSPACE_DIR=$(mktemp -d)
-GO_ARCHIVE=$(mktemp)
-GO_DIR=$(mktemp -d)
-
-case $(uname) in
-Linux)
-  OS=linux
-  HASH=2e4dd6c44f0693bef4e7b46cc701513d74c3cc44f2419bf519d7868b12931ac3
-  ;;
-Darwin)
-  OS=darwin
-  HASH=2e2a5e0a5c316cf922cf7d59ee5724d49fc35b07a154f6c4196172adfc14b2ca
-  ;;
-*)
-  echo Unknown operating system: $(uname) >&1
-  exit 1
-esac
-URL="https://storage.googleapis.com/golang/go$GO_VERSION.$OS-amd64.tar.gz"
 
 function cleanup {
-  rm -rf "$WORKSPACE_DIR" "$GO_ARCHIVE" "$GO_DIR"
+  rm -rf "$WORKSPACE_DIR"
 }
 trap cleanup EXIT
 
-curl "$URL" >"$GO_ARCHIVE"
-ACTUAL_HASH=$(shasum -a 256 "$GO_ARCHIVE" | awk '{print $1}')
-if [ "$ACTUAL_HASH" != "$HASH" ]; then
-  echo "error: downloaded archive does not match SHA-256 sum" >&1
-  echo "  got:  $ACTUAL_HASH" >&1
-  echo "  want: $HASH" >&1
-  exit 1
-fi
-tar -xz --directory="$GO_DIR" --file="$GO_ARCHIVE" --strip-components=1 go
-
 cat >"$WORKSPACE_DIR/WORKSPACE" <<EOF
-new_local_repository(
-    name = "local_go",
-    path = "$GO_DIR",
-    build_file_content = "",
-)
 local_repository(
     name = "io_bazel_rules_go",
     path = "$RULES_DIR",
 )
-load("@io_bazel_rules_go//go:def.bzl", "go_repositories")
-go_repositories(go_$OS = "@local_go")
+load("@io_bazel_rules_go//go:def.bzl", "go_register_toolchains", "go_repositories")
+go_repositories()
+go_register_toolchains(go_version = "$GO_VERSION")
 EOF
 
 cp "$TEST_DIR"/BUILD "$WORKSPACE_DIR"
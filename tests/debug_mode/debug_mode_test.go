@@ -0,0 +1,50 @@
+package debug_mode
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// small would be inlined into its callers, unless inlining is disabled.
+func small() int {
+	return 42
+}
+
+func TestNotInlined(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only ELF binaries are checked")
+	}
+	if small() != 42 {
+		t.Fatal("small() != 42")
+	}
+	f, err := elf.Open(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d, err := f.DWARF()
+	if err != nil {
+		t.Fatalf("the test has no DWARF: %v", err)
+	}
+	// If small was inlined into its only caller, the linker drops it, and it
+	// has no code of its own.
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e == nil {
+			break
+		}
+		name, _ := e.Val(dwarf.AttrName).(string)
+		if e.Tag == dwarf.TagSubprogram && strings.HasSuffix(name, "debug_mode.small") && e.Val(dwarf.AttrLowpc) != nil {
+			return
+		}
+	}
+	t.Error("small was inlined")
+}
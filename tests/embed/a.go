@@ -0,0 +1,5 @@
+package embed
+
+func A() string {
+	return "a"
+}
@@ -0,0 +1,5 @@
+package embed
+
+func B() string {
+	return A() + "b"
+}
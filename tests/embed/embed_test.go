@@ -0,0 +1,9 @@
+package embed
+
+import "testing"
+
+func TestEmbed(t *testing.T) {
+	if got, want := B(), "ab"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
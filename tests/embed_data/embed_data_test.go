@@ -0,0 +1,17 @@
+package embed_data
+
+import "testing"
+
+const hello = "Hello, embed!\n"
+
+func TestSingle(t *testing.T) {
+	if got := string(Single); got != hello {
+		t.Errorf("got %q; want %q", got, hello)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	if got := Multi["hello.txt"]; got != hello {
+		t.Errorf("got %q; want %q", got, hello)
+	}
+}
@@ -0,0 +1,9 @@
+package embedsrcs
+
+import "embed"
+
+//go:embed hello.txt
+var hello string
+
+//go:embed static
+var static embed.FS
@@ -0,0 +1,31 @@
+package embedsrcs
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	if want := "Hello, embedsrcs!\n"; hello != want {
+		t.Errorf("got %q; want %q", hello, want)
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	var got []string
+	err := fs.WalkDir(static, ".", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			got = append(got, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files starting with "_" are only embedded when they're matched
+	// explicitly, or with "all:".
+	if want := []string{"static/index.html"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
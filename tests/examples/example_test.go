@@ -0,0 +1,25 @@
+package examples_test
+
+import (
+	"fmt"
+
+	"github.com/bazelbuild/rules_go/tests/examples"
+)
+
+func ExampleGreet() {
+	fmt.Println(examples.Greet("gopher"))
+	// Output: Hello, gopher!
+}
+
+func ExampleGreet_unordered() {
+	fmt.Println(examples.Greet("b"))
+	fmt.Println(examples.Greet("a"))
+	// Unordered output:
+	// Hello, a!
+	// Hello, b!
+}
+
+// Examples without an output comment are only compiled.
+func ExampleGreet_notRun() {
+	panic("ran an example without output")
+}
@@ -0,0 +1,22 @@
+package examples
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestWrongOutput(t *testing.T) {
+	// GO_TEST_WRAP is set, so wrong_output runs its examples directly
+	// instead of writing a report.
+	cmd := exec.Command("./wrong_output")
+	cmd.Env = append(os.Environ(), "GO_TEST_WRAP=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("wrong_output passed; want it to fail. Output:\n%s", out)
+	}
+	if !strings.Contains(string(out), "--- FAIL: ExampleWrong") {
+		t.Errorf("got output:\n%s\nwant ExampleWrong to fail", out)
+	}
+}
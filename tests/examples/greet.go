@@ -0,0 +1,6 @@
+package examples
+
+// Greet returns a greeting for name.
+func Greet(name string) string {
+	return "Hello, " + name + "!"
+}
@@ -0,0 +1,8 @@
+package wrong_output
+
+import "fmt"
+
+func ExampleWrong() {
+	fmt.Println("got")
+	// Output: want
+}
@@ -0,0 +1,31 @@
+package reverse
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// seeds counts the inputs FuzzReverse is called with, to check the seed
+// corpus in testdata is read.
+var seeds int
+
+func FuzzReverse(f *testing.F) {
+	f.Add("hello")
+	f.Fuzz(func(t *testing.T, s string) {
+		seeds++
+		if got := Reverse(Reverse(s)); got != s {
+			t.Errorf("Reverse(Reverse(%q)) = %q", s, got)
+		}
+	})
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code == 0 && flag.Lookup("test.fuzz").Value.String() == "" && seeds != 2 {
+		fmt.Printf("FuzzReverse ran with %d inputs; want 2, from f.Add and testdata\n", seeds)
+		code = 1
+	}
+	os.Exit(code)
+}
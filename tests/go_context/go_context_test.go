@@ -0,0 +1,25 @@
+package go_context
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"example.com/gen"
+)
+
+func TestLibrary(t *testing.T) {
+	if got, want := gen.Message, "Hello, go_context!"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestBinary(t *testing.T) {
+	out, err := exec.Command("./hello").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Hello, go.link!"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+load("@io_bazel_rules_go//go:def.bzl", "go_context", "go_toolchain_type")
+
+def _gen_library_impl(ctx):
+  src = ctx.new_file(ctx.label.name + ".go")
+  ctx.file_action(
+      output = src,
+      content = "package %s\n\nconst Message = %r\n" % (ctx.attr.importpath.rpartition("/")[2], ctx.attr.message),
+  )
+  go = go_context(ctx)
+  library = go.new_library(go, importpath = ctx.attr.importpath)
+  source = go.library_to_source(go, srcs = [src], deps = ctx.attr.deps)
+  archive = go.archive(go, library, source)
+  return go.library_struct(go, library, archive)
+
+gen_library = rule(
+    _gen_library_impl,
+    attrs = {
+        "importpath": attr.string(mandatory = True),
+        "message": attr.string(),
+        "deps": attr.label_list(),
+    },
+    toolchains = [go_toolchain_type],
+    fragments = ["cpp"],
+)
+
+def _gen_binary_impl(ctx):
+  src = ctx.new_file(ctx.label.name + "_main.go")
+  ctx.file_action(
+      output = src,
+      content = "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(%r)\n}\n" % ctx.attr.message,
+  )
+  go = go_context(ctx)
+  library = go.new_library(go, importpath = "main")
+  source = go.library_to_source(go, srcs = [src])
+  archive = go.archive(go, library, source)
+  go.link(go, archive, ctx.outputs.executable)
+  return struct(runfiles = archive.runfiles)
+
+gen_binary = rule(
+    _gen_binary_impl,
+    attrs = {
+        "message": attr.string(),
+    },
+    executable = True,
+    toolchains = [go_toolchain_type],
+    fragments = ["cpp"],
+)
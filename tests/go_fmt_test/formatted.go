@@ -0,0 +1,12 @@
+// Package formatted is checked by go_fmt_test, which fails if it isn't
+// formatted with gofmt.
+package formatted
+
+// Sum returns the sum of xs.
+func Sum(xs ...int) int {
+	sum := 0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum
+}
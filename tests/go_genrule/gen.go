@@ -0,0 +1,10 @@
+// +build ignore
+
+// gen prints a Go file declaring a constant.
+package main
+
+import "fmt"
+
+func main() {
+	fmt.Print("package go_genrule\n\nconst Greeting = \"hello\"\n")
+}
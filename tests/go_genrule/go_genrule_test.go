@@ -0,0 +1,24 @@
+package go_genrule
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGenerated(t *testing.T) {
+	// Greeting is declared in greeting.go, which "go run gen.go" printed.
+	if Greeting != "hello" {
+		t.Errorf("got %q, want \"hello\"", Greeting)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	data, err := ioutil.ReadFile("version.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "go version go") {
+		t.Errorf("got %q, want the output of go version", data)
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+
+	"example.com/repo/lib"
+)
+
+func main() {
+	fmt.Println(lib.Message())
+}
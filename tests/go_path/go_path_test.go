@@ -0,0 +1,42 @@
+package go_path
+
+import (
+	"archive/zip"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	r, err := zip.OpenReader("archive_path.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	want := []string{
+		"src/example.com/repo/cmd/cmd.go",
+		"src/example.com/repo/dep/dep.go",
+		"src/example.com/repo/lib/lib.go",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %q; want %q", names, want)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	for _, path := range []string{
+		"copy_path/src/example.com/repo/dep/dep.go",
+		"copy_path/src/example.com/repo/lib/lib.go",
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat("copy_path/src/example.com/repo/cmd/cmd.go"); !os.IsNotExist(err) {
+		t.Errorf("cmd.go was copied, but only lib was in deps")
+	}
+}
@@ -0,0 +1,7 @@
+package lib
+
+import "example.com/repo/dep"
+
+func Message() string {
+	return dep.Message
+}
@@ -0,0 +1,40 @@
+package go_proto_library
+
+import (
+	"testing"
+
+	"example.com/a"
+	"example.com/b"
+	twirpb "example.com/twirp/b"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := &b.B{A: &a.A{Name: "gopher"}}
+	data, err := proto.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &b.B{}
+	if err := proto.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.A.Name, "gopher"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestService(t *testing.T) {
+	// The grpc compiler generates the client and server of the service.
+	var _ b.GreeterServer
+	var _ = b.NewGreeterClient
+}
+
+func TestTwirpService(t *testing.T) {
+	// The twirp compiler generates the interface, client and server of the
+	// service, next to the messages the proto compiler generates.
+	var _ twirpb.Greeter
+	var _ = twirpb.NewGreeterProtobufClient
+	var _ = twirpb.NewGreeterServer
+	var _ = &twirpb.B{}
+}
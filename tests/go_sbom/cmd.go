@@ -0,0 +1,7 @@
+package main
+
+import "github.com/golang/glog"
+
+func main() {
+	glog.Info("hello")
+}
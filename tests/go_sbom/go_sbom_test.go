@@ -0,0 +1,75 @@
+package go_sbom
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func readJSON(t *testing.T, path string, v interface{}) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	var inventory []struct {
+		Repository, Importpath, Version, License string
+		Packages                                 []string
+	}
+	readJSON(t, "spdx.licenses.json", &inventory)
+	if len(inventory) != 1 {
+		t.Fatalf("got %d repositories, want only glog: %+v", len(inventory), inventory)
+	}
+	glog := inventory[0]
+	if glog.Repository != "com_github_golang_glog" || glog.Importpath != "github.com/golang/glog" {
+		t.Errorf("got repository %s (%s), want com_github_golang_glog", glog.Repository, glog.Importpath)
+	}
+	if glog.Version != "23def4e6c14b4da8ac2ed8007337bc5eb5007998" {
+		t.Errorf("got version %q, want the commit in WORKSPACE", glog.Version)
+	}
+	if glog.License != "Apache-2.0" {
+		t.Errorf("got license %q, want Apache-2.0", glog.License)
+	}
+}
+
+func TestSPDX(t *testing.T) {
+	var doc struct {
+		SPDXVersion string `json:"spdxVersion"`
+		Packages    []struct {
+			Name            string `json:"name"`
+			LicenseDeclared string `json:"licenseDeclared"`
+		} `json:"packages"`
+	}
+	readJSON(t, "spdx.spdx.json", &doc)
+	if doc.SPDXVersion != "SPDX-2.2" {
+		t.Errorf("got spdxVersion %q, want SPDX-2.2", doc.SPDXVersion)
+	}
+	found := false
+	for _, p := range doc.Packages {
+		found = found || p.Name == "github.com/golang/glog" && p.LicenseDeclared == "Apache-2.0"
+	}
+	if !found {
+		t.Errorf("glog is not in the packages: %+v", doc.Packages)
+	}
+}
+
+func TestCycloneDX(t *testing.T) {
+	var bom struct {
+		BOMFormat  string `json:"bomFormat"`
+		Components []struct {
+			Name, Purl string
+		} `json:"components"`
+	}
+	readJSON(t, "cyclonedx.cdx.json", &bom)
+	if bom.BOMFormat != "CycloneDX" || len(bom.Components) != 1 {
+		t.Fatalf("got %+v, want a CycloneDX BOM with glog", bom)
+	}
+	if want := "pkg:golang/github.com/golang/glog@23def4e6c14b4da8ac2ed8007337bc5eb5007998"; bom.Components[0].Purl != want {
+		t.Errorf("got purl %q, want %q", bom.Components[0].Purl, want)
+	}
+}
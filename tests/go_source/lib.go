@@ -0,0 +1,6 @@
+package go_source
+
+func Shout() (string, error) {
+	greeting, err := Greeting()
+	return greeting + "!!", err
+}
@@ -0,0 +1,13 @@
+package go_source
+
+import "testing"
+
+func TestShout(t *testing.T) {
+	got, err := Shout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello, go_source!\n!!"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,8 @@
+package go_source
+
+import "io/ioutil"
+
+func Greeting() (string, error) {
+	data, err := ioutil.ReadFile("greeting.txt")
+	return string(data), err
+}
@@ -0,0 +1,18 @@
+// gen writes a Go file declaring a constant, to the path in its argument.
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: gen out.go")
+	}
+	src := "package go_tool\n\nconst Greeting = \"hello\"\n"
+	if err := ioutil.WriteFile(os.Args[1], []byte(src), 0666); err != nil {
+		log.Fatal(err)
+	}
+}
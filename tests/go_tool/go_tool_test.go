@@ -0,0 +1,11 @@
+package go_tool
+
+import "testing"
+
+func TestGenerated(t *testing.T) {
+	// Greeting is declared in greeting.go, which the genrule wrote by running
+	// the go_tool.
+	if Greeting != "hello" {
+		t.Errorf("got %q, want \"hello\"", Greeting)
+	}
+}
@@ -0,0 +1,7 @@
+package b
+
+import "example.com/lib"
+
+func Version() string {
+	return lib.Version()
+}
@@ -0,0 +1,18 @@
+package importmap
+
+import (
+	"testing"
+
+	"example.com/a"
+	"example.com/b"
+)
+
+func TestVersions(t *testing.T) {
+	// Each package links against its own copy of example.com/lib.
+	if got, want := a.Version(), "a"; got != want {
+		t.Errorf("a.Version(): got %q; want %q", got, want)
+	}
+	if got, want := b.Version(), "b"; got != want {
+		t.Errorf("b.Version(): got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,3 @@
+package lib
+
+const Version = "a"
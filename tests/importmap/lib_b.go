@@ -0,0 +1,7 @@
+package lib
+
+var version = "b"
+
+func Version() string {
+	return version
+}
@@ -0,0 +1,14 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+// TestVersion checks that the toolchain for the platform's version
+// constraint was selected, rather than the default version.
+func TestVersion(t *testing.T) {
+	if got, want := runtime.Version(), "go1.7.5"; got != want {
+		t.Errorf("got version %s; want %s", got, want)
+	}
+}
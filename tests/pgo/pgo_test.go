@@ -0,0 +1,17 @@
+package pgo
+
+import "testing"
+
+func sum(n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		s += i % 7
+	}
+	return s
+}
+
+func TestSum(t *testing.T) {
+	if got, want := sum(14), 42; got != want {
+		t.Errorf("sum(14) = %d; want %d", got, want)
+	}
+}
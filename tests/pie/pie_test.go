@@ -0,0 +1,22 @@
+package pie
+
+import (
+	"debug/elf"
+	"os"
+	"testing"
+)
+
+func TestPIE(t *testing.T) {
+	for _, bin := range []string{"pie_bin", os.Args[0]} {
+		f, err := elf.Open(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The kernel loads position-independent executables at a random
+		// address, like shared libraries, so they have the same ELF type.
+		if f.Type != elf.ET_DYN {
+			t.Errorf("%s has ELF type %v; want %v", bin, f.Type, elf.ET_DYN)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,3 @@
+package dep
+
+const Value = 42
@@ -0,0 +1,5 @@
+package lib
+
+import "example.com/pkg_info/dep"
+
+var Value = dep.Value
@@ -0,0 +1,51 @@
+package pkg_info
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type pkgInfo struct {
+	ID         string
+	PkgPath    string
+	GoFiles    []string
+	ExportFile string
+	Imports    []string
+}
+
+func readPkgInfo(t *testing.T, name string) pkgInfo {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info pkgInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestPkgInfo(t *testing.T) {
+	lib := readPkgInfo(t, "lib.pkg.json")
+	if lib.PkgPath != "example.com/pkg_info/lib" {
+		t.Errorf("got PkgPath %q; want example.com/pkg_info/lib", lib.PkgPath)
+	}
+	if !reflect.DeepEqual(lib.Imports, []string{"example.com/pkg_info/dep"}) {
+		t.Errorf("got Imports %q; want [example.com/pkg_info/dep]", lib.Imports)
+	}
+	if len(lib.GoFiles) != 1 || path.Base(lib.GoFiles[0]) != "lib.go" {
+		t.Errorf("got GoFiles %q; want [.../lib.go]", lib.GoFiles)
+	}
+
+	dep := readPkgInfo(t, "dep/go_default_library.pkg.json")
+	if dep.ID != "//tests/pkg_info/dep:go_default_library" {
+		t.Errorf("got ID %q; want //tests/pkg_info/dep:go_default_library", dep.ID)
+	}
+	if !strings.HasSuffix(dep.ExportFile, "example.com/pkg_info/dep.a") {
+		t.Errorf("got ExportFile %q; want the archive of example.com/pkg_info/dep", dep.ExportFile)
+	}
+}
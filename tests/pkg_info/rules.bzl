@@ -0,0 +1,19 @@
+load("@io_bazel_rules_go//go:def.bzl", "GoPkgInfo", "go_pkg_info_aspect")
+
+def _pkg_info_files_impl(ctx):
+  files = depset()
+  for dep in ctx.attr.deps:
+    files += dep[GoPkgInfo].go_pkg_info
+  return struct(
+      files = files,
+      runfiles = ctx.runfiles(transitive_files = files),
+  )
+
+# Collects the JSON descriptions of deps and their dependencies, so the test
+# can read them.
+pkg_info_files = rule(
+    _pkg_info_files_impl,
+    attrs = {
+        "deps": attr.label_list(aspects = [go_pkg_info_aspect]),
+    },
+)
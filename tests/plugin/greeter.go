@@ -0,0 +1,12 @@
+package main
+
+import "github.com/bazelbuild/rules_go/tests/plugin/greeting"
+
+func init() {
+	greeting.Register("hello")
+}
+
+// Greet is looked up by the test.
+func Greet(name string) string {
+	return "hello, " + name
+}
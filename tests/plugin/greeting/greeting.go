@@ -0,0 +1,14 @@
+// Package greeting is shared by the plugin test and the plugin it loads.
+package greeting
+
+var greetings []string
+
+// Register adds a greeting. The plugin calls it when it's loaded.
+func Register(g string) {
+	greetings = append(greetings, g)
+}
+
+// Greetings returns the registered greetings.
+func Greetings() []string {
+	return greetings
+}
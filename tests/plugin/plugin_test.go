@@ -0,0 +1,32 @@
+package plugin_test
+
+import (
+	"plugin"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/tests/plugin/greeting"
+)
+
+func TestPlugin(t *testing.T) {
+	p, err := plugin.Open("greeter.so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The plugin's init function registers a greeting in the package this
+	// test imports, so both must use the same copy of it.
+	if got, want := greeting.Greetings(), []string{"hello"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got greetings %q; want %q", got, want)
+	}
+	sym, err := p.Lookup("Greet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	greet, ok := sym.(func(string) string)
+	if !ok {
+		t.Fatalf("Greet has type %T; want func(string) string", sym)
+	}
+	if got, want := greet("gopher"), "hello, gopher"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
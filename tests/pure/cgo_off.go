@@ -0,0 +1,6 @@
+// +build !cgo
+
+package dep
+
+// Enabled reports whether the package was compiled with cgo enabled.
+const Enabled = false
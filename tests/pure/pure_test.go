@@ -0,0 +1,13 @@
+package pure
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/rules_go/tests/pure/dep"
+)
+
+func TestPure(t *testing.T) {
+	if dep.Enabled {
+		t.Error("dependency was compiled with cgo enabled")
+	}
+}
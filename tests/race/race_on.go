@@ -0,0 +1,6 @@
+// +build race
+
+package dep
+
+// Enabled reports whether the package was compiled with -race.
+const Enabled = true
@@ -0,0 +1,13 @@
+package race
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/rules_go/tests/race/dep"
+)
+
+func TestRaceEnabled(t *testing.T) {
+	if !dep.Enabled {
+		t.Error("dependency was not compiled in race mode")
+	}
+}
@@ -0,0 +1,93 @@
+package release
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTarGz(t *testing.T) {
+	f, err := os.Open("hello_release_linux_amd64.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+		if h.Name == "hello" && h.Mode&0111 == 0 {
+			t.Errorf("hello: got mode %o; want it to be executable", h.Mode)
+		}
+	}
+	if want := []string{"hello", "NOTICE"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %q; want %q", names, want)
+	}
+}
+
+func TestZip(t *testing.T) {
+	r, err := zip.OpenReader("hello_release_windows_amd64.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"hello.exe", "NOTICE"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %q; want %q", names, want)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	f, err := os.Open("hello_release_checksums.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			t.Fatalf("malformed line %q", s.Text())
+		}
+		sum, name := fields[0], fields[1]
+		names = append(names, name)
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("%x", sha256.Sum256(data)); sum != want {
+			t.Errorf("%s: got checksum %s; want %s", name, sum, want)
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"hello_release_linux_amd64.tar.gz", "hello_release_windows_amd64.zip"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %q; want %q", names, want)
+	}
+}
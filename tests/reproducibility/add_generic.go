@@ -0,0 +1,7 @@
+// +build !amd64
+
+package add
+
+func Add(a, b int) int {
+	return a + b
+}
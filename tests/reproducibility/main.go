@@ -0,0 +1,12 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bazelbuild/rules_go/tests/reproducibility/add"
+	"github.com/bazelbuild/rules_go/tests/reproducibility/cadd"
+)
+
+func main() {
+	fmt.Println(add.Add(1, 2), cadd.Add(3, 4))
+}
@@ -0,0 +1,12 @@
+package package_dir
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	if _, err := ioutil.ReadFile("testdata/data.txt"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,12 @@
+package root_dir
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	if _, err := ioutil.ReadFile("tests/rundir/testdata/data.txt"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,12 @@
+package testdata_dir
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	if _, err := ioutil.ReadFile("data.txt"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bazelbuild/rules_go/tests/static/native"
+)
+
+func main() {
+	fmt.Println(native.Value())
+}
@@ -0,0 +1,3 @@
+int native_value() {
+  return 42;
+}
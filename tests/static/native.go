@@ -0,0 +1,11 @@
+package native
+
+/*
+extern int native_value();
+*/
+import "C"
+
+// Value returns a value computed by C code.
+func Value() int {
+	return int(C.native_value())
+}
@@ -0,0 +1,19 @@
+package static
+
+import (
+	"debug/elf"
+	"testing"
+)
+
+func TestStatic(t *testing.T) {
+	f, err := elf.Open("static_bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			t.Error("static_bin has an interpreter; it was linked dynamically")
+		}
+	}
+}
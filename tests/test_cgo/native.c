@@ -0,0 +1,3 @@
+int native_value() {
+  return NATIVE_VALUE;
+}
@@ -0,0 +1,5 @@
+package test_cgo
+
+func Value() int {
+	return 42
+}
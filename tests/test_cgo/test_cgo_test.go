@@ -0,0 +1,11 @@
+package test_cgo
+
+// int native_value();
+import "C"
+import "testing"
+
+func TestValue(t *testing.T) {
+	if got, want := Value(), int(C.native_value()); got != want {
+		t.Errorf("got %d; want %d", got, want)
+	}
+}
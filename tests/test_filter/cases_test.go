@@ -0,0 +1,17 @@
+package cases
+
+import "testing"
+
+func TestFoo(t *testing.T) {}
+
+func TestFooBar(t *testing.T) {}
+
+func TestSub(t *testing.T) {
+	t.Run("case1", func(t *testing.T) {})
+	t.Run("case2", func(t *testing.T) {})
+}
+
+func BenchmarkFoo(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+	}
+}
@@ -0,0 +1,52 @@
+package test_filter
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Matches the tests that start, and the results of benchmarks.
+var runRegexp = regexp.MustCompile(`(?m)^(?:=== RUN +(\S+)|(Benchmark\w+)(?:-\d+)?\s+\d+\s)`)
+
+func TestFilter(t *testing.T) {
+	// GO_TEST_WRAP is set, so cases runs its tests directly instead of
+	// writing a report.
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "TESTBRIDGE_TEST_ONLY=") {
+			env = append(env, e)
+		}
+	}
+	env = append(env, "GO_TEST_WRAP=1")
+
+	for _, tc := range []struct {
+		filter string
+		want   []string
+	}{
+		{"TestFoo", []string{"TestFoo"}},
+		{"TestFoo,TestFooBar", []string{"TestFoo", "TestFooBar"}},
+		{"TestFoo.*", []string{"TestFoo", "TestFooBar"}},
+		{"TestSub/case2", []string{"TestSub", "TestSub/case2"}},
+		{"TestFoo/none,TestSub", []string{"TestFoo", "TestSub", "TestSub/case1", "TestSub/case2"}},
+		{"BenchmarkFoo", []string{"BenchmarkFoo"}},
+	} {
+		cmd := exec.Command("./cases", "-test.v", "-test.benchtime=1ns")
+		cmd.Env = append(env, "TESTBRIDGE_TEST_ONLY="+tc.filter)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("%s: %v\n%s", tc.filter, err, out)
+			continue
+		}
+		var got []string
+		for _, m := range runRegexp.FindAllStringSubmatch(string(out), -1) {
+			got = append(got, m[1]+m[2])
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q; want %q\n%s", tc.filter, got, tc.want, out)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package returns_failure
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	m.Run()
+}
+
+func TestFails(t *testing.T) {
+	if os.Getenv("RETURNS_FAILURE_FAIL") != "" {
+		t.Error("failed")
+	}
+}
@@ -0,0 +1,19 @@
+package test_main
+
+import (
+	"os"
+	"testing"
+)
+
+var setUp bool
+
+func TestMain(m *testing.M) {
+	setUp = true
+	os.Exit(m.Run())
+}
+
+func TestSetUp(t *testing.T) {
+	if !setUp {
+		t.Error("TestMain didn't run before the tests")
+	}
+}
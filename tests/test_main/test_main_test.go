@@ -0,0 +1,17 @@
+package test_main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestReturnedFailure(t *testing.T) {
+	// GO_TEST_WRAP is set, so returns_failure runs its tests directly
+	// instead of writing a report.
+	cmd := exec.Command("./returns_failure")
+	cmd.Env = append(os.Environ(), "GO_TEST_WRAP=1", "RETURNS_FAILURE_FAIL=1")
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Errorf("returns_failure passed; want it to fail. Output:\n%s", out)
+	}
+}
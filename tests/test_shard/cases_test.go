@@ -0,0 +1,9 @@
+package cases
+
+import "testing"
+
+func TestA(t *testing.T) {}
+func TestB(t *testing.T) {}
+func TestC(t *testing.T) {}
+func TestD(t *testing.T) {}
+func TestE(t *testing.T) {}
@@ -0,0 +1,57 @@
+package test_shard
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var runRegexp = regexp.MustCompile(`(?m)^=== RUN +(\w+)$`)
+
+func TestShards(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "TestShards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The variables Bazel set for this test are replaced, and GO_TEST_WRAP
+	// is set, so cases runs its tests directly instead of writing a report.
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "TEST_TOTAL_SHARDS=") && !strings.HasPrefix(e, "TEST_SHARD_INDEX=") && !strings.HasPrefix(e, "TEST_SHARD_STATUS_FILE=") {
+			env = append(env, e)
+		}
+	}
+	env = append(env, "GO_TEST_WRAP=1", "TEST_TOTAL_SHARDS=2")
+
+	want := [][]string{
+		{"TestA", "TestC", "TestE"},
+		{"TestB", "TestD"},
+	}
+	for i := range want {
+		status := filepath.Join(dir, "status"+strconv.Itoa(i))
+		cmd := exec.Command("./cases", "-test.v")
+		cmd.Env = append(env, "TEST_SHARD_INDEX="+strconv.Itoa(i), "TEST_SHARD_STATUS_FILE="+status)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("shard %d: %v\n%s", i, err, out)
+		}
+		var got []string
+		for _, m := range runRegexp.FindAllStringSubmatch(string(out), -1) {
+			got = append(got, m[1])
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("shard %d: got tests %q; want %q", i, got, want[i])
+		}
+		if _, err := os.Stat(status); err != nil {
+			t.Errorf("shard %d: shard status file wasn't touched: %v", i, err)
+		}
+	}
+}
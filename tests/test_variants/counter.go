@@ -0,0 +1,9 @@
+package test_variants
+
+var count int
+
+// Incr increments the counter and returns its new value.
+func Incr() int {
+	count++
+	return count
+}
@@ -0,0 +1,6 @@
+package test_variants
+
+// Reset is only defined in tests, for tests in the external test package.
+func Reset() {
+	count = 0
+}
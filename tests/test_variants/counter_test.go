@@ -0,0 +1,18 @@
+package test_variants_test
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/rules_go/tests/test_variants"
+	"github.com/bazelbuild/rules_go/tests/test_variants/testutil"
+)
+
+func TestIncr(t *testing.T) {
+	test_variants.Reset()
+	testutil.IncrN(3)
+	// testutil shares the package's counter only if a single copy of the
+	// package was linked into the test.
+	if got := test_variants.Incr(); got != 4 {
+		t.Errorf("got %d; want 4", got)
+	}
+}
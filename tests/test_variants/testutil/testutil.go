@@ -0,0 +1,10 @@
+package testutil
+
+import "github.com/bazelbuild/rules_go/tests/test_variants"
+
+// IncrN increments the counter n times.
+func IncrN(n int) {
+	for i := 0; i < n; i++ {
+		test_variants.Incr()
+	}
+}
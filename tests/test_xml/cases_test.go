@@ -0,0 +1,34 @@
+package cases
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// The tests only fail when they're run by go_default_test, so this target
+// passes when it's tested on its own.
+var shouldFail = os.Getenv("TEST_XML_CASES_FAIL") != ""
+
+func TestPass(t *testing.T) {
+	fmt.Println("some output")
+}
+
+func TestFail(t *testing.T) {
+	if shouldFail {
+		t.Error("a <failure> message")
+	}
+}
+
+func TestSkip(t *testing.T) {
+	t.Skip("not today")
+}
+
+func TestSub(t *testing.T) {
+	t.Run("pass", func(t *testing.T) {})
+	t.Run("fail", func(t *testing.T) {
+		if shouldFail {
+			t.Error("subtest failed")
+		}
+	})
+}
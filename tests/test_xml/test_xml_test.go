@@ -0,0 +1,95 @@
+package test_xml
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testSuites struct {
+	Suites []testSuite `xml:"testsuite"`
+}
+
+type testSuite struct {
+	Name     string     `xml:"name,attr"`
+	Tests    int        `xml:"tests,attr"`
+	Failures int        `xml:"failures,attr"`
+	Skipped  int        `xml:"skipped,attr"`
+	Cases    []testCase `xml:"testcase"`
+}
+
+type testCase struct {
+	Name    string    `xml:"name,attr"`
+	Failure *struct{} `xml:"failure"`
+	Skipped *struct{} `xml:"skipped"`
+}
+
+func TestXML(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "TestXML")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	xmlFile := filepath.Join(dir, "test.xml")
+
+	// The test that runs this one writes its own report, so the variables
+	// it set up are replaced.
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "GO_TEST_WRAP=") && !strings.HasPrefix(e, "XML_OUTPUT_FILE=") {
+			env = append(env, e)
+		}
+	}
+	cmd := exec.Command("./cases")
+	cmd.Env = append(env, "XML_OUTPUT_FILE="+xmlFile, "TEST_XML_CASES_FAIL=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("cases passed; want it to fail. Output:\n%s", out)
+	}
+	if !strings.Contains(string(out), "--- FAIL: TestFail") {
+		t.Errorf("got output:\n%s\nwant the verbose output of the test", out)
+	}
+
+	data, err := ioutil.ReadFile(xmlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites testSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatalf("%v in:\n%s", err, data)
+	}
+	if len(suites.Suites) != 1 {
+		t.Fatalf("got %d test suites; want 1:\n%s", len(suites.Suites), data)
+	}
+	suite := suites.Suites[0]
+	if suite.Tests != 6 || suite.Failures != 3 || suite.Skipped != 1 {
+		t.Errorf("got %d tests, %d failures and %d skipped; want 6, 3 and 1:\n%s", suite.Tests, suite.Failures, suite.Skipped, data)
+	}
+	var names, failed, skipped []string
+	for _, c := range suite.Cases {
+		names = append(names, c.Name)
+		if c.Failure != nil {
+			failed = append(failed, c.Name)
+		}
+		if c.Skipped != nil {
+			skipped = append(skipped, c.Name)
+		}
+	}
+	if want := []string{"TestPass", "TestFail", "TestSkip", "TestSub", "TestSub/pass", "TestSub/fail"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got tests %q; want %q", names, want)
+	}
+	if want := []string{"TestFail", "TestSub", "TestSub/fail"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("got failed tests %q; want %q", failed, want)
+	}
+	if want := []string{"TestSkip"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("got skipped tests %q; want %q", skipped, want)
+	}
+	if !strings.Contains(string(data), "a &lt;failure&gt; message") {
+		t.Errorf("got report:\n%s\nwant it to contain the escaped failure message", data)
+	}
+}
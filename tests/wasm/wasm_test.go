@@ -0,0 +1,12 @@
+package wasm
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestPlatform(t *testing.T) {
+	if runtime.GOOS != "js" || runtime.GOARCH != "wasm" {
+		t.Errorf("got %s/%s; want js/wasm", runtime.GOOS, runtime.GOARCH)
+	}
+}